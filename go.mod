@@ -2,4 +2,12 @@ module github.com/cpcf/peep
 
 go 1.24.6
 
-require golang.org/x/tools v0.35.0
+require (
+	github.com/creack/pty v1.1.24
+	github.com/google/pprof v0.0.0-20250607225305-033d6d78b36a
+	github.com/pmezard/go-difflib v1.0.0
+	golang.org/x/term v0.33.0
+	golang.org/x/tools v0.35.0
+)
+
+require golang.org/x/sys v0.34.0 // indirect
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+
+	"github.com/cpcf/peep/pkg/peep"
+)
+
+func main() {
+	// Subcommands are dispatched before flag parsing
+	if len(os.Args) > 1 && os.Args[1] == "clean" {
+		if err := peep.RunClean(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "daemon" {
+		if err := peep.RunDaemon(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		code, err := peep.RunClient(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(code)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bisect" {
+		if err := peep.RunBisect(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		if err := peep.RunReport(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export-dashboard" {
+		if err := peep.RunExportDashboard(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff-instrument" {
+		if err := peep.RunDiffInstrument(os.Stdout, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	peep.CatchInterrupts()
+
+	// The flags fill opts directly; Run checks them
+	opts := peep.DefaultOptions()
+	var memOnly, cpuOnly bool
+	logsKB := opts.LogsSize / 1024
+	flag.BoolVar(&opts.EnableWeb, "dash", false, "Enable web dashboard")
+	flag.StringVar(&opts.Port, "port", "", "Port for web dashboard (default 6060, or a free port if 6060 is taken)")
+	flag.StringVar(&opts.CPUFile, "cpu-out", "", "Output file for CPU profile")
+	flag.StringVar(&opts.MemFile, "mem-out", "", "Output file for memory profile")
+	flag.BoolVar(&memOnly, "mem", false, "Enable memory profiling (use alone for memory-only)")
+	flag.BoolVar(&cpuOnly, "cpu", false, "Enable CPU profiling (use alone for CPU-only)")
+	flag.BoolVar(&opts.EnableMutex, "mutex", false, "Also write a mutex contention profile (mutex.prof)")
+	flag.StringVar(&opts.MutexFile, "mutex-out", "", "Output file for mutex profile")
+	flag.IntVar(&opts.MutexRate, "mutex-rate", opts.MutexRate, "With -mutex, sample one in this many mutex contention events")
+	flag.Func("mark-regex", "Record target stdout lines matching this regex as dashboard annotations", func(s string) (err error) {
+		opts.MarkRegex, err = regexp.Compile(s)
+		return err
+	})
+	flag.BoolVar(&opts.FailOnEmptyProfile, "fail-on-empty-profile", false, "Exit non-zero if a written profile contains no samples")
+	flag.StringVar(&opts.Entry, "entry", "", "File of the package whose func main() is the entry point, when several files define one")
+	flag.StringVar(&opts.Entry, "main", "", "Same as -entry")
+	flag.BoolVar(&opts.Generate, "generate", false, "Run go generate in the package directory before instrumenting")
+	flag.BoolVar(&opts.InjectAtReturn, "inject-at-return", false, "Record a final metrics snapshot when main returns")
+	flag.BoolVar(&opts.GCTrace, "gctrace", false, "Run the target with GODEBUG=gctrace=1 and show its GC events on the dashboard")
+	flag.BoolVar(&opts.NanoTimestamps, "timestamp-ns", false, "Stamp dashboard metrics samples with nanosecond precision")
+	flag.BoolVar(&opts.PTY, "pty", false, "Run the target in a pseudo-terminal (for TUI programs, Unix only)")
+	flag.BoolVar(&opts.CPUContinuous, "cpu-continuous", false, "Start CPU profiling in init and keep one profile until main returns or the program is signalled")
+	flag.BoolVar(&opts.ShowEnv, "show-env", false, "Show environment values in the dashboard run info instead of redacting them")
+	flag.BoolVar(&opts.Adaptive, "adaptive", false, "Sample dashboard metrics more often while memory changes quickly and less often when stable")
+	flag.BoolVar(&opts.UseMetricsSocket, "metrics-socket", false, "Send dashboard metrics from the program to peep over a Unix domain socket instead of a file (Unix only)")
+	flag.StringVar(&opts.MetricsPriority, "metrics-priority", opts.MetricsPriority, "Dashboard collector priority: normal, or low to read runtime/metrics without stopping the world, back off while the system is busy and drop the collector's own CPU samples")
+	flag.StringVar(&opts.BaselineFile, "metrics-baseline", "", "Compare peak alloc, goroutines and GC count against this baseline JSON file")
+	flag.StringVar(&opts.SaveBaselineFile, "save-baseline", "", "Write this run's peak alloc, goroutines and GC count to a baseline JSON file")
+	flag.Float64Var(&opts.BaselineThreshold, "metrics-threshold", opts.BaselineThreshold, "Fail -metrics-baseline if a metric grows by more than this percent")
+	flag.DurationVar(&opts.MemSnapshots, "mem-snapshots", 0, "Have the dashboard's metrics collector also write a heap profile (mem-<unix>.prof) this often while the program runs (e.g. 30s)")
+	flag.IntVar(&opts.MaxSnapshots, "max-snapshots", opts.MaxSnapshots, "Number of newest -mem-snapshots heap profiles kept, deleting the oldest (0 keeps all)")
+	flag.DurationVar(&opts.GoroutineInterval, "goroutine-interval", 0, "Write a goroutine profile (goroutine-<n>.prof) this often while the program runs and report the functions whose goroutines grew (e.g. 5s)")
+	flag.DurationVar(&opts.Interval, "interval", opts.Interval, "How often the program samples metrics for -dash, -summary and -max-alloc (e.g. 100ms)")
+	flag.DurationVar(&opts.MetricsDuration, "metrics-duration", 0, "Stop collecting dashboard metrics this long after the program starts and keep showing the last sample (e.g. 1m)")
+	flag.DurationVar(&opts.Duration, "duration", 0, "Stop the program this long after it starts, flushing its profiles, for programs like servers that never exit on their own (e.g. 10s)")
+	flag.DurationVar(&opts.Warmup, "warmup", 0, "Start CPU profiling this long after main starts, leaving out the program's warmup (e.g. 10s)")
+	flag.DurationVar(&opts.CPUDuration, "cpu-duration", 0, "Stop CPU profiling this long after it starts, leaving memory profiling and metrics running until the program exits (e.g. 30s)")
+	flag.DurationVar(&opts.MaxRuntime, "max-runtime", 0, "Stop the dashboard this long after the program exits instead of waiting for Ctrl+C (e.g. 30s)")
+	flag.BoolVar(&opts.Cgo, "cgo", false, "Set CGO_ENABLED=1 for the target, for programs that use cgo")
+	flag.BoolVar(&opts.PostInitHeap, "post-init-heap", false, "Also write a heap profile at the start of main, after package initialization (<mem-out>_postinit)")
+	flag.BoolVar(&opts.PerCore, "per-core", false, "Also report per-core CPU usage to the dashboard")
+	flag.BoolVar(&opts.PerCore, "percpu", false, "Same as -per-core")
+	flag.StringVar(&opts.ArchiveMetricsFile, "archive-metrics", "", "Copy the final dashboard metrics frame to this file when the program exits")
+	flag.StringVar(&opts.Example, "example", "", "Profile the named Example function of the package (e.g. ExampleParse) via go test")
+	flag.BoolVar(&opts.Test, "test", false, "Profile the package's tests via go test instead of its main function; arguments after the package go to go test (e.g. -run TestParse)")
+	flag.BoolVar(&opts.SilentTarget, "silent-target", false, "Discard the target's stdout (required when a profile is written to stdout with -)")
+	flag.StringVar(&opts.Toolchain, "toolchain", "", "Build and run the target with this Go toolchain (e.g. go1.22.0), through GOTOOLCHAIN")
+	flag.IntVar(&opts.BuildParallelism, "p", 0, "Number of packages the go command builds in parallel (go build -p); affects the build, not the program's concurrency")
+	flag.StringVar(&opts.BuildTags, "tags", "", "Comma-separated build tags for building the program and selecting its files (go build -tags)")
+	flag.BoolVar(&opts.Race, "race", false, "Build the program with the race detector (go build -race); its overhead skews the profiles and metrics")
+	flag.StringVar(&opts.LDFlags, "ldflags", "", "Linker flags for building the program, e.g. '-X main.version=1.2.3' (go build -ldflags)")
+	flag.IntVar(&opts.HistorySize, "history", opts.HistorySize, "Number of recent metrics samples the dashboard keeps and serves at /history")
+	flag.IntVar(&logsKB, "logs-kb", logsKB, "KiB of the program's most recent stdout and stderr the dashboard keeps and serves at /logs (0 disables it)")
+	flag.StringVar(&opts.ManifestFile, "manifest", "", "Write the run's target, command, modes, Go version, timing and output files to this JSON file after the run, for peep report")
+	flag.StringVar(&opts.LivePprofAddr, "live-pprof", "", "Serve net/http/pprof from the program on this address while it runs, for go tool pprof (e.g. localhost:6061); only the memory profile is written unless -cpu is set")
+	flag.StringVar(&opts.StaticDir, "static-dir", "", "Serve the dashboard page from this directory instead of the one built into peep, to customize it")
+	flag.StringVar(&opts.HistoryFile, "history-out", "", "Also append every dashboard metrics sample to this JSONL file, for peep export-dashboard")
+	flag.BoolVar(&opts.Summary, "summary", false, "Print the peak and average CPU, memory and GC figures of the program's metrics after the run, collecting them without the dashboard if -dash is not set")
+	flag.Func("max-alloc", "Exit non-zero after the run if a metrics sample reports Alloc above this size (e.g. 256MB or 1GiB), collecting metrics without the dashboard if -dash is not set", func(s string) (err error) {
+		if opts.MaxAlloc, err = peep.ParseByteSize(s); err == nil && opts.MaxAlloc == 0 {
+			err = errors.New("must be positive")
+		}
+		return err
+	})
+	flag.StringVar(&opts.CSVFile, "csv-out", "", "Write every dashboard metrics sample to this CSV file when the run ends, one row per sample")
+	flag.BoolVar(&opts.NoStaleCheck, "no-stale-check", false, "Always serve the last metrics sample, with its age, instead of blanking samples older than 2s")
+	flag.BoolVar(&opts.ProfileInTargetDir, "profile-in-target-dir", false, "Write default profile outputs (cpu.prof, mem.prof) to the target's directory instead of the working directory")
+	flag.Func("top", "Print the N functions with the highest flat value in each profile after the run (default 10, -top=false to turn the report off)", func(s string) (err error) {
+		opts.TopN, err = peep.ParseTopCount(s)
+		return err
+	})
+	flag.IntVar(&opts.TopLines, "top-lines", 0, "With -top, also print the N hottest source lines of each top function")
+	flag.Func("list", "Print the source of the functions matching this regex, annotated with each line's flat and cum values, after the run (like pprof -list)", func(s string) (err error) {
+		opts.ListRegex, err = regexp.Compile(s)
+		return err
+	})
+	flag.IntVar(&opts.AllocSites, "alloc-sites", 0, "Print the N source lines that allocated the most bytes, from the heap profile after the run")
+	flag.StringVar(&opts.ReportFormat, "format", opts.ReportFormat, "Format of the -top report: text or json")
+	flag.BoolVar(&opts.Trace, "trace", false, "Also write an execution trace (trace.out) for go tool trace")
+	flag.StringVar(&opts.TraceFile, "trace-out", "", "Output file for the execution trace, with -trace or -trace-region")
+	flag.Func("trace-region", "Write an execution trace (trace.out) and wrap a function in a trace task and region: func=Name or func=Type.Method", func(s string) (err error) {
+		opts.TraceRegionFunc, err = peep.ParseTraceRegion(s)
+		return err
+	})
+	flag.IntVar(&opts.BestOf, "best-of", opts.BestOf, "Run the target N times and keep only the profiles of one run, chosen by -best-by")
+	flag.StringVar(&opts.BestBy, "best-by", opts.BestBy, "Which -best-of run to keep: fastest or median")
+	flag.BoolVar(&opts.RecoverPanic, "recover-panic", false, "Recover a panic in main to log it and record a last metrics sample, then re-panic")
+	flag.IntVar(&opts.CPUHz, "cpu-hz", 0, "CPU profiling rate in samples per second (default: the runtime's 100)")
+	flag.StringVar(&opts.Func, "func", "", "Instrument this function (Name or Type.Method) instead of main, e.g. a CLI subcommand's run function")
+	flag.IntVar(&opts.WarmCalls, "warm-calls", 0, "Start CPU profiling once the -func function has been called more than N times")
+	flag.StringVar(&opts.EmitPatchesDir, "emit-patches", "", "Write the instrumentation as unified diffs into this directory instead of running the target")
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "Print the instrumented main file (or -func file) to stdout instead of building and running the target")
+	flag.IntVar(&opts.AlertGoroutines, "alert-goroutines", 0, "Highlight the dashboard while the goroutine count exceeds N")
+	flag.Uint64Var(&opts.AlertAlloc, "alert-alloc", 0, "Highlight the dashboard while Alloc exceeds this many bytes")
+	flag.BoolVar(&opts.AlertSound, "alert-sound", false, "Also beep in the browser when a dashboard alert starts")
+	flag.BoolVar(&opts.Verbose, "verbose", false, "Also print the temp files written, the go command run and the imports added to the instrumented file")
+	flag.BoolVar(&opts.Quiet, "quiet", false, "Print only errors, none of peep's progress messages or warnings")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		fmt.Println("Usage: peep [-mem] [-cpu] [-cpu-out file] [-mem-out file] [-dash] [-port port] [-mark-regex regex] <main.go | package_dir> [program_args...]")
+		os.Exit(1)
+	}
+
+	// Get the target (file or directory) and any remaining arguments for the program
+	opts.Target = flag.Arg(0)
+	opts.ProgramArgs = flag.Args()[1:]
+	if len(opts.ProgramArgs) > 0 && opts.ProgramArgs[0] == "--" {
+		// peep -func=runServe ./cmd -- serve: the separator is not for the program
+		opts.ProgramArgs = opts.ProgramArgs[1:]
+	}
+
+	// -cpu or -mem alone selects that profile, neither selects both
+	opts.EnableCPU = cpuOnly || !memOnly
+	opts.EnableMem = memOnly || !cpuOnly
+	if opts.LivePprofAddr != "" && !cpuOnly && !memOnly {
+		// The live endpoint takes CPU profiles, which a CPU profile file would block
+		opts.EnableCPU = false
+	}
+	opts.LogsSize = logsKB * 1024
+	opts.DaemonSocket = os.Getenv(peep.DaemonDashboardEnv)
+
+	result, err := peep.Run(context.Background(), opts)
+	if err != nil {
+		// A failed program's exit code is passed on, for CI to tell from peep's own errors
+		log.Print(err)
+		os.Exit(max(result.ExitCode, 1))
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestPeepExitsWithTargetExitCode(t *testing.T) {
+	tempDir := t.TempDir()
+	peep := filepath.Join(tempDir, "peep")
+	if output, err := exec.Command("go", "build", "-o", peep, ".").CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build peep: %v\n%s", err, output)
+	}
+
+	testFile := filepath.Join(tempDir, "main.go")
+	content := "package main\n\nimport \"os\"\n\nfunc main() { os.Exit(3) }\n"
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	cmd := exec.Command(peep, "-cpu", "-cpu-out", filepath.Join(tempDir, "cpu.prof"), testFile)
+	output, err := cmd.CombinedOutput()
+	if code := cmd.ProcessState.ExitCode(); code != 3 {
+		t.Errorf("Expected peep to exit with the program's code 3, got %d (%v)\n%s", code, err, output)
+	}
+}
@@ -0,0 +1,31 @@
+package peep
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// DashboardConfig holds the alert thresholds the dashboard checks each
+// incoming sample against. A zero threshold is disabled.
+type DashboardConfig struct {
+	AlertGoroutines int    `json:"alertGoroutines,omitempty"`
+	AlertAlloc      uint64 `json:"alertAlloc,omitempty"` // bytes
+	AlertSound      bool   `json:"alertSound"`           // beep when a threshold is first crossed
+}
+
+// newDashboardConfig builds the dashboard config from opts
+func newDashboardConfig(opts Options) *DashboardConfig {
+	return &DashboardConfig{
+		AlertGoroutines: opts.AlertGoroutines,
+		AlertAlloc:      opts.AlertAlloc,
+		AlertSound:      opts.AlertSound,
+	}
+}
+
+// configHandler serves the dashboard config as JSON
+func configHandler(config *DashboardConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(config)
+	}
+}
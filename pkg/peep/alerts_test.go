@@ -0,0 +1,29 @@
+package peep
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConfigHandler(t *testing.T) {
+	config := newDashboardConfig(Options{AlertGoroutines: 500, AlertAlloc: 64 << 20, AlertSound: true})
+
+	rec := httptest.NewRecorder()
+	configHandler(config)(rec, httptest.NewRequest("GET", "/config", nil))
+
+	var got map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode config: %v", err)
+	}
+	if got["alertGoroutines"] != float64(500) || got["alertAlloc"] != float64(64<<20) || got["alertSound"] != true {
+		t.Errorf("Unexpected config: %s", rec.Body.String())
+	}
+
+	// Unset thresholds are left out so the dashboard skips them
+	rec = httptest.NewRecorder()
+	configHandler(newDashboardConfig(Options{}))(rec, httptest.NewRequest("GET", "/config", nil))
+	if body := rec.Body.String(); body != "{\"alertSound\":false}\n" {
+		t.Errorf("Expected only alertSound for an empty config, got %s", body)
+	}
+}
@@ -0,0 +1,133 @@
+package peep
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// AllocSite is one source line that allocates, aggregated over all stacks
+type AllocSite struct {
+	Function string
+	File     string
+	Line     int64
+	Bytes    int64
+	Objects  int64
+	Percent  float64 // of all allocated bytes
+}
+
+// sampleTypeIndex returns the index of the sample type called name, or -1
+func sampleTypeIndex(p *profile.Profile, name string) int {
+	for i, st := range p.SampleType {
+		if st.Type == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// allocSiteLine returns the frame responsible for a heap sample: the innermost
+// one outside the runtime, so make, new and append growth are attributed to
+// the line calling them. Samples without such a frame keep the leaf.
+func allocSiteLine(s *profile.Sample) (profile.Line, bool) {
+	var leaf profile.Line
+	found := false
+	for _, loc := range s.Location {
+		for _, line := range loc.Line {
+			if line.Function == nil {
+				continue
+			}
+			if !found {
+				leaf, found = line, true
+			}
+			if !strings.HasPrefix(line.Function.Name, "runtime.") {
+				return line, true
+			}
+		}
+	}
+	return leaf, found
+}
+
+// newAllocSites returns the n source lines that allocated the most bytes over
+// the run, from a heap profile's alloc_space and alloc_objects values
+func newAllocSites(p *profile.Profile, n int) ([]AllocSite, error) {
+	bytesIdx := sampleTypeIndex(p, "alloc_space")
+	objectsIdx := sampleTypeIndex(p, "alloc_objects")
+	if bytesIdx < 0 || objectsIdx < 0 {
+		return nil, fmt.Errorf("profile has no alloc_space and alloc_objects samples, is it a heap profile?")
+	}
+
+	type key struct {
+		function, file string
+		line           int64
+	}
+	sites := make(map[key]*AllocSite)
+	var total int64
+	for _, s := range p.Sample {
+		total += s.Value[bytesIdx]
+		line, ok := allocSiteLine(s)
+		if !ok {
+			continue
+		}
+		k := key{line.Function.Name, line.Function.Filename, line.Line}
+		site := sites[k]
+		if site == nil {
+			site = &AllocSite{Function: k.function, File: k.file, Line: k.line}
+			sites[k] = site
+		}
+		site.Bytes += s.Value[bytesIdx]
+		site.Objects += s.Value[objectsIdx]
+	}
+
+	result := make([]AllocSite, 0, len(sites))
+	for _, site := range sites {
+		if site.Bytes == 0 {
+			continue
+		}
+		site.Percent = percentOf(site.Bytes, total)
+		result = append(result, *site)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result, nil
+}
+
+// writeAllocSites prints the allocation sites with their file:line locations
+func writeAllocSites(w io.Writer, path string, sites []AllocSite) {
+	fmt.Fprintf(w, "[prof] Top allocation sites in %s (alloc_space):\n", path)
+	fmt.Fprintf(w, "[prof] %12s %10s %7s  %s\n", "bytes", "objects", "bytes%", "site")
+	for _, s := range sites {
+		fmt.Fprintf(w, "[prof] %12d %10d %6.2f%%  %s:%d %s\n", s.Bytes, s.Objects, s.Percent, s.File, s.Line, s.Function)
+	}
+	if len(sites) > 0 {
+		fmt.Fprintln(w, "[prof] Check why these escape with: go build -gcflags=-m")
+	}
+}
+
+// reportAllocSites prints the top opts.AllocSites allocation sites of the heap profile
+func reportAllocSites(w io.Writer, opts Options) error {
+	p, err := loadProfile(opts.MemFile)
+	if err != nil {
+		return err
+	}
+	sites, err := newAllocSites(p, opts.AllocSites)
+	if err != nil {
+		return fmt.Errorf("failed to report allocation sites of %s: %w", opts.MemFile, err)
+	}
+	writeAllocSites(w, opts.MemFile, sites)
+	return nil
+}
@@ -0,0 +1,103 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestNewAllocSites(t *testing.T) {
+	fn := func(id uint64, name, file string) *profile.Function {
+		return &profile.Function{ID: id, Name: name, Filename: file}
+	}
+	growslice := fn(1, "runtime.growslice", "/go/src/runtime/slice.go")
+	build := fn(2, "main.build", "/src/main.go")
+	parse := fn(3, "main.parse", "/src/parse.go")
+
+	loc := func(id uint64, f *profile.Function, line int64) *profile.Location {
+		return &profile.Location{ID: id, Line: []profile.Line{{Function: f, Line: line}}}
+	}
+	grow := loc(1, growslice, 177)
+	buildAppend := loc(2, build, 12)
+	buildMake := loc(3, build, 20)
+	parseNew := loc(4, parse, 7)
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "alloc_objects", Unit: "count"},
+			{Type: "alloc_space", Unit: "bytes"},
+			{Type: "inuse_objects", Unit: "count"},
+			{Type: "inuse_space", Unit: "bytes"},
+		},
+		Sample: []*profile.Sample{
+			// Append growth is attributed to the line calling append
+			{Location: []*profile.Location{grow, buildAppend}, Value: []int64{2, 600, 0, 0}},
+			{Location: []*profile.Location{grow, buildAppend, parseNew}, Value: []int64{1, 100, 0, 0}},
+			{Location: []*profile.Location{buildMake, parseNew}, Value: []int64{4, 200, 0, 0}},
+			{Location: []*profile.Location{parseNew}, Value: []int64{10, 100, 0, 0}},
+		},
+	}
+
+	sites, err := newAllocSites(p, 2)
+	if err != nil {
+		t.Fatalf("newAllocSites failed: %v", err)
+	}
+	want := []AllocSite{
+		{Function: "main.build", File: "/src/main.go", Line: 12, Bytes: 700, Objects: 3, Percent: 70},
+		{Function: "main.build", File: "/src/main.go", Line: 20, Bytes: 200, Objects: 4, Percent: 20},
+	}
+	if len(sites) != len(want) {
+		t.Fatalf("Expected %d sites, got %+v", len(want), sites)
+	}
+	for i := range want {
+		if sites[i] != want[i] {
+			t.Errorf("Site %d: expected %+v, got %+v", i, want[i], sites[i])
+		}
+	}
+
+	if _, err := newAllocSites(newStackProfile(map[string]int64{"main.main": 10}), 5); err == nil {
+		t.Error("Expected an error for a profile without allocation samples")
+	}
+}
+
+func TestReportAllocSitesResolvesSourceLines(t *testing.T) {
+	// Allocations well above the 512KiB sampling rate are always recorded
+	content := `package main
+
+var sink [][]byte
+
+func main() {
+	for i := 0; i < 20; i++ {
+		sink = append(sink, make([]byte, 1<<20))
+	}
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{MemFile: filepath.Join(tempDir, "mem.prof"), EnableMem: true, AllocSites: 3}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := reportAllocSites(&buf, opts); err != nil {
+		t.Fatalf("reportAllocSites failed: %v", err)
+	}
+	// Locations refer to the original file, not the instrumented copy
+	if !strings.Contains(buf.String(), testFile+":7 ") || !strings.Contains(buf.String(), " main.main") {
+		t.Errorf("Expected an allocation site at test.go:7 in main.main, got:\n%s", buf.String())
+	}
+}
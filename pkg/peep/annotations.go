@@ -0,0 +1,25 @@
+package peep
+
+import (
+	"io"
+	"regexp"
+	"time"
+)
+
+// Annotation marks a point on the metrics timeline
+type Annotation struct {
+	TimestampMS int64  `json:"timestampMs"`
+	Text        string `json:"text"`
+}
+
+// newMarkWriter creates a lineWriter that records lines matching re as annotations
+func newMarkWriter(out io.Writer, re *regexp.Regexp, annotations *eventLog[Annotation]) *lineWriter {
+	return newLineWriter(out, func(line []byte) {
+		if re.Match(line) {
+			annotations.Add(Annotation{
+				TimestampMS: time.Now().UnixMilli(),
+				Text:        string(line),
+			})
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package peep
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestMarkWriterForwardsAndRecords(t *testing.T) {
+	var out bytes.Buffer
+	annotations := &eventLog[Annotation]{}
+	w := newMarkWriter(&out, regexp.MustCompile(`^MARK`), annotations)
+
+	// Write lines split across several calls to exercise buffering
+	chunks := []string{"starting\nMARK phase", " one\nother line\n", "MARK done"}
+	for _, chunk := range chunks {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	w.Flush()
+
+	expectedOut := "starting\nMARK phase one\nother line\nMARK done"
+	if out.String() != expectedOut {
+		t.Errorf("Expected output to be forwarded unchanged, got %q", out.String())
+	}
+
+	entries := annotations.List()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 annotations, got %d", len(entries))
+	}
+	if entries[0].Text != "MARK phase one" {
+		t.Errorf("Expected first annotation 'MARK phase one', got %q", entries[0].Text)
+	}
+	if entries[1].Text != "MARK done" {
+		t.Errorf("Expected second annotation 'MARK done', got %q", entries[1].Text)
+	}
+	if entries[0].TimestampMS == 0 {
+		t.Error("Expected annotation to be timestamped")
+	}
+}
+
+func TestAnnotationsHandler(t *testing.T) {
+	annotations := &eventLog[Annotation]{}
+	annotations.Add(Annotation{TimestampMS: 1, Text: "request burst"})
+
+	rec := httptest.NewRecorder()
+	eventsHandler(annotations)(rec, httptest.NewRequest("GET", "/annotations", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON content type, got %q", ct)
+	}
+
+	var got []Annotation
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode annotations: %v", err)
+	}
+	if len(got) != 1 || got[0].Text != "request burst" {
+		t.Errorf("Unexpected annotations: %+v", got)
+	}
+}
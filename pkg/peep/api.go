@@ -0,0 +1,187 @@
+package peep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Result reports the outcome of Run
+type Result struct {
+	Files    []string // the profiles, trace and metrics files the run was set to write, as -manifest lists them
+	ExitCode int      // exit code of the target, or of the go command if the build failed; 0 on success, -1 if the run failed otherwise
+}
+
+// instrumentedTarget is the instrumented file of a target, along with what
+// running it needs
+type instrumentedTarget struct {
+	node     *ast.File
+	fset     *token.FileSet
+	file     string   // the file instrumented: the target itself, or the package's main or Func file
+	pkgFiles []string // every file of the package, nil for a single file
+}
+
+// instrumentTarget resolves opts.Target, a Go file or a package directory,
+// and injects the profiling code opts asks for into its main function or
+// opts.Func. A file outside any module sets opts.ResolveImports when it
+// imports more than the standard library.
+func instrumentTarget(opts *Options) (*instrumentedTarget, error) {
+	target, isDir, err := resolveTarget(opts.Target, *opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if isDir {
+		mainFile, allFiles, err := resolvePackage(target, *opts)
+		if err != nil {
+			return nil, err
+		}
+
+		// Process the main file, or the file declaring -func
+		instrumentedFile := mainFile
+		if opts.Func != "" {
+			if instrumentedFile, err = findFuncFile(allFiles, opts.Func); err != nil {
+				return nil, err
+			}
+		}
+		node, fset, err := processGoFile(instrumentedFile, *opts)
+		if err != nil {
+			return nil, err
+		}
+		return &instrumentedTarget{node: node, fset: fset, file: instrumentedFile, pkgFiles: allFiles}, nil
+	}
+
+	if opts.Generate {
+		return nil, fmt.Errorf("-generate requires a package directory")
+	}
+	if opts.Entry != "" {
+		return nil, fmt.Errorf("-entry requires a package directory")
+	}
+
+	if importsC(target) {
+		if err := checkCgo([]string{target}, *opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// go build only finds the standard library without a go.mod
+	if imports := nonStdImports(target); len(imports) > 0 && outsideModule(*opts) {
+		opts.log.logf(levelNormal, "%s is not inside a Go module, resolving %s to the latest versions", filepath.Base(target), strings.Join(imports, ", "))
+		opts.log.logf(levelNormal, "Hint: run go mod init and go mod tidy in its directory to pin the versions")
+		opts.ResolveImports = true
+	}
+
+	if opts.Func != "" {
+		if _, err := findFuncFile([]string{target}, opts.Func); err != nil {
+			return nil, err
+		}
+	}
+
+	node, fset, err := processGoFile(target, *opts)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedTarget{node: node, fset: fset, file: target}, nil
+}
+
+// run builds and runs the instrumented target
+func (t *instrumentedTarget) run(ctx context.Context, opts Options) error {
+	if t.pkgFiles != nil {
+		return writeAndExecutePackage(ctx, t.node, t.fset, t.file, t.pkgFiles, opts)
+	}
+	return writeAndExecute(ctx, t.node, t.fset, opts)
+}
+
+// Instrument returns the instrumented form of the file opts.Target names, or
+// of the main file of the package directory it names (the Func file if
+// opts.Func is set). opts is checked as Run checks it. Nothing is written or
+// run.
+func Instrument(opts Options) (*ast.File, *token.FileSet, error) {
+	if err := opts.validate(); err != nil {
+		return nil, nil, err
+	}
+	opts.setup()
+	cleanup, err := opts.openStdinTarget()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cleanup()
+
+	t, err := instrumentTarget(&opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t.node, t.fset, nil
+}
+
+// Run checks opts, instruments opts.Target, runs it and reports on the run as
+// the peep command does, printing its progress messages along the way. Start
+// from DefaultOptions for the command's defaults. Relative paths in opts are
+// resolved against the working directory, and the outputs and the metrics
+// file left empty get their default paths. With opts.MetricsChan set, each
+// sample is sent on it while the target runs, and it is closed before Run
+// returns, whether or not the target ran.
+func Run(ctx context.Context, opts Options) (Result, error) {
+	if opts.MetricsChan != nil {
+		defer close(opts.MetricsChan)
+	}
+	if err := opts.validate(); err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	opts.setup()
+	// Every error is returned through here, so the program read from stdin
+	// is removed however the run ends
+	cleanup, err := opts.openStdinTarget()
+	if err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	defer cleanup()
+
+	err = runTarget(ctx, opts)
+
+	result := Result{Files: manifestFiles(opts)}
+	var exitErr *targetExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.code
+	} else if err != nil {
+		result.ExitCode = -1
+	}
+	return result, err
+}
+
+// runTarget runs validated opts in the mode they select: the package's
+// Example or tests through go test, the instrumentation written as patches
+// or printed, or the instrumented target, once or -best-of times
+func runTarget(ctx context.Context, opts Options) error {
+	if opts.Example != "" {
+		return runExample(ctx, opts.Target, opts.Example, opts)
+	}
+	if opts.Test {
+		return runTests(ctx, opts.Target, opts)
+	}
+
+	t, err := instrumentTarget(&opts)
+	if err != nil {
+		return err
+	}
+	if opts.EmitPatchesDir != "" {
+		return emitPatches(opts.log.writer(), opts.EmitPatchesDir, t.file, t.node, t.fset, opts)
+	}
+	if opts.DryRun {
+		if err := printInstrumented(os.Stdout, t.node, t.fset); err != nil {
+			return err
+		}
+		opts.log.logf(levelNormal, "Dry run: printed the instrumented %s, nothing was built or run", t.file)
+		return nil
+	}
+
+	if opts.BestOf > 1 {
+		return runBestOf(opts.log.writer(), opts.BestOf, opts.BestBy, opts, func() error { return t.run(ctx, opts) })
+	}
+	return t.run(ctx, opts)
+}
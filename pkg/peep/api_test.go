@@ -0,0 +1,127 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"go/format"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	node, fset, err := Instrument(Options{Target: testFile, CPUFile: "cpu.prof", EnableCPU: true})
+	if err != nil {
+		t.Fatalf("Instrument failed: %v", err)
+	}
+	var out bytes.Buffer
+	if err := format.Node(&out, fset, node); err != nil {
+		t.Fatalf("Failed to format instrumented code: %v", err)
+	}
+	if !strings.Contains(out.String(), "pprof.StartCPUProfile") {
+		t.Errorf("Expected CPU profiling in main, got:\n%s", out.String())
+	}
+
+	if _, _, err := Instrument(Options{Target: testFile, Generate: true}); err == nil {
+		t.Error("Expected -generate to be rejected for a single file")
+	}
+}
+
+func TestRun(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	content := `package main
+
+import "os"
+
+func main() {
+	if len(os.Args) > 1 {
+		os.Exit(3)
+	}
+}
+`
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{Target: testFile, CPUFile: filepath.Join(tempDir, "cpu.prof"), EnableCPU: true}
+	result, err := Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.ExitCode != 0 || !slices.Equal(result.Files, []string{opts.CPUFile}) {
+		t.Errorf("Expected exit code 0 and %s, got %+v", opts.CPUFile, result)
+	}
+	if info, err := os.Stat(opts.CPUFile); err != nil || info.Size() == 0 {
+		t.Errorf("Expected a non-empty CPU profile: %v", err)
+	}
+
+	// The program's own exit code is reported, not go run's
+	opts.ProgramArgs = []string{"fail"}
+	result, err = Run(context.Background(), opts)
+	if err == nil || result.ExitCode != 3 {
+		t.Errorf("Expected the failing program to report exit code 3, got %d (%v)", result.ExitCode, err)
+	}
+}
+
+func TestRunSendsMetrics(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	content := `package main
+
+import "time"
+
+var sink [][]byte
+
+func main() {
+	for i := 0; i < 15; i++ {
+		sink = append(sink, make([]byte, 1<<20))
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+`
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	samples := make(chan Metrics)
+	var received []Metrics
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range samples {
+			received = append(received, m)
+		}
+	}()
+
+	opts := Options{Target: testFile, MemFile: filepath.Join(tempDir, "mem.prof"), EnableMem: true, MetricsChan: samples}
+	if _, err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// Run closes the channel, ending the range above
+	<-done
+
+	if len(received) == 0 {
+		t.Fatal("Expected at least one metrics sample")
+	}
+	if last := received[len(received)-1]; last.Alloc == 0 || last.TimestampMS == 0 {
+		t.Errorf("Expected a decoded sample, got %+v", last)
+	}
+}
+
+func TestRunClosesMetricsChanOnError(t *testing.T) {
+	samples := make(chan Metrics)
+	if _, err := Run(context.Background(), Options{Target: filepath.Join(t.TempDir(), "missing.go"), MetricsChan: samples}); err == nil {
+		t.Fatal("Expected Run to fail for a missing target")
+	}
+	if _, ok := <-samples; ok {
+		t.Error("Expected the channel to be closed")
+	}
+}
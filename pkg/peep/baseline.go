@@ -0,0 +1,137 @@
+package peep
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Baseline holds the summary metrics of a run, compared across commits to
+// track high-level resource trends without diffing profiles
+type Baseline struct {
+	PeakAlloc  uint64 `json:"peakAlloc"`
+	Goroutines int    `json:"goroutines"`
+	NumGC      uint32 `json:"numGC"`
+}
+
+// metricDelta is the change of one summary metric relative to the baseline
+type metricDelta struct {
+	Name     string
+	Baseline float64
+	Current  float64
+	Percent  float64 // +Inf when the baseline value is zero and the current one is not
+}
+
+// newBaseline extracts the summary metrics from a final snapshot
+func newBaseline(m *Metrics) Baseline {
+	return Baseline{
+		PeakAlloc:  m.PeakAlloc,
+		Goroutines: m.Goroutines,
+		NumGC:      m.NumGC,
+	}
+}
+
+// loadBaseline reads a baseline written by saveBaseline
+func loadBaseline(path string) (Baseline, error) {
+	var b Baseline
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return b, fmt.Errorf("failed to read baseline: %w", err)
+	}
+	if err := json.Unmarshal(data, &b); err != nil {
+		return b, fmt.Errorf("failed to parse baseline %s: %w", path, err)
+	}
+	return b, nil
+}
+
+// saveBaseline writes b to path as JSON
+func saveBaseline(path string, b Baseline) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode baseline: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write baseline: %w", err)
+	}
+	return nil
+}
+
+// percentChange returns how much current differs from baseline, in percent
+func percentChange(baseline, current float64) float64 {
+	if baseline == 0 {
+		if current == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (current - baseline) / baseline * 100
+}
+
+// compareBaseline computes the change of each summary metric from baseline to current
+func compareBaseline(baseline, current Baseline) []metricDelta {
+	pairs := []struct {
+		name              string
+		baseline, current float64
+	}{
+		{"PeakAlloc", float64(baseline.PeakAlloc), float64(current.PeakAlloc)},
+		{"Goroutines", float64(baseline.Goroutines), float64(current.Goroutines)},
+		{"NumGC", float64(baseline.NumGC), float64(current.NumGC)},
+	}
+
+	deltas := make([]metricDelta, 0, len(pairs))
+	for _, p := range pairs {
+		deltas = append(deltas, metricDelta{
+			Name:     p.name,
+			Baseline: p.baseline,
+			Current:  p.current,
+			Percent:  percentChange(p.baseline, p.current),
+		})
+	}
+	return deltas
+}
+
+// reportBaseline prints the deltas and returns an error naming every metric
+// that grew by more than threshold percent. Decreases never fail the check.
+func reportBaseline(w io.Writer, deltas []metricDelta, threshold float64) error {
+	fmt.Fprintln(w, "[prof] Metrics compared to baseline:")
+
+	var exceeded []string
+	for _, d := range deltas {
+		marker := ""
+		if d.Percent > threshold {
+			marker = " (exceeds threshold)"
+			exceeded = append(exceeded, d.Name)
+		}
+		fmt.Fprintf(w, "[prof]   %-11s %.0f -> %.0f (%+.1f%%)%s\n", d.Name+":", d.Baseline, d.Current, d.Percent, marker)
+	}
+
+	if len(exceeded) > 0 {
+		return fmt.Errorf("metrics exceeded the %.1f%% baseline threshold: %v", threshold, exceeded)
+	}
+	return nil
+}
+
+// checkBaseline saves and/or compares the run's summary metrics as opts requests
+func checkBaseline(w io.Writer, m *Metrics, opts Options) error {
+	current := newBaseline(m)
+
+	if opts.BaselineFile != "" {
+		baseline, err := loadBaseline(opts.BaselineFile)
+		if err != nil {
+			return err
+		}
+		if err := reportBaseline(w, compareBaseline(baseline, current), opts.BaselineThreshold); err != nil {
+			return err
+		}
+	}
+
+	if opts.SaveBaselineFile != "" {
+		if err := saveBaseline(opts.SaveBaselineFile, current); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "[prof] Baseline saved to %s\n", opts.SaveBaselineFile)
+	}
+	return nil
+}
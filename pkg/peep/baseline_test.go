@@ -0,0 +1,86 @@
+package peep
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareBaseline(t *testing.T) {
+	baseline := Baseline{PeakAlloc: 100, Goroutines: 4, NumGC: 0}
+	current := Baseline{PeakAlloc: 150, Goroutines: 3, NumGC: 2}
+
+	deltas := compareBaseline(baseline, current)
+	if len(deltas) != 3 {
+		t.Fatalf("Expected 3 deltas, got %d", len(deltas))
+	}
+	if deltas[0].Name != "PeakAlloc" || deltas[0].Percent != 50 {
+		t.Errorf("Expected PeakAlloc +50%%, got %+v", deltas[0])
+	}
+	if deltas[1].Name != "Goroutines" || deltas[1].Percent != -25 {
+		t.Errorf("Expected Goroutines -25%%, got %+v", deltas[1])
+	}
+	if deltas[2].Name != "NumGC" || !math.IsInf(deltas[2].Percent, 1) {
+		t.Errorf("Expected NumGC growth from zero to be +Inf, got %+v", deltas[2])
+	}
+}
+
+func TestReportBaselineThreshold(t *testing.T) {
+	deltas := compareBaseline(
+		Baseline{PeakAlloc: 100, Goroutines: 10, NumGC: 10},
+		Baseline{PeakAlloc: 105, Goroutines: 5, NumGC: 20},
+	)
+
+	var out bytes.Buffer
+	err := reportBaseline(&out, deltas, 10)
+	if err == nil {
+		t.Fatal("Expected NumGC growth of 100% to exceed a 10% threshold")
+	}
+	if !strings.Contains(err.Error(), "NumGC") || strings.Contains(err.Error(), "PeakAlloc") {
+		t.Errorf("Expected only NumGC to be reported, got %v", err)
+	}
+	if !strings.Contains(out.String(), "PeakAlloc:  100 -> 105 (+5.0%)") {
+		t.Errorf("Expected PeakAlloc delta in report, got:\n%s", out.String())
+	}
+
+	// Decreases and growth within the threshold pass
+	out.Reset()
+	if err := reportBaseline(&out, deltas, 100); err != nil {
+		t.Errorf("Expected deltas within 100%% to pass, got %v", err)
+	}
+}
+
+func TestCheckBaselineSaveAndCompare(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	first := &Metrics{PeakAlloc: 1000, Goroutines: 2, NumGC: 4}
+
+	var out bytes.Buffer
+	if err := checkBaseline(&out, first, Options{SaveBaselineFile: path}); err != nil {
+		t.Fatalf("Failed to save baseline: %v", err)
+	}
+
+	saved, err := loadBaseline(path)
+	if err != nil {
+		t.Fatalf("Failed to load baseline: %v", err)
+	}
+	if saved != newBaseline(first) {
+		t.Errorf("Expected saved baseline %+v, got %+v", newBaseline(first), saved)
+	}
+
+	regressed := &Metrics{PeakAlloc: 2000, Goroutines: 2, NumGC: 4}
+	if err := checkBaseline(&out, regressed, Options{BaselineFile: path, BaselineThreshold: 10}); err == nil {
+		t.Error("Expected doubled peak alloc to fail the baseline check")
+	}
+
+	if err := checkBaseline(&out, first, Options{BaselineFile: path, BaselineThreshold: 10}); err != nil {
+		t.Errorf("Expected identical metrics to pass, got %v", err)
+	}
+}
+
+func TestLoadBaselineMissing(t *testing.T) {
+	if _, err := loadBaseline(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for missing baseline")
+	}
+}
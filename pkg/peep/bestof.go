@@ -0,0 +1,124 @@
+package peep
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Run selection criteria accepted by -best-by
+const (
+	bestByFastest = "fastest"
+	bestByMedian  = "median"
+)
+
+// runPath returns where a run's copy of an output is kept: cpu.prof becomes cpu-2.prof
+func runPath(path string, run int) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), run, ext)
+}
+
+// runOutputs lists the files the instrumented target writes for one run
+func runOutputs(opts Options) []string {
+	var outputs []string
+	if opts.EnableCPU {
+		outputs = append(outputs, opts.CPUFile)
+	}
+	if opts.EnableMem {
+		outputs = append(outputs, opts.MemFile)
+	}
+	if opts.EnableMutex {
+		outputs = append(outputs, opts.MutexFile)
+	}
+	if opts.PostInitHeapFile != "" {
+		outputs = append(outputs, opts.PostInitHeapFile)
+	}
+	if opts.TraceFile != "" {
+		outputs = append(outputs, opts.TraceFile)
+	}
+	return outputs
+}
+
+// runDuration measures a finished run. The CPU profile's duration covers only
+// the program, so it is preferred over the wall time, which includes the
+// build.
+func runDuration(opts Options, wall time.Duration) time.Duration {
+	if opts.EnableCPU {
+		if p, err := loadProfile(opts.CPUFile); err == nil && p.DurationNanos > 0 {
+			return time.Duration(p.DurationNanos)
+		}
+	}
+	return wall
+}
+
+// selectRun returns the index of the run chosen by criterion: the fastest, or
+// the median (the lower of the two middle runs for an even count)
+func selectRun(durations []time.Duration, criterion string) int {
+	order := make([]int, len(durations))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return durations[order[a]] < durations[order[b]] })
+
+	if criterion == bestByMedian {
+		return order[(len(order)-1)/2]
+	}
+	return order[0]
+}
+
+// reportRuns prints every run's duration, the spread and the run that was kept
+func reportRuns(w io.Writer, durations []time.Duration, kept int, criterion string) {
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for i, d := range durations {
+		fmt.Fprintf(w, "[prof]   run %d: %s\n", i+1, d)
+	}
+	fmt.Fprintf(w, "[prof] Spread: min %s, median %s, max %s\n", sorted[0], sorted[(len(sorted)-1)/2], sorted[len(sorted)-1])
+	fmt.Fprintf(w, "[prof] Kept the profiles of run %d of %d (%s)\n", kept+1, len(durations), criterion)
+}
+
+// runBestOf runs the target n times through run and keeps only the outputs of
+// the run selected by criterion, at their usual paths. The other runs'
+// outputs are removed, also when a run fails.
+func runBestOf(w io.Writer, n int, criterion string, opts Options, run func() error) error {
+	outputs := runOutputs(opts)
+	var durations []time.Duration
+
+	// Remove the runs' copies; the selected run's are moved back before this runs
+	defer func() {
+		for i := range durations {
+			for _, path := range outputs {
+				os.Remove(runPath(path, i+1))
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(w, "[prof] Run %d of %d\n", i+1, n)
+		start := time.Now()
+		if err := run(); err != nil {
+			return fmt.Errorf("run %d of %d: %w", i+1, n, err)
+		}
+		durations = append(durations, runDuration(opts, time.Since(start)))
+
+		for _, path := range outputs {
+			if err := os.Rename(path, runPath(path, i+1)); err != nil {
+				return fmt.Errorf("failed to keep the output of run %d: %w", i+1, err)
+			}
+		}
+	}
+
+	kept := selectRun(durations, criterion)
+	for _, path := range outputs {
+		if err := os.Rename(runPath(path, kept+1), path); err != nil {
+			return fmt.Errorf("failed to restore the output of run %d: %w", kept+1, err)
+		}
+	}
+	reportRuns(w, durations, kept, criterion)
+	return nil
+}
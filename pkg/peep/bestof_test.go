@@ -0,0 +1,82 @@
+package peep
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunPath(t *testing.T) {
+	if got := runPath("/out/cpu.prof", 2); got != "/out/cpu-2.prof" {
+		t.Errorf("Expected /out/cpu-2.prof, got %s", got)
+	}
+	if got := runPath("trace.out", 10); got != "trace-10.out" {
+		t.Errorf("Expected trace-10.out, got %s", got)
+	}
+}
+
+func TestSelectRun(t *testing.T) {
+	durations := []time.Duration{3 * time.Second, time.Second, 4 * time.Second, 2 * time.Second}
+	if got := selectRun(durations, bestByFastest); got != 1 {
+		t.Errorf("Expected the fastest run to be run 1, got %d", got)
+	}
+	// The lower of the two middle runs (2s) for an even count
+	if got := selectRun(durations, bestByMedian); got != 3 {
+		t.Errorf("Expected the median run to be run 3, got %d", got)
+	}
+}
+
+func TestRunBestOfKeepsSelectedRun(t *testing.T) {
+	// Memory-only runs are timed by wall time, so each run sleeps for a set time
+	memFile := filepath.Join(t.TempDir(), "mem.prof")
+	opts := Options{EnableMem: true, MemFile: memFile}
+	sleeps := []time.Duration{60 * time.Millisecond, 10 * time.Millisecond, 120 * time.Millisecond}
+
+	run := 0
+	var out bytes.Buffer
+	err := runBestOf(&out, len(sleeps), bestByFastest, opts, func() error {
+		time.Sleep(sleeps[run])
+		run++
+		return os.WriteFile(memFile, []byte{byte('0' + run)}, 0o644)
+	})
+	if err != nil {
+		t.Fatalf("runBestOf failed: %v", err)
+	}
+
+	kept, err := os.ReadFile(memFile)
+	if err != nil || string(kept) != "2" {
+		t.Errorf("Expected the profile of run 2 to be kept, got %q (%v)", kept, err)
+	}
+	for i := 1; i <= len(sleeps); i++ {
+		if _, err := os.Stat(runPath(memFile, i)); !os.IsNotExist(err) {
+			t.Errorf("Expected the copy of run %d to be removed", i)
+		}
+	}
+	if !strings.Contains(out.String(), "Kept the profiles of run 2 of 3 (fastest)") {
+		t.Errorf("Expected the kept run to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestRunBestOfCleansUpOnFailure(t *testing.T) {
+	memFile := filepath.Join(t.TempDir(), "mem.prof")
+	opts := Options{EnableMem: true, MemFile: memFile}
+
+	run := 0
+	err := runBestOf(&bytes.Buffer{}, 3, bestByFastest, opts, func() error {
+		run++
+		if run == 2 {
+			return errors.New("boom")
+		}
+		return os.WriteFile(memFile, []byte("profile"), 0o644)
+	})
+	if err == nil || !strings.Contains(err.Error(), "run 2 of 3") {
+		t.Errorf("Expected the failing run to be reported, got %v", err)
+	}
+	if _, err := os.Stat(runPath(memFile, 1)); !os.IsNotExist(err) {
+		t.Error("Expected the first run's copy to be removed after the failure")
+	}
+}
@@ -0,0 +1,378 @@
+package peep
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// Metrics -bisect can search for a regression in
+const (
+	bisectByDuration  = "duration"
+	bisectByCPU       = "cpu"
+	bisectByPeakAlloc = "peak-alloc"
+)
+
+// revisionSource lists and checks out the revisions bisect measures. gitRepo
+// implements it with git worktrees; tests substitute a fake.
+type revisionSource interface {
+	// Revisions resolves good and bad and returns good followed by every
+	// commit on the ancestry path up to and including bad, oldest first
+	Revisions(good, bad string) ([]string, error)
+	// Checkout makes rev available in a directory until remove is called
+	Checkout(rev string) (dir string, remove func(), err error)
+	// Describe returns a one-line summary of rev for the report
+	Describe(rev string) string
+}
+
+// gitRepo is the revisionSource of the git repository containing root
+type gitRepo struct {
+	root string
+}
+
+// git runs a git command in the repository and returns its trimmed output
+func (g gitRepo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.root
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w\n%s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// findGitRepo returns the repository containing dir
+func findGitRepo(dir string) (gitRepo, error) {
+	root, err := gitRepo{root: dir}.git("rev-parse", "--show-toplevel")
+	if err != nil {
+		return gitRepo{}, fmt.Errorf("bisect must run inside a git repository: %w", err)
+	}
+	return gitRepo{root: root}, nil
+}
+
+func (g gitRepo) Revisions(good, bad string) ([]string, error) {
+	var resolved []string
+	for _, rev := range []string{good, bad} {
+		hash, err := g.git("rev-parse", "--verify", rev+"^{commit}")
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, hash)
+	}
+
+	out, err := g.git("rev-list", "--reverse", "--ancestry-path", resolved[0]+".."+resolved[1])
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, fmt.Errorf("%s is not a descendant of %s", bad, good)
+	}
+	return append(resolved[:1], strings.Fields(out)...), nil
+}
+
+func (g gitRepo) Checkout(rev string) (string, func(), error) {
+	parent, err := os.MkdirTemp("", "peep-bisect-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create worktree directory: %w", err)
+	}
+
+	dir := filepath.Join(parent, shortRev(rev))
+	if _, err := g.git("worktree", "add", "--detach", dir, rev); err != nil {
+		os.RemoveAll(parent)
+		return "", nil, err
+	}
+
+	remove := func() {
+		g.git("worktree", "remove", "--force", dir)
+		os.RemoveAll(parent)
+	}
+	return dir, remove, nil
+}
+
+func (g gitRepo) Describe(rev string) string {
+	out, err := g.git("log", "-1", "--format=%h %s", rev)
+	if err != nil {
+		return shortRev(rev)
+	}
+	return out
+}
+
+// shortRev abbreviates a commit hash for file names and messages
+func shortRev(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
+
+// revisionResult is what one revision measured
+type revisionResult struct {
+	Duration time.Duration // wall time covered by the CPU profile
+	CPU      time.Duration // CPU time sampled across all goroutines
+	Baseline Baseline
+}
+
+// metric returns the value of the named metric for the regression search
+func (r revisionResult) metric(name string) float64 {
+	switch name {
+	case bisectByCPU:
+		return float64(r.CPU)
+	case bisectByPeakAlloc:
+		return float64(r.Baseline.PeakAlloc)
+	}
+	return float64(r.Duration)
+}
+
+// readRevisionResult reads the CPU profile and baseline a measured run wrote
+func readRevisionResult(cpuPath, baselinePath string) (revisionResult, error) {
+	var r revisionResult
+
+	f, err := os.Open(cpuPath)
+	if err != nil {
+		return r, fmt.Errorf("failed to open CPU profile: %w", err)
+	}
+	defer f.Close()
+	p, err := profile.Parse(f)
+	if err != nil {
+		return r, fmt.Errorf("failed to parse CPU profile %s: %w", cpuPath, err)
+	}
+
+	r.Duration = time.Duration(p.DurationNanos)
+	if i := sampleTypeIndex(p, "cpu"); i >= 0 {
+		for _, s := range p.Sample {
+			r.CPU += time.Duration(s.Value[i])
+		}
+	}
+
+	r.Baseline, err = loadBaseline(baselinePath)
+	return r, err
+}
+
+// bisectMeasurer measures revisions by running peep itself on the target in
+// each checkout, keeping every revision's profile and baseline in outDir
+type bisectMeasurer struct {
+	exe    string
+	target string // the target relative to the repository root
+	args   []string
+	outDir string
+	count  int
+}
+
+// measure runs the target in dir count times and returns the median run
+func (m bisectMeasurer) measure(rev, dir string) (revisionResult, error) {
+	var results []revisionResult
+	var durations []time.Duration
+	for i := 1; i <= m.count; i++ {
+		name := shortRev(rev)
+		if m.count > 1 {
+			name = fmt.Sprintf("%s_%d", name, i)
+		}
+		cpuPath := filepath.Join(m.outDir, name+".cpu.prof")
+		baselinePath := filepath.Join(m.outDir, name+".baseline.json")
+
+		args := []string{"-cpu", "-cpu-out", cpuPath, "-save-baseline", baselinePath, "./" + filepath.ToSlash(m.target)}
+		cmd := exec.Command(m.exe, append(args, m.args...)...)
+		cmd.Dir = dir
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			if interrupted.Load() {
+				return revisionResult{}, errInterrupted
+			}
+			return revisionResult{}, fmt.Errorf("failed to run %s at %s: %w\n%s", m.target, shortRev(rev), err, out.String())
+		}
+
+		r, err := readRevisionResult(cpuPath, baselinePath)
+		if err != nil {
+			return r, err
+		}
+		results = append(results, r)
+		durations = append(durations, r.Duration)
+	}
+	return results[selectRun(durations, bestByMedian)], nil
+}
+
+// bisector measures revisions from src and searches them for a regression
+type bisector struct {
+	w       io.Writer
+	src     revisionSource
+	measure func(rev, dir string) (revisionResult, error)
+}
+
+// measureRevision checks out rev, measures it and removes the checkout
+func (b bisector) measureRevision(rev string) (revisionResult, error) {
+	fmt.Fprintf(b.w, "[prof] Measuring %s\n", b.src.Describe(rev))
+	dir, remove, err := b.src.Checkout(rev)
+	if err != nil {
+		return revisionResult{}, err
+	}
+	defer remove()
+	return b.measure(rev, dir)
+}
+
+// compareRevisions computes the change of each metric from good to bad
+func compareRevisions(good, bad revisionResult) []metricDelta {
+	deltas := []metricDelta{
+		{Name: "Duration", Baseline: good.Duration.Seconds(), Current: bad.Duration.Seconds()},
+		{Name: "CPU", Baseline: good.CPU.Seconds(), Current: bad.CPU.Seconds()},
+	}
+	for i := range deltas {
+		deltas[i].Percent = percentChange(deltas[i].Baseline, deltas[i].Current)
+	}
+	return append(deltas, compareBaseline(good.Baseline, bad.Baseline)...)
+}
+
+// reportRevisions prints the deltas between the good and bad revisions
+func reportRevisions(w io.Writer, good, bad string, deltas []metricDelta) {
+	fmt.Fprintf(w, "[prof] %s -> %s:\n", good, bad)
+	for _, d := range deltas {
+		if d.Name == "Duration" || d.Name == "CPU" {
+			fmt.Fprintf(w, "[prof]   %-11s %.3fs -> %.3fs (%+.1f%%)\n", d.Name+":", d.Baseline, d.Current, d.Percent)
+			continue
+		}
+		fmt.Fprintf(w, "[prof]   %-11s %.0f -> %.0f (%+.1f%%)\n", d.Name+":", d.Baseline, d.Current, d.Percent)
+	}
+}
+
+// regressed reports whether by grew by more than threshold percent from good to r
+func regressed(good, r revisionResult, by string, threshold float64) bool {
+	return percentChange(good.metric(by), r.metric(by)) > threshold
+}
+
+// run measures the first and last of revs and reports the change. With search
+// set and a regression of by above threshold between them, it binary searches
+// revs for the first revision with that regression and returns it.
+func (b bisector) run(revs []string, by string, threshold float64, search bool) (string, error) {
+	good, err := b.measureRevision(revs[0])
+	if err != nil {
+		return "", err
+	}
+	bad, err := b.measureRevision(revs[len(revs)-1])
+	if err != nil {
+		return "", err
+	}
+	reportRevisions(b.w, b.src.Describe(revs[0]), b.src.Describe(revs[len(revs)-1]), compareRevisions(good, bad))
+
+	if !search {
+		return "", nil
+	}
+	if !regressed(good, bad, by, threshold) {
+		fmt.Fprintf(b.w, "[prof] No %s regression above %.1f%%, nothing to bisect\n", by, threshold)
+		return "", nil
+	}
+
+	// revs[lo] is known good and revs[hi] known bad
+	lo, hi := 0, len(revs)-1
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		r, err := b.measureRevision(revs[mid])
+		if err != nil {
+			return "", err
+		}
+		if regressed(good, r, by, threshold) {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	fmt.Fprintf(b.w, "[prof] First revision with a %s regression above %.1f%%: %s\n", by, threshold, b.src.Describe(revs[hi]))
+	return revs[hi], nil
+}
+
+// RunBisect implements the bisect subcommand
+func RunBisect(args []string) error {
+	fs := flag.NewFlagSet("bisect", flag.ExitOnError)
+	search := fs.Bool("bisect", false, "Binary search the commits between the revisions for the first one with the regression")
+	by := fs.String("by", bisectByDuration, "Metric to bisect on: duration, cpu or peak-alloc")
+	threshold := fs.Float64("threshold", 10, "Percent increase over the good revision that counts as a regression")
+	count := fs.Int("count", 1, "Runs per revision; the median run by duration is used")
+	outDir := fs.String("out", "peep-bisect", "Directory for each revision's CPU profile and baseline")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: peep bisect [flags] <good-rev> <bad-rev> <target> [args...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 3 {
+		fs.Usage()
+		return fmt.Errorf("bisect needs a good revision, a bad revision and a target")
+	}
+	switch *by {
+	case bisectByDuration, bisectByCPU, bisectByPeakAlloc:
+	default:
+		return fmt.Errorf("-by must be duration, cpu or peak-alloc, got %q", *by)
+	}
+	if *count < 1 {
+		return fmt.Errorf("-count must be at least 1")
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	repo, err := findGitRepo(workDir)
+	if err != nil {
+		return err
+	}
+
+	// Each checkout is a different directory, so the target is resolved
+	// relative to the repository root
+	target, err := filepath.Abs(fs.Arg(2))
+	if err != nil {
+		return fmt.Errorf("failed to resolve target: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(target); err == nil {
+		target = resolved
+	}
+	target, err = filepath.Rel(repo.root, target)
+	if err != nil || target == ".." || strings.HasPrefix(target, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("target %s is outside the repository %s", fs.Arg(2), repo.root)
+	}
+
+	revs, err := repo.Revisions(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the peep executable: %w", err)
+	}
+	out, err := filepath.Abs(*outDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve -out: %w", err)
+	}
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return fmt.Errorf("failed to create -out directory: %w", err)
+	}
+
+	CatchInterrupts()
+
+	m := bisectMeasurer{exe: exe, target: target, args: fs.Args()[3:], outDir: out, count: *count}
+	b := bisector{w: os.Stdout, src: repo, measure: m.measure}
+	log := newLogger(os.Stdout, levelNormal)
+	log.logf(levelNormal, "%d commits between %s and %s", len(revs)-1, fs.Arg(0), fs.Arg(1))
+	if _, err := b.run(revs, *by, *threshold, *search); err != nil {
+		return err
+	}
+
+	good, bad := shortRev(revs[0]), shortRev(revs[len(revs)-1])
+	if *count > 1 {
+		good, bad = good+"_1", bad+"_1"
+	}
+	log.logf(levelNormal, "Compare the profiles with: go tool pprof -diff_base %s %s",
+		filepath.Join(*outDir, good+".cpu.prof"), filepath.Join(*outDir, bad+".cpu.prof"))
+	return nil
+}
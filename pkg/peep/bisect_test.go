@@ -0,0 +1,128 @@
+package peep
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRevisions is a revisionSource over named revisions without git
+type fakeRevisions struct {
+	revs    []string
+	checked []string
+}
+
+func (f *fakeRevisions) Revisions(good, bad string) ([]string, error) { return f.revs, nil }
+
+func (f *fakeRevisions) Checkout(rev string) (string, func(), error) {
+	f.checked = append(f.checked, rev)
+	return "/checkout/" + rev, func() {}, nil
+}
+
+func (f *fakeRevisions) Describe(rev string) string { return rev }
+
+func TestBisectorFindsFirstRegressingRevision(t *testing.T) {
+	src := &fakeRevisions{revs: []string{"c0", "c1", "c2", "c3", "c4", "c5", "c6", "c7", "c8"}}
+	measure := func(rev, dir string) (revisionResult, error) {
+		if dir != "/checkout/"+rev {
+			t.Errorf("Expected %s to be measured in its checkout, got %s", rev, dir)
+		}
+		if rev >= "c5" {
+			return revisionResult{Duration: 150 * time.Millisecond}, nil
+		}
+		return revisionResult{Duration: 100 * time.Millisecond}, nil
+	}
+
+	b := bisector{w: io.Discard, src: src, measure: measure}
+	found, err := b.run(src.revs, bisectByDuration, 10, true)
+	if err != nil {
+		t.Fatalf("bisect failed: %v", err)
+	}
+	if found != "c5" {
+		t.Errorf("Expected c5 to be the first regressing revision, got %q", found)
+	}
+	// good and bad, then log2 of the 7 commits between them
+	if len(src.checked) != 5 {
+		t.Errorf("Expected 5 revisions to be measured, got %v", src.checked)
+	}
+}
+
+func TestBisectorWithoutRegression(t *testing.T) {
+	src := &fakeRevisions{revs: []string{"c0", "c1", "c2"}}
+	measure := func(rev, dir string) (revisionResult, error) {
+		return revisionResult{Duration: 100 * time.Millisecond, Baseline: Baseline{PeakAlloc: 1 << 20}}, nil
+	}
+
+	b := bisector{w: io.Discard, src: src, measure: measure}
+	found, err := b.run(src.revs, bisectByPeakAlloc, 10, true)
+	if err != nil {
+		t.Fatalf("bisect failed: %v", err)
+	}
+	if found != "" || len(src.checked) != 2 {
+		t.Errorf("Expected only good and bad to be measured and nothing found, got %q after %v", found, src.checked)
+	}
+}
+
+func TestGitRepoRevisionsAndCheckout(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=peep", "-c", "user.email=peep@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	for _, version := range []string{"1", "2", "3"} {
+		if err := os.WriteFile(filepath.Join(dir, "version"), []byte(version), 0o644); err != nil {
+			t.Fatalf("Failed to write version: %v", err)
+		}
+		git("add", "version")
+		git("commit", "-q", "-m", "version "+version)
+	}
+
+	repo, err := findGitRepo(dir)
+	if err != nil {
+		t.Fatalf("findGitRepo failed: %v", err)
+	}
+	revs, err := repo.Revisions("HEAD~2", "HEAD")
+	if err != nil {
+		t.Fatalf("Revisions failed: %v", err)
+	}
+	if len(revs) != 3 || revs[2] != git("rev-parse", "HEAD") {
+		t.Fatalf("Expected the 3 commits oldest first, got %v", revs)
+	}
+	if _, err := repo.Revisions("HEAD", "HEAD~2"); err == nil {
+		t.Error("Expected an error when bad is not a descendant of good")
+	}
+
+	checkout, remove, err := repo.Checkout(revs[1])
+	if err != nil {
+		t.Fatalf("Checkout failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(checkout, "version"))
+	if err != nil || string(data) != "2" {
+		t.Errorf("Expected the checkout to hold version 2, got %q (%v)", data, err)
+	}
+	if desc := repo.Describe(revs[1]); !strings.HasSuffix(desc, " version 2") {
+		t.Errorf("Expected the description to hold the subject, got %q", desc)
+	}
+
+	remove()
+	if _, err := os.Stat(checkout); !os.IsNotExist(err) {
+		t.Errorf("Expected the checkout to be removed, got %v", err)
+	}
+	if list := git("worktree", "list"); strings.Contains(list, checkout) {
+		t.Errorf("Expected the worktree to be unregistered, got:\n%s", list)
+	}
+}
@@ -0,0 +1,64 @@
+package peep
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// cgoEnabled reports whether the go command has cgo enabled in the environment
+// it gets for opts
+func cgoEnabled(opts Options) bool {
+	cmd := exec.Command("go", "env", "CGO_ENABLED")
+	cmd.Env = goEnv(opts)
+	out, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(out)) == "1"
+}
+
+// importsC reports whether the Go file at path imports "C"
+func importsC(path string) bool {
+	node, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+	if err != nil {
+		return false
+	}
+	for _, imp := range node.Imports {
+		if imp.Path.Value == `"C"` {
+			return true
+		}
+	}
+	return false
+}
+
+// cgoRequiredFiles lists the package files that need cgo. With cgo disabled
+// go list reports them as ignored rather than as CgoFiles, so ignored files
+// that would build with cgo enabled and import "C" are included too.
+func cgoRequiredFiles(pkgInfo *PackageInfo, opts Options) []string {
+	var files []string
+	for _, file := range pkgInfo.CgoFiles {
+		files = append(files, filepath.Join(pkgInfo.Dir, file))
+	}
+
+	ctx := buildContext(opts)
+	ctx.CgoEnabled = true
+	for _, file := range pkgInfo.IgnoredGoFiles {
+		if ok, err := ctx.MatchFile(pkgInfo.Dir, file); err != nil || !ok {
+			continue
+		}
+		if path := filepath.Join(pkgInfo.Dir, file); importsC(path) {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// checkCgo turns the linker errors go build reports for cgo files built with
+// CGO_ENABLED=0 into an actionable message
+func checkCgo(files []string, opts Options) error {
+	if len(files) == 0 || cgoEnabled(opts) {
+		return nil
+	}
+	return fmt.Errorf("%s uses cgo but cgo is disabled (CGO_ENABLED=0)\nHint: set CGO_ENABLED=1 or pass -cgo", filepath.Base(files[0]))
+}
@@ -0,0 +1,75 @@
+package peep
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCgoPackage creates a main package whose main function lives in a cgo file
+func writeCgoPackage(t *testing.T) string {
+	t.Helper()
+	tempDir := t.TempDir()
+
+	files := map[string]string{
+		"go.mod": "module cgopackage\n\ngo 1.21\n",
+		"main.go": `package main
+
+import "C"
+
+func main() {}
+`,
+		// Never built on this platform, so it must not count as requiring cgo
+		"other.go": `//go:build plan9
+
+package main
+
+import "C"
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	return tempDir
+}
+
+func TestResolvePackageCgoDisabled(t *testing.T) {
+	dir := writeCgoPackage(t)
+	t.Setenv("CGO_ENABLED", "0")
+
+	_, _, err := resolvePackage(dir, Options{})
+	if err == nil {
+		t.Fatal("Expected error for a cgo package with cgo disabled")
+	}
+	if !strings.Contains(err.Error(), "main.go uses cgo") || !strings.Contains(err.Error(), "CGO_ENABLED=1") {
+		t.Errorf("Expected actionable cgo message, got: %v", err)
+	}
+}
+
+func TestCgoRequiredFiles(t *testing.T) {
+	dir := writeCgoPackage(t)
+
+	// As reported by go list with cgo disabled
+	pkgInfo := &PackageInfo{Name: "main", Dir: dir, IgnoredGoFiles: []string{"main.go", "other.go"}}
+	files := cgoRequiredFiles(pkgInfo, Options{})
+	if len(files) != 1 || filepath.Base(files[0]) != "main.go" {
+		t.Errorf("Expected only main.go to require cgo, got %v", files)
+	}
+
+	// As reported by go list with cgo enabled
+	pkgInfo = &PackageInfo{Name: "main", Dir: dir, CgoFiles: []string{"main.go"}, IgnoredGoFiles: []string{"other.go"}}
+	files = cgoRequiredFiles(pkgInfo, Options{})
+	if len(files) != 1 || filepath.Base(files[0]) != "main.go" {
+		t.Errorf("Expected only main.go to require cgo, got %v", files)
+	}
+}
+
+func TestCheckCgoWithoutCgoFiles(t *testing.T) {
+	t.Setenv("CGO_ENABLED", "0")
+	if err := checkCgo(nil, Options{}); err != nil {
+		t.Errorf("Expected no error without cgo files, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package peep
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// artifactPatterns match files peep writes into the working directory,
+// including their numbered and timestamped variants
+var artifactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^(cpu|mem|mutex|block|goroutine)([-_]\d+)?(_postinit)?\.prof$`),
+	regexp.MustCompile(`^trace([-_]\d+)?\.out$`),
+	regexp.MustCompile(`^peep_metrics([-_][0-9a-f]+)?\.json(\.tmp)?$`),
+}
+
+// tempArtifactPatterns match files and directories peep leaves in the temp directory
+var tempArtifactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^peep-\d+$`),
+	regexp.MustCompile(`^peep-pkg-\d+$`),
+	regexp.MustCompile(`^peep-stdin-\d+$`),
+	regexp.MustCompile(`^main_prof\.go$`),
+	regexp.MustCompile(`^peep_cpu_prof\.go$`),
+	regexp.MustCompile(`^peep_final_[0-9a-f]+\.json$`),
+	regexp.MustCompile(`^peep_metrics_[0-9a-f]+\.json(\.tmp)?$`),
+	regexp.MustCompile(`^peep-metrics-[0-9a-f]+\.sock$`),
+}
+
+// matchEntries returns the paths of entries in dir whose names match any pattern
+func matchEntries(dir string, patterns []*regexp.Regexp) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	var matches []string
+	for _, entry := range entries {
+		for _, re := range patterns {
+			if re.MatchString(entry.Name()) {
+				matches = append(matches, filepath.Join(dir, entry.Name()))
+				break
+			}
+		}
+	}
+	return matches, nil
+}
+
+// findArtifacts lists peep artifacts in the working directory and the temp directory
+func findArtifacts(workDir, tempDir string) ([]string, error) {
+	artifacts, err := matchEntries(workDir, artifactPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	tempArtifacts, err := matchEntries(tempDir, tempArtifactPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts = append(artifacts, tempArtifacts...)
+	sort.Strings(artifacts)
+	return artifacts, nil
+}
+
+// removeArtifacts deletes the given artifacts, or only lists them unless force is set
+func removeArtifacts(w io.Writer, artifacts []string, force bool) error {
+	if len(artifacts) == 0 {
+		fmt.Fprintln(w, "[prof] No peep artifacts found")
+		return nil
+	}
+
+	for _, path := range artifacts {
+		if !force {
+			fmt.Fprintf(w, "[prof] Would remove %s\n", path)
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		fmt.Fprintf(w, "[prof] Removed %s\n", path)
+	}
+
+	if !force {
+		fmt.Fprintln(w, "[prof] Dry run: pass -force to delete these files")
+	}
+	return nil
+}
+
+// RunClean implements the clean subcommand
+func RunClean(args []string) error {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	force := fs.Bool("force", false, "Delete the artifacts instead of listing them")
+	fs.Parse(args)
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	artifacts, err := findArtifacts(workDir, os.TempDir())
+	if err != nil {
+		return err
+	}
+	return removeArtifacts(os.Stdout, artifacts, *force)
+}
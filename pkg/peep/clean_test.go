@@ -0,0 +1,95 @@
+package peep
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindArtifacts(t *testing.T) {
+	workDir := t.TempDir()
+	tempDir := t.TempDir()
+
+	artifacts := []string{
+		filepath.Join(workDir, "cpu.prof"),
+		filepath.Join(workDir, "mem.prof"),
+		filepath.Join(workDir, "mem-1700000000.prof"),
+		filepath.Join(workDir, "goroutine-3.prof"),
+		filepath.Join(workDir, "mem_postinit.prof"),
+		filepath.Join(workDir, "peep_metrics.json"),
+		filepath.Join(tempDir, "main_prof.go"),
+		filepath.Join(tempDir, "peep_cpu_prof.go"),
+		filepath.Join(tempDir, "peep_final_deadbeef.json"),
+		filepath.Join(tempDir, "peep_metrics_deadbeef.json"),
+	}
+	unrelated := []string{
+		filepath.Join(workDir, "main.go"),
+		filepath.Join(workDir, "cpu.prof.bak"),
+		filepath.Join(workDir, "metrics.json"),
+		filepath.Join(tempDir, "other.go"),
+	}
+	for _, path := range append(artifacts, unrelated...) {
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatalf("Failed to create %s: %v", path, err)
+		}
+	}
+	pkgDir := filepath.Join(tempDir, "peep-pkg-123456")
+	if err := os.Mkdir(pkgDir, 0o755); err != nil {
+		t.Fatalf("Failed to create temp package dir: %v", err)
+	}
+	artifacts = append(artifacts, pkgDir)
+
+	found, err := findArtifacts(workDir, tempDir)
+	if err != nil {
+		t.Fatalf("findArtifacts failed: %v", err)
+	}
+
+	if len(found) != len(artifacts) {
+		t.Errorf("Expected %d artifacts, got %d: %v", len(artifacts), len(found), found)
+	}
+	foundSet := make(map[string]bool)
+	for _, path := range found {
+		foundSet[path] = true
+	}
+	for _, path := range artifacts {
+		if !foundSet[path] {
+			t.Errorf("Expected %s to be recognized as an artifact", path)
+		}
+	}
+	for _, path := range unrelated {
+		if foundSet[path] {
+			t.Errorf("Expected %s not to be recognized as an artifact", path)
+		}
+	}
+}
+
+func TestRemoveArtifactsDryRunAndForce(t *testing.T) {
+	workDir := t.TempDir()
+	profile := filepath.Join(workDir, "cpu.prof")
+	if err := os.WriteFile(profile, []byte("x"), 0o644); err != nil {
+		t.Fatalf("Failed to create profile: %v", err)
+	}
+
+	// Dry run only lists the file
+	var out bytes.Buffer
+	if err := removeArtifacts(&out, []string{profile}, false); err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if _, err := os.Stat(profile); err != nil {
+		t.Error("Expected dry run to keep the file")
+	}
+	if !strings.Contains(out.String(), "Would remove "+profile) {
+		t.Errorf("Expected dry run to list the file, got: %s", out.String())
+	}
+
+	// Force deletes it
+	out.Reset()
+	if err := removeArtifacts(&out, []string{profile}, true); err != nil {
+		t.Fatalf("Forced removal failed: %v", err)
+	}
+	if _, err := os.Stat(profile); !os.IsNotExist(err) {
+		t.Error("Expected forced removal to delete the file")
+	}
+}
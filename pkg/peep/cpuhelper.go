@@ -0,0 +1,201 @@
+package peep
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cpuHelperFile is the name of the CPU helper written next to the instrumented code
+const cpuHelperFile = "peep_cpu_prof.go"
+
+// cpuHelperFunc is the helper function the injected metrics collector calls,
+// returning the aggregate and per-core CPU usage
+const cpuHelperFunc = "peepCPUSample"
+
+// cpuFieldHelperFunc is the helper that turns the aggregate CPU usage into
+// the metrics frame's cpuPercent value
+const cpuFieldHelperFunc = "peepCPUField"
+
+// memStatsHelperFunc is the helper the collector calls instead of
+// runtime.ReadMemStats with -metrics-priority low
+const memStatsHelperFunc = "peepReadMemStats"
+
+// cpuHelperSource reads system CPU usage from /proc/stat so the instrumented
+// target needs no third-party dependency, and so no network or go.mod changes.
+// Without /proc/stat (non-Linux) it reports no cores, and cpuPercent is null so
+// the dashboard shows the CPU series as unavailable rather than idle. It also
+// holds the runtime/metrics reader used by -metrics-priority low.
+const cpuHelperSource = `// Code generated by peep. DO NOT EDIT.
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+)
+
+// peepCPULast holds the busy and total times of each cpu line at the previous call
+var peepCPULast = map[string][2]uint64{}
+
+// peepCPUMissing is set once /proc/stat cannot be read
+var peepCPUMissing bool
+
+// peepCPUMu guards peepCPULast and peepCPUMissing, as a panicking main samples
+// outside the collector
+var peepCPUMu sync.Mutex
+
+// peepCPUSample returns the aggregate and per-core CPU usage since the previous call
+func peepCPUSample() (float64, []float64) {
+	peepCPUMu.Lock()
+	defer peepCPUMu.Unlock()
+
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		peepCPUMissing = true
+		return 0, nil
+	}
+
+	// The aggregate "cpu" line comes first, followed by cpu0, cpu1, ...
+	var usage []float64
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fields := bytes.Fields(line)
+		if len(fields) < 5 || !bytes.HasPrefix(fields[0], []byte("cpu")) {
+			continue
+		}
+
+		// user nice system idle iowait irq softirq steal, guest time is already
+		// included in user and nice
+		var busy, total uint64
+		for i, field := range fields[1:] {
+			if i == 8 {
+				break
+			}
+			v, err := strconv.ParseUint(string(field), 10, 64)
+			if err != nil {
+				break
+			}
+			total += v
+			if i != 3 && i != 4 {
+				busy += v
+			}
+		}
+
+		name := string(fields[0])
+		last := peepCPULast[name]
+		peepCPULast[name] = [2]uint64{busy, total}
+
+		var percent float64
+		if total > last[1] {
+			percent = float64(busy-last[0]) / float64(total-last[1]) * 100
+		}
+		usage = append(usage, percent)
+	}
+
+	if len(usage) == 0 {
+		return 0, nil
+	}
+	return usage[0], usage[1:]
+}
+
+// peepCPUField returns percent for the metrics frame, or nil, written as null,
+// when there is no /proc/stat to read CPU usage from
+func peepCPUField(percent float64) interface{} {
+	peepCPUMu.Lock()
+	defer peepCPUMu.Unlock()
+
+	if peepCPUMissing {
+		return nil
+	}
+	return percent
+}
+
+// peepMemSamples are the runtime/metrics equivalents of the MemStats fields the
+// dashboard shows: Alloc, TotalAlloc, Sys, NumGC, what HeapInuse, HeapObjects,
+// Mallocs, Frees and GCCPUFraction are made of, and the GC pause histogram under
+// its current and its pre-Go 1.22 name. The histogram has no latest pause, so
+// PauseNs stays empty.
+var peepMemSamples = []metrics.Sample{
+	{Name: "/memory/classes/heap/objects:bytes"},
+	{Name: "/gc/heap/allocs:bytes"},
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/gc/cycles/total:gc-cycles"},
+	{Name: "/memory/classes/heap/unused:bytes"},
+	{Name: "/gc/heap/objects:objects"},
+	{Name: "/gc/heap/allocs:objects"},
+	{Name: "/gc/heap/frees:objects"},
+	{Name: "/gc/heap/tiny/allocs:objects"},
+	{Name: "/cpu/classes/gc/total:cpu-seconds"},
+	{Name: "/cpu/classes/total:cpu-seconds"},
+	{Name: "/sched/pauses/total/gc:seconds"},
+	{Name: "/gc/pauses:seconds"},
+}
+
+// peepMemMu guards peepMemSamples, as a panicking main samples outside the collector
+var peepMemMu sync.Mutex
+
+// peepUint64 returns the value of a uint64 sample, or 0 if the runtime lacks it
+func peepUint64(s metrics.Sample) uint64 {
+	if s.Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s.Value.Uint64()
+}
+
+// peepReadMemStats fills the MemStats fields the dashboard shows from
+// runtime/metrics, which unlike runtime.ReadMemStats does not stop the world.
+// The pause total is estimated from the midpoints of the pause histogram.
+func peepReadMemStats(m *runtime.MemStats) {
+	peepMemMu.Lock()
+	defer peepMemMu.Unlock()
+
+	metrics.Read(peepMemSamples)
+	m.Alloc = peepUint64(peepMemSamples[0])
+	m.TotalAlloc = peepUint64(peepMemSamples[1])
+	m.Sys = peepUint64(peepMemSamples[2])
+	m.NumGC = uint32(peepUint64(peepMemSamples[3]))
+	m.HeapInuse = m.Alloc + peepUint64(peepMemSamples[4])
+	m.HeapObjects = peepUint64(peepMemSamples[5])
+	// MemStats counts tiny allocations as both allocated and freed
+	tiny := peepUint64(peepMemSamples[8])
+	m.Mallocs = peepUint64(peepMemSamples[6]) + tiny
+	m.Frees = peepUint64(peepMemSamples[7]) + tiny
+	if gc, total := peepMemSamples[9].Value, peepMemSamples[10].Value; gc.Kind() == metrics.KindFloat64 && total.Kind() == metrics.KindFloat64 && total.Float64() > 0 {
+		m.GCCPUFraction = gc.Float64() / total.Float64()
+	}
+
+	for _, s := range peepMemSamples[11:] {
+		if s.Value.Kind() != metrics.KindFloat64Histogram {
+			continue
+		}
+		h := s.Value.Float64Histogram()
+		var total float64
+		for i, count := range h.Counts {
+			lo, hi := h.Buckets[i], h.Buckets[i+1]
+			if math.IsInf(lo, -1) {
+				lo = hi
+			}
+			if math.IsInf(hi, 1) {
+				hi = lo
+			}
+			total += float64(count) * (lo + hi) / 2
+		}
+		m.PauseTotalNs = uint64(total * 1e9)
+		break
+	}
+}
+`
+
+// writeCPUHelper writes the CPU helper into dir and returns its path
+func writeCPUHelper(dir string) (string, error) {
+	path := filepath.Join(dir, cpuHelperFile)
+	if err := os.WriteFile(path, []byte(cpuHelperSource), 0644); err != nil {
+		return "", fmt.Errorf("failed to write CPU helper: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,133 @@
+package peep
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCPUHelperReportsPercent(t *testing.T) {
+	tempDir := t.TempDir()
+	if _, err := writeCPUHelper(tempDir); err != nil {
+		t.Fatalf("Failed to write CPU helper: %v", err)
+	}
+
+	content := `package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func main() {
+	peepCPUSample()
+	time.Sleep(200 * time.Millisecond)
+	total, cores := peepCPUSample()
+	fmt.Println(peepCPUField(total))
+	for _, core := range cores {
+		fmt.Println(core)
+	}
+}`
+	if err := os.WriteFile(filepath.Join(tempDir, "main.go"), []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	output, err := exec.Command("go", "run", filepath.Join(tempDir, "main.go"), filepath.Join(tempDir, cpuHelperFile)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run failed: %v\n%s", err, output)
+	}
+
+	lines := strings.Fields(string(output))
+	if runtime.GOOS != "linux" {
+		if len(lines) != 1 || lines[0] != "<nil>" {
+			t.Errorf("Expected no CPU usage without /proc/stat, got %q", output)
+		}
+		return
+	}
+	for _, line := range lines {
+		percent, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			t.Fatalf("Expected a percentage, got %q", line)
+		}
+		if percent < 0 || percent > 100 {
+			t.Errorf("Expected percentage within 0-100, got %v", percent)
+		}
+	}
+
+	// One entry per core listed, however many the machine has
+	stat, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		t.Fatalf("Failed to read /proc/stat: %v", err)
+	}
+	cores := 0
+	for _, line := range strings.Split(string(stat), "\n") {
+		if strings.HasPrefix(line, "cpu") && !strings.HasPrefix(line, "cpu ") {
+			cores++
+		}
+	}
+	if len(lines) != 1+cores {
+		t.Errorf("Expected aggregate and usage of %d cores, got %q", cores, output)
+	}
+}
+
+// buildWebInstrumented instruments an empty program with opts and builds it
+// together with the CPU helper, offline so any non-standard dependency fails
+func buildWebInstrumented(t *testing.T, opts Options) {
+	t.Helper()
+	content := `package main
+
+func main() {}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts.CPUFile = filepath.Join(tempDir, "cpu.prof")
+	opts.EnableCPU = true
+	opts.EnableWeb = true
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	for _, imp := range node.Imports {
+		if strings.Contains(imp.Path.Value, ".") {
+			t.Errorf("Expected only standard library imports, got %s", imp.Path.Value)
+		}
+	}
+
+	buildDir := filepath.Join(tempDir, "build")
+	if err := os.Mkdir(buildDir, 0o755); err != nil {
+		t.Fatalf("Failed to create build directory: %v", err)
+	}
+	out, err := os.Create(filepath.Join(buildDir, "main.go"))
+	if err != nil {
+		t.Fatalf("Failed to create instrumented file: %v", err)
+	}
+	if err := writeInstrumented(out, fset, node); err != nil {
+		t.Fatalf("Failed to write instrumented file: %v", err)
+	}
+	out.Close()
+	if _, err := writeCPUHelper(buildDir); err != nil {
+		t.Fatalf("Failed to write CPU helper: %v", err)
+	}
+
+	cmd := exec.Command("go", "build", "-o", os.DevNull, filepath.Join(buildDir, "main.go"), filepath.Join(buildDir, cpuHelperFile))
+	cmd.Env = append(os.Environ(), "GOPROXY=off", "GOFLAGS=-mod=mod")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Instrumented program failed to build: %v\n%s", err, output)
+	}
+}
+
+func TestWebInstrumentationBuildsWithoutDependencies(t *testing.T) {
+	buildWebInstrumented(t, Options{})
+}
+
+func TestPerCoreInstrumentationBuilds(t *testing.T) {
+	buildWebInstrumented(t, Options{PerCore: true})
+}
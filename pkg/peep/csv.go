@@ -0,0 +1,69 @@
+package peep
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// metricsCSVColumns returns the CSV header for Metrics, one column per field
+// named after its JSON key
+func metricsCSVColumns() []string {
+	t := reflect.TypeFor[Metrics]()
+	columns := make([]string, t.NumField())
+	for i := range columns {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		columns[i] = name
+	}
+	return columns
+}
+
+// metricsCSVRow renders m as CSV cells in the order of metricsCSVColumns.
+// Fields the JSON leaves out when zero, like the timestamp unit not in use,
+// are left empty, and the per-core CPU percents are joined with semicolons.
+func metricsCSVRow(m Metrics) []string {
+	t, v := reflect.TypeFor[Metrics](), reflect.ValueOf(m)
+	row := make([]string, t.NumField())
+	for i := range row {
+		field := v.Field(i)
+		if field.IsZero() && strings.HasSuffix(t.Field(i).Tag.Get("json"), ",omitempty") {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Float64:
+			row[i] = strconv.FormatFloat(field.Float(), 'f', -1, 64)
+		case reflect.Slice:
+			cells := make([]string, field.Len())
+			for j := range cells {
+				cells[j] = strconv.FormatFloat(field.Index(j).Float(), 'f', -1, 64)
+			}
+			row[i] = strings.Join(cells, ";")
+		default:
+			row[i] = fmt.Sprint(field.Interface())
+		}
+	}
+	return row
+}
+
+// writeMetricsCSV writes a header row and one row per sample to w. Without
+// samples only the header is written.
+func writeMetricsCSV(w io.Writer, samples []json.RawMessage) error {
+	cw := csv.NewWriter(w)
+	cw.Write(metricsCSVColumns())
+	for i, raw := range samples {
+		var m Metrics
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return fmt.Errorf("failed to parse sample %d: %w", i+1, err)
+		}
+		cw.Write(metricsCSVRow(m))
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWriteMetricsCSV(t *testing.T) {
+	metricsPath := filepath.Join(t.TempDir(), "peep_metrics.json")
+	data := &dashboardData{history: newRingBuffer[json.RawMessage](0), samples: &eventLog[json.RawMessage]{}, historyPoll: 5 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		recordHistory(ctx, metricsFileSource{metricsPath}, data)
+		close(done)
+	}()
+	// Every sample is kept, even with the history ring buffer disabled
+	for i, sample := range []string{
+		`{"alloc":1024,"numGC":1,"cpuPercent":12.5,"cpuPerCore":[20,5],"goroutines":3,"timestampMs":1000}`,
+		`{"alloc":2048,"numGC":2,"cpuPercent":50,"cpuPerCore":[60,40],"goroutines":4,"timestampMs":1500}`,
+	} {
+		if err := os.WriteFile(metricsPath, []byte(sample), 0o644); err != nil {
+			t.Fatalf("Failed to write metrics: %v", err)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for len(data.samples.List()) <= i && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	cancel()
+	<-done
+
+	var buf bytes.Buffer
+	if err := writeMetricsCSV(&buf, data.samples.List()); err != nil {
+		t.Fatalf("writeMetricsCSV failed: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v\n%s", err, buf.String())
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected a header and 2 rows, got %d: %v", len(rows), rows)
+	}
+
+	header := rows[0]
+	wantHeader := []string{
+		"alloc", "totalAlloc", "sys", "numGC", "pauseTotal", "heapInuse", "heapObjects", "mallocs", "frees",
+		"cpuPercent", "cpuPerCore", "goroutines", "threads", "timestampMs", "timestampNs", "peakAlloc",
+		"gcCpuFraction", "lastPauseNs",
+	}
+	if !slices.Equal(header, wantHeader) {
+		t.Errorf("Expected header %v, got %v", wantHeader, header)
+	}
+	cell := func(row []string, column string) string {
+		return row[slices.Index(header, column)]
+	}
+	for i, want := range []map[string]string{
+		{"alloc": "1024", "numGC": "1", "cpuPercent": "12.5", "cpuPerCore": "20;5", "goroutines": "3", "timestampMs": "1000", "timestampNs": "", "peakAlloc": "", "sys": "0"},
+		{"alloc": "2048", "numGC": "2", "cpuPercent": "50", "cpuPerCore": "60;40", "goroutines": "4", "timestampMs": "1500", "timestampNs": "", "peakAlloc": "", "sys": "0"},
+	} {
+		for column, value := range want {
+			if got := cell(rows[i+1], column); got != value {
+				t.Errorf("Expected row %d to have %s %q, got %q", i+1, column, value, got)
+			}
+		}
+	}
+
+	buf.Reset()
+	if err := writeMetricsCSV(&buf, nil); err != nil {
+		t.Fatalf("writeMetricsCSV failed without samples: %v", err)
+	}
+	if rows, err := csv.NewReader(&buf).ReadAll(); err != nil || len(rows) != 1 || !slices.Equal(rows[0], wantHeader) {
+		t.Errorf("Expected only the header without samples, got %v (%v)", rows, err)
+	}
+}
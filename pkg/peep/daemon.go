@@ -0,0 +1,315 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// daemonSocketEnv overrides the control socket used by peep daemon and peep run
+const daemonSocketEnv = "PEEP_DAEMON_SOCKET"
+
+// DaemonDashboardEnv tells a run started by the daemon where to serve its dashboard
+const DaemonDashboardEnv = "PEEP_DAEMON_DASHBOARD"
+
+// daemonExitTrailer carries the exit code of a run after its streamed output
+const daemonExitTrailer = "Peep-Exit-Code"
+
+// daemonLinger is how long a run keeps its dashboard up after the program
+// exits, longer than the dashboard's 1 second polling interval
+const daemonLinger = 2 * time.Second
+
+// daemonIdleResponses are served for each proxied dashboard endpoint before
+// the first run has answered it, in the shape the dashboard expects
+var daemonIdleResponses = map[string]string{
+	"/metrics":     "{}",
+	"/annotations": "[]",
+	"/gc":          "[]",
+	"/history":     "[]",
+	"/config":      "{}",
+	"/logs":        `{"output":"","truncated":false}`,
+	"/status":      `{"running":false,"completed":false,"exitCode":0}`,
+	"/runinfo":     `{"target":"waiting for peep run","command":[],"modes":[],"goVersion":""}`,
+}
+
+// runRequest asks the daemon to run peep with Args from the client's Dir and Env
+type runRequest struct {
+	Args []string `json:"args"`
+	Dir  string   `json:"dir"`
+	Env  []string `json:"env"`
+}
+
+// daemonSocketPath returns the control socket of the daemon
+func daemonSocketPath() string {
+	if path := os.Getenv(daemonSocketEnv); path != "" {
+		return path
+	}
+	return filepath.Join(os.TempDir(), "peep-daemon.sock")
+}
+
+// unixClient returns an HTTP client that connects to the socket at path
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+// daemon runs one peep at a time as a child process and proxies the run's
+// dashboard, so a dashboard left open in the browser follows every run
+type daemon struct {
+	exe           string // command run for each request, normally peep itself
+	dashboardPath string // socket the current run serves its dashboard on
+	dashboard     *http.Client
+	log           *logger // prints the daemon's messages
+
+	mu      sync.Mutex
+	running bool
+	cache   map[string][]byte // last response of each proxied endpoint
+}
+
+// newDaemon creates a daemon running exe, whose runs serve their dashboard on dashboardPath
+func newDaemon(exe, dashboardPath string) *daemon {
+	return &daemon{
+		exe:           exe,
+		dashboardPath: dashboardPath,
+		dashboard:     unixClient(dashboardPath),
+		cache:         make(map[string][]byte),
+		log:           newLogger(os.Stdout, levelNormal),
+	}
+}
+
+// proxyHandler serves a dashboard endpoint from the current run, or the last
+// response it gave once the run has exited
+func (d *daemon) proxyHandler(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		body, err := d.fetch(r.Context(), path)
+		d.mu.Lock()
+		if err == nil {
+			d.cache[path] = body
+		} else if cached, ok := d.cache[path]; ok {
+			body = cached
+		} else {
+			body = []byte(daemonIdleResponses[path])
+		}
+		d.mu.Unlock()
+		w.Write(body)
+	}
+}
+
+// fetch requests path from the current run's dashboard
+func (d *daemon) fetch(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://peep"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.dashboard.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dashboard returned %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// flushWriter flushes every write so the client sees output as it is produced
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// runHandler starts a run, streams its combined output and ends with its
+// exit code in the daemonExitTrailer trailer. Only one run is allowed at a time.
+func (d *daemon) runHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req runRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid run request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	if d.running {
+		d.mu.Unlock()
+		http.Error(w, "another run is in progress", http.StatusConflict)
+		return
+	}
+	d.running = true
+	// The dashboard starts over with the new run
+	clear(d.cache)
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.running = false
+		d.mu.Unlock()
+	}()
+
+	// A run that was killed leaves its socket behind
+	os.Remove(d.dashboardPath)
+
+	// Interrupt the run like Ctrl+C would when the client goes away
+	cmd := exec.CommandContext(r.Context(), d.exe, req.Args...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+	cmd.Dir = req.Dir
+	cmd.Env = append(req.Env, DaemonDashboardEnv+"="+d.dashboardPath)
+
+	w.Header().Set("Trailer", daemonExitTrailer)
+	w.WriteHeader(http.StatusOK)
+	out := &flushWriter{w: w}
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	d.log.logf(levelNormal, "Run started: %v", req.Args)
+	code := 0
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+			code = exitErr.ExitCode()
+		} else {
+			fmt.Fprintf(out, "[prof] Run failed: %v\n", err)
+			code = 1
+		}
+	}
+	d.log.logf(levelNormal, "Run finished with exit code %d", code)
+	w.Header().Set(daemonExitTrailer, fmt.Sprint(code))
+}
+
+// listenDaemonSocket listens on the control socket, replacing a stale one but
+// refusing to take over from a daemon that is still running
+func listenDaemonSocket(path string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("a peep daemon is already listening on %s", path)
+	}
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	return listener, nil
+}
+
+// RunDaemon implements the daemon subcommand
+func RunDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	port := fs.String("port", "6060", "Port for the web dashboard")
+	socket := fs.String("socket", daemonSocketPath(), "Control socket that peep run connects to")
+	staticDir := fs.String("static-dir", "", "Serve the dashboard page from this directory instead of the one built into peep")
+	fs.Parse(args)
+	if *staticDir != "" {
+		if info, err := os.Stat(*staticDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("-static-dir %s is not a directory", *staticDir)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the peep executable: %w", err)
+	}
+	d := newDaemon(exe, filepath.Join(os.TempDir(), fmt.Sprintf("peep-daemon-dashboard-%d.sock", os.Getpid())))
+	defer os.Remove(d.dashboardPath)
+
+	control, err := listenDaemonSocket(*socket)
+	if err != nil {
+		return err
+	}
+	controlMux := http.NewServeMux()
+	controlMux.HandleFunc("/run", d.runHandler)
+	controlServer := &http.Server{Handler: controlMux}
+
+	dashboardMux := http.NewServeMux()
+	for path := range daemonIdleResponses {
+		dashboardMux.HandleFunc(path, d.proxyHandler(path))
+	}
+	dashboardMux.Handle("/", staticHandler(*staticDir))
+	dashboardServer := &http.Server{Addr: ":" + *port, Handler: dashboardMux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	errs := make(chan error, 2)
+	go func() { errs <- controlServer.Serve(control) }()
+	go func() { errs <- dashboardServer.ListenAndServe() }()
+	d.log.logf(levelNormal, "Daemon dashboard at http://localhost:%s", *port)
+	d.log.logf(levelNormal, "Accepting runs on %s, start them with: peep run [flags] <target> [args...]", *socket)
+
+	select {
+	case <-ctx.Done():
+		d.log.logf(levelNormal, "Shutting down the daemon")
+	case err = <-errs:
+		err = fmt.Errorf("daemon server error: %w", err)
+	}
+
+	ctxShutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	controlServer.Shutdown(ctxShutdown)
+	dashboardServer.Shutdown(ctxShutdown)
+	return err
+}
+
+// requestRun sends req to the daemon on socket, copies the run's output to out
+// and returns the run's exit code
+func requestRun(socket string, req runRequest, out io.Writer) (int, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode run request: %w", err)
+	}
+	resp, err := unixClient(socket).Post("http://peep/run", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to reach the peep daemon on %s, is peep daemon running? %w", socket, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("daemon refused the run: %s", bytes.TrimSpace(msg))
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return 0, fmt.Errorf("lost connection to the daemon: %w", err)
+	}
+
+	var code int
+	if _, err := fmt.Sscan(resp.Trailer.Get(daemonExitTrailer), &code); err != nil {
+		return 0, fmt.Errorf("daemon did not report the run's exit code")
+	}
+	return code, nil
+}
+
+// RunClient implements the run subcommand: the arguments are peep's usual
+// flags, target and program arguments, run by the daemon from this directory
+func RunClient(args []string) (int, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get working directory: %w", err)
+	}
+	return requestRun(daemonSocketPath(), runRequest{Args: args, Dir: dir, Env: os.Environ()}, os.Stdout)
+}
@@ -0,0 +1,96 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// serveUnix serves handler on a unix socket at path until the test ends or stop is called
+func serveUnix(t *testing.T, path string, handler http.Handler) (stop func()) {
+	t.Helper()
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", path, err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	stop = func() { server.Shutdown(context.Background()) }
+	t.Cleanup(stop)
+	return stop
+}
+
+func TestDaemonProxyKeepsLastResponse(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("daemon tests use unix sockets")
+	}
+	d := newDaemon("", filepath.Join(t.TempDir(), "dash.sock"))
+	proxy := d.proxyHandler("/metrics")
+	get := func() string {
+		rec := httptest.NewRecorder()
+		proxy(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+
+	if got := get(); got != "{}" {
+		t.Errorf("Expected the idle response before any run, got %q", got)
+	}
+
+	stop := serveUnix(t, d.dashboardPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"goroutines":3}`))
+	}))
+	if got := get(); got != `{"goroutines":3}` {
+		t.Errorf("Expected the run's metrics, got %q", got)
+	}
+
+	// Once the run exits, its final state is still shown
+	stop()
+	if got := get(); got != `{"goroutines":3}` {
+		t.Errorf("Expected the last metrics after the run exited, got %q", got)
+	}
+}
+
+func TestDaemonRunStreamsOutputAndExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("daemon tests use unix sockets")
+	}
+	dir := t.TempDir()
+	d := newDaemon("/bin/sh", filepath.Join(dir, "dash.sock"))
+	socket := filepath.Join(dir, "control.sock")
+	control, err := listenDaemonSocket(socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on the control socket: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(d.runHandler)}
+	go server.Serve(control)
+	t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+	if _, err := listenDaemonSocket(socket); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Errorf("Expected a second daemon to be refused, got %v", err)
+	}
+
+	// The run sees the client's directory and environment, plus the dashboard socket
+	req := runRequest{
+		Args: []string{"-c", `pwd; echo "$GREETING"; echo "$` + DaemonDashboardEnv + `" >&2; exit 3`},
+		Dir:  dir,
+		Env:  []string{"GREETING=hello"},
+	}
+	var out bytes.Buffer
+	code, err := requestRun(socket, req, &out)
+	if err != nil {
+		t.Fatalf("requestRun failed: %v", err)
+	}
+	if code != 3 {
+		t.Errorf("Expected exit code 3, got %d", code)
+	}
+	want := dir + "\nhello\n" + d.dashboardPath + "\n"
+	if out.String() != want {
+		t.Errorf("Expected output %q, got %q", want, out.String())
+	}
+}
@@ -0,0 +1,109 @@
+package peep
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// splitLines splits text into lines that keep their newlines. Unlike
+// difflib.SplitLines it adds no empty line after a trailing newline, which
+// would make the diff's line counts disagree with the file.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// instrumentDiff returns a unified diff from the source file to its instrumented
+// form, with a/ and b/ prefixed paths so it can be checked with git apply
+func instrumentDiff(sourceFile, displayPath string, opts Options) (string, error) {
+	original, err := os.ReadFile(sourceFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", sourceFile, err)
+	}
+
+	node, fset, err := processGoFile(sourceFile, opts)
+	if err != nil {
+		return "", err
+	}
+
+	// gofmt the instrumented code so formatted sources only differ by the injected lines
+	var instrumented bytes.Buffer
+	if err := format.Node(&instrumented, fset, node); err != nil {
+		return "", fmt.Errorf("failed to format instrumented code: %w", err)
+	}
+
+	return unifiedDiff(string(original), instrumented.String(), displayPath)
+}
+
+// unifiedDiff returns a git-style diff of displayPath from original to
+// modified. An empty original diffs from /dev/null, creating the file.
+func unifiedDiff(original, modified, displayPath string) (string, error) {
+	fromFile := "a/" + filepath.ToSlash(displayPath)
+	var a []string
+	if original == "" {
+		fromFile = "/dev/null"
+	} else {
+		a = splitLines(original)
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        splitLines(modified),
+		FromFile: fromFile,
+		ToFile:   "b/" + filepath.ToSlash(displayPath),
+		Context:  3,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to diff instrumented code: %w", err)
+	}
+	return diff, nil
+}
+
+// RunDiffInstrument implements the diff-instrument subcommand
+func RunDiffInstrument(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("diff-instrument", flag.ExitOnError)
+	cpuOnly := fs.Bool("cpu", false, "Show CPU profiling instrumentation only")
+	memOnly := fs.Bool("mem", false, "Show memory profiling instrumentation only")
+	dash := fs.Bool("dash", false, "Include the dashboard metrics collector")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: peep diff-instrument [-cpu] [-mem] [-dash] <main.go>")
+	}
+	target := fs.Arg(0)
+
+	absTarget, isDir, err := resolveTarget(target, Options{})
+	if err != nil {
+		return err
+	}
+	if isDir {
+		return fmt.Errorf("diff-instrument requires a Go file, got directory %s", target)
+	}
+
+	opts := Options{
+		Target:    absTarget,
+		CPUFile:   "cpu.prof",
+		MemFile:   "mem.prof",
+		EnableCPU: *cpuOnly || !*memOnly,
+		EnableMem: *memOnly || !*cpuOnly,
+		EnableWeb: *dash,
+	}
+
+	diff, err := instrumentDiff(absTarget, filepath.Clean(target), opts)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, diff)
+	return err
+}
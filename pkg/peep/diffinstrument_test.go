@@ -0,0 +1,75 @@
+package peep
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitLines(t *testing.T) {
+	got := splitLines("a\nb\n")
+	if want := []string{"a\n", "b\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	got = splitLines("a\nb")
+	if want := []string{"a\n", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestDiffInstrumentAppliesToOriginal(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+}
+`
+
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "main.go"), []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	var out bytes.Buffer
+	if err := RunDiffInstrument(&out, []string{"-cpu", "main.go"}); err != nil {
+		t.Fatalf("diff-instrument failed: %v", err)
+	}
+	diff := out.String()
+
+	if !strings.HasPrefix(diff, "--- a/main.go\n+++ b/main.go\n@@ ") {
+		t.Errorf("Expected unified diff headers, got:\n%s", diff)
+	}
+	if !strings.Contains(diff, "+\t\tpprof.StartCPUProfile(") {
+		t.Errorf("Expected CPU profiling to be added, got:\n%s", diff)
+	}
+	if strings.Contains(diff, "WriteHeapProfile") {
+		t.Errorf("Expected no memory profiling with -cpu, got:\n%s", diff)
+	}
+	if strings.Contains(diff, "-\tfmt.Println") {
+		t.Errorf("Expected user code to be left unchanged, got:\n%s", diff)
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	cmd := exec.Command("git", "apply", "--check", "-")
+	cmd.Stdin = strings.NewReader(diff)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("Expected diff to apply to the original: %v\n%s", err, output)
+	}
+}
+
+func TestDiffInstrumentRejectsDirectory(t *testing.T) {
+	var out bytes.Buffer
+	if err := RunDiffInstrument(&out, []string{t.TempDir()}); err == nil {
+		t.Error("Expected error for a directory target")
+	}
+}
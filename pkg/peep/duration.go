@@ -0,0 +1,84 @@
+package peep
+
+import (
+	"errors"
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// errDurationElapsed is the cause of the target's context ending once
+// -duration has elapsed, which interrupts the target rather than killing it
+var errDurationElapsed = errors.New("-duration elapsed")
+
+// createDurationStmt creates the statement that flushes the profiles when
+// peep interrupts the program once -duration has elapsed, or it gets
+// SIGINT/SIGTERM otherwise, and then exits. main's body runs as it is, and the
+// flushes are main's deferred calls moved into peepFlush:
+//
+//	go func() {
+//		peepStop := make(chan os.Signal, 1)
+//		signal.Notify(peepStop, os.Interrupt, syscall.SIGTERM)
+//		<-peepStop
+//		log.Print("[prof] Stopped, flushing profiles")
+//		peepFlushOnce.Do(peepFlush)
+//		os.Exit(0)
+//	}()
+func createDurationStmt() ast.Stmt {
+	return &ast.GoStmt{
+		Call: &ast.CallExpr{
+			Fun: &ast.FuncLit{
+				Type: &ast.FuncType{},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						// peepStop := make(chan os.Signal, 1)
+						&ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("peepStop")},
+							Tok: token.DEFINE,
+							Rhs: []ast.Expr{
+								&ast.CallExpr{
+									Fun: ast.NewIdent("make"),
+									Args: []ast.Expr{
+										&ast.ChanType{
+											Dir:   ast.SEND | ast.RECV,
+											Value: &ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("Signal")},
+										},
+										&ast.BasicLit{Kind: token.INT, Value: "1"},
+									},
+								},
+							},
+						},
+						// signal.Notify(peepStop, os.Interrupt, syscall.SIGTERM)
+						&ast.ExprStmt{
+							X: &ast.CallExpr{
+								Fun: &ast.SelectorExpr{X: ast.NewIdent("signal"), Sel: ast.NewIdent("Notify")},
+								Args: []ast.Expr{
+									ast.NewIdent("peepStop"),
+									&ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("Interrupt")},
+									&ast.SelectorExpr{X: ast.NewIdent("syscall"), Sel: ast.NewIdent("SIGTERM")},
+								},
+							},
+						},
+						// <-peepStop
+						&ast.ExprStmt{X: &ast.UnaryExpr{Op: token.ARROW, X: ast.NewIdent("peepStop")}},
+						// log.Print("[prof] Stopped, flushing profiles")
+						&ast.ExprStmt{
+							X: &ast.CallExpr{
+								Fun:  &ast.SelectorExpr{X: ast.NewIdent("log"), Sel: ast.NewIdent("Print")},
+								Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("[prof] Stopped, flushing profiles")}},
+							},
+						},
+						createFlushOnceStmt(),
+						// os.Exit(0)
+						&ast.ExprStmt{
+							X: &ast.CallExpr{
+								Fun:  &ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("Exit")},
+								Args: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "0"}},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runWithDuration instruments content with opts and runs it to completion
+func runWithDuration(t *testing.T, ctx context.Context, content string, opts Options) error {
+	t.Helper()
+	testFile := filepath.Join(t.TempDir(), "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	return writeAndExecute(ctx, node, fset, opts)
+}
+
+func TestDurationStopsLoopingProgram(t *testing.T) {
+	content := `package main
+
+func spin() int {
+	total := 0
+	for i := 0; i < 1000000; i++ {
+		total += i % 7
+	}
+	return total
+}
+
+func main() {
+	for {
+		spin()
+	}
+}`
+
+	dir := t.TempDir()
+	opts := Options{
+		EnableCPU: true,
+		CPUFile:   filepath.Join(dir, "cpu.prof"),
+		EnableMem: true,
+		MemFile:   filepath.Join(dir, "mem.prof"),
+		Duration:  500 * time.Millisecond,
+	}
+	// A cancellable context gives the target a process group of its own,
+	// which the deadline interrupts as a whole
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, ctx := range []context.Context{context.Background(), ctx} {
+		start := time.Now()
+		if err := runWithDuration(t, ctx, content, opts); err != nil {
+			t.Fatalf("Expected the stopped program to succeed, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Minute {
+			t.Errorf("Expected the program to be stopped after -duration, took %v", elapsed)
+		}
+
+		for _, path := range []string{opts.CPUFile, opts.MemFile} {
+			info, err := os.Stat(path)
+			if err != nil || info.Size() == 0 {
+				t.Errorf("Expected a non-empty profile at %s, got %v", path, err)
+			}
+			os.Remove(path)
+		}
+	}
+}
+
+func TestDurationLeavesMainBodyInPlace(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {\n\tfor {\n\t}\n}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	node, fset, err := processGoFile(testFile, Options{EnableCPU: true, CPUFile: "cpu.prof", Duration: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := writeInstrumented(&buf, fset, node); err != nil {
+		t.Fatalf("Failed to write instrumented file: %v", err)
+	}
+
+	// The signal handler runs the flushes, and the loop stays in main itself
+	src := buf.String()
+	handler := strings.Index(src, "signal.Notify(peepStop, os.Interrupt, syscall.SIGTERM)")
+	if handler < 0 || !strings.Contains(src, "peepFlushOnce.Do(peepFlush)") {
+		t.Fatalf("Expected a signal handler that flushes the profiles, got:\n%s", src)
+	}
+	if !strings.Contains(src, "}()\n\tfor {") {
+		t.Errorf("Expected main's body to follow the injected code directly, got:\n%s", src)
+	}
+}
+
+func TestDurationKeepsEarlyReturnAndPanic(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{EnableCPU: true, CPUFile: filepath.Join(dir, "cpu.prof"), Duration: time.Minute}
+
+	start := time.Now()
+	if err := runWithDuration(t, context.Background(), "package main\n\nfunc main() {\n\treturn\n}\n", opts); err != nil {
+		t.Fatalf("Expected main returning on its own to succeed, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("Expected main to return without waiting for -duration, took %v", elapsed)
+	}
+
+	err := runWithDuration(t, context.Background(), "package main\n\nfunc main() {\n\tpanic(\"boom\")\n}\n", opts)
+	if err == nil || !strings.Contains(err.Error(), "execution failed") {
+		t.Errorf("Expected a panic in main to still fail the program, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package peep
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// entryPrompt asks the user to pick one of several candidate main files
+type entryPrompt func(candidates []string) (string, error)
+
+// terminalEntryPrompt returns a prompt reading the choice from stdin and
+// listing the candidates on w, or nil when stdin is not a terminal and nobody
+// can answer
+func terminalEntryPrompt(w io.Writer) entryPrompt {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	return func(candidates []string) (string, error) {
+		return promptEntry(os.Stdin, w, candidates)
+	}
+}
+
+// promptEntry lists the candidates with numbers on w and reads the number of
+// the chosen one from r, asking again until the answer is valid
+func promptEntry(r io.Reader, w io.Writer, candidates []string) (string, error) {
+	fmt.Fprintln(w, "[prof] Multiple files define func main():")
+	for i, file := range candidates {
+		fmt.Fprintf(w, "[prof]   %d) %s\n", i+1, file)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for {
+		fmt.Fprintf(w, "[prof] Entry point [1-%d]: ", len(candidates))
+		if !scanner.Scan() {
+			fmt.Fprintln(w)
+			if err := scanner.Err(); err != nil {
+				return "", fmt.Errorf("failed to read entry point choice: %w", err)
+			}
+			return "", fmt.Errorf("no entry point chosen, pass -entry to select one of %v", candidates)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err == nil && n >= 1 && n <= len(candidates) {
+			return candidates[n-1], nil
+		}
+		fmt.Fprintf(w, "[prof] Enter a number between 1 and %d\n", len(candidates))
+	}
+}
+
+// buildableFiles returns the files whose name and //go:build line match the
+// GOOS, GOARCH, build tags and cgo setting of buildCtx, as go build would
+// select them
+func buildableFiles(buildCtx build.Context, files []string) []string {
+	var matched []string
+	for _, file := range files {
+		if ok, err := buildCtx.MatchFile(filepath.Dir(file), filepath.Base(file)); err == nil && ok {
+			matched = append(matched, file)
+		}
+	}
+	return matched
+}
+
+// matchEntry returns the package file -entry names, given as a path or as a
+// file name within the package, and checks that it defines main
+func matchEntry(buildCtx build.Context, files, mainFiles []string, entry string) (string, error) {
+	absEntry, err := filepath.Abs(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve -entry %s: %w", entry, err)
+	}
+
+	for _, file := range files {
+		if file != absEntry && filepath.Base(file) != entry {
+			continue
+		}
+		for _, mainFile := range mainFiles {
+			if mainFile == file {
+				return file, nil
+			}
+		}
+		return "", fmt.Errorf("-entry %s does not define func main()", entry)
+	}
+	if len(files) > 0 {
+		// go list leaves out files excluded by their build constraints
+		path := absEntry
+		if filepath.Base(entry) == entry {
+			path = filepath.Join(filepath.Dir(files[0]), entry)
+		}
+		if _, err := os.Stat(path); err == nil && len(buildableFiles(buildCtx, []string{path})) == 0 {
+			return "", fmt.Errorf("-entry %s is excluded by its build constraints for %s/%s", entry, buildCtx.GOOS, buildCtx.GOARCH)
+		}
+	}
+	return "", fmt.Errorf("-entry %s is not a file of the package", entry)
+}
@@ -0,0 +1,121 @@
+package peep
+
+import (
+	"go/build"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeMains writes one file defining main per name into a temp dir
+func writeMains(t *testing.T, names ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var files []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		files = append(files, path)
+	}
+	return files
+}
+
+func TestFindMainFileWithEntry(t *testing.T) {
+	files := writeMains(t, "server.go", "tool.go")
+	helper := filepath.Join(filepath.Dir(files[0]), "helper.go")
+	if err := os.WriteFile(helper, []byte("package main\n\nfunc help() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write helper: %v", err)
+	}
+	files = append(files, helper)
+
+	if _, err := findMainFile(build.Default, files, "", nil); err == nil || !strings.Contains(err.Error(), "-entry") {
+		t.Errorf("Expected a non-interactive error suggesting -entry, got %v", err)
+	}
+
+	for _, entry := range []string{"tool.go", files[1]} {
+		got, err := findMainFile(build.Default, files, entry, nil)
+		if err != nil || got != files[1] {
+			t.Errorf("Expected -entry %s to select %s, got %q (%v)", entry, files[1], got, err)
+		}
+	}
+
+	if _, err := findMainFile(build.Default, files, "helper.go", nil); err == nil || !strings.Contains(err.Error(), "does not define func main") {
+		t.Errorf("Expected an error for an entry without main, got %v", err)
+	}
+	if _, err := findMainFile(build.Default, files, "missing.go", nil); err == nil || !strings.Contains(err.Error(), "not a file of the package") {
+		t.Errorf("Expected an error for an entry outside the package, got %v", err)
+	}
+}
+
+func TestFindMainFilePrompts(t *testing.T) {
+	files := writeMains(t, "a.go", "b.go")
+
+	var offered []string
+	prompt := func(candidates []string) (string, error) {
+		offered = candidates
+		return candidates[1], nil
+	}
+	got, err := findMainFile(build.Default, files, "", prompt)
+	if err != nil || got != files[1] {
+		t.Errorf("Expected the prompted choice %s, got %q (%v)", files[1], got, err)
+	}
+	if len(offered) != 2 {
+		t.Errorf("Expected both candidates to be offered, got %v", offered)
+	}
+}
+
+func TestFindMainFileBuildConstraints(t *testing.T) {
+	other := "windows"
+	if runtime.GOOS == "windows" {
+		other = "linux"
+	}
+	dir := t.TempDir()
+	var files []string
+	for name, content := range map[string]string{
+		"main_" + runtime.GOOS + ".go": "//go:build " + runtime.GOOS + "\n\npackage main\n\nfunc main() {}\n",
+		"main_" + other + ".go":        "//go:build " + other + "\n\npackage main\n\nfunc main() {}\n",
+		"main_tagged.go":               "//go:build peeptag\n\npackage main\n\nfunc main() {}\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		files = append(files, path)
+	}
+
+	want := filepath.Join(dir, "main_"+runtime.GOOS+".go")
+	got, err := findMainFile(build.Default, files, "", nil)
+	if err != nil || got != want {
+		t.Errorf("Expected the main file for %s, %s, got %q (%v)", runtime.GOOS, want, got, err)
+	}
+
+	// As go list reports the package, without the excluded files
+	if _, err := findMainFile(build.Default, []string{want}, "main_"+other+".go", nil); err == nil || !strings.Contains(err.Error(), "excluded by its build constraints") {
+		t.Errorf("Expected an error for an entry excluded by build constraints, got %v", err)
+	}
+}
+
+func TestPromptEntry(t *testing.T) {
+	candidates := []string{"a.go", "b.go", "c.go"}
+
+	var out strings.Builder
+	got, err := promptEntry(strings.NewReader("x\n7\n 2 \n"), &out, candidates)
+	if err != nil || got != "b.go" {
+		t.Errorf("Expected b.go after two invalid answers, got %q (%v)", got, err)
+	}
+	if strings.Count(out.String(), "Enter a number between 1 and 3") != 2 {
+		t.Errorf("Expected two invalid answers to be rejected, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "3) c.go") {
+		t.Errorf("Expected numbered candidates, got:\n%s", out.String())
+	}
+
+	if _, err := promptEntry(strings.NewReader(""), io.Discard, candidates); err == nil {
+		t.Error("Expected an error when no answer is given")
+	}
+}
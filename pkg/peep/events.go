@@ -0,0 +1,109 @@
+package peep
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// eventLog collects events recorded while the target runs
+type eventLog[T any] struct {
+	mu      sync.Mutex
+	entries []T
+}
+
+// Add records a new event
+func (l *eventLog[T]) Add(event T) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, event)
+}
+
+// List returns a copy of the recorded events
+func (l *eventLog[T]) List() []T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]T{}, l.entries...)
+}
+
+// eventsHandler serves the recorded events as a JSON array
+func eventsHandler[T any](events *eventLog[T]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events.List())
+	}
+}
+
+// lineWriter forwards output unchanged while passing each complete line to onLine
+type lineWriter struct {
+	out    io.Writer
+	onLine func(line []byte)
+	buf    []byte
+}
+
+// newLineWriter creates a lineWriter that forwards to out
+func newLineWriter(out io.Writer, onLine func(line []byte)) *lineWriter {
+	return &lineWriter{out: out, onLine: onLine}
+}
+
+// Write forwards p and scans any complete lines it contains
+func (w *lineWriter) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+
+	w.buf = append(w.buf, p[:n]...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(bytes.TrimRight(w.buf[:i], "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return n, err
+}
+
+// Flush scans any trailing output that did not end with a newline
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.onLine(bytes.TrimRight(w.buf, "\r"))
+		w.buf = nil
+	}
+}
+
+// ringBuffer keeps the most recent entries up to a fixed capacity
+type ringBuffer[T any] struct {
+	mu      sync.Mutex
+	entries []T
+	next    int // index the next entry is written to once the buffer is full
+}
+
+// newRingBuffer creates a ring buffer holding at most size entries
+func newRingBuffer[T any](size int) *ringBuffer[T] {
+	return &ringBuffer[T]{entries: make([]T, 0, size)}
+}
+
+// Add records an entry, overwriting the oldest one when full
+func (b *ringBuffer[T]) Add(entry T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cap(b.entries) == 0 {
+		return
+	}
+	if len(b.entries) < cap(b.entries) {
+		b.entries = append(b.entries, entry)
+		return
+	}
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+}
+
+// List returns a copy of the entries, oldest first
+func (b *ringBuffer[T]) List() []T {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := make([]T, 0, len(b.entries))
+	list = append(list, b.entries[b.next:]...)
+	return append(list, b.entries[:b.next]...)
+}
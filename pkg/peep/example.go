@@ -0,0 +1,93 @@
+package peep
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runnableExamples lists the Example functions in dir's test files that go test
+// runs, i.e. those with an output comment
+func runnableExamples(dir string) ([]string, error) {
+	testFiles, err := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list test files: %w", err)
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, path := range testFiles {
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		files = append(files, file)
+	}
+
+	var names []string
+	for _, ex := range doc.Examples(files...) {
+		if ex.Output == "" && !ex.EmptyOutput {
+			continue // compiled but never run by go test
+		}
+		names = append(names, "Example"+ex.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// exampleFuncName accepts an example as ExampleFoo or just Foo
+func exampleFuncName(name string) string {
+	if strings.HasPrefix(name, "Example") {
+		return name
+	}
+	return "Example" + name
+}
+
+// runExample profiles a single Example function in the package at dir, using
+// go test's own profiling flags as there is no main function to instrument
+func runExample(ctx context.Context, dir, name string, opts Options) error {
+	examples, err := runnableExamples(dir)
+	if err != nil {
+		return err
+	}
+
+	funcName := exampleFuncName(name)
+	found := false
+	for _, ex := range examples {
+		if ex == funcName {
+			found = true
+			break
+		}
+	}
+	if !found {
+		if len(examples) == 0 {
+			return fmt.Errorf("no runnable examples (Example functions with an output comment) in %s", dir)
+		}
+		return fmt.Errorf("example %s not found in %s, available: %s", funcName, dir, strings.Join(examples, ", "))
+	}
+
+	tempDir, err := os.MkdirTemp("", "peep-pkg-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	args, err := goTestArgs(filepath.Join(tempDir, "example.test"), opts)
+	if err != nil {
+		return err
+	}
+	args = append(args, "-run", "^"+funcName+"$", ".")
+
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	configureCancel(ctx, cmd, opts.PTY)
+	return runInstrumented(ctx, cmd, opts, "example "+funcName)
+}
@@ -0,0 +1,104 @@
+package peep
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeExamplePackage writes a package whose tests contain a mix of examples
+func writeExamplePackage(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example\n\ngo 1.21\n",
+		"main.go": "package main\n\nfunc Sum(n int) int {\n\ttotal := 0\n\tfor i := 0; i < n; i++ {\n\t\ttotal += i\n\t}\n\treturn total\n}\n\nfunc main() {}\n",
+		"main_test.go": `package main
+
+import "fmt"
+
+func ExampleSum() {
+	fmt.Println(Sum(4))
+	// Output: 6
+}
+
+func ExampleSum_large() {
+	fmt.Println(Sum(5))
+	// Output: 10
+}
+
+// Never run by go test as it has no output comment
+func ExampleSum_silent() {
+	Sum(3)
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestRunnableExamples(t *testing.T) {
+	dir := writeExamplePackage(t)
+
+	examples, err := runnableExamples(dir)
+	if err != nil {
+		t.Fatalf("runnableExamples failed: %v", err)
+	}
+
+	expected := []string{"ExampleSum", "ExampleSum_large"}
+	if !reflect.DeepEqual(examples, expected) {
+		t.Errorf("Expected %v, got %v", expected, examples)
+	}
+}
+
+func TestExampleFuncName(t *testing.T) {
+	for _, name := range []string{"Sum", "ExampleSum"} {
+		if got := exampleFuncName(name); got != "ExampleSum" {
+			t.Errorf("exampleFuncName(%q) = %q, want ExampleSum", name, got)
+		}
+	}
+}
+
+func TestRunExampleUnknown(t *testing.T) {
+	dir := writeExamplePackage(t)
+
+	err := runExample(context.Background(), dir, "Missing", Options{EnableCPU: true, CPUFile: "cpu.prof"})
+	if err == nil {
+		t.Fatal("Expected an error for an unknown example")
+	}
+	if !strings.Contains(err.Error(), "ExampleSum, ExampleSum_large") {
+		t.Errorf("Expected the error to list the available examples, got: %v", err)
+	}
+}
+
+func TestRunExampleWritesProfiles(t *testing.T) {
+	dir := writeExamplePackage(t)
+	t.Chdir(t.TempDir())
+
+	opts := Options{
+		EnableCPU: true,
+		EnableMem: true,
+		CPUFile:   "cpu.prof",
+		MemFile:   "mem.prof",
+	}
+	if err := runExample(context.Background(), dir, "Sum", opts); err != nil {
+		t.Fatalf("runExample failed: %v", err)
+	}
+
+	// Profiles land in the working directory, not the package directory
+	for _, name := range []string{"cpu.prof", "mem.prof"} {
+		if info, err := os.Stat(name); err != nil || info.Size() == 0 {
+			t.Errorf("Expected non-empty %s in the working directory: %v", name, err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("Expected the package directory to be left untouched, got %d entries", len(entries))
+	}
+}
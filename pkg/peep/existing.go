@@ -0,0 +1,85 @@
+package peep
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path"
+)
+
+// existingProfileCall is a call to runtime/pprof the program makes itself
+type existingProfileCall struct {
+	name string // StartCPUProfile or WriteHeapProfile
+	pos  token.Pos
+}
+
+// existingProfileCalls returns the calls to pprof.StartCPUProfile and
+// pprof.WriteHeapProfile in the function peep instruments, in source order.
+// They are the program's own profiling, or that of an earlier peep run
+// whose instrumented copy was kept.
+func existingProfileCalls(node *ast.File, funcName string) []existingProfileCall {
+	pprofName := importName(node, "runtime/pprof")
+	if pprofName == "" {
+		return nil
+	}
+	// Imported without a name, the package goes by the last element of its path
+	pprofName = path.Base(pprofName)
+
+	var calls []existingProfileCall
+	for _, decl := range node.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil || funcDeclName(fn) != funcName {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			// A local variable shadowing the package resolves to an object
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == pprofName && x.Obj == nil {
+				if sel.Sel.Name == "StartCPUProfile" || sel.Sel.Name == "WriteHeapProfile" {
+					calls = append(calls, existingProfileCall{name: sel.Sel.Name, pos: call.Pos()})
+				}
+			}
+			return true
+		})
+	}
+	return calls
+}
+
+// checkExistingProfiling refuses to inject a profile the instrumented function
+// already takes: a second pprof.StartCPUProfile fails at runtime with "cpu
+// profiling already in use", and peep's heap profile would duplicate the
+// program's. The error names the flag that profiles only the other type, if any.
+func checkExistingProfiling(fset *token.FileSet, node *ast.File, funcName string, opts Options) error {
+	first := make(map[string]token.Pos)
+	for _, call := range existingProfileCalls(node, funcName) {
+		if _, ok := first[call.name]; !ok {
+			first[call.name] = call.pos
+		}
+	}
+	cpu, hasCPU := first["StartCPUProfile"]
+	heap, hasHeap := first["WriteHeapProfile"]
+
+	var name, instead string
+	var pos token.Pos
+	switch {
+	case opts.EnableCPU && hasCPU:
+		name, pos, instead = "StartCPUProfile", cpu, "-mem"
+	case opts.EnableMem && hasHeap:
+		name, pos, instead = "WriteHeapProfile", heap, "-cpu"
+	default:
+		return nil
+	}
+
+	msg := fmt.Sprintf("%s already calls pprof.%s at %s, so peep would profile it twice", funcName, name, fset.Position(pos))
+	if hasCPU && hasHeap {
+		return fmt.Errorf("%s; remove the program's profiling calls, or run peep on the original file if this is an instrumented copy", msg)
+	}
+	return fmt.Errorf("%s; profile the other type only with %s, or remove the call", msg, instead)
+}
@@ -0,0 +1,135 @@
+package peep
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExistingCPUProfileIsRefused(t *testing.T) {
+	content := `package main
+
+import (
+	"os"
+	prof "runtime/pprof"
+)
+
+func main() {
+	f, _ := os.Create("own.prof")
+	prof.StartCPUProfile(f)
+	defer prof.StopCPUProfile()
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{
+		CPUFile:   filepath.Join(tempDir, "cpu.prof"),
+		MemFile:   filepath.Join(tempDir, "mem.prof"),
+		EnableCPU: true,
+		EnableMem: true,
+	}
+	_, _, err := processGoFile(testFile, opts)
+	if err == nil {
+		t.Fatal("Expected a main that starts its own CPU profile to be refused")
+	}
+	for _, want := range []string{"pprof.StartCPUProfile", "main.go:10", "-mem"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got: %v", want, err)
+		}
+	}
+
+	// Only the memory profile does not clash with the program's own
+	opts.EnableCPU = false
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Expected -mem to be allowed, got: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := writeInstrumented(&buf, fset, node); err != nil {
+		t.Fatalf("Failed to print the instrumented file: %v", err)
+	}
+	if n := strings.Count(buf.String(), "StartCPUProfile("); n != 1 {
+		t.Errorf("Expected only the program's StartCPUProfile call, found %d:\n%s", n, buf.String())
+	}
+}
+
+func TestInstrumentedCopyIsRefused(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{
+		CPUFile:   filepath.Join(tempDir, "cpu.prof"),
+		MemFile:   filepath.Join(tempDir, "mem.prof"),
+		EnableCPU: true,
+		EnableMem: true,
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := writeInstrumented(&buf, fset, node); err != nil {
+		t.Fatalf("Failed to print the instrumented file: %v", err)
+	}
+	instrumented := filepath.Join(tempDir, "instrumented.go")
+	if err := os.WriteFile(instrumented, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write the instrumented file: %v", err)
+	}
+
+	_, _, err = processGoFile(instrumented, opts)
+	if err == nil || !strings.Contains(err.Error(), "instrumented copy") {
+		t.Errorf("Expected an instrumented copy to be refused, got: %v", err)
+	}
+}
+
+func TestExistingProfileCallsIgnoreShadowingAndOtherFuncs(t *testing.T) {
+	content := `package main
+
+import "runtime/pprof"
+
+func helper() {
+	pprof.StartCPUProfile(nil)
+}
+
+func main() {
+	pprof := struct{ StartCPUProfile func(any) }{}
+	pprof.StartCPUProfile(nil)
+	helper()
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{
+		CPUFile:   filepath.Join(tempDir, "cpu.prof"),
+		EnableCPU: true,
+	}
+	if _, _, err := processGoFile(testFile, opts); err != nil {
+		t.Errorf("Expected calls outside main and on a shadowing variable to be ignored, got: %v", err)
+	}
+
+	opts.Func = "helper"
+	if _, _, err := processGoFile(testFile, opts); err == nil {
+		t.Error("Expected -func helper to be refused, helper starts its own CPU profile")
+	}
+}
@@ -0,0 +1,232 @@
+package peep
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// exitFunc is the injected replacement for os.Exit, which flushes the
+// profiles that os.Exit would skip before calling it
+const exitFunc = "peepExit"
+
+// fatalFuncs maps the log functions that exit after logging to the fmt
+// function that formats their message, as the log package does
+var fatalFuncs = map[string]string{
+	"Fatal":   "Sprint",
+	"Fatalf":  "Sprintf",
+	"Fatalln": "Sprintln",
+}
+
+// importName returns the name path is imported under in node, or "" if it
+// is not imported by name
+func importName(node *ast.File, path string) string {
+	for _, imp := range node.Imports {
+		p, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || p != path {
+			continue
+		}
+		if imp.Name == nil {
+			return path
+		}
+		if imp.Name.Name != "_" && imp.Name.Name != "." {
+			return imp.Name.Name
+		}
+	}
+	return ""
+}
+
+// rewriteExitCalls replaces each reference to os.Exit in node with exitFunc,
+// and each reference to log.Fatal, log.Fatalf and log.Fatalln with its
+// peep-prefixed replacement, and reports whether there were any. It runs
+// before peep injects its own code, whose exits are left alone.
+func rewriteExitCalls(node *ast.File) bool {
+	osName := importName(node, "os")
+	logName := importName(node, "log")
+	if osName == "" && logName == "" {
+		return false
+	}
+	found := false
+	astutil.Apply(node, func(c *astutil.Cursor) bool {
+		sel, ok := c.Node().(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		// A local variable shadowing the package resolves to an object
+		x, ok := sel.X.(*ast.Ident)
+		if !ok || x.Obj != nil {
+			return true
+		}
+		switch {
+		case x.Name == osName && sel.Sel.Name == "Exit":
+			c.Replace(ast.NewIdent(exitFunc))
+			found = true
+		case x.Name == logName && fatalFuncs[sel.Sel.Name] != "":
+			c.Replace(ast.NewIdent("peep" + sel.Sel.Name))
+			found = true
+		}
+		return true
+	}, nil)
+	return found
+}
+
+// createExitDecls creates the package-level replacement for os.Exit. main
+// sets peepFlush to its deferred profile flushes, and peepExit runs them,
+// once, before exiting:
+//
+//	var peepFlush func()
+//	var peepFlushOnce sync.Once
+//
+//	func peepExit(code int) {
+//		if peepFlush != nil {
+//			log.Printf("[prof] os.Exit(%d) called, flushing profiles", code)
+//			peepFlushOnce.Do(peepFlush)
+//		}
+//		os.Exit(code)
+//	}
+//
+// An exit before main starts, from init, has nothing to flush. The
+// replacements for the log package's Fatal functions are created along with
+// it, see createFatalDecl.
+func createExitDecls() []ast.Decl {
+	varDecl := func(name string, typ ast.Expr) ast.Decl {
+		return &ast.GenDecl{
+			Tok:   token.VAR,
+			Specs: []ast.Spec{&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(name)}, Type: typ}},
+		}
+	}
+
+	return []ast.Decl{
+		varDecl("peepFlush", &ast.FuncType{Params: &ast.FieldList{}}),
+		varDecl("peepFlushOnce", &ast.SelectorExpr{X: ast.NewIdent("sync"), Sel: ast.NewIdent("Once")}),
+		&ast.FuncDecl{
+			Name: ast.NewIdent(exitFunc),
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{
+					List: []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("code")}, Type: ast.NewIdent("int")}},
+				},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.IfStmt{
+						Cond: &ast.BinaryExpr{
+							X:  ast.NewIdent("peepFlush"),
+							Op: token.NEQ,
+							Y:  ast.NewIdent("nil"),
+						},
+						Body: &ast.BlockStmt{
+							List: []ast.Stmt{
+								&ast.ExprStmt{
+									X: &ast.CallExpr{
+										Fun: &ast.SelectorExpr{X: ast.NewIdent("log"), Sel: ast.NewIdent("Printf")},
+										Args: []ast.Expr{
+											&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("[prof] os.Exit(%d) called, flushing profiles")},
+											ast.NewIdent("code"),
+										},
+									},
+								},
+								createFlushOnceStmt(),
+							},
+						},
+					},
+					&ast.ExprStmt{
+						X: &ast.CallExpr{
+							Fun:  &ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("Exit")},
+							Args: []ast.Expr{ast.NewIdent("code")},
+						},
+					},
+				},
+			},
+		},
+		createFatalDecl("Fatal"),
+		createFatalDecl("Fatalf"),
+		createFatalDecl("Fatalln"),
+	}
+}
+
+// createFatalDecl creates the replacement for the log function name, which
+// logs the message just as it would and then exits through exitFunc:
+//
+//	func peepFatalf(format string, v ...any) {
+//		log.Output(2, fmt.Sprintf(format, v...))
+//		peepExit(1)
+//	}
+//
+// The call depth of 2 attributes the message to the caller of peepFatalf,
+// as it would have been to the caller of log.Fatalf.
+func createFatalDecl(name string) *ast.FuncDecl {
+	params := []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("v")}, Type: &ast.Ellipsis{Elt: ast.NewIdent("any")}}}
+	args := []ast.Expr{ast.NewIdent("v")}
+	if name == "Fatalf" {
+		params = append([]*ast.Field{{Names: []*ast.Ident{ast.NewIdent("format")}, Type: ast.NewIdent("string")}}, params...)
+		args = append([]ast.Expr{ast.NewIdent("format")}, args...)
+	}
+
+	return &ast.FuncDecl{
+		Name: ast.NewIdent("peep" + name),
+		Type: &ast.FuncType{Params: &ast.FieldList{List: params}},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ExprStmt{
+					X: &ast.CallExpr{
+						Fun: &ast.SelectorExpr{X: ast.NewIdent("log"), Sel: ast.NewIdent("Output")},
+						Args: []ast.Expr{
+							&ast.BasicLit{Kind: token.INT, Value: "2"},
+							&ast.CallExpr{
+								Fun:      &ast.SelectorExpr{X: ast.NewIdent("fmt"), Sel: ast.NewIdent(fatalFuncs[name])},
+								Args:     args,
+								Ellipsis: 1,
+							},
+						},
+					},
+				},
+				&ast.ExprStmt{
+					X: &ast.CallExpr{
+						Fun:  ast.NewIdent(exitFunc),
+						Args: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "1"}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createFlushOnceStmt creates peepFlushOnce.Do(peepFlush)
+func createFlushOnceStmt() *ast.ExprStmt {
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun:  &ast.SelectorExpr{X: ast.NewIdent("peepFlushOnce"), Sel: ast.NewIdent("Do")},
+			Args: []ast.Expr{ast.NewIdent("peepFlush")},
+		},
+	}
+}
+
+// createExitFlushStmts moves the deferred calls of the instrumentation stmts
+// into peepFlush, in the order they would have run, and defers peepFlush in
+// their place, so that peepExit and main returning flush the same way. The
+// deferred calls only refer to variables that keep their value, so calling
+// them later from the closure does not change what they do.
+func createExitFlushStmts(stmts []ast.Stmt) []ast.Stmt {
+	var rest, flushes []ast.Stmt
+	for _, stmt := range stmts {
+		d, ok := stmt.(*ast.DeferStmt)
+		if !ok {
+			rest = append(rest, stmt)
+			continue
+		}
+		flushes = append([]ast.Stmt{&ast.ExprStmt{X: d.Call}}, flushes...)
+	}
+
+	return append(rest,
+		// peepFlush = func() { ... }
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("peepFlush")},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{&ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{List: flushes}}},
+		},
+		// defer peepFlushOnce.Do(peepFlush)
+		&ast.DeferStmt{Call: createFlushOnceStmt().X.(*ast.CallExpr)},
+	)
+}
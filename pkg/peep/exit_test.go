@@ -0,0 +1,176 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteExitCalls(t *testing.T) {
+	src := `package main
+
+import (
+	"log"
+	goos "os"
+)
+
+func main() {
+	if len(goos.Args) > 2 {
+		log.Fatalf("too many arguments: %d", len(goos.Args))
+	}
+	exit := goos.Exit
+	defer exit(3)
+	if len(goos.Args) > 1 {
+		goos.Exit(1)
+	}
+	func() {
+		goos := struct{ Exit func(int) }{}
+		goos.Exit(2)
+	}()
+}
+`
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, "main.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if !rewriteExitCalls(node) {
+		t.Fatal("Expected the calls to be rewritten")
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, node)
+	out := buf.String()
+	for _, want := range []string{"exit := peepExit", "peepExit(1)", "goos.Exit(2)", "goos.Args", "peepFatalf(\"too many"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in the rewritten file:\n%s", want, out)
+		}
+	}
+
+	node, err = parser.ParseFile(fset, "main.go", "package main\n\nfunc main() {}\n", 0)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if rewriteExitCalls(node) {
+		t.Error("Expected nothing to rewrite without an os or log import")
+	}
+}
+
+func TestOsExitFlushesProfiles(t *testing.T) {
+	content := `package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+var sink [][]byte
+
+func main() {
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		sink = append(sink, make([]byte, 1024))
+		if len(sink) > 1000 {
+			sink = sink[:0]
+		}
+	}
+	fmt.Println("done")
+	os.Exit(0)
+}`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{
+		CPUFile:   filepath.Join(tempDir, "cpu.prof"),
+		MemFile:   filepath.Join(tempDir, "mem.prof"),
+		EnableCPU: true,
+		EnableMem: true,
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	cpu, err := loadProfile(opts.CPUFile)
+	if err != nil {
+		t.Fatalf("Expected a CPU profile despite os.Exit: %v", err)
+	}
+	if len(cpu.Sample) == 0 {
+		t.Error("Expected the CPU profile to have samples")
+	}
+	if info, err := os.Stat(opts.MemFile); err != nil || info.Size() == 0 {
+		t.Errorf("Expected a non-empty memory profile, got %v", err)
+	}
+}
+
+func TestLogFatalFlushesProfiles(t *testing.T) {
+	content := `package main
+
+import (
+	"log"
+	"time"
+)
+
+func main() {
+	deadline := time.Now().Add(300 * time.Millisecond)
+	n := 0
+	for time.Now().Before(deadline) {
+		n++
+	}
+	log.SetFlags(log.Lshortfile)
+	log.Fatalf("giving up after %d iterations", n)
+}`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{
+		CPUFile:   filepath.Join(tempDir, "cpu.prof"),
+		EnableCPU: true,
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// Capture the program's stderr to check where the message is attributed
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	err = writeAndExecute(context.Background(), node, fset, opts)
+	os.Stderr = stderr
+	w.Close()
+	var out bytes.Buffer
+	out.ReadFrom(r)
+	if err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Errorf("Expected exit status 1 from log.Fatalf, got %v", err)
+	}
+	if !strings.Contains(out.String(), "test.go:14: giving up after") {
+		t.Errorf("Expected the message attributed to the log.Fatalf call, got:\n%s", out.String())
+	}
+
+	cpu, err := loadProfile(opts.CPUFile)
+	if err != nil {
+		t.Fatalf("Expected a CPU profile despite log.Fatalf: %v", err)
+	}
+	if len(cpu.Sample) == 0 {
+		t.Error("Expected the CPU profile to have samples")
+	}
+}
@@ -0,0 +1,244 @@
+package peep
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Size of each chart of an exported dashboard, in SVG user units
+const (
+	exportChartWidth  = 900
+	exportChartHeight = 200
+)
+
+// historySample holds the fields of a metrics sample the exported dashboard charts
+type historySample struct {
+	TimestampMs float64 `json:"timestampMs"`
+	TimestampNs float64 `json:"timestampNs"`
+	CPUPercent  float64 `json:"cpuPercent"`
+	Alloc       float64 `json:"alloc"`
+	Goroutines  float64 `json:"goroutines"`
+	Threads     float64 `json:"threads"`
+}
+
+// timeMs returns the sample's timestamp in milliseconds, whichever unit it carries
+func (s historySample) timeMs() float64 {
+	if s.TimestampNs > 0 {
+		return s.TimestampNs / 1e6
+	}
+	return s.TimestampMs
+}
+
+// readHistory reads metrics samples written by -history-out, one JSON object
+// per line, or a JSON array as served at /history
+func readHistory(r io.Reader) ([]json.RawMessage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	var samples []json.RawMessage
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &samples); err != nil {
+			return nil, fmt.Errorf("failed to parse history: %w", err)
+		}
+		return samples, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20) // per-core samples of large machines exceed the default
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return nil, fmt.Errorf("failed to parse history: line %d is not valid JSON", n)
+		}
+		samples = append(samples, json.RawMessage(bytes.Clone(line)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return samples, nil
+}
+
+// exportChart is one metric of the exported dashboard, drawn as an SVG polyline
+type exportChart struct {
+	Title  string
+	Max    string // label of the top of the y axis
+	Points string
+}
+
+// newExportChart scales values, taken at the given elapsed milliseconds, into
+// the chart area. The y axis starts at 0 and ends at max, or at the highest value.
+func newExportChart(title string, elapsed, values []float64, max float64) exportChart {
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	end := elapsed[len(elapsed)-1]
+
+	var points strings.Builder
+	for i, v := range values {
+		x := 0.0
+		if end > 0 {
+			x = elapsed[i] / end * exportChartWidth
+		}
+		y := float64(exportChartHeight)
+		if max > 0 {
+			y -= v / max * exportChartHeight
+		}
+		fmt.Fprintf(&points, "%.1f,%.1f ", x, y)
+	}
+	return exportChart{
+		Title:  title,
+		Max:    strconv.FormatFloat(max, 'g', 4, 64),
+		Points: strings.TrimSpace(points.String()),
+	}
+}
+
+// exportPage is the data rendered into an exported dashboard
+type exportPage struct {
+	Source   string
+	Samples  int
+	Start    string
+	Duration string
+	Charts   []exportChart
+	Data     []json.RawMessage
+}
+
+var exportTemplate = template.Must(template.New("export").Parse(`<!doctype html>
+<html>
+
+<head>
+    <meta charset="utf-8">
+    <title>peep dashboard snapshot of {{.Source}}</title>
+    <style>
+        body {
+            font-family: system-ui, Segoe UI, Roboto, Arial;
+            margin: 18px
+        }
+
+        svg {
+            background: #fafafa;
+            border: 1px solid #ddd;
+            overflow: visible
+        }
+
+        polyline {
+            fill: none;
+            stroke: #2c7be5;
+            stroke-width: 1.5
+        }
+
+        text {
+            fill: #555;
+            font-size: 12px
+        }
+    </style>
+</head>
+
+<body>
+    <h1>CPU & Memory Usage</h1>
+    <p>{{.Samples}} samples from {{.Source}}, recorded over {{.Duration}} starting {{.Start}}</p>
+    {{- range .Charts}}
+    <h2>{{.Title}}</h2>
+    <svg width="900" height="200" viewBox="0 0 900 200">
+        <polyline points="{{.Points}}"/>
+        <text x="4" y="14">{{.Max}}</text>
+        <text x="4" y="196">0</text>
+        <text x="896" y="196" text-anchor="end">{{$.Duration}}</text>
+    </svg>
+    {{- end}}
+    <script type="application/json" id="samples">{{.Data}}</script>
+</body>
+
+</html>
+`))
+
+// writeExportedDashboard renders samples as a self-contained HTML page with
+// one chart per metric of the live dashboard and the samples embedded as JSON
+func writeExportedDashboard(w io.Writer, source string, samples []json.RawMessage) error {
+	if len(samples) == 0 {
+		return fmt.Errorf("no metrics samples in %s", source)
+	}
+
+	var elapsed, cpu, alloc, goroutines, threads []float64
+	var start float64
+	for i, raw := range samples {
+		var s historySample
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return fmt.Errorf("failed to parse sample %d: %w", i+1, err)
+		}
+		if i == 0 {
+			start = s.timeMs()
+		}
+		elapsed = append(elapsed, s.timeMs()-start)
+		cpu = append(cpu, s.CPUPercent)
+		alloc = append(alloc, s.Alloc/1024/1024)
+		goroutines = append(goroutines, s.Goroutines)
+		threads = append(threads, s.Threads)
+	}
+
+	page := exportPage{
+		Source:   source,
+		Samples:  len(samples),
+		Start:    time.UnixMilli(int64(start)).Format("2006-01-02 15:04:05"),
+		Duration: time.Duration(elapsed[len(elapsed)-1] * float64(time.Millisecond)).Round(time.Millisecond).String(),
+		Charts: []exportChart{
+			newExportChart("CPU %", elapsed, cpu, 100),
+			newExportChart("Alloc MiB", elapsed, alloc, 0),
+			newExportChart("Goroutines", elapsed, goroutines, 0),
+			newExportChart("OS Threads", elapsed, threads, 0),
+		},
+		Data: samples,
+	}
+	if err := exportTemplate.Execute(w, page); err != nil {
+		return fmt.Errorf("failed to render dashboard: %w", err)
+	}
+	return nil
+}
+
+// RunExportDashboard implements peep export-dashboard
+func RunExportDashboard(args []string) error {
+	fs := flag.NewFlagSet("export-dashboard", flag.ExitOnError)
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: peep export-dashboard <history.jsonl> <out.html>")
+	}
+	historyPath, outPath := fs.Arg(0), fs.Arg(1)
+
+	f, err := os.Open(historyPath)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+	samples, err := readHistory(f)
+	if err != nil {
+		return err
+	}
+
+	var page bytes.Buffer
+	if err := writeExportedDashboard(&page, filepath.Base(historyPath), samples); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outPath, page.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write dashboard: %w", err)
+	}
+	log := newLogger(os.Stdout, levelNormal)
+	log.logf(levelNormal, "Dashboard snapshot of %d samples written to %s", len(samples), outPath)
+	return nil
+}
@@ -0,0 +1,63 @@
+package peep
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestReadHistory(t *testing.T) {
+	jsonl := "{\"alloc\":1}\n\n{\"alloc\":2}\n"
+	samples, err := readHistory(strings.NewReader(jsonl))
+	if err != nil {
+		t.Fatalf("Failed to read JSONL history: %v", err)
+	}
+	if len(samples) != 2 || string(samples[1]) != `{"alloc":2}` {
+		t.Errorf("Expected 2 samples skipping the blank line, got %q", samples)
+	}
+
+	samples, err = readHistory(strings.NewReader(` [{"alloc":1},{"alloc":2},{"alloc":3}]`))
+	if err != nil {
+		t.Fatalf("Failed to read history array: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Errorf("Expected 3 samples from a /history array, got %q", samples)
+	}
+
+	if _, err := readHistory(strings.NewReader("{\"alloc\":1}\n{\"alloc\":\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected an error naming line 2, got %v", err)
+	}
+}
+
+func TestWriteExportedDashboard(t *testing.T) {
+	samples := []json.RawMessage{
+		json.RawMessage(`{"timestampMs":1000,"cpuPercent":50,"alloc":1048576,"goroutines":2,"threads":4}`),
+		json.RawMessage(`{"timestampMs":2000,"cpuPercent":100,"alloc":2097152,"goroutines":4,"threads":8}`),
+	}
+	var buf bytes.Buffer
+	if err := writeExportedDashboard(&buf, "h.jsonl", samples); err != nil {
+		t.Fatalf("writeExportedDashboard failed: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		"<h2>CPU %</h2>",
+		"<h2>Alloc MiB</h2>",
+		"<h2>Goroutines</h2>",
+		"<h2>OS Threads</h2>",
+		`<polyline points="0.0,100.0 900.0,0.0"/>`,
+		"2 samples from h.jsonl, recorded over 1s",
+		`"goroutines":4`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected the page to contain %q, got:\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "<script src") {
+		t.Error("Expected the page to load no external scripts")
+	}
+
+	if err := writeExportedDashboard(&buf, "empty.jsonl", nil); err == nil {
+		t.Error("Expected an error for a history without samples")
+	}
+}
@@ -0,0 +1,108 @@
+package peep
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// declaresFunc reports whether node declares the function called name, using
+// the Name or Type.Method form of funcDeclName
+func declaresFunc(node *ast.File, name string) bool {
+	for _, decl := range node.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil && funcDeclName(fn) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// methodsNamed returns the Type.Method names of the methods in node called
+// name, for pointing a -func given a bare method name at the form it needs
+func methodsNamed(node *ast.File, name string) []string {
+	var methods []string
+	for _, decl := range node.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil && fn.Recv != nil && fn.Name.Name == name {
+			methods = append(methods, funcDeclName(fn))
+		}
+	}
+	return methods
+}
+
+// funcNotFoundError reports that the function called name is not declared,
+// suggesting the Type.Method form when methods of that name are
+func funcNotFoundError(name, where string, methods []string) error {
+	if len(methods) > 0 {
+		return fmt.Errorf("-func: function %s not found in %s, but it is declared as a method; pass -func %s", name, where, strings.Join(methods, " or -func "))
+	}
+	return fmt.Errorf("-func: function %s not found in %s", name, where)
+}
+
+// referencesFunc reports whether node calls or otherwise refers to the function
+// called name. Without type information a method counts as referenced when any
+// selector uses its name.
+func referencesFunc(node *ast.File, name string) bool {
+	_, method, isMethod := strings.Cut(name, ".")
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			// Declared names are not references, and neither is the function
+			// calling itself
+			if n.Body != nil && funcDeclName(n) != name {
+				ast.Inspect(n.Body, func(m ast.Node) bool {
+					found = found || refersTo(m, name, method, isMethod)
+					return !found
+				})
+			}
+			return false
+		default:
+			found = refersTo(n, name, method, isMethod)
+			return !found
+		}
+	})
+	return found
+}
+
+// refersTo reports whether n is an identifier or selector naming the function
+func refersTo(n ast.Node, name, method string, isMethod bool) bool {
+	if isMethod {
+		sel, ok := n.(*ast.SelectorExpr)
+		return ok && sel.Sel.Name == method
+	}
+	ident, ok := n.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+// findFuncFile returns the package file declaring the function called name,
+// checking that the package refers to it so the instrumented code can run
+func findFuncFile(files []string, name string) (string, error) {
+	fset := token.NewFileSet()
+	var declFile string
+	var methods []string
+	referenced := false
+	for _, file := range files {
+		node, err := parser.ParseFile(fset, file, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		if declFile == "" && declaresFunc(node, name) {
+			declFile = file
+		}
+		methods = append(methods, methodsNamed(node, name)...)
+		referenced = referenced || referencesFunc(node, name)
+	}
+
+	if declFile == "" {
+		return "", funcNotFoundError(name, "the target package", methods)
+	}
+	if !referenced {
+		return "", fmt.Errorf("-func: %s is never called or referenced in the target package, so it would not run", name)
+	}
+	return declFile, nil
+}
@@ -0,0 +1,190 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSubcommandPackage writes a package dispatching on its first argument to
+// runServe, declared in a file other than main's
+func writeSubcommandPackage(t *testing.T) (string, []string) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.go": `package main
+
+import "os"
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		runServe(os.Args[2:])
+	}
+}
+`,
+		"serve.go": `package main
+
+import "fmt"
+
+type server struct{}
+
+func (s *server) unused() {}
+
+func runServe(args []string) {
+	data := make([][]byte, 0)
+	for i := 0; i < 100; i++ {
+		data = append(data, make([]byte, 1024))
+	}
+	fmt.Println("serving", args, len(data))
+}
+
+func unreferenced() {
+	unreferenced()
+}
+`,
+	}
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		paths = append(paths, path)
+	}
+	// Package mode builds in place, inside the package's module
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module subcommand\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write go.mod: %v", err)
+	}
+	return dir, paths
+}
+
+func TestFindFuncFile(t *testing.T) {
+	dir, files := writeSubcommandPackage(t)
+
+	got, err := findFuncFile(files, "runServe")
+	if err != nil {
+		t.Fatalf("Expected runServe to be found: %v", err)
+	}
+	if got != filepath.Join(dir, "serve.go") {
+		t.Errorf("Expected runServe in serve.go, got %s", got)
+	}
+
+	tests := []struct {
+		name, want string
+	}{
+		{"RunClient", "not found"},
+		{"unreferenced", "never called or referenced"}, // only calls itself
+		{"server.unused", "never called or referenced"},
+		{"server.missing", "not found"},
+		{"unused", "pass -func server.unused"}, // a method, given without its type
+	}
+	for _, tt := range tests {
+		if _, err := findFuncFile(files, tt.name); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestInstrumentNamedFunction(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	work()
+}
+
+func work() {
+	fmt.Println("working")
+}
+`
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, "test.go", content, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Failed to parse test file: %v", err)
+	}
+
+	cpuFileVar, cpuErrVar := generateUniqueVars()
+	memFileVar, memErrVar := generateUniqueVars()
+	instrumentMainFunction(node, cpuFileVar, cpuErrVar, memFileVar, memErrVar, Options{CPUFile: "cpu.prof", EnableCPU: true, Func: "work"})
+
+	bodies := map[string]string{}
+	for _, decl := range node.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			var buf bytes.Buffer
+			format.Node(&buf, fset, fn.Body)
+			bodies[fn.Name.Name] = buf.String()
+		}
+	}
+	if !strings.Contains(bodies["work"], "pprof.StartCPUProfile") || !strings.Contains(bodies["work"], "defer pprof.StopCPUProfile()") {
+		t.Errorf("Expected work to start and defer stopping the CPU profile, got:\n%s", bodies["work"])
+	}
+	if strings.Contains(bodies["main"], "pprof") {
+		t.Errorf("Expected main to be left alone, got:\n%s", bodies["main"])
+	}
+}
+
+func TestProcessGoFileFuncIsMethod(t *testing.T) {
+	content := `package main
+
+type server struct{}
+
+func (s *server) Serve() {}
+
+func main() {
+	new(server).Serve()
+}
+`
+	testFile := filepath.Join(t.TempDir(), "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	_, _, err := processGoFile(testFile, Options{CPUFile: "cpu.prof", EnableCPU: true, Func: "Serve"})
+	if err == nil || !strings.Contains(err.Error(), "pass -func server.Serve") {
+		t.Errorf("Expected an error pointing at server.Serve, got %v", err)
+	}
+	if _, _, err := processGoFile(testFile, Options{CPUFile: "cpu.prof", EnableCPU: true, Func: "missing"}); err == nil || !strings.Contains(err.Error(), "function missing not found") {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+}
+
+func TestFuncProfilesSubcommand(t *testing.T) {
+	dir, files := writeSubcommandPackage(t)
+	cpuFile := filepath.Join(dir, "cpu.prof")
+	memFile := filepath.Join(dir, "mem.prof")
+
+	opts := Options{
+		CPUFile:     cpuFile,
+		MemFile:     memFile,
+		EnableCPU:   true,
+		EnableMem:   true,
+		Func:        "runServe",
+		ProgramArgs: []string{"serve", "--port", "8080"},
+	}
+	funcFile, err := findFuncFile(files, opts.Func)
+	if err != nil {
+		t.Fatalf("Failed to find %s: %v", opts.Func, err)
+	}
+	node, fset, err := processGoFile(funcFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process %s: %v", funcFile, err)
+	}
+	if err := writeAndExecutePackage(context.Background(), node, fset, funcFile, files, opts); err != nil {
+		t.Fatalf("writeAndExecutePackage failed: %v", err)
+	}
+
+	// The profiles are only written if the subcommand ran
+	for _, path := range []string{cpuFile, memFile} {
+		if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+			t.Errorf("Expected a non-empty profile at %s: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package peep
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GCEvent is a single garbage collection reported by GODEBUG=gctrace=1
+type GCEvent struct {
+	Num          int     `json:"num"`
+	ElapsedSec   float64 `json:"elapsedSec"`   // time since program start
+	CPUPercent   int     `json:"cpuPercent"`   // share of CPU used by GC since program start
+	PauseMS      float64 `json:"pauseMs"`      // stop-the-world sweep termination + mark termination
+	HeapBeforeMB int     `json:"heapBeforeMb"` // heap size at GC start
+	HeapAfterMB  int     `json:"heapAfterMb"`  // heap size at GC end
+	HeapLiveMB   int     `json:"heapLiveMb"`   // live heap after marking
+	HeapGoalMB   int     `json:"heapGoalMb"`
+	TimestampMS  int64   `json:"timestampMs"`
+}
+
+// gctraceLine matches the fields of a gctrace line that peep reports, e.g.
+// gc 1 @0.012s 2%: 0.010+0.50+0.003 ms clock, 0.08+0.1/0.4/0.2+0.02 ms cpu, 4->4->0 MB, 4 MB goal, ...
+var gctraceLine = regexp.MustCompile(`^gc (\d+) @([\d.]+)s (\d+)%: ([\d.]+)\+[\d.]+\+([\d.]+) ms clock, .*? (\d+)->(\d+)->(\d+) MB, (\d+) MB goal`)
+
+// parseGCTraceLine parses a gctrace line, reporting false for any other output
+func parseGCTraceLine(line string) (GCEvent, bool) {
+	match := gctraceLine.FindStringSubmatch(line)
+	if match == nil {
+		return GCEvent{}, false
+	}
+
+	num, _ := strconv.Atoi(match[1])
+	elapsed, _ := strconv.ParseFloat(match[2], 64)
+	cpuPercent, _ := strconv.Atoi(match[3])
+	sweepTerm, _ := strconv.ParseFloat(match[4], 64)
+	markTerm, _ := strconv.ParseFloat(match[5], 64)
+	before, _ := strconv.Atoi(match[6])
+	after, _ := strconv.Atoi(match[7])
+	live, _ := strconv.Atoi(match[8])
+	goal, _ := strconv.Atoi(match[9])
+
+	return GCEvent{
+		Num:          num,
+		ElapsedSec:   elapsed,
+		CPUPercent:   cpuPercent,
+		PauseMS:      sweepTerm + markTerm,
+		HeapBeforeMB: before,
+		HeapAfterMB:  after,
+		HeapLiveMB:   live,
+		HeapGoalMB:   goal,
+		TimestampMS:  time.Now().UnixMilli(),
+	}, true
+}
+
+// newGCTraceWriter creates a lineWriter that records gctrace lines as GC events
+func newGCTraceWriter(out io.Writer, gcEvents *eventLog[GCEvent]) *lineWriter {
+	return newLineWriter(out, func(line []byte) {
+		if event, ok := parseGCTraceLine(string(line)); ok {
+			gcEvents.Add(event)
+		}
+	})
+}
+
+// gctraceGODEBUG returns the GODEBUG value for the target with gctrace enabled,
+// preserving any settings already present in peep's environment
+func gctraceGODEBUG() string {
+	existing := os.Getenv("GODEBUG")
+	if existing == "" {
+		return "gctrace=1"
+	}
+	if strings.HasSuffix(existing, ",") {
+		return existing + "gctrace=1"
+	}
+	return existing + ",gctrace=1"
+}
@@ -0,0 +1,108 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseGCTraceLine(t *testing.T) {
+	line := "gc 3 @0.125s 2%: 0.010+0.50+0.003 ms clock, 0.08+0.1/0.4/0.2+0.02 ms cpu, 12->14->5 MB, 16 MB goal, 0 MB stacks, 0 MB globals, 8 P"
+
+	event, ok := parseGCTraceLine(line)
+	if !ok {
+		t.Fatal("Expected gctrace line to parse")
+	}
+
+	if event.Num != 3 {
+		t.Errorf("Expected GC number 3, got %d", event.Num)
+	}
+	if event.ElapsedSec != 0.125 {
+		t.Errorf("Expected elapsed 0.125s, got %v", event.ElapsedSec)
+	}
+	if event.CPUPercent != 2 {
+		t.Errorf("Expected 2%% GC CPU, got %d", event.CPUPercent)
+	}
+	if event.PauseMS < 0.0129 || event.PauseMS > 0.0131 {
+		t.Errorf("Expected pause of 0.013ms (STW phases only), got %v", event.PauseMS)
+	}
+	if event.HeapBeforeMB != 12 || event.HeapAfterMB != 14 || event.HeapLiveMB != 5 || event.HeapGoalMB != 16 {
+		t.Errorf("Unexpected heap sizes: %+v", event)
+	}
+}
+
+func TestParseGCTraceLineIgnoresOtherOutput(t *testing.T) {
+	lines := []string{
+		"",
+		"Starting test program...",
+		"gc is great",
+		"scvg: 0 MB released",
+	}
+	for _, line := range lines {
+		if _, ok := parseGCTraceLine(line); ok {
+			t.Errorf("Expected %q not to parse as a GC event", line)
+		}
+	}
+}
+
+func TestGCTraceGODEBUG(t *testing.T) {
+	t.Setenv("GODEBUG", "")
+	if got := gctraceGODEBUG(); got != "gctrace=1" {
+		t.Errorf("Expected gctrace=1, got %q", got)
+	}
+
+	t.Setenv("GODEBUG", "madvdontneed=1")
+	if got := gctraceGODEBUG(); got != "madvdontneed=1,gctrace=1" {
+		t.Errorf("Expected existing settings to be preserved, got %q", got)
+	}
+}
+
+func TestGCTraceOnlyReachesTarget(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("gctrace mode relies on env")
+	}
+
+	content := `package main
+
+import "runtime"
+
+func main() {
+	runtime.GC()
+	runtime.GC()
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	binary, err := buildTarget(context.Background(), Options{}, "", tempDir, testFile)
+	if err != nil {
+		t.Fatalf("buildTarget failed: %v", err)
+	}
+	// GODEBUG is set for the built binary only, as runInstrumented does
+	cmd := exec.Command(binary)
+	cmd.Env = append(os.Environ(), "GODEBUG="+gctraceGODEBUG())
+
+	var stderr bytes.Buffer
+	gcEvents := &eventLog[GCEvent]{}
+	cmd.Stderr = newGCTraceWriter(&stderr, gcEvents)
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Target failed: %v\n%s", err, stderr.String())
+	}
+
+	// Only the two forced collections in the target should be reported,
+	// not collections from the go command or compiler
+	events := gcEvents.List()
+	if len(events) != 2 {
+		t.Errorf("Expected 2 GC events from the target, got %d:\n%s", len(events), stderr.String())
+	}
+}
@@ -0,0 +1,273 @@
+package peep
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// goroutineGrowthTop is how many growing start functions the leak report lists
+const goroutineGrowthTop = 10
+
+// listGoroutineProfiles returns the periodic goroutine profiles written with
+// prefix, in the order they were taken
+func listGoroutineProfiles(prefix string) ([]string, error) {
+	matches, err := filepath.Glob(prefix + "-*.prof")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list goroutine profiles: %w", err)
+	}
+
+	numbers := make(map[string]int)
+	var paths []string
+	for _, path := range matches {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path, prefix+"-"), ".prof"))
+		if err != nil || n < 1 {
+			continue
+		}
+		numbers[path] = n
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return numbers[paths[i]] < numbers[paths[j]] })
+	return paths, nil
+}
+
+// removeGoroutineProfiles deletes the periodic goroutine profiles of an
+// earlier run, so they are not mistaken for this run's
+func removeGoroutineProfiles(w io.Writer, prefix string) error {
+	paths, err := listGoroutineProfiles(prefix)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+	if len(paths) > 0 {
+		fmt.Fprintf(w, "[prof] Removed %d goroutine profiles from an earlier run\n", len(paths))
+	}
+	return nil
+}
+
+// createGoroutineTickerStmt creates a goroutine that writes a numbered
+// goroutine profile every interval:
+//
+//	go func() {
+//		ticker := time.NewTicker(interval)
+//		n := 0
+//		for range ticker.C {
+//			n++
+//			f, err := os.Create(prefix + "-" + strconv.Itoa(n) + ".prof")
+//			if err != nil { log.Print(err); return }
+//			pprof.Lookup("goroutine").WriteTo(f, 0)
+//			f.Close()
+//		}
+//	}()
+func createGoroutineTickerStmt(prefix string, interval time.Duration) ast.Stmt {
+	// f, err := os.Create(prefix + "-" + strconv.Itoa(n) + ".prof")
+	create := &ast.AssignStmt{
+		Lhs: []ast.Expr{ast.NewIdent("f"), ast.NewIdent("err")},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{
+			&ast.CallExpr{
+				Fun: &ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("Create")},
+				Args: []ast.Expr{
+					&ast.BinaryExpr{
+						X: &ast.BinaryExpr{
+							X:  &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(prefix + "-")},
+							Op: token.ADD,
+							Y: &ast.CallExpr{
+								Fun:  &ast.SelectorExpr{X: ast.NewIdent("strconv"), Sel: ast.NewIdent("Itoa")},
+								Args: []ast.Expr{ast.NewIdent("n")},
+							},
+						},
+						Op: token.ADD,
+						Y:  &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(".prof")},
+					},
+				},
+			},
+		},
+	}
+
+	// if err != nil { log.Print(err); return }
+	checkErr := &ast.IfStmt{
+		Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ExprStmt{
+					X: &ast.CallExpr{
+						Fun:  &ast.SelectorExpr{X: ast.NewIdent("log"), Sel: ast.NewIdent("Print")},
+						Args: []ast.Expr{ast.NewIdent("err")},
+					},
+				},
+				&ast.ReturnStmt{},
+			},
+		},
+	}
+
+	// pprof.Lookup("goroutine").WriteTo(f, 0)
+	write := &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X: &ast.CallExpr{
+					Fun:  &ast.SelectorExpr{X: ast.NewIdent("pprof"), Sel: ast.NewIdent("Lookup")},
+					Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("goroutine")}},
+				},
+				Sel: ast.NewIdent("WriteTo"),
+			},
+			Args: []ast.Expr{ast.NewIdent("f"), &ast.BasicLit{Kind: token.INT, Value: "0"}},
+		},
+	}
+
+	// f.Close()
+	closeFile := &ast.ExprStmt{
+		X: &ast.CallExpr{Fun: &ast.SelectorExpr{X: ast.NewIdent("f"), Sel: ast.NewIdent("Close")}},
+	}
+
+	return &ast.GoStmt{
+		Call: &ast.CallExpr{
+			Fun: &ast.FuncLit{
+				Type: &ast.FuncType{Params: &ast.FieldList{}},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						// ticker := time.NewTicker(interval)
+						&ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("ticker")},
+							Tok: token.DEFINE,
+							Rhs: []ast.Expr{
+								&ast.CallExpr{
+									Fun: &ast.SelectorExpr{X: ast.NewIdent("time"), Sel: ast.NewIdent("NewTicker")},
+									Args: []ast.Expr{
+										&ast.CallExpr{
+											Fun:  &ast.SelectorExpr{X: ast.NewIdent("time"), Sel: ast.NewIdent("Duration")},
+											Args: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(int64(interval), 10)}},
+										},
+									},
+								},
+							},
+						},
+						// n := 0
+						&ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("n")},
+							Tok: token.DEFINE,
+							Rhs: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "0"}},
+						},
+						// for range ticker.C { ... }
+						&ast.RangeStmt{
+							X: &ast.SelectorExpr{X: ast.NewIdent("ticker"), Sel: ast.NewIdent("C")},
+							Body: &ast.BlockStmt{
+								List: []ast.Stmt{
+									&ast.IncDecStmt{X: ast.NewIdent("n"), Tok: token.INC},
+									create,
+									checkErr,
+									write,
+									closeFile,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// goroutineStartFunc returns the function a sampled goroutine started in: the
+// outermost frame of its stack, below runtime.goexit
+func goroutineStartFunc(s *profile.Sample) string {
+	for i := len(s.Location) - 1; i >= 0; i-- {
+		lines := s.Location[i].Line
+		for j := len(lines) - 1; j >= 0; j-- {
+			if fn := lines[j].Function; fn != nil && fn.Name != "runtime.goexit" {
+				return fn.Name
+			}
+		}
+	}
+	return ""
+}
+
+// goroutinesByStartFunc counts the goroutines of a goroutine profile by the
+// function they started in
+func goroutinesByStartFunc(p *profile.Profile) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, s := range p.Sample {
+		if name := goroutineStartFunc(s); name != "" && len(s.Value) > 0 {
+			counts[name] += s.Value[0]
+		}
+	}
+	return counts
+}
+
+// GoroutineGrowth is the change in goroutines started in one function
+type GoroutineGrowth struct {
+	Function    string
+	First, Last int64
+}
+
+// goroutineGrowth returns the start functions with more goroutines in last
+// than in first, the fastest growing first
+func goroutineGrowth(first, last map[string]int64) []GoroutineGrowth {
+	var growth []GoroutineGrowth
+	for name, n := range last {
+		if n > first[name] {
+			growth = append(growth, GoroutineGrowth{Function: name, First: first[name], Last: n})
+		}
+	}
+	sort.Slice(growth, func(i, j int) bool {
+		a, b := growth[i], growth[j]
+		if a.Last-a.First != b.Last-b.First {
+			return a.Last-a.First > b.Last-b.First
+		}
+		return a.Function < b.Function
+	})
+	return growth
+}
+
+// reportGoroutineProfiles lists the periodic goroutine profiles of the run and
+// the start functions whose goroutines grew from the first to the last
+func reportGoroutineProfiles(w io.Writer, opts Options) error {
+	paths, err := listGoroutineProfiles(opts.GoroutinePrefix)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		fmt.Fprintf(w, "[prof] No goroutine profiles were written, the program exited within -goroutine-interval %s\n", opts.GoroutineInterval)
+		return nil
+	}
+	fmt.Fprintf(w, "[prof] %d goroutine profiles saved to %s\n", len(paths), paths[0]+" ... "+filepath.Base(paths[len(paths)-1]))
+	if len(paths) < 2 {
+		return nil
+	}
+
+	first, err := loadProfile(paths[0])
+	if err != nil {
+		return err
+	}
+	last, err := loadProfile(paths[len(paths)-1])
+	if err != nil {
+		return err
+	}
+
+	growth := goroutineGrowth(goroutinesByStartFunc(first), goroutinesByStartFunc(last))
+	if len(growth) == 0 {
+		fmt.Fprintln(w, "[prof] No function gained goroutines between the first and last goroutine profile")
+		return nil
+	}
+	if len(growth) > goroutineGrowthTop {
+		growth = growth[:goroutineGrowthTop]
+	}
+	fmt.Fprintf(w, "[prof] Goroutines by start function, %s -> %s:\n", filepath.Base(paths[0]), filepath.Base(paths[len(paths)-1]))
+	for _, g := range growth {
+		fmt.Fprintf(w, "[prof]   %d -> %d (+%d) %s\n", g.First, g.Last, g.Last-g.First, g.Function)
+	}
+	return nil
+}
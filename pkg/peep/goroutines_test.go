@@ -0,0 +1,84 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListGoroutineProfilesInNumericOrder(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "goroutine")
+	for _, name := range []string{"goroutine-10.prof", "goroutine-2.prof", "goroutine-1.prof", "goroutine-x.prof", "goroutine.prof"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	paths, err := listGoroutineProfiles(prefix)
+	if err != nil {
+		t.Fatalf("listGoroutineProfiles failed: %v", err)
+	}
+	want := []string{prefix + "-1.prof", prefix + "-2.prof", prefix + "-10.prof"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expected %v, got %v", want, paths)
+	}
+}
+
+func TestGoroutineGrowth(t *testing.T) {
+	first := map[string]int64{"main.worker": 4, "main.leak": 1, "main.done": 3}
+	last := map[string]int64{"main.worker": 4, "main.leak": 40, "main.poll": 2}
+
+	want := []GoroutineGrowth{
+		{Function: "main.leak", First: 1, Last: 40},
+		{Function: "main.poll", First: 0, Last: 2},
+	}
+	if got := goroutineGrowth(first, last); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestGoroutineIntervalReportsLeak(t *testing.T) {
+	content := `package main
+
+import "time"
+
+func leak(ch chan int) { <-ch }
+
+func main() {
+	ch := make(chan int)
+	for i := 0; i < 40; i++ {
+		go leak(ch)
+		time.Sleep(10 * time.Millisecond)
+	}
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	prefix := filepath.Join(tempDir, "goroutine")
+	opts := Options{GoroutineInterval: 100 * time.Millisecond, GoroutinePrefix: prefix}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := reportGoroutineProfiles(&buf, opts); err != nil {
+		t.Fatalf("reportGoroutineProfiles failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "main.leak") {
+		t.Errorf("Expected the leaking function to be reported, got:\n%s", buf.String())
+	}
+}
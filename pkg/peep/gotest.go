@@ -0,0 +1,62 @@
+package peep
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// goTestArgs returns the go test arguments that compile the test binary to
+// binary, out of the way of the package's directory where go test leaves it
+// when profiling, and write the enabled profiles with go test's own flags
+func goTestArgs(binary string, opts Options) ([]string, error) {
+	args := []string{"test", "-count=1", "-o", binary}
+	args = append(args, goBuildFlags(opts)...)
+	if opts.EnableCPU {
+		cpuFile, err := filepath.Abs(opts.CPUFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", opts.CPUFile, err)
+		}
+		args = append(args, "-cpuprofile", cpuFile)
+	}
+	if opts.EnableMem {
+		memFile, err := filepath.Abs(opts.MemFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", opts.MemFile, err)
+		}
+		args = append(args, "-memprofile", memFile)
+	}
+	if opts.EnableMutex {
+		args = append(args, "-mutexprofile", opts.MutexFile, "-mutexprofilefraction", strconv.Itoa(opts.MutexRate))
+	}
+	if opts.TraceFile != "" {
+		args = append(args, "-trace", opts.TraceFile)
+	}
+	return args, nil
+}
+
+// runTests profiles the tests of the package at dir, passing opts.ProgramArgs
+// to go test to select them (-run, -bench and the like). go test only writes
+// profiles for a single package, so dir is one package rather than a pattern.
+func runTests(ctx context.Context, dir string, opts Options) error {
+	tempDir, err := os.MkdirTemp("", "peep-pkg-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	args, err := goTestArgs(filepath.Join(tempDir, "pkg.test"), opts)
+	if err != nil {
+		return err
+	}
+	args = append(args, ".")
+	args = append(args, opts.ProgramArgs...)
+
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	configureCancel(ctx, cmd, opts.PTY)
+	return runInstrumented(ctx, cmd, opts, "tests")
+}
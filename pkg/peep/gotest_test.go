@@ -0,0 +1,61 @@
+package peep
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunTestsWritesProfiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module sum\n\ngo 1.21\n",
+		"sum.go": "package sum\n\nfunc Sum(n int) int {\n\ttotal := 0\n\tfor i := 0; i < n; i++ {\n\t\ttotal += i % 7\n\t}\n\treturn total\n}\n",
+		"sum_test.go": `package sum
+
+import "testing"
+
+func TestSum(t *testing.T) {
+	if Sum(4) != 6 {
+		t.Fatal("wrong sum")
+	}
+	for i := 0; i < 200; i++ {
+		Sum(1_000_000)
+	}
+}
+
+func TestSkipped(t *testing.T) {
+	t.Fatal("deselected by -run")
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	out := t.TempDir()
+
+	opts := Options{
+		EnableCPU:   true,
+		EnableMem:   true,
+		CPUFile:     filepath.Join(out, "cpu.prof"),
+		MemFile:     filepath.Join(out, "mem.prof"),
+		ProgramArgs: []string{"-run", "^TestSum$"},
+	}
+	if err := runTests(context.Background(), dir, opts); err != nil {
+		t.Fatalf("runTests failed: %v", err)
+	}
+
+	for _, path := range []string{opts.CPUFile, opts.MemFile} {
+		if _, err := loadProfile(path); err != nil {
+			t.Errorf("Expected a profile at %s: %v", path, err)
+		}
+	}
+	// No test binary is left in the package directory
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != len(files) {
+		t.Errorf("Expected the package directory to be left untouched, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,103 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// historyPollInterval is how often the metrics source is checked for new
+// samples, matching the fastest adaptive sampling interval. A shorter -interval
+// is polled at that interval instead.
+const historyPollInterval = adaptiveMinIntervalMS * time.Millisecond
+
+// recordHistory polls the metrics the target reports and adds each new
+// sample to data's history until ctx is done, publishing it to the feed and
+// keeping it for -csv-out and -summary when they are set. With historyOut set, each sample
+// is also appended to it as a line of JSON, for -history-out.
+func recordHistory(ctx context.Context, source metricsSource, data *dashboardData) {
+	ticker := time.NewTicker(data.historyPoll)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sample, err := source.Latest()
+		if err != nil || bytes.Equal(sample, last) || !json.Valid(sample) {
+			continue
+		}
+		last = sample
+		data.history.Add(json.RawMessage(sample))
+		if data.feed != nil {
+			data.feed.Publish(json.RawMessage(sample))
+		}
+		if data.samples != nil {
+			data.samples.Add(json.RawMessage(sample))
+		}
+		if out := data.historyOut; out != nil {
+			var line bytes.Buffer
+			if json.Compact(&line, sample) == nil {
+				line.WriteByte('\n')
+				out.Write(line.Bytes())
+			}
+		}
+	}
+}
+
+// historyHandler serves the recorded samples as a JSON array, oldest first
+func historyHandler(history *ringBuffer[json.RawMessage]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history.List())
+	}
+}
+
+// sendMetrics polls the metrics the target reports and sends each new sample,
+// decoded, on out until the returned stop is called. stop waits for a send in
+// progress to give up, so nothing is sent on out afterwards, and may be
+// called more than once.
+func sendMetrics(ctx context.Context, source metricsSource, out chan<- Metrics, poll time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(poll)
+		defer ticker.Stop()
+
+		var last []byte
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			sample, err := source.Latest()
+			if err != nil || bytes.Equal(sample, last) {
+				continue
+			}
+			var m Metrics
+			if json.Unmarshal(sample, &m) != nil {
+				continue
+			}
+			last = sample
+			select {
+			case out <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
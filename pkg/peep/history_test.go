@@ -0,0 +1,76 @@
+package peep
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRingBufferKeepsMostRecent(t *testing.T) {
+	b := newRingBuffer[int](3)
+	if got := b.List(); len(got) != 0 {
+		t.Errorf("Expected an empty buffer, got %v", got)
+	}
+
+	for i := 1; i <= 5; i++ {
+		b.Add(i)
+	}
+	if got := b.List(); !slices.Equal(got, []int{3, 4, 5}) {
+		t.Errorf("Expected the 3 most recent entries oldest first, got %v", got)
+	}
+
+	disabled := newRingBuffer[int](0)
+	disabled.Add(1)
+	if got := disabled.List(); len(got) != 0 {
+		t.Errorf("Expected a zero-size buffer to keep nothing, got %v", got)
+	}
+}
+
+func TestRecordHistory(t *testing.T) {
+	metricsPath := filepath.Join(t.TempDir(), "peep_metrics.json")
+	history := newRingBuffer[json.RawMessage](10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		recordHistory(ctx, metricsFileSource{metricsPath}, &dashboardData{history: history, historyPoll: 5 * time.Millisecond})
+		close(done)
+	}()
+
+	// Each sample is written once and must be recorded once, however many
+	// times the file is polled
+	for i := 1; i <= 3; i++ {
+		sample := []byte(`{"alloc":` + string(rune('0'+i)) + `}`)
+		if err := os.WriteFile(metricsPath, sample, 0o644); err != nil {
+			t.Fatalf("Failed to write metrics: %v", err)
+		}
+		deadline := time.Now().Add(2 * time.Second)
+		for len(history.List()) < i && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	rec := httptest.NewRecorder()
+	historyHandler(history)(rec, httptest.NewRequest("GET", "/history", nil))
+
+	var samples []map[string]float64
+	if err := json.Unmarshal(rec.Body.Bytes(), &samples); err != nil {
+		t.Fatalf("Failed to decode history: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("Expected 3 samples, got %d: %s", len(samples), rec.Body.String())
+	}
+	for i, s := range samples {
+		if s["alloc"] != float64(i+1) {
+			t.Errorf("Expected sample %d to have alloc %d, got %v", i, i+1, s["alloc"])
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package peep_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cpcf/peep/pkg/peep"
+)
+
+// TestRunFromAnotherPackage uses the API as tools importing peep see it
+func TestRunFromAnotherPackage(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := peep.DefaultOptions()
+	opts.Target = testFile
+	opts.EnableMem = true
+	opts.MemFile = filepath.Join(tempDir, "mem.prof")
+	result, err := peep.Run(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if result.ExitCode != 0 || len(result.Files) != 1 || result.Files[0] != opts.MemFile {
+		t.Errorf("Expected exit code 0 and %s, got %+v", opts.MemFile, result)
+	}
+
+	opts.MetricsPriority = "low"
+	if _, err := peep.Run(context.Background(), opts); err == nil {
+		t.Error("Expected -metrics-priority low without -dash to be rejected")
+	}
+}
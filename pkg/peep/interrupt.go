@@ -0,0 +1,33 @@
+package peep
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+)
+
+// interrupted is set once peep receives Ctrl+C
+var interrupted atomic.Bool
+
+// errInterrupted is returned instead of starting the target after Ctrl+C
+var errInterrupted = errors.New("interrupted")
+
+// CatchInterrupts keeps Ctrl+C from killing peep before its deferred cleanup of
+// temp files has run. The terminal also delivers the interrupt to the go
+// commands peep is running (go list, go generate, go build and the target), so
+// they exit and peep unwinds through its normal error paths. Once interrupted,
+// no further run is started. A second Ctrl+C exits immediately.
+func CatchInterrupts() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		for range sigs {
+			if interrupted.Swap(true) {
+				os.Exit(130)
+			}
+			fmt.Fprintln(os.Stderr, "[prof] Interrupted, cleaning up (press Ctrl+C again to exit immediately)")
+		}
+	}()
+}
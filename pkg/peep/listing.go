@@ -0,0 +1,208 @@
+package peep
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// LineEntry is one source line of a function with its flat and cum values
+type LineEntry struct {
+	File        string  `json:"file"`
+	Line        int64   `json:"line"`
+	Flat        int64   `json:"flat"`
+	FlatPercent float64 `json:"flatPercent"`
+	Cum         int64   `json:"cum"`
+	CumPercent  float64 `json:"cumPercent"`
+}
+
+// functionLines holds the values a profile attributes to the lines of one function
+type functionLines struct {
+	name      string
+	file      string
+	startLine int64
+	lines     map[int64]*LineEntry
+}
+
+// newFunctionLines attributes the sample value at idx to the source lines of
+// every function matched by match. Like in the top report, a line's flat value
+// counts samples where it is the innermost frame, and its cum value samples
+// where it appears anywhere in the stack.
+func newFunctionLines(p *profile.Profile, idx int, match func(string) bool) map[string]*functionLines {
+	funcs := make(map[string]*functionLines)
+	for _, s := range p.Sample {
+		v := s.Value[idx]
+
+		type frame struct {
+			function string
+			line     int64
+		}
+		seen := make(map[frame]bool)
+		for i, loc := range s.Location {
+			for j, line := range loc.Line {
+				if line.Function == nil || !match(line.Function.Name) {
+					continue
+				}
+				fl := funcs[line.Function.Name]
+				if fl == nil {
+					fl = &functionLines{
+						name:      line.Function.Name,
+						file:      line.Function.Filename,
+						startLine: line.Function.StartLine,
+						lines:     make(map[int64]*LineEntry),
+					}
+					funcs[fl.name] = fl
+				}
+				entry := fl.lines[line.Line]
+				if entry == nil {
+					entry = &LineEntry{File: fl.file, Line: line.Line}
+					fl.lines[line.Line] = entry
+				}
+				// The first line of the first location is the innermost frame
+				if i == 0 && j == 0 {
+					entry.Flat += v
+				}
+				if f := (frame{fl.name, line.Line}); !seen[f] {
+					seen[f] = true
+					entry.Cum += v
+				}
+			}
+		}
+	}
+	return funcs
+}
+
+// hottestLines returns the n lines of fl with the highest flat value, then cum value
+func hottestLines(fl *functionLines, n int, total int64) []LineEntry {
+	lines := make([]LineEntry, 0, len(fl.lines))
+	for _, entry := range fl.lines {
+		lines = append(lines, *entry)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if a.Flat != b.Flat {
+			return a.Flat > b.Flat
+		}
+		if a.Cum != b.Cum {
+			return a.Cum > b.Cum
+		}
+		return a.Line < b.Line
+	})
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	for i := range lines {
+		lines[i].FlatPercent = percentOf(lines[i].Flat, total)
+		lines[i].CumPercent = percentOf(lines[i].Cum, total)
+	}
+	return lines
+}
+
+// addTopLines lists the n hottest source lines of each function in report
+func addTopLines(report *TopReport, p *profile.Profile, n int) {
+	if len(p.SampleType) == 0 {
+		return
+	}
+	top := make(map[string]bool)
+	for _, e := range report.Entries {
+		top[e.Function] = true
+	}
+	funcs := newFunctionLines(p, sampleIndex(p), func(name string) bool { return top[name] })
+	for i, e := range report.Entries {
+		if fl := funcs[e.Function]; fl != nil {
+			report.Entries[i].Lines = hottestLines(fl, n, report.Total)
+		}
+	}
+}
+
+// readSourceLines returns the lines of a source file, or nil if it cannot be read
+func readSourceLines(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Split(string(data), "\n")
+}
+
+// formatLineValue renders a line value for a listing, with a dot for zero as pprof does
+func formatLineValue(v int64) string {
+	if v == 0 {
+		return "."
+	}
+	return fmt.Sprint(v)
+}
+
+// writeListing prints the source of fl from its first line to its last line
+// with samples, each annotated with its flat and cum values
+func writeListing(w io.Writer, fl *functionLines) {
+	var first, last int64
+	for line := range fl.lines {
+		if first == 0 || line < first {
+			first = line
+		}
+		if line > last {
+			last = line
+		}
+	}
+	if fl.startLine > 0 && fl.startLine < first {
+		first = fl.startLine
+	}
+
+	fmt.Fprintf(w, "[prof] ROUTINE ======================== %s in %s\n", fl.name, fl.file)
+	source := readSourceLines(fl.file)
+	if source == nil {
+		fmt.Fprintf(w, "[prof] Source not found, listing only the lines with samples\n")
+	}
+	for line := first; line <= last; line++ {
+		entry := fl.lines[line]
+		if entry == nil {
+			entry = &LineEntry{}
+			if source == nil {
+				continue
+			}
+		}
+		var text string
+		if line <= int64(len(source)) {
+			text = source[line-1]
+		}
+		fmt.Fprintf(w, "[prof] %12s %12s %6d  %s\n", formatLineValue(entry.Flat), formatLineValue(entry.Cum), line, text)
+	}
+}
+
+// reportListing prints annotated source of the functions matching
+// opts.ListRegex in each profile written to a file, like pprof -list
+func reportListing(w io.Writer, opts Options) error {
+	for _, path := range reportedProfiles(opts) {
+		p, err := loadProfile(path)
+		if err != nil {
+			return err
+		}
+		if len(p.SampleType) == 0 {
+			continue
+		}
+		idx := sampleIndex(p)
+		funcs := newFunctionLines(p, idx, opts.ListRegex.MatchString)
+		if len(funcs) == 0 {
+			fmt.Fprintf(w, "[prof] No functions matching %q in %s\n", opts.ListRegex.String(), path)
+			continue
+		}
+
+		names := make([]string, 0, len(funcs))
+		for name := range funcs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		st := p.SampleType[idx]
+		fmt.Fprintf(w, "[prof] Source listing of %s (%s, in %s):\n", path, st.Type, st.Unit)
+		fmt.Fprintf(w, "[prof] %12s %12s %6s  %s\n", "flat", "cum", "line", "source")
+		for _, name := range names {
+			writeListing(w, funcs[name])
+		}
+	}
+	return nil
+}
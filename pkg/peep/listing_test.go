@@ -0,0 +1,129 @@
+package peep
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// newLineProfile builds a CPU profile for source in file. Each stack lists
+// function:line frames, leaf first.
+func newLineProfile(file string, samples map[string]int64) *profile.Profile {
+	p := &profile.Profile{SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}}}
+	funcs := make(map[string]*profile.Function)
+	for stack, v := range samples {
+		s := &profile.Sample{Value: []int64{v}}
+		for _, frame := range strings.Split(stack, ";") {
+			name, line, _ := strings.Cut(frame, ":")
+			fn, ok := funcs[name]
+			if !ok {
+				fn = &profile.Function{ID: uint64(len(funcs) + 1), Name: name, Filename: file}
+				funcs[name] = fn
+				p.Function = append(p.Function, fn)
+			}
+			n, _ := strconv.ParseInt(line, 10, 64)
+			loc := &profile.Location{ID: uint64(len(p.Location) + 1), Line: []profile.Line{{Function: fn, Line: n}}}
+			p.Location = append(p.Location, loc)
+			s.Location = append(s.Location, loc)
+		}
+		p.Sample = append(p.Sample, s)
+	}
+	return p
+}
+
+const listingSource = `package main
+
+func work(n int) int {
+	total := 0
+	for i := 0; i < n; i++ {
+		total += i
+	}
+	return total
+}
+
+func main() {
+	work(10)
+}
+`
+
+func TestNewFunctionLines(t *testing.T) {
+	p := newLineProfile("main.go", map[string]int64{
+		"main.work:6;main.main:12":             70,
+		"main.work:5;main.main:12":             20,
+		"main.work:6;main.work:6;main.main:12": 10,
+	})
+
+	funcs := newFunctionLines(p, 0, func(name string) bool { return name == "main.work" })
+	if len(funcs) != 1 {
+		t.Fatalf("Expected only main.work to be matched, got %d functions", len(funcs))
+	}
+	lines := funcs["main.work"].lines
+	if l := lines[6]; l == nil || l.Flat != 80 || l.Cum != 80 {
+		t.Errorf("Expected line 6 to count recursive frames once, got %+v", l)
+	}
+	if l := lines[5]; l == nil || l.Flat != 20 || l.Cum != 20 {
+		t.Errorf("Expected line 5 flat and cum 20, got %+v", l)
+	}
+}
+
+func TestReportListingAndTopLines(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(source, []byte(listingSource), 0o644); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	p := newLineProfile(source, map[string]int64{
+		"main.work:6;main.main:12": 70,
+		"main.work:5;main.main:12": 30,
+	})
+	p.Function[0].StartLine = 3
+	path := filepath.Join(dir, "cpu.prof")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create profile: %v", err)
+	}
+	if err := p.Write(f); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	f.Close()
+
+	opts := Options{EnableCPU: true, CPUFile: path, ListRegex: regexp.MustCompile(`^main\.work$`)}
+	var buf bytes.Buffer
+	if err := reportListing(&buf, opts); err != nil {
+		t.Fatalf("reportListing failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"ROUTINE ======================== main.work in " + source,
+		"           .            .      3  func work(n int) int {",
+		"          70           70      6  \t\ttotal += i",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected the listing to contain %q, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "return total") || strings.Contains(out, "main.main") {
+		t.Errorf("Expected the listing to stop at the last sampled line of main.work, got:\n%s", out)
+	}
+
+	opts = Options{EnableCPU: true, CPUFile: path, TopN: 1, TopLines: 1, ReportFormat: formatJSON}
+	var jsonOut bytes.Buffer
+	if err := reportTop(&buf, &jsonOut, opts); err != nil {
+		t.Fatalf("reportTop failed: %v", err)
+	}
+	var reports []TopReport
+	if err := json.Unmarshal(jsonOut.Bytes(), &reports); err != nil {
+		t.Fatalf("Expected a JSON report, got %q: %v", jsonOut.String(), err)
+	}
+	want := LineEntry{File: source, Line: 6, Flat: 70, FlatPercent: 70, Cum: 70, CumPercent: 70}
+	if len(reports) != 1 || len(reports[0].Entries) != 1 || len(reports[0].Entries[0].Lines) != 1 || reports[0].Entries[0].Lines[0] != want {
+		t.Errorf("Expected main.work's hottest line %+v, got %+v", want, reports)
+	}
+}
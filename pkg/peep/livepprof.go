@@ -0,0 +1,78 @@
+package peep
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// addBlankImportIfMissing adds a blank import of pkg, for its side effects,
+// unless pkg is already imported
+func addBlankImportIfMissing(fset *token.FileSet, node *ast.File, pkg string) {
+	for _, imp := range node.Imports {
+		if imp.Path.Value == strconv.Quote(pkg) {
+			return
+		}
+	}
+	astutil.AddNamedImport(fset, node, "_", pkg)
+}
+
+// createLivePprofStmts creates a goroutine serving the handlers that the
+// blank net/http/pprof import registers on http.DefaultServeMux, so that
+// go tool pprof can attach to the running program:
+//
+//	go func() {
+//		log.Printf("[prof] Live pprof endpoint at http://%s/debug/pprof/", addr)
+//		if err := http.ListenAndServe(addr, nil); err != nil {
+//			log.Printf("[prof] Live pprof endpoint failed: %v", err)
+//		}
+//	}()
+//
+// A failure to listen is logged rather than fatal, as the program itself is
+// still worth running.
+func createLivePprofStmts(addr string) []ast.Stmt {
+	logf := func(format string, arg ast.Expr) ast.Stmt {
+		return &ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun:  &ast.SelectorExpr{X: ast.NewIdent("log"), Sel: ast.NewIdent("Printf")},
+				Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(format)}, arg},
+			},
+		}
+	}
+	quotedAddr := func() ast.Expr {
+		return &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(addr)}
+	}
+
+	return []ast.Stmt{
+		&ast.GoStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.FuncLit{
+					Type: &ast.FuncType{Params: &ast.FieldList{}},
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							logf("[prof] Live pprof endpoint at http://%s/debug/pprof/", quotedAddr()),
+							&ast.IfStmt{
+								Init: &ast.AssignStmt{
+									Lhs: []ast.Expr{ast.NewIdent("err")},
+									Tok: token.DEFINE,
+									Rhs: []ast.Expr{
+										&ast.CallExpr{
+											Fun:  &ast.SelectorExpr{X: ast.NewIdent("http"), Sel: ast.NewIdent("ListenAndServe")},
+											Args: []ast.Expr{quotedAddr(), ast.NewIdent("nil")},
+										},
+									},
+								},
+								Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+								Body: &ast.BlockStmt{
+									List: []ast.Stmt{logf("[prof] Live pprof endpoint failed: %v", ast.NewIdent("err"))},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,98 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/printer"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProcessGoFileInjectsLivePprof(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{MemFile: filepath.Join(tempDir, "mem.prof"), EnableMem: true, LivePprofAddr: "localhost:6061"}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	blank := false
+	for _, imp := range node.Imports {
+		if imp.Path.Value == `"net/http/pprof"` {
+			blank = imp.Name != nil && imp.Name.Name == "_"
+		}
+	}
+	if !blank {
+		t.Error(`Expected a blank import of "net/http/pprof"`)
+	}
+
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		t.Fatalf("Failed to print instrumented file: %v", err)
+	}
+	src := buf.String()
+	if !strings.Contains(src, `"net/http"`) {
+		t.Errorf("Expected net/http to be imported, got:\n%s", src)
+	}
+	if !strings.Contains(src, "go func() {") || !strings.Contains(src, `http.ListenAndServe("localhost:6061", nil)`) {
+		t.Errorf("Expected a goroutine serving the endpoint, got:\n%s", src)
+	}
+}
+
+func TestLivePprofServesWhileRunning(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	// The program fetches its own endpoint, and fails if it never comes up
+	content := fmt.Sprintf(`package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	for i := 0; i < 100; i++ {
+		resp, err := http.Get("http://%s/debug/pprof/heap?debug=1")
+		if err == nil && resp.StatusCode == http.StatusOK {
+			resp.Body.Close()
+			fmt.Println("endpoint up")
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	os.Exit(1)
+}`, addr)
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{MemFile: filepath.Join(tempDir, "mem.prof"), EnableMem: true, LivePprofAddr: addr}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("Expected the program to reach its live endpoint: %v", err)
+	}
+	if _, err := os.Stat(opts.MemFile); err != nil {
+		t.Errorf("Expected the memory profile alongside the live endpoint: %v", err)
+	}
+}
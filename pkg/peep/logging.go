@@ -0,0 +1,55 @@
+package peep
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// logLevel is how much of its own progress peep prints
+type logLevel int
+
+const (
+	levelQuiet   logLevel = iota // errors only, set by -quiet
+	levelNormal                  // progress, warnings and where the outputs went
+	levelVerbose                 // also temp paths, go commands and added imports, set by -verbose
+)
+
+// logger prints peep's own [prof] messages for one run. A nil logger prints
+// at levelNormal to stdout, as the peep command does by default.
+type logger struct {
+	w     io.Writer
+	level logLevel
+}
+
+// newLogger returns a logger printing the messages up to level to w
+func newLogger(w io.Writer, level logLevel) *logger {
+	return &logger{w: w, level: level}
+}
+
+// logf prints a [prof] message when l's level is at least level. Errors are
+// not logged through it, they are returned and printed whatever the level.
+func (l *logger) logf(level logLevel, format string, args ...any) {
+	if l.verbosity() < level {
+		return
+	}
+	fmt.Fprintf(l.writer(), "[prof] "+format+"\n", args...)
+}
+
+// writer returns where l prints, which the reports printed along with its
+// messages share. It is stderr while stdout is kept for a streamed profile,
+// the instrumented code or a JSON report.
+func (l *logger) writer() io.Writer {
+	if l == nil {
+		return os.Stdout
+	}
+	return l.w
+}
+
+// verbosity returns the level of the messages l prints
+func (l *logger) verbosity() logLevel {
+	if l == nil {
+		return levelNormal
+	}
+	return l.level
+}
@@ -0,0 +1,99 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogLevels(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	normal := []string{"[prof] Running instrumented program with CPU profiling...", "[prof] CPU profile saved to"}
+	verbose := []string{"[prof] Added import \"runtime/pprof\"", "[prof] Instrumented copy written to", "[prof] Building: go build"}
+	tests := []struct {
+		level   logLevel
+		want    []string
+		notWant []string
+	}{
+		{levelQuiet, nil, append(normal, verbose...)},
+		{levelNormal, normal, verbose},
+		{levelVerbose, append(normal, verbose...), nil},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		opts := Options{CPUFile: filepath.Join(tempDir, "cpu.prof"), EnableCPU: true, log: newLogger(&buf, tt.level)}
+		node, fset, err := processGoFile(testFile, opts)
+		if err != nil {
+			t.Fatalf("Failed to process Go file: %v", err)
+		}
+		if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+			t.Fatalf("writeAndExecute failed: %v", err)
+		}
+
+		out := buf.String()
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("Level %d: expected %q in the output, got:\n%s", tt.level, want, out)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(out, notWant) {
+				t.Errorf("Level %d: expected no %q in the output, got:\n%s", tt.level, notWant, out)
+			}
+		}
+	}
+}
+
+func TestSetupLogger(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		level logLevel
+		w     io.Writer
+	}{
+		{Options{}, levelNormal, os.Stdout},
+		{Options{Quiet: true}, levelQuiet, os.Stdout},
+		{Options{Verbose: true}, levelVerbose, os.Stdout},
+		{Options{DryRun: true}, levelNormal, os.Stderr},
+		{Options{EnableCPU: true, CPUFile: stdoutPath}, levelNormal, os.Stderr},
+	}
+	for _, tt := range tests {
+		opts := tt.opts
+		opts.setup()
+		if opts.log.verbosity() != tt.level || opts.log.writer() != tt.w {
+			t.Errorf("%+v: expected level %d, got %d, and stderr %v, got %v", tt.opts, tt.level, opts.log.verbosity(), tt.w == os.Stderr, opts.log.writer() == os.Stderr)
+		}
+	}
+
+	// Each run logs through its own logger, so runs with different levels
+	// do not affect each other
+	var quiet, normal bytes.Buffer
+	newLogger(&quiet, levelQuiet).logf(levelNormal, "Warning: dropped")
+	newLogger(&normal, levelNormal).logf(levelNormal, "Warning: kept")
+	if quiet.Len() != 0 || normal.String() != "[prof] Warning: kept\n" {
+		t.Errorf("Expected only the normal logger to print the warning, got %q and %q", quiet.String(), normal.String())
+	}
+
+	// A nil logger, as in options that were not set up, prints at the normal level
+	var none *logger
+	if none.verbosity() != levelNormal || none.writer() != os.Stdout {
+		t.Error("Expected a nil logger to print at the normal level to stdout")
+	}
+}
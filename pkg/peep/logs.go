@@ -0,0 +1,68 @@
+package peep
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// defaultLogsKB is how much of the target's recent output the dashboard keeps, in KiB
+const defaultLogsKB = 64
+
+// outputBuffer keeps the most recent output of the target, up to a fixed
+// number of bytes, for /logs
+type outputBuffer struct {
+	mu        sync.Mutex
+	size      int
+	buf       []byte
+	truncated bool // older output was dropped to stay within size
+}
+
+// newOutputBuffer creates a buffer keeping at most size bytes of output
+func newOutputBuffer(size int) *outputBuffer {
+	return &outputBuffer{size: size}
+}
+
+// Write records p, dropping the oldest output beyond the buffer's size. It
+// never fails, so an io.MultiWriter keeps forwarding the output to the terminal.
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.size == 0 {
+		return len(p), nil
+	}
+
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - b.size; over > 0 {
+		// Start at a whole line when the cut leaves one in the buffer
+		cut := over
+		if i := bytes.IndexByte(b.buf[over:], '\n'); i >= 0 && i+1 < len(b.buf)-over {
+			cut += i + 1
+		}
+		b.buf = append(b.buf[:0], b.buf[cut:]...)
+		b.truncated = true
+	}
+	return len(p), nil
+}
+
+// OutputLog is the recent output of the target served at /logs
+type OutputLog struct {
+	Output    string `json:"output"`
+	Truncated bool   `json:"truncated"` // earlier output was dropped
+}
+
+// Log returns a copy of the recorded output
+func (b *outputBuffer) Log() OutputLog {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return OutputLog{Output: string(b.buf), Truncated: b.truncated}
+}
+
+// logsHandler serves the target's recent stdout and stderr as JSON
+func logsHandler(logs *outputBuffer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logs.Log())
+	}
+}
@@ -0,0 +1,110 @@
+package peep
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputBuffer(t *testing.T) {
+	b := newOutputBuffer(16)
+	b.Write([]byte("first line\n"))
+	if got := b.Log(); got.Output != "first line\n" || got.Truncated {
+		t.Errorf("Expected the output kept as is, got %+v", got)
+	}
+
+	// The oldest output is dropped, from the start of a line
+	b.Write([]byte("second\nthird\n"))
+	if got := b.Log(); got.Output != "second\nthird\n" || !got.Truncated {
+		t.Errorf("Expected the first line dropped, got %+v", got)
+	}
+
+	// A line longer than the buffer keeps its end
+	b.Write([]byte("0123456789abcdefXYZ"))
+	if got := b.Log(); got.Output != "3456789abcdefXYZ" {
+		t.Errorf("Expected the last 16 bytes, got %q", got.Output)
+	}
+
+	disabled := newOutputBuffer(0)
+	if n, err := disabled.Write([]byte("ignored")); n != 7 || err != nil {
+		t.Errorf("Expected a disabled buffer to accept writes, got %d, %v", n, err)
+	}
+	if got := disabled.Log(); got.Output != "" {
+		t.Errorf("Expected a disabled buffer to keep nothing, got %q", got.Output)
+	}
+}
+
+func TestDashboardServesLogs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the dashboard is reached through a Unix socket")
+	}
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	content := `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println("hello from the target")
+	fmt.Fprintln(os.Stderr, "warning from the target")
+}
+`
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// A short path, as Unix socket paths are limited in length
+	socketDir, err := os.MkdirTemp("", "peep")
+	if err != nil {
+		t.Fatalf("Failed to create socket directory: %v", err)
+	}
+	defer os.RemoveAll(socketDir)
+	socket := filepath.Join(socketDir, "dash.sock")
+
+	// A daemon's dashboard stays up for daemonLinger after the run
+	opts := Options{
+		Target:       testFile,
+		CPUFile:      filepath.Join(tempDir, "cpu.prof"),
+		EnableCPU:    true,
+		EnableWeb:    true,
+		DaemonSocket: socket,
+		LogsSize:     defaultLogsKB * 1024,
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := Run(context.Background(), opts)
+		done <- err
+	}()
+
+	client := unixClient(socket)
+	var logs OutputLog
+	deadline := time.After(30 * time.Second)
+	for !strings.Contains(logs.Output, "hello from the target") || !strings.Contains(logs.Output, "warning from the target") {
+		select {
+		case err := <-done:
+			t.Fatalf("Run ended before /logs had the output (%v), last got %q", err, logs.Output)
+		case <-deadline:
+			t.Fatalf("Timed out waiting for the output at /logs, last got %q", logs.Output)
+		case <-time.After(50 * time.Millisecond):
+		}
+		resp, err := client.Get("http://peep/logs")
+		if err != nil {
+			continue
+		}
+		json.NewDecoder(resp.Body).Decode(&logs)
+		resp.Body.Close()
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Run failed: %v", err)
+	}
+}
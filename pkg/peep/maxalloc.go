@@ -0,0 +1,75 @@
+package peep
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// errMaxAllocExceeded is returned when a metrics sample reports Alloc above -max-alloc
+var errMaxAllocExceeded = errors.New("-max-alloc exceeded")
+
+// byteSizeUnits maps the units ParseByteSize accepts, in lower case, to their
+// size in bytes. KB, MB and so on are decimal, KiB, MiB and so on binary, as
+// GOMEMLIMIT reads them.
+var byteSizeUnits = map[string]uint64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// ParseByteSize parses a size such as 256MB, 1.5GiB or 1048576 into bytes.
+// Units are case-insensitive and may be separated from the number by a space.
+func ParseByteSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	split := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, unit := s, ""
+	if split >= 0 {
+		number, unit = s[:split], strings.TrimSpace(s[split:])
+	}
+
+	scale, ok := byteSizeUnits[strings.ToLower(unit)]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q, expected B, KB, MB, GB, TB, KiB, MiB, GiB or TiB", s, unit)
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: expected a number followed by a unit, like 256MB", s)
+	}
+	size := n * float64(scale)
+	if size >= math.MaxUint64 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	return uint64(size), nil
+}
+
+// checkMaxAlloc warns on w and returns errMaxAllocExceeded when the peak Alloc
+// of samples exceeds limit. Samples that cannot be parsed are skipped.
+func checkMaxAlloc(w io.Writer, samples []json.RawMessage, limit uint64) error {
+	var peak uint64
+	for _, raw := range samples {
+		var m Metrics
+		if json.Unmarshal(raw, &m) != nil {
+			continue
+		}
+		peak = max(peak, m.Alloc)
+	}
+	if peak <= limit {
+		return nil
+	}
+	fmt.Fprintf(w, "[prof] Warning: Alloc peaked at %.2f MiB, over the -max-alloc limit of %.2f MiB\n", float64(peak)/1024/1024, float64(limit)/1024/1024)
+	return errMaxAllocExceeded
+}
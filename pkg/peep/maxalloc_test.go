@@ -0,0 +1,94 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+	}{
+		{"1048576", 1 << 20},
+		{"512B", 512},
+		{"256MB", 256e6},
+		{"256mb", 256e6},
+		{"64 KiB", 64 << 10},
+		{"1.5GiB", 3 << 29},
+		{"2TB", 2e12},
+	}
+	for _, tt := range tests {
+		got, err := ParseByteSize(tt.in)
+		if err != nil {
+			t.Errorf("ParseByteSize(%q) failed: %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, expected %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "MB", "256XB", "-1MB", "1.2.3MB"} {
+		if _, err := ParseByteSize(in); err == nil {
+			t.Errorf("Expected ParseByteSize(%q) to fail", in)
+		}
+	}
+}
+
+func TestCheckMaxAlloc(t *testing.T) {
+	samples := []json.RawMessage{
+		json.RawMessage(`{"alloc":1000}`),
+		json.RawMessage(`{"alloc":5000}`),
+		json.RawMessage(`{"alloc":2000}`),
+	}
+	var out bytes.Buffer
+	if err := checkMaxAlloc(&out, samples, 5000); err != nil || out.Len() != 0 {
+		t.Errorf("Expected a peak at the limit to pass silently, got %v and %q", err, out.String())
+	}
+	if err := checkMaxAlloc(&out, samples, 4999); !errors.Is(err, errMaxAllocExceeded) {
+		t.Errorf("Expected errMaxAllocExceeded, got %v", err)
+	}
+	if !strings.Contains(out.String(), "-max-alloc limit") {
+		t.Errorf("Expected a warning, got %q", out.String())
+	}
+}
+
+func TestMaxAllocFailsRun(t *testing.T) {
+	content := `package main
+
+import "time"
+
+var sink [][]byte
+
+func main() {
+	for i := 0; i < 64; i++ {
+		sink = append(sink, make([]byte, 1<<20))
+	}
+	time.Sleep(1500 * time.Millisecond)
+}`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{
+		MemFile:     filepath.Join(tempDir, "mem.prof"),
+		EnableMem:   true,
+		MaxAlloc:    16 << 20,
+		MetricsFile: filepath.Join(tempDir, "metrics.json"),
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	err = writeAndExecute(context.Background(), node, fset, opts)
+	if !errors.Is(err, errMaxAllocExceeded) {
+		t.Errorf("Expected the run to fail with errMaxAllocExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package peep
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxSnapshots is how many heap snapshots are kept without -max-snapshots
+const defaultMaxSnapshots = 20
+
+// listMemSnapshots returns the heap snapshots written with prefix, oldest first
+func listMemSnapshots(prefix string) ([]string, error) {
+	matches, err := filepath.Glob(prefix + "-*.prof")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list heap snapshots: %w", err)
+	}
+
+	taken := make(map[string]int64)
+	var paths []string
+	for _, path := range matches {
+		unix, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(path, prefix+"-"), ".prof"), 10, 64)
+		if err != nil || unix < 1 {
+			continue
+		}
+		taken[path] = unix
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return taken[paths[i]] < taken[paths[j]] })
+	return paths, nil
+}
+
+// removeMemSnapshots deletes the heap snapshots of an earlier run, so the
+// cap applies to this run's and they are not mistaken for them
+func removeMemSnapshots(w io.Writer, prefix string) error {
+	paths, err := listMemSnapshots(prefix)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+	if len(paths) > 0 {
+		fmt.Fprintf(w, "[prof] Removed %d heap snapshots from an earlier run\n", len(paths))
+	}
+	return nil
+}
+
+// reportMemSnapshots lists the heap snapshots left by the run
+func reportMemSnapshots(w io.Writer, opts Options) error {
+	paths, err := listMemSnapshots(opts.MemSnapshotPrefix)
+	if err != nil {
+		return err
+	}
+	switch len(paths) {
+	case 0:
+		fmt.Fprintf(w, "[prof] No heap snapshots were written, the program exited within -mem-snapshots %s\n", opts.MemSnapshots)
+	case 1:
+		fmt.Fprintf(w, "[prof] 1 heap snapshot saved to %s\n", paths[0])
+	default:
+		fmt.Fprintf(w, "[prof] %d heap snapshots saved to %s\n", len(paths), paths[0]+" ... "+filepath.Base(paths[len(paths)-1]))
+		fmt.Fprintf(w, "[prof] Compare two with: go tool pprof -diff_base %s %s\n", paths[0], paths[len(paths)-1])
+	}
+	return nil
+}
+
+// createMemSnapshotDeclStmts declares the state the collector keeps between
+// heap snapshots, ahead of its sampling loop:
+//
+//	memSnapshotAt := time.Now()
+//	var memSnapshots []string
+func createMemSnapshotDeclStmts() []ast.Stmt {
+	return []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("memSnapshotAt")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{Fun: &ast.SelectorExpr{X: ast.NewIdent("time"), Sel: ast.NewIdent("Now")}},
+			},
+		},
+		&ast.DeclStmt{
+			Decl: &ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{
+					&ast.ValueSpec{
+						Names: []*ast.Ident{ast.NewIdent("memSnapshots")},
+						Type:  &ast.ArrayType{Elt: ast.NewIdent("string")},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createMemSnapshotStmt creates the step of the collector's sampling loop
+// that writes a heap profile once every interval has passed, keeping the
+// newest max of them (all of them if max is 0):
+//
+//	if now := time.Now(); now.Sub(memSnapshotAt) >= interval {
+//		memSnapshotAt = now
+//		path := prefix + "-" + strconv.FormatInt(now.Unix(), 10) + ".prof"
+//		if f, err := os.Create(path); err == nil {
+//			pprof.WriteHeapProfile(f)
+//			f.Close()
+//			memSnapshots = append(memSnapshots, path)
+//			if len(memSnapshots) > max {
+//				os.Remove(memSnapshots[0])
+//				memSnapshots = memSnapshots[1:]
+//			}
+//		}
+//	}
+func createMemSnapshotStmt(prefix string, interval time.Duration, max int) ast.Stmt {
+	call := func(x, sel string, args ...ast.Expr) *ast.CallExpr {
+		return &ast.CallExpr{Fun: &ast.SelectorExpr{X: ast.NewIdent(x), Sel: ast.NewIdent(sel)}, Args: args}
+	}
+	first := &ast.IndexExpr{X: ast.NewIdent("memSnapshots"), Index: &ast.BasicLit{Kind: token.INT, Value: "0"}}
+
+	written := []ast.Stmt{
+		// pprof.WriteHeapProfile(f)
+		&ast.ExprStmt{X: call("pprof", "WriteHeapProfile", ast.NewIdent("f"))},
+		// f.Close()
+		&ast.ExprStmt{X: call("f", "Close")},
+		// memSnapshots = append(memSnapshots, path)
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("memSnapshots")},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{Fun: ast.NewIdent("append"), Args: []ast.Expr{ast.NewIdent("memSnapshots"), ast.NewIdent("path")}},
+			},
+		},
+	}
+	if max > 0 {
+		// if len(memSnapshots) > max { os.Remove(memSnapshots[0]); memSnapshots = memSnapshots[1:] }
+		written = append(written, &ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  &ast.CallExpr{Fun: ast.NewIdent("len"), Args: []ast.Expr{ast.NewIdent("memSnapshots")}},
+				Op: token.GTR,
+				Y:  &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(max)},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.ExprStmt{X: call("os", "Remove", first)},
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("memSnapshots")},
+						Tok: token.ASSIGN,
+						Rhs: []ast.Expr{
+							&ast.SliceExpr{X: ast.NewIdent("memSnapshots"), Low: &ast.BasicLit{Kind: token.INT, Value: "1"}},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	return &ast.IfStmt{
+		// now := time.Now()
+		Init: &ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("now")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{call("time", "Now")},
+		},
+		// now.Sub(memSnapshotAt) >= time.Duration(interval)
+		Cond: &ast.BinaryExpr{
+			X:  call("now", "Sub", ast.NewIdent("memSnapshotAt")),
+			Op: token.GEQ,
+			Y:  call("time", "Duration", &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(int64(interval), 10)}),
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				// memSnapshotAt = now
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("memSnapshotAt")},
+					Tok: token.ASSIGN,
+					Rhs: []ast.Expr{ast.NewIdent("now")},
+				},
+				// path := prefix + "-" + strconv.FormatInt(now.Unix(), 10) + ".prof"
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("path")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{
+						&ast.BinaryExpr{
+							X: &ast.BinaryExpr{
+								X:  &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(prefix + "-")},
+								Op: token.ADD,
+								Y:  call("strconv", "FormatInt", call("now", "Unix"), &ast.BasicLit{Kind: token.INT, Value: "10"}),
+							},
+							Op: token.ADD,
+							Y:  &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(".prof")},
+						},
+					},
+				},
+				// if f, err := os.Create(path); err == nil { ... }
+				&ast.IfStmt{
+					Init: &ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("f"), ast.NewIdent("err")},
+						Tok: token.DEFINE,
+						Rhs: []ast.Expr{call("os", "Create", ast.NewIdent("path"))},
+					},
+					Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.EQL, Y: ast.NewIdent("nil")},
+					Body: &ast.BlockStmt{List: written},
+				},
+			},
+		},
+	}
+}
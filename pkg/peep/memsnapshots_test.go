@@ -0,0 +1,133 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/printer"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListMemSnapshotsInTimeOrder(t *testing.T) {
+	dir := t.TempDir()
+	prefix := filepath.Join(dir, "mem")
+	for _, name := range []string{"mem-1700000100.prof", "mem-1700000005.prof", "mem.prof", "mem-x.prof", "mem_postinit.prof"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	paths, err := listMemSnapshots(prefix)
+	if err != nil {
+		t.Fatalf("listMemSnapshots failed: %v", err)
+	}
+	want := []string{prefix + "-1700000005.prof", prefix + "-1700000100.prof"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("Expected %v, got %v", want, paths)
+	}
+}
+
+func TestMemSnapshotsInjectedInMetricsLoop(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{
+		MemFile:           filepath.Join(tempDir, "mem.prof"),
+		EnableMem:         true,
+		EnableWeb:         true,
+		MetricsFile:       filepath.Join(tempDir, "peep_metrics.json"),
+		MemSnapshots:      5 * time.Second,
+		MemSnapshotPrefix: filepath.Join(tempDir, "mem"),
+		MaxSnapshots:      3,
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// The heap is written from the collector's loop, not a goroutine of its own
+	var loop *ast.RangeStmt
+	ast.Inspect(node, func(n ast.Node) bool {
+		if r, ok := n.(*ast.RangeStmt); ok && loop == nil {
+			loop = r
+		}
+		return true
+	})
+	if loop == nil {
+		t.Fatal("Expected the metrics collector's ticker loop")
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, loop)
+	for _, want := range []string{"pprof.WriteHeapProfile(f)", "os.Remove(memSnapshots[0])", "len(memSnapshots) > 3"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %q in the metrics loop:\n%s", want, buf.String())
+		}
+	}
+
+	opts.MemSnapshots = 0
+	node, fset, err = processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	buf.Reset()
+	printer.Fprint(&buf, fset, node)
+	if strings.Contains(buf.String(), "memSnapshots") {
+		t.Errorf("Expected no heap snapshots without -mem-snapshots:\n%s", buf.String())
+	}
+}
+
+func TestMemSnapshotsKeepNewest(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	content := "package main\n\nimport \"time\"\n\nfunc main() { time.Sleep(2750 * time.Millisecond) }\n"
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// A snapshot from an earlier run is removed before this one starts
+	prefix := filepath.Join(tempDir, "mem")
+	if err := os.WriteFile(prefix+"-1000.prof", nil, 0o644); err != nil {
+		t.Fatalf("Failed to write old snapshot: %v", err)
+	}
+
+	opts := Options{
+		MemFile:           filepath.Join(tempDir, "mem.prof"),
+		EnableMem:         true,
+		EnableWeb:         true,
+		Port:              "0",
+		MaxRuntime:        time.Millisecond,
+		MetricsFile:       filepath.Join(tempDir, "peep_metrics.json"),
+		MemSnapshots:      time.Second,
+		MemSnapshotPrefix: prefix,
+		MaxSnapshots:      1,
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	paths, err := listMemSnapshots(prefix)
+	if err != nil {
+		t.Fatalf("listMemSnapshots failed: %v", err)
+	}
+	// Snapshots are due about 1s and 2s in, well before the program exits
+	if len(paths) != 1 || paths[0] == prefix+"-1000.prof" {
+		t.Fatalf("Expected only the newest snapshot of this run, got %v", paths)
+	}
+	for _, path := range paths {
+		if _, err := loadProfile(path); err != nil {
+			t.Errorf("Expected %s to be a heap profile: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package peep
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// Values accepted by -metrics-priority
+const (
+	metricsPriorityNormal = "normal"
+	metricsPriorityLow    = "low"
+)
+
+// The pprof label set on the low priority collector goroutine, so its own CPU
+// samples can be told apart from the target's
+const (
+	metricsLabelKey   = "peep"
+	metricsLabelValue = "metrics"
+)
+
+// lowPriorityBusyPercent is the system CPU usage above which the low priority
+// collector backs off
+const lowPriorityBusyPercent = 80
+
+// createMemStatsReadStmt reads the memory statistics into m: with
+// runtime.ReadMemStats, or with the runtime/metrics helper at low priority
+func createMemStatsReadStmt(lowPriority bool) ast.Stmt {
+	var fun ast.Expr = &ast.SelectorExpr{
+		X:   ast.NewIdent("runtime"),
+		Sel: ast.NewIdent("ReadMemStats"),
+	}
+	if lowPriority {
+		fun = ast.NewIdent(memStatsHelperFunc)
+	}
+	// runtime.ReadMemStats(&m)
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: fun,
+			Args: []ast.Expr{
+				&ast.UnaryExpr{
+					Op: token.AND,
+					X:  ast.NewIdent("m"),
+				},
+			},
+		},
+	}
+}
+
+// createLowPriorityLoopStmts creates a loop that samples every 500ms while the
+// system is not busy, and doubles the interval up to adaptiveMaxIntervalMS
+// while its CPU usage is above lowPriorityBusyPercent
+func createLowPriorityLoopStmts(sample []ast.Stmt) []ast.Stmt {
+	body := []ast.Stmt{
+		// time.Sleep(interval)
+		&ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("time"),
+					Sel: ast.NewIdent("Sleep"),
+				},
+				Args: []ast.Expr{ast.NewIdent("interval")},
+			},
+		},
+	}
+	body = append(body, sample...)
+	body = append(body,
+		// if cpuVal > 80 { interval *= 2 } else { interval = 500 * time.Millisecond }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  ast.NewIdent("cpuVal"),
+				Op: token.GTR,
+				Y:  &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(lowPriorityBusyPercent)},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("interval")},
+						Tok: token.MUL_ASSIGN,
+						Rhs: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "2"}},
+					},
+				},
+			},
+			Else: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("interval")},
+						Tok: token.ASSIGN,
+						Rhs: []ast.Expr{millisecondsExpr(500)},
+					},
+				},
+			},
+		},
+		clampIntervalStmt(token.GTR, adaptiveMaxIntervalMS),
+	)
+
+	return []ast.Stmt{
+		// interval := 500 * time.Millisecond
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("interval")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{millisecondsExpr(500)},
+		},
+		// for { ... }
+		&ast.ForStmt{
+			Body: &ast.BlockStmt{List: body},
+		},
+	}
+}
+
+// createLabeledGoStmt runs body in a goroutine labeled peep=metrics:
+// go pprof.Do(context.Background(), pprof.Labels("peep", "metrics"), func(context.Context) { ... })
+func createLabeledGoStmt(body []ast.Stmt) ast.Stmt {
+	return &ast.GoStmt{
+		Call: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("pprof"),
+				Sel: ast.NewIdent("Do"),
+			},
+			Args: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("context"),
+						Sel: ast.NewIdent("Background"),
+					},
+				},
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("pprof"),
+						Sel: ast.NewIdent("Labels"),
+					},
+					Args: []ast.Expr{
+						&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(metricsLabelKey)},
+						&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(metricsLabelValue)},
+					},
+				},
+				&ast.FuncLit{
+					Type: &ast.FuncType{
+						Params: &ast.FieldList{
+							List: []*ast.Field{
+								{Type: &ast.SelectorExpr{X: ast.NewIdent("context"), Sel: ast.NewIdent("Context")}},
+							},
+						},
+					},
+					Body: &ast.BlockStmt{List: body},
+				},
+			},
+		},
+	}
+}
+
+// dropLabeledSamples removes the samples carrying the label key=value from the
+// profile at path, rewriting it, and returns how many were removed
+func dropLabeledSamples(path, key, value string) (int, error) {
+	p, err := loadProfile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	kept := p.Sample[:0]
+	for _, s := range p.Sample {
+		if !s.HasLabel(key, value) {
+			kept = append(kept, s)
+		}
+	}
+	dropped := len(p.Sample) - len(kept)
+	if dropped == 0 {
+		return 0, nil
+	}
+	p.Sample = kept
+
+	// Locations and functions only the dropped samples used go too
+	p = p.Compact()
+	if err := writeProfile(path, p); err != nil {
+		return 0, err
+	}
+	return dropped, nil
+}
+
+// reportDroppedMetricsSamples removes the low priority collector's samples
+// from the CPU profile and says how many there were
+func reportDroppedMetricsSamples(opts Options) error {
+	dropped, err := dropLabeledSamples(opts.CPUFile, metricsLabelKey, metricsLabelValue)
+	if err != nil {
+		return fmt.Errorf("failed to drop the metrics collector's samples: %w", err)
+	}
+	if dropped > 0 {
+		opts.log.logf(levelNormal, "Removed %d CPU samples taken in the metrics collector", dropped)
+	}
+	return nil
+}
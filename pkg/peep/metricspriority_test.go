@@ -0,0 +1,128 @@
+package peep
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestLowPriorityInstrumentationBuilds(t *testing.T) {
+	buildWebInstrumented(t, Options{MetricsPriority: metricsPriorityLow})
+	buildWebInstrumented(t, Options{MetricsPriority: metricsPriorityLow, PerCore: true, RecoverPanic: true})
+}
+
+func TestMemStatsHelperMatchesReadMemStats(t *testing.T) {
+	tempDir := t.TempDir()
+	if _, err := writeCPUHelper(tempDir); err != nil {
+		t.Fatalf("Failed to write CPU helper: %v", err)
+	}
+
+	// Fields that peepReadMemStats fills, read both ways after a settled GC
+	content := `package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+var sink [][]byte
+
+func main() {
+	for i := 0; i < 100; i++ {
+		sink = append(sink, make([]byte, 64<<10))
+	}
+	runtime.GC()
+	runtime.GC()
+
+	var want, got runtime.MemStats
+	runtime.ReadMemStats(&want)
+	peepReadMemStats(&got)
+	fmt.Println(want.Alloc, got.Alloc)
+	fmt.Println(want.Sys, got.Sys)
+	fmt.Println(want.NumGC, got.NumGC)
+	fmt.Println(want.TotalAlloc, got.TotalAlloc)
+	fmt.Println(want.PauseTotalNs, got.PauseTotalNs)
+	fmt.Println(want.HeapInuse, got.HeapInuse)
+	fmt.Println(want.HeapObjects, got.HeapObjects)
+	fmt.Println(want.Mallocs, got.Mallocs)
+	fmt.Println(want.Frees, got.Frees)
+	fmt.Println(want.GCCPUFraction, got.GCCPUFraction)
+}`
+	if err := os.WriteFile(filepath.Join(tempDir, "main.go"), []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	output, err := exec.Command("go", "run", filepath.Join(tempDir, "main.go"), filepath.Join(tempDir, cpuHelperFile)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run failed: %v\n%s", err, output)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	names := []string{"Alloc", "Sys", "NumGC", "TotalAlloc", "PauseTotalNs", "HeapInuse", "HeapObjects", "Mallocs", "Frees", "GCCPUFraction"}
+	if len(lines) != len(names) {
+		t.Fatalf("Unexpected output:\n%s", output)
+	}
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		want, _ := strconv.ParseFloat(fields[0], 64)
+		got, _ := strconv.ParseFloat(fields[1], 64)
+		if names[i] == "NumGC" {
+			if got != want {
+				t.Errorf("NumGC: expected %v, got %v", want, got)
+			}
+			continue
+		}
+		// Allocations keep happening between the two reads, and the pause
+		// total is estimated from a histogram
+		if got <= 0 || got < want/2 || got > want*2 {
+			t.Errorf("%s: expected about %v, got %v", names[i], want, got)
+		}
+	}
+}
+
+func TestDropLabeledSamples(t *testing.T) {
+	fn := &profile.Function{ID: 1, Name: "main.main"}
+	collector := &profile.Function{ID: 2, Name: "main.main.func1"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn}}}
+	collectorLoc := &profile.Location{ID: 2, Line: []profile.Line{{Function: collector}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{loc}, Value: []int64{5}},
+			{Location: []*profile.Location{collectorLoc}, Value: []int64{2}, Label: map[string][]string{metricsLabelKey: {metricsLabelValue}}},
+			{Location: []*profile.Location{loc}, Value: []int64{1}, Label: map[string][]string{"other": {"label"}}},
+		},
+		Location: []*profile.Location{loc, collectorLoc},
+		Function: []*profile.Function{fn, collector},
+	}
+	path := filepath.Join(t.TempDir(), "cpu.prof")
+	if err := writeProfile(path, p); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+
+	dropped, err := dropLabeledSamples(path, metricsLabelKey, metricsLabelValue)
+	if err != nil {
+		t.Fatalf("dropLabeledSamples failed: %v", err)
+	}
+	if dropped != 1 {
+		t.Errorf("Expected 1 sample dropped, got %d", dropped)
+	}
+
+	got, err := loadProfile(path)
+	if err != nil {
+		t.Fatalf("Failed to load profile: %v", err)
+	}
+	if len(got.Sample) != 2 {
+		t.Errorf("Expected 2 samples left, got %d", len(got.Sample))
+	}
+	for _, f := range got.Function {
+		if f.Name == collector.Name {
+			t.Errorf("Expected the collector's function to be dropped with its samples")
+		}
+	}
+}
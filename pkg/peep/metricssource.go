@@ -0,0 +1,185 @@
+package peep
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsSocketDrainTimeout bounds how long peep waits, once the target has
+// exited, for the last samples still buffered in the socket
+const metricsSocketDrainTimeout = time.Second
+
+// errNoMetrics is returned by a socket source before the first sample arrives
+var errNoMetrics = errors.New("no metrics sample received")
+
+// metricsSource provides the latest sample written by the injected collector
+type metricsSource interface {
+	// Latest returns the most recent sample
+	Latest() ([]byte, error)
+	// Close stops receiving samples and removes the file or socket
+	Close() error
+}
+
+// metricsFileSource reads the samples the collector writes to a file
+type metricsFileSource struct {
+	path string
+}
+
+func (s metricsFileSource) Latest() ([]byte, error) {
+	return os.ReadFile(s.path)
+}
+
+func (s metricsFileSource) Close() error {
+	return os.Remove(s.path)
+}
+
+// metricsFilePath returns a fresh metrics file path. It is absolute, so the
+// collector and the dashboard agree on it whatever directory the target runs in.
+func metricsFilePath() string {
+	return filepath.Join(os.TempDir(), "peep_metrics_"+randomSuffix()+".json")
+}
+
+// metricsSocketPath returns a fresh socket path for -metrics-socket
+func metricsSocketPath() string {
+	return filepath.Join(os.TempDir(), "peep-metrics-"+randomSuffix()+".sock")
+}
+
+// metricsSocketSource receives the collector's samples on a Unix domain
+// socket, one JSON object per line, keeping only the latest
+type metricsSocketSource struct {
+	listener net.Listener
+	keepLast bool // keep serving the last sample after the target disconnects
+	latest   atomic.Pointer[[]byte]
+	readers  sync.WaitGroup
+}
+
+// listenMetricsSocket starts receiving samples on path. Unless keepLast is
+// set, the latest sample is dropped when the target disconnects, as the
+// metrics file is removed when the target exits.
+func listenMetricsSocket(path string, keepLast bool) (*metricsSocketSource, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on metrics socket: %w", err)
+	}
+
+	s := &metricsSocketSource{listener: listener, keepLast: keepLast}
+	go s.accept()
+	return s, nil
+}
+
+// accept reads every connection until the listener is closed
+func (s *metricsSocketSource) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.readers.Add(1)
+		go s.read(conn)
+	}
+}
+
+// read stores each valid sample received on conn
+func (s *metricsSocketSource) read(conn net.Conn) {
+	defer s.readers.Done()
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, 1<<20) // per-core samples of large machines exceed the default
+	for scanner.Scan() {
+		sample := bytes.Clone(scanner.Bytes())
+		if json.Valid(sample) {
+			s.latest.Store(&sample)
+		}
+	}
+	if !s.keepLast {
+		s.latest.Store(nil)
+	}
+}
+
+// drain waits up to timeout for the connections of an exited target to be read to the end
+func (s *metricsSocketSource) drain(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.readers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func (s *metricsSocketSource) Latest() ([]byte, error) {
+	sample := s.latest.Load()
+	if sample == nil {
+		return nil, errNoMetrics
+	}
+	return *sample, nil
+}
+
+func (s *metricsSocketSource) Close() error {
+	// Closing a Unix listener also removes its socket file
+	return s.listener.Close()
+}
+
+// createMetricsDialStmts creates AST statements that connect the collector to
+// peep's metrics socket for the rest of main:
+//
+//	metricsConn, metricsErr := net.Dial("unix", socketPath)
+//	if metricsErr != nil { log.Fatal(metricsErr) }
+//	defer metricsConn.Close()
+func createMetricsDialStmts(socketPath string) []ast.Stmt {
+	return []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("metricsConn"), ast.NewIdent("metricsErr")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{X: ast.NewIdent("net"), Sel: ast.NewIdent("Dial")},
+					Args: []ast.Expr{
+						&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("unix")},
+						&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(socketPath)},
+					},
+				},
+			},
+		},
+		logFatalStmt("metricsErr"),
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{X: ast.NewIdent("metricsConn"), Sel: ast.NewIdent("Close")},
+			},
+		},
+	}
+}
+
+// createMetricsSocketWriteStmt creates metricsConn.Write(append(data, '\n')),
+// sending one sample as a line
+func createMetricsSocketWriteStmt() ast.Stmt {
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{X: ast.NewIdent("metricsConn"), Sel: ast.NewIdent("Write")},
+			Args: []ast.Expr{
+				&ast.CallExpr{
+					Fun: ast.NewIdent("append"),
+					Args: []ast.Expr{
+						ast.NewIdent("data"),
+						&ast.BasicLit{Kind: token.CHAR, Value: `'\n'`},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,210 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForSample polls source until it returns want or the deadline passes
+func waitForSample(t *testing.T, source metricsSource, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if got, err := source.Latest(); err == nil && string(got) == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	got, err := source.Latest()
+	t.Fatalf("Expected latest sample %s, got %q (%v)", want, got, err)
+}
+
+func TestMetricsSocketSource(t *testing.T) {
+	if !metricsSocketSupported {
+		t.Skip("-metrics-socket is not supported on this platform")
+	}
+
+	for _, keepLast := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "metrics.sock")
+		source, err := listenMetricsSocket(path, keepLast)
+		if err != nil {
+			t.Fatalf("listenMetricsSocket failed: %v", err)
+		}
+		defer source.Close()
+
+		if _, err := source.Latest(); !errors.Is(err, errNoMetrics) {
+			t.Errorf("Expected no sample before the target connects, got %v", err)
+		}
+
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.Write([]byte("{\"alloc\":1}\n{\"alloc\":2}\nnot json\n"))
+		waitForSample(t, source, `{"alloc":2}`)
+
+		conn.Close()
+		source.drain(time.Second)
+		_, err = source.Latest()
+		if keepLast && err != nil {
+			t.Errorf("Expected the last sample to be kept after the target disconnects, got %v", err)
+		}
+		if !keepLast && !errors.Is(err, errNoMetrics) {
+			t.Errorf("Expected the last sample to be dropped after the target disconnects, got %v", err)
+		}
+	}
+}
+
+func TestMetricsSocketCollectorBuilds(t *testing.T) {
+	buildWebInstrumented(t, Options{MetricsSocket: "/tmp/peep-metrics-test.sock", RecoverPanic: true})
+}
+
+func TestMetricsFilePathIsPerRun(t *testing.T) {
+	// Neither a file of the program's own nor another peep run's is reused
+	first, second := metricsFilePath(), metricsFilePath()
+	if first == second {
+		t.Errorf("Expected two runs to get different metrics files, both got %s", first)
+	}
+	if name := filepath.Base(first); name == "peep_metrics.json" || !strings.HasPrefix(name, "peep_metrics_") {
+		t.Errorf("Expected a generated peep_metrics_<hex>.json name, got %s", name)
+	}
+}
+
+func TestPackageDashboardReadsInjectedMetricsFile(t *testing.T) {
+	path := metricsFilePath()
+	if !filepath.IsAbs(path) {
+		t.Fatalf("Expected an absolute metrics file path, got %s", path)
+	}
+	opts := Options{MetricsFile: path}
+	assign := createMetricsCollectionStmts(opts)[0].(*ast.AssignStmt)
+	if lit := assign.Rhs[0].(*ast.BasicLit); lit.Value != strconv.Quote(path) {
+		t.Errorf("Expected the collector to write %s, got %s", path, lit.Value)
+	}
+
+	// Package mode runs the target from its package directory, but the
+	// dashboard must still see its samples, as the archived final frame shows
+	pkgDir := t.TempDir()
+	mainFile := filepath.Join(pkgDir, "main.go")
+	content := "package main\n\nimport \"time\"\n\nfunc main() { time.Sleep(1200 * time.Millisecond) }\n"
+	if err := os.WriteFile(mainFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create main file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, "go.mod"), []byte("module example\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create go.mod: %v", err)
+	}
+	t.Chdir(t.TempDir())
+
+	archive := filepath.Join(pkgDir, "archive.json")
+	opts = Options{
+		CPUFile:            filepath.Join(pkgDir, "cpu.prof"),
+		EnableCPU:          true,
+		EnableWeb:          true,
+		Port:               "0",
+		MaxRuntime:         time.Millisecond,
+		MetricsFile:        filepath.Join(t.TempDir(), "peep_metrics.json"),
+		ArchiveMetricsFile: archive,
+	}
+	node, fset, err := processGoFile(mainFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecutePackage(context.Background(), node, fset, mainFile, []string{mainFile}, opts); err != nil {
+		t.Fatalf("writeAndExecutePackage failed: %v", err)
+	}
+
+	data, err := os.ReadFile(archive)
+	if err != nil || !strings.Contains(string(data), `"alloc"`) {
+		t.Errorf("Expected the dashboard to archive the target's last sample, got %q (%v)", data, err)
+	}
+}
+
+func TestMetricsFileWritesAreAtomic(t *testing.T) {
+	// A program replacing the metrics file with large samples as fast as it
+	// can, through the injected write statements
+	var writes bytes.Buffer
+	for _, stmt := range createMetricsFileWriteStmts() {
+		printer.Fprint(&writes, token.NewFileSet(), stmt)
+		writes.WriteString("\n")
+	}
+	src := `package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+func main() {
+	metricsFile := os.Args[1]
+	for i := 0; i < 3000; i++ {
+		data, _ := json.Marshal(map[string]interface{}{"n": i, "pad": strings.Repeat("x", 64<<10)})
+		` + writes.String() + `
+	}
+}
+`
+	dir := t.TempDir()
+	prog := filepath.Join(dir, "writer.go")
+	if err := os.WriteFile(prog, []byte(src), 0o644); err != nil {
+		t.Fatalf("Failed to write program: %v", err)
+	}
+	metricsFile := filepath.Join(dir, "peep_metrics.json")
+	cmd := exec.Command("go", "run", prog, metricsFile)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start writer: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	// Read like the dashboard does until the writer exits
+	var reads, failures int
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Writer failed: %v", err)
+			}
+			running = false
+		default:
+		}
+		data, err := os.ReadFile(metricsFile)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Failed to read metrics: %v", err)
+		}
+		var sample map[string]interface{}
+		if err := json.Unmarshal(data, &sample); err != nil {
+			failures++
+		}
+		reads++
+	}
+	if reads == 0 {
+		t.Fatal("Expected to read the metrics file while it was written")
+	}
+	if failures > 0 {
+		t.Errorf("Expected every read to see a complete sample, %d of %d did not", failures, reads)
+	}
+	// 0644, less whatever the umask removes
+	info, err := os.Stat(metricsFile)
+	if err != nil {
+		t.Fatalf("Expected the metrics file to remain: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&^0o644 != 0 || perm&0o600 != 0o600 {
+		t.Errorf("Expected the metrics file to keep mode 0644, got %v", perm)
+	}
+}
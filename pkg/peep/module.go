@@ -0,0 +1,42 @@
+package peep
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// outsideModule reports whether the go command runs in module-aware mode
+// without a main module, as for a snippet with no go.mod up the tree. go build
+// then only finds standard library imports.
+func outsideModule(opts Options) bool {
+	cmd := exec.Command("go", "env", "GOMOD")
+	cmd.Env = goEnv(opts)
+	out, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(out)) == os.DevNull
+}
+
+// nonStdImports lists the imports of the Go file at path that are not in the
+// standard library, going by the go command's rule that only those have a dot
+// in their first path element
+func nonStdImports(path string) []string {
+	node, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	var imports []string
+	for _, imp := range node.Imports {
+		importPath, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		first, _, _ := strings.Cut(importPath, "/")
+		if strings.Contains(first, ".") {
+			imports = append(imports, importPath)
+		}
+	}
+	return imports
+}
@@ -0,0 +1,73 @@
+package peep
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNonStdImports(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.go")
+	src := `package main
+
+import (
+	"fmt"
+	"net/http"
+	"C"
+	"golang.org/x/term"
+	yaml "gopkg.in/yaml.v3"
+	"example/internal/util"
+)
+
+func main() {}
+`
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	want := []string{"golang.org/x/term", "gopkg.in/yaml.v3"}
+	if got := nonStdImports(path); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestStandaloneFileOutsideModule(t *testing.T) {
+	// A directory with no go.mod up the tree, as for a snippet in /tmp
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOFLAGS", "")
+	if !outsideModule(Options{}) {
+		t.Skip("the temp directory is inside a Go module")
+	}
+
+	inModule := t.TempDir()
+	if err := os.WriteFile(filepath.Join(inModule, "go.mod"), []byte("module example\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write go.mod: %v", err)
+	}
+	t.Chdir(inModule)
+	if outsideModule(Options{}) {
+		t.Error("Expected a directory with a go.mod to be inside a module")
+	}
+	t.Chdir(dir)
+
+	testFile := filepath.Join(dir, "snippet.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nimport \"fmt\"\n\nfunc main() { fmt.Println(\"snippet\") }\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write snippet: %v", err)
+	}
+
+	// Resolving imports must not break a snippet that only uses the standard library
+	opts := Options{EnableCPU: true, CPUFile: filepath.Join(dir, "cpu.prof"), ResolveImports: true}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("Expected the snippet to run outside a module, got %v", err)
+	}
+	if info, err := os.Stat(opts.CPUFile); err != nil || info.Size() == 0 {
+		t.Errorf("Expected a CPU profile, got %v", err)
+	}
+}
@@ -0,0 +1,447 @@
+package peep
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultOptions returns the options the peep command starts from before its
+// flags are applied
+func DefaultOptions() Options {
+	return Options{
+		MutexRate:         1,
+		MetricsPriority:   metricsPriorityNormal,
+		Interval:          defaultMetricsInterval,
+		HistorySize:       600,
+		LogsSize:          defaultLogsKB * 1024,
+		MaxSnapshots:      defaultMaxSnapshots,
+		BaselineThreshold: 10,
+		ReportFormat:      formatText,
+		TopN:              defaultTopN,
+		BestOf:            1,
+		BestBy:            bestByFastest,
+	}
+}
+
+// validate checks that opts can be run together, as the peep command checks
+// its flags, and fills in the paths they leave to defaults: the profile
+// outputs, the metrics file or socket and the files derived from other
+// outputs. Errors name the flags of the options at fault.
+func (o *Options) validate() error {
+	if o.Verbose && o.Quiet {
+		return errors.New("-verbose and -quiet cannot be combined")
+	}
+	if o.Target == "" {
+		return errors.New("no target given, expected a Go file or package directory")
+	}
+	if o.Test && strings.HasSuffix(o.Target, "...") {
+		return fmt.Errorf("-test profiles the tests of one package, as go test -cpuprofile does; give a package directory instead of %s", o.Target)
+	}
+
+	// Default profile names go in peep's working directory, or next to the target
+	var defaultDir string
+	var isDir bool
+	if o.Target == stdinTarget {
+		if o.ProfileInTargetDir {
+			return errors.New("-profile-in-target-dir cannot be combined with a program read from stdin, whose temp directory is removed after the run")
+		}
+	} else {
+		target, dir, err := resolveTarget(o.Target, *o)
+		if err != nil {
+			return err
+		}
+		o.Target, isDir = target, dir
+		if o.ProfileInTargetDir {
+			defaultDir = target
+			if !isDir {
+				defaultDir = filepath.Dir(target)
+			}
+		}
+	}
+
+	var err error
+	if o.EnableCPU {
+		if o.CPUFile, err = resolveProfilePath(o.CPUFile, "cpu.prof", defaultDir); err != nil {
+			return err
+		}
+	}
+	if o.EnableMem {
+		if o.MemFile, err = resolveProfilePath(o.MemFile, "mem.prof", defaultDir); err != nil {
+			return err
+		}
+	}
+	if o.EnableMutex {
+		if o.MutexFile == stdoutPath {
+			return errors.New("-mutex-out cannot write to stdout")
+		}
+		if o.MutexFile, err = resolveProfilePath(o.MutexFile, "mutex.prof", defaultDir); err != nil {
+			return err
+		}
+	}
+	if o.MutexRate < 0 || (o.EnableMutex && o.MutexRate < 1) {
+		return errors.New("-mutex-rate must be at least 1")
+	}
+	if (o.MutexFile != "" || o.MutexRate > 1) && !o.EnableMutex {
+		return errors.New("-mutex-out and -mutex-rate require -mutex")
+	}
+
+	if o.ReportFormat == "" {
+		o.ReportFormat = formatText
+	}
+	if o.ReportFormat != formatText && o.ReportFormat != formatJSON {
+		return fmt.Errorf("invalid -format %q, expected text or json", o.ReportFormat)
+	}
+	if o.TopN < 0 {
+		return errors.New("-top must not be negative")
+	}
+	if o.TopLines < 0 {
+		return errors.New("-top-lines must not be negative")
+	}
+	if o.TopLines > 0 && o.TopN == 0 {
+		return errors.New("-top-lines requires -top")
+	}
+	if o.AllocSites < 0 {
+		return errors.New("-alloc-sites must not be negative")
+	}
+	if o.AllocSites > 0 && (!o.EnableMem || o.MemFile == stdoutPath) {
+		return errors.New("-alloc-sites requires a heap profile written to a file")
+	}
+	if o.DryRun && o.EmitPatchesDir != "" {
+		return errors.New("-dry-run cannot be combined with -emit-patches")
+	}
+
+	streaming := streamsToStdout(*o)
+	if streaming {
+		if o.EnableCPU && o.EnableMem && o.CPUFile == o.MemFile {
+			return errors.New("only one profile can be written to stdout, use -cpu or -mem")
+		}
+		if !o.SilentTarget {
+			return errors.New("writing a profile to stdout requires -silent-target, as the program's own output would corrupt it")
+		}
+		if o.TopN > 0 && o.ReportFormat == formatJSON {
+			return errors.New("-format json writes the report to stdout, which cannot be combined with writing a profile to stdout")
+		}
+		if o.PTY || o.MarkRegex != nil || o.FailOnEmptyProfile || o.PostInitHeap {
+			return errors.New("writing a profile to stdout cannot be combined with -pty, -mark-regex, -fail-on-empty-profile or -post-init-heap")
+		}
+	}
+
+	if o.Trace || o.TraceRegionFunc != "" {
+		if o.TraceFile == stdoutPath {
+			return errors.New("-trace-out cannot write to stdout")
+		}
+		if o.TraceFile, err = resolveProfilePath(o.TraceFile, "trace.out", defaultDir); err != nil {
+			return err
+		}
+	} else if o.TraceFile != "" {
+		return errors.New("-trace-out requires -trace or -trace-region")
+	}
+
+	if o.GoroutineInterval > 0 && o.GoroutinePrefix == "" {
+		if o.GoroutinePrefix, err = resolveProfilePath("", "goroutine", defaultDir); err != nil {
+			return err
+		}
+	}
+	if o.MemSnapshots > 0 && o.MemSnapshotPrefix == "" {
+		if o.MemSnapshotPrefix, err = resolveProfilePath("", "mem", defaultDir); err != nil {
+			return err
+		}
+	}
+
+	var outputs []outputPath
+	if o.EnableCPU && o.CPUFile != stdoutPath {
+		outputs = append(outputs, outputPath{"-cpu-out", o.CPUFile})
+	}
+	if o.EnableMem && o.MemFile != stdoutPath {
+		outputs = append(outputs, outputPath{"-mem-out", o.MemFile})
+	}
+	if o.EnableMutex {
+		outputs = append(outputs, outputPath{"-mutex-out", o.MutexFile})
+	}
+	if o.PostInitHeap {
+		if !o.EnableMem {
+			return errors.New("-post-init-heap requires memory profiling")
+		}
+		o.PostInitHeapFile = postInitHeapPath(o.MemFile)
+		outputs = append(outputs, outputPath{"-post-init-heap", o.PostInitHeapFile})
+	}
+	if o.TraceFile != "" {
+		outputs = append(outputs, outputPath{"-trace-out", o.TraceFile})
+	}
+	if o.ArchiveMetricsFile != "" {
+		outputs = append(outputs, outputPath{"-archive-metrics", o.ArchiveMetricsFile})
+	}
+	if o.SaveBaselineFile != "" {
+		outputs = append(outputs, outputPath{"-save-baseline", o.SaveBaselineFile})
+	}
+	if err := validateOutputPaths(outputs); err != nil {
+		return err
+	}
+
+	if o.CPUHz < 0 {
+		return errors.New("-cpu-hz must not be negative")
+	}
+	if o.CPUHz > 0 && !o.EnableCPU {
+		return errors.New("-cpu-hz requires CPU profiling")
+	}
+	if o.CPUHz > 0 && o.EnableCPU && o.CPUFile == stdoutPath {
+		return errors.New("-cpu-hz cannot be combined with writing the CPU profile to stdout, as the profile is annotated after the run")
+	}
+	if o.BestOf < 0 {
+		return errors.New("-best-of must be at least 1")
+	}
+	if o.BestBy == "" {
+		o.BestBy = bestByFastest
+	}
+	if o.BestBy != bestByFastest && o.BestBy != bestByMedian {
+		return fmt.Errorf("invalid -best-by %q, expected fastest or median", o.BestBy)
+	}
+	if o.BestOf > 1 && (collectsMetrics(*o) || streaming || o.Example != "" || o.InjectAtReturn || tracksPeakAlloc(*o)) {
+		return errors.New("-best-of cannot be combined with -dash, -summary, -max-alloc, -example, -inject-at-return, baselines or writing a profile to stdout")
+	}
+	if o.BuildParallelism < 0 {
+		return errors.New("-p must not be negative")
+	}
+	tags := strings.FieldsFunc(o.BuildTags, func(r rune) bool { return r == ',' || r == ' ' })
+	o.BuildTags = strings.Join(tags, ",")
+	if o.HistorySize < 0 {
+		return errors.New("-history must not be negative")
+	}
+	if o.LogsSize < 0 {
+		return errors.New("-logs-kb must not be negative")
+	}
+	if o.HistoryFile != "" && !o.EnableWeb {
+		return errors.New("-history-out requires -dash")
+	}
+	if o.CSVFile != "" && !o.EnableWeb {
+		return errors.New("-csv-out requires -dash")
+	}
+	if o.LivePprofAddr != "" && (o.Func != "" || o.Example != "") {
+		return errors.New("-live-pprof cannot be combined with -func or -example, which do not instrument main")
+	}
+	if o.StaticDir != "" {
+		if !o.EnableWeb {
+			return errors.New("-static-dir requires -dash")
+		}
+		if info, err := os.Stat(o.StaticDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("-static-dir %s is not a directory", o.StaticDir)
+		}
+	}
+	if o.MaxRuntime < 0 {
+		return errors.New("-max-runtime must not be negative")
+	}
+	if o.GoroutineInterval < 0 {
+		return errors.New("-goroutine-interval must not be negative")
+	}
+	if o.GoroutineInterval > 0 && (o.Func != "" || o.Example != "" || o.BestOf > 1) {
+		return errors.New("-goroutine-interval cannot be combined with -func, -example or -best-of")
+	}
+	if o.MaxRuntime > 0 && !o.EnableWeb {
+		return errors.New("-max-runtime requires -dash")
+	}
+	if o.Duration < 0 {
+		return errors.New("-duration must not be negative")
+	}
+	if o.Duration > 0 && (o.Func != "" || o.Example != "") {
+		return errors.New("-duration cannot be combined with -func or -example, it stops main")
+	}
+	if o.CPUDuration < 0 {
+		return errors.New("-cpu-duration must not be negative")
+	}
+	if o.CPUDuration > 0 && !o.EnableCPU {
+		return errors.New("-cpu-duration requires CPU profiling")
+	}
+	if o.CPUDuration > 0 && o.Example != "" {
+		return errors.New("-cpu-duration cannot be combined with -example, whose CPU profile is written by go test")
+	}
+	if o.Warmup < 0 {
+		return errors.New("-warmup must not be negative")
+	}
+	if o.Warmup > 0 && !o.EnableCPU {
+		return errors.New("-warmup requires CPU profiling")
+	}
+	if o.Warmup > 0 && (o.CPUContinuous || o.WarmCalls > 0 || o.Example != "") {
+		return errors.New("-warmup cannot be combined with -cpu-continuous, -warm-calls or -example, which start CPU profiling themselves")
+	}
+	if o.MetricsDuration < 0 {
+		return errors.New("-metrics-duration must not be negative")
+	}
+	if o.MetricsDuration > 0 && !o.EnableWeb {
+		return errors.New("-metrics-duration requires -dash")
+	}
+	if o.MemSnapshots < 0 {
+		return errors.New("-mem-snapshots must not be negative")
+	}
+	if o.MemSnapshots > 0 && o.MemSnapshots < time.Second {
+		return errors.New("-mem-snapshots must be at least 1s, as snapshots are named by the second they are taken")
+	}
+	if o.MemSnapshots > 0 && !o.EnableWeb {
+		return errors.New("-mem-snapshots requires -dash, whose metrics collector writes the snapshots")
+	}
+	if o.MaxSnapshots < 0 {
+		return errors.New("-max-snapshots must not be negative")
+	}
+	if o.ArchiveMetricsFile != "" && !o.EnableWeb {
+		return errors.New("-archive-metrics requires -dash")
+	}
+	if o.NoStaleCheck && !o.EnableWeb {
+		return errors.New("-no-stale-check requires -dash")
+	}
+	if o.Func != "" && (o.CPUContinuous || o.PostInitHeap) {
+		return errors.New("-func cannot be combined with -cpu-continuous or -post-init-heap, which profile from the start of the program")
+	}
+	if o.AlertGoroutines < 0 {
+		return errors.New("-alert-goroutines must not be negative")
+	}
+	if (o.AlertGoroutines > 0 || o.AlertAlloc > 0 || o.AlertSound) && !o.EnableWeb {
+		return errors.New("-alert-goroutines, -alert-alloc and -alert-sound require -dash")
+	}
+	if o.AlertSound && o.AlertGoroutines == 0 && o.AlertAlloc == 0 {
+		return errors.New("-alert-sound requires -alert-goroutines or -alert-alloc")
+	}
+	if o.PerCore && !o.EnableWeb {
+		return errors.New("-per-core requires -dash")
+	}
+	if o.Adaptive && !o.EnableWeb {
+		return errors.New("-adaptive requires -dash")
+	}
+	if o.Interval < 0 {
+		return errors.New("-interval must be positive")
+	}
+	if o.MetricsPriority == "" {
+		o.MetricsPriority = metricsPriorityNormal
+	}
+	if o.Interval != 0 && o.Interval != defaultMetricsInterval {
+		if !collectsMetrics(*o) {
+			return errors.New("-interval requires -dash, -summary or -max-alloc, which collect metrics")
+		}
+		if o.MetricsPriority == metricsPriorityLow {
+			return errors.New("-interval cannot be combined with -metrics-priority low, which varies the interval itself")
+		}
+	}
+	if o.MetricsPriority != metricsPriorityNormal && o.MetricsPriority != metricsPriorityLow {
+		return fmt.Errorf("invalid -metrics-priority %q, expected normal or low", o.MetricsPriority)
+	}
+	if o.MetricsPriority == metricsPriorityLow {
+		if !o.EnableWeb {
+			return errors.New("-metrics-priority low requires -dash")
+		}
+		if o.Adaptive {
+			return errors.New("-metrics-priority low cannot be combined with -adaptive, which samples more often under load")
+		}
+	}
+	if o.UseMetricsSocket {
+		if !o.EnableWeb {
+			return errors.New("-metrics-socket requires -dash")
+		}
+		if metricsSocketSupported && o.MetricsSocket == "" {
+			o.MetricsSocket = metricsSocketPath()
+		}
+	}
+	if collectsMetrics(*o) && o.MetricsSocket == "" && o.MetricsFile == "" {
+		o.MetricsFile = metricsFilePath()
+	}
+	if o.GCTrace && !o.EnableWeb {
+		return errors.New("-gctrace requires -dash")
+	}
+	if o.GCTrace && o.PTY {
+		return errors.New("-gctrace cannot be combined with -pty, which merges stderr into the terminal")
+	}
+
+	if (o.InjectAtReturn || tracksPeakAlloc(*o)) && o.FinalSnapshotFile == "" {
+		o.FinalSnapshotFile = filepath.Join(os.TempDir(), "peep_final_"+randomSuffix()+".json")
+	}
+
+	if o.WarmCalls < 0 {
+		return errors.New("-warm-calls must not be negative")
+	}
+	if o.WarmCalls > 0 {
+		if o.Func == "" || !o.EnableCPU || o.EnableMem {
+			return errors.New("-warm-calls requires -func and -cpu")
+		}
+		// Everything else injected into the function would run on every call
+		if collectsMetrics(*o) || o.TraceFile != "" || o.RecoverPanic || o.FinalSnapshotFile != "" {
+			return errors.New("-warm-calls only supports CPU profiling, without -dash, -summary, -max-alloc, -trace, -trace-region, -recover-panic, -inject-at-return or metrics baselines")
+		}
+	}
+
+	if o.MarkRegex != nil && !o.EnableWeb {
+		return errors.New("-mark-regex requires -dash")
+	}
+	if o.Toolchain != "" {
+		if err := validateToolchain(o.Toolchain); err != nil {
+			return err
+		}
+	}
+
+	if o.Example != "" {
+		if !isDir {
+			return errors.New("-example requires a package directory")
+		}
+		if collectsMetrics(*o) || o.FinalSnapshotFile != "" || o.CPUContinuous || o.PostInitHeap || o.PTY || streaming || o.TraceFile != "" || o.CPUHz > 0 || o.Func != "" || o.EmitPatchesDir != "" || o.DryRun {
+			return errors.New("-example only supports -cpu, -mem, -mutex, their output flags and -fail-on-empty-profile")
+		}
+	}
+	if o.Test {
+		if !isDir {
+			return errors.New("-test requires a package directory")
+		}
+		if collectsMetrics(*o) || o.FinalSnapshotFile != "" || o.CPUContinuous || o.PostInitHeap || o.PTY || streaming || o.TraceRegionFunc != "" || o.CPUHz > 0 || o.Func != "" || o.EmitPatchesDir != "" || o.DryRun || o.Example != "" || o.Duration > 0 || o.CPUDuration > 0 || o.Warmup > 0 || o.GoroutineInterval > 0 || o.LivePprofAddr != "" || o.RecoverPanic || o.BestOf > 1 || o.Generate || o.Entry != "" {
+			return errors.New("-test only supports -cpu, -mem, -mutex, -trace, their output flags and the reports after the run, as the tests are not instrumented")
+		}
+	}
+	return nil
+}
+
+// setup prepares the run of validated opts: the logger that prints peep's
+// messages at the level asked for, and the warnings about opts
+func (o *Options) setup() {
+	level := levelNormal
+	if o.Quiet {
+		// Errors are returned, which still prints them, and the reports
+		// opts asks for are still written to the logger's writer
+		level = levelQuiet
+	} else if o.Verbose {
+		level = levelVerbose
+	}
+	// Keep stdout for the report, the instrumented code or the profile
+	var w io.Writer = os.Stdout
+	if o.ReportFormat == formatJSON || o.DryRun || streamsToStdout(*o) {
+		w = os.Stderr
+	}
+	o.log = newLogger(w, level)
+
+	if o.Race {
+		o.log.logf(levelNormal, "Warning: -race slows the program down and adds memory overhead, so the profiles and metrics do not reflect a normal build")
+	}
+	if o.UseMetricsSocket && !metricsSocketSupported {
+		o.log.logf(levelNormal, "-metrics-socket is not supported on Windows, using the metrics file")
+	}
+}
+
+// openStdinTarget saves the program of a stdin target to a temp file and
+// points o.Target at it. The returned function removes the file again, and
+// does nothing for any other target.
+func (o *Options) openStdinTarget() (func(), error) {
+	if o.Target != stdinTarget {
+		return func() {}, nil
+	}
+	r := o.Stdin
+	if r == nil {
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return nil, errors.New("- reads the program from stdin, pipe it in: cat main.go | peep -")
+		}
+		r = os.Stdin
+	}
+	path, err := writeStdinTarget(r)
+	if err != nil {
+		return nil, err
+	}
+	o.Target = path
+	return func() { os.RemoveAll(filepath.Dir(path)) }, nil
+}
@@ -0,0 +1,93 @@
+package peep
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts Options
+		want string // substring of the error, "" if opts are valid
+	}{
+		{"defaults", Options{EnableCPU: true}, ""},
+		{"mutex rate unset", Options{EnableMutex: true}, "-mutex-rate must be at least 1"},
+		{"mutex rate negative", Options{EnableMutex: true, MutexRate: -1}, "-mutex-rate must be at least 1"},
+		{"mutex rate without mutex", Options{MutexRate: 5}, "require -mutex"},
+		{"low priority without dashboard", Options{MetricsPriority: metricsPriorityLow, MetricsChan: make(chan Metrics)}, "-metrics-priority low requires -dash"},
+		{"interval without metrics", Options{Interval: time.Second}, "-interval requires"},
+		{"interval with summary", Options{Interval: time.Second, Summary: true}, ""},
+		{"per-core without dashboard", Options{PerCore: true}, "-per-core requires -dash"},
+		{"verbose and quiet", Options{Verbose: true, Quiet: true}, "cannot be combined"},
+		{"stdout without silent target", Options{EnableCPU: true, CPUFile: stdoutPath}, "requires -silent-target"},
+		{"example of a file", Options{Example: "ExampleParse", EnableCPU: true}, "-example requires a package directory"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.Target = testFile
+			err := tc.opts.validate()
+			if tc.want == "" {
+				if err != nil {
+					t.Errorf("Expected valid options, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestOptionsValidateFillsDefaults(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{Target: testFile, EnableCPU: true, EnableMem: true, PostInitHeap: true, Summary: true, ProfileInTargetDir: true}
+	if err := opts.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if want := filepath.Join(tempDir, "cpu.prof"); opts.CPUFile != want {
+		t.Errorf("Expected the CPU profile at %s, got %s", want, opts.CPUFile)
+	}
+	if want := filepath.Join(tempDir, "mem_postinit.prof"); opts.PostInitHeapFile != want {
+		t.Errorf("Expected the post-init heap profile at %s, got %s", want, opts.PostInitHeapFile)
+	}
+	if opts.MetricsFile == "" {
+		t.Error("Expected a metrics file for -summary")
+	}
+	if opts.MetricsPriority != metricsPriorityNormal || opts.ReportFormat != formatText || opts.BestBy != bestByFastest {
+		t.Errorf("Expected the empty settings to take their defaults, got %+v", opts)
+	}
+}
+
+func TestRunValidatesOptions(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.Target = testFile
+	opts.EnableMutex = true
+	opts.MutexRate = 0
+	result, err := Run(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "-mutex-rate") {
+		t.Errorf("Expected Run to reject -mutex-rate 0, got %v", err)
+	}
+	if result.ExitCode != -1 {
+		t.Errorf("Expected exit code -1 for invalid options, got %d", result.ExitCode)
+	}
+}
@@ -0,0 +1,79 @@
+package peep
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// metricsTimestamp returns the key and time.Time method used to stamp samples:
+// milliseconds unless nanosecond precision is requested
+func metricsTimestamp(opts Options) (string, string) {
+	if opts.NanoTimestamps {
+		return `"timestampNs"`, "UnixNano"
+	}
+	return `"timestampMs"`, "UnixMilli"
+}
+
+// createRecoverPanicStmts creates a deferred recover for main. It is injected
+// after the other instrumentation so it runs before their deferred flushes: it
+// logs the panic, writes a last metrics sample when the dashboard is enabled
+// and re-panics, which unwinds through the profile flushes and exits as before.
+func createRecoverPanicStmts(opts Options) []ast.Stmt {
+	handle := []ast.Stmt{
+		// log.Printf("[prof] main panicked: %v, flushing profiles", r)
+		&ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("log"),
+					Sel: ast.NewIdent("Printf"),
+				},
+				Args: []ast.Expr{
+					&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("[prof] main panicked: %v, flushing profiles")},
+					ast.NewIdent("r"),
+				},
+			},
+		},
+	}
+
+	if collectsMetrics(opts) {
+		timestampKey, timestampFunc := metricsTimestamp(opts)
+		handle = append(handle, createMetricsSampleStmts(timestampKey, timestampFunc, opts.PerCore, opts.MetricsPriority == metricsPriorityLow, opts.MetricsSocket != "")...)
+	}
+
+	// panic(r)
+	handle = append(handle, &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun:  ast.NewIdent("panic"),
+			Args: []ast.Expr{ast.NewIdent("r")},
+		},
+	})
+
+	return []ast.Stmt{
+		// defer func() { if r := recover(); r != nil { ... } }()
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.FuncLit{
+					Type: &ast.FuncType{},
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.IfStmt{
+								Init: &ast.AssignStmt{
+									Lhs: []ast.Expr{ast.NewIdent("r")},
+									Tok: token.DEFINE,
+									Rhs: []ast.Expr{&ast.CallExpr{Fun: ast.NewIdent("recover")}},
+								},
+								Cond: &ast.BinaryExpr{
+									X:  ast.NewIdent("r"),
+									Op: token.NEQ,
+									Y:  ast.NewIdent("nil"),
+								},
+								Body: &ast.BlockStmt{List: handle},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package peep
+
+import (
+	"bytes"
+	"go/printer"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestRecoverPanicInstrumentationBuilds(t *testing.T) {
+	buildWebInstrumented(t, Options{RecoverPanic: true})
+}
+
+func TestRecoverPanicFlushesProfiles(t *testing.T) {
+	content := `package main
+
+var data [][]byte
+
+func main() {
+	for i := 0; i < 1000; i++ {
+		data = append(data, make([]byte, 1024))
+	}
+	panic("boom")
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	memFile := filepath.Join(tempDir, "mem.prof")
+	opts := Options{EnableMem: true, MemFile: memFile, RecoverPanic: true}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	instrumented := filepath.Join(tempDir, "main_prof.go")
+	out, err := os.Create(instrumented)
+	if err != nil {
+		t.Fatalf("Failed to create instrumented file: %v", err)
+	}
+	if err := printer.Fprint(out, fset, node); err != nil {
+		t.Fatalf("Failed to write instrumented file: %v", err)
+	}
+	out.Close()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("go", "run", instrumented)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err == nil {
+		t.Fatal("Expected the program to still exit with the panic")
+	}
+
+	// Logged by the injected recover, then re-panicked with the original value
+	if !strings.Contains(stderr.String(), "[prof] main panicked: boom") || !strings.Contains(stderr.String(), "panic: boom") {
+		t.Errorf("Expected the panic to be logged and re-raised, got:\n%s", stderr.String())
+	}
+
+	f, err := os.Open(memFile)
+	if err != nil {
+		t.Fatalf("Expected the memory profile to be flushed: %v", err)
+	}
+	defer f.Close()
+	if _, err := profile.Parse(f); err != nil {
+		t.Errorf("Expected a valid memory profile: %v", err)
+	}
+}
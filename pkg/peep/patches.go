@@ -0,0 +1,95 @@
+package peep
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// patchDisplayPath returns the path a patch names for file: relative to the
+// working directory, so the patches apply from where peep was run
+func patchDisplayPath(file string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return filepath.Base(file)
+	}
+	rel, err := filepath.Rel(wd, file)
+	if err != nil {
+		return filepath.Base(file)
+	}
+	return rel
+}
+
+// writePatch writes the diff of displayPath from original to modified into dir
+// as <file>.patch and returns its path
+func writePatch(dir, original, modified, displayPath string) (string, error) {
+	diff, err := unifiedDiff(original, modified, displayPath)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, filepath.Base(displayPath)+".patch")
+	if err := os.WriteFile(path, []byte(diff), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write patch: %w", err)
+	}
+	return path, nil
+}
+
+// emitPatches writes one unified diff per file the instrumentation changes or
+// adds into dir instead of running the target: the instrumented file, and
+// the CPU helper when the dashboard collector is injected
+func emitPatches(w io.Writer, dir, instrumentedFile string, node *ast.File, fset *token.FileSet, opts Options) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create patch directory: %w", err)
+	}
+
+	original, err := os.ReadFile(instrumentedFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", instrumentedFile, err)
+	}
+	// gofmt the instrumented code so formatted sources only differ by the injected lines
+	var instrumented bytes.Buffer
+	if err := format.Node(&instrumented, fset, node); err != nil {
+		return fmt.Errorf("failed to format instrumented code: %w", err)
+	}
+
+	displayPath := patchDisplayPath(instrumentedFile)
+	paths := make([]string, 0, 2)
+	path, err := writePatch(dir, string(original), instrumented.String(), displayPath)
+	if err != nil {
+		return err
+	}
+	paths = append(paths, path)
+
+	if collectsMetrics(opts) {
+		helper := filepath.Join(filepath.Dir(displayPath), cpuHelperFile)
+		path, err := writePatch(dir, "", cpuHelperSource, helper)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+
+	for _, path := range paths {
+		fmt.Fprintf(w, "[prof] Patch written to %s\n", path)
+	}
+	fmt.Fprintf(w, "[prof] Apply from this directory with: git apply %s\n", filepath.Join(dir, "*.patch"))
+	return nil
+}
+
+// printInstrumented writes the instrumented file to w, gofmt'ed, for -dry-run.
+// It is formatted in full before anything is written.
+func printInstrumented(w io.Writer, node *ast.File, fset *token.FileSet) error {
+	var instrumented bytes.Buffer
+	if err := format.Node(&instrumented, fset, node); err != nil {
+		return fmt.Errorf("failed to format instrumented code: %w", err)
+	}
+	if _, err := w.Write(instrumented.Bytes()); err != nil {
+		return fmt.Errorf("failed to print instrumented code: %w", err)
+	}
+	return nil
+}
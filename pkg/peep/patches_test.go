@@ -0,0 +1,99 @@
+package peep
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmitPatchesApplyAndBuild(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	tempDir := t.TempDir()
+	pkgDir := filepath.Join(tempDir, "cmd", "app")
+	if err := os.MkdirAll(pkgDir, 0o755); err != nil {
+		t.Fatalf("Failed to create package directory: %v", err)
+	}
+	files := map[string]string{
+		"go.mod":  "module example.com/app\n\ngo 1.24\n",
+		"main.go": "package main\n\nfunc main() {\n\twork()\n}\n",
+		"work.go": "package main\n\nfunc work() {}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(pkgDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	t.Chdir(tempDir)
+
+	mainFile := filepath.Join(pkgDir, "main.go")
+	opts := Options{CPUFile: filepath.Join(tempDir, "cpu.prof"), EnableCPU: true, EnableWeb: true}
+	node, fset, err := processGoFile(mainFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	patchDir := filepath.Join(tempDir, "patches")
+	var out bytes.Buffer
+	if err := emitPatches(&out, patchDir, mainFile, node, fset, opts); err != nil {
+		t.Fatalf("emitPatches failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(patchDir)
+	if err != nil {
+		t.Fatalf("Failed to read patch directory: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, " ") != "main.go.patch peep_cpu_prof.go.patch" {
+		t.Fatalf("Expected patches for main.go and the CPU helper, got %v", names)
+	}
+
+	mainPatch, err := os.ReadFile(filepath.Join(patchDir, "main.go.patch"))
+	if err != nil {
+		t.Fatalf("Failed to read main.go.patch: %v", err)
+	}
+	if !strings.HasPrefix(string(mainPatch), "--- a/cmd/app/main.go\n+++ b/cmd/app/main.go\n") {
+		t.Errorf("Expected paths relative to the working directory, got:\n%s", mainPatch)
+	}
+
+	// Applied to the real tree, the instrumentation builds without peep
+	apply := exec.Command("git", "apply", filepath.Join(patchDir, "main.go.patch"), filepath.Join(patchDir, "peep_cpu_prof.go.patch"))
+	if output, err := apply.CombinedOutput(); err != nil {
+		t.Fatalf("git apply failed: %v\n%s", err, output)
+	}
+	build := exec.Command("go", "build", "-o", filepath.Join(tempDir, "app"), ".")
+	build.Dir = pkgDir
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Errorf("Patched package failed to build: %v\n%s", err, output)
+	}
+}
+
+func TestPrintInstrumented(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	opts := Options{CPUFile: "cpu.prof", MemFile: "mem.prof", EnableCPU: true, EnableMem: true}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := printInstrumented(&out, node, fset); err != nil {
+		t.Fatalf("printInstrumented failed: %v", err)
+	}
+	for _, want := range []string{`"runtime/pprof"`, "pprof.StartCPUProfile", "defer pprof.StopCPUProfile()", "pprof.WriteHeapProfile", `fmt.Println("hi")`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in the printed code, got:\n%s", want, out.String())
+		}
+	}
+}
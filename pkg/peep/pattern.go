@@ -0,0 +1,47 @@
+package peep
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// isPackagePattern reports whether target reads as a go list package pattern
+// rather than a path, such as ./cmd/... or all
+func isPackagePattern(target string) bool {
+	return strings.Contains(target, "...") || target == "all" || target == "std" || target == "cmd"
+}
+
+// resolvePackagePattern runs go list on pattern from the working directory and
+// returns the directory of the one main package it matches, as go run would
+// build for an import path or a pattern like ./cmd/...
+func resolvePackagePattern(pattern string, opts Options) (string, error) {
+	cmd := exec.Command("go", append(goListArgs(opts), pattern)...)
+	cmd.Env = goEnv(opts)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go list %s failed: %s", pattern, strings.TrimSpace(stderr.String()))
+	}
+
+	pkgs, err := decodePackages(bytes.NewReader(output))
+	if err != nil {
+		return "", err
+	}
+	if len(pkgs) == 0 {
+		return "", fmt.Errorf("pattern %s matched no packages", pattern)
+	}
+	pkg, err := selectMainPackage(pkgs)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", pattern, err)
+	}
+	if pkg.Error != nil && pkg.Dir == "" {
+		return "", fmt.Errorf("go list %s failed: %s", pattern, pkg.Error.Err)
+	}
+	if pkg.Name != "main" {
+		return "", fmt.Errorf("%s is not a main package (found package %s)", pkg.ImportPath, pkg.Name)
+	}
+	return pkg.Dir, nil
+}
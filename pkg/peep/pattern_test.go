@@ -0,0 +1,85 @@
+package peep
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePatternModule creates a module with a main package in cmd/app and a
+// library package in lib, and changes into it
+func writePatternModule(t *testing.T) string {
+	t.Helper()
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"go.mod":          "module example.com/m\n\ngo 1.21\n",
+		"cmd/app/main.go": "package main\n\nfunc main() {}\n",
+		"lib/lib.go":      "package lib\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+	t.Chdir(tempDir)
+	return tempDir
+}
+
+func TestResolveTargetPackagePattern(t *testing.T) {
+	tempDir := writePatternModule(t)
+	appDir, err := filepath.EvalSymlinks(filepath.Join(tempDir, "cmd", "app"))
+	if err != nil {
+		t.Fatalf("Failed to resolve %s: %v", appDir, err)
+	}
+
+	for _, form := range []string{"./cmd/...", "./...", "example.com/m/cmd/app"} {
+		path, isDir, err := resolveTarget(form, Options{})
+		if err != nil {
+			t.Errorf("resolveTarget(%q) failed: %v", form, err)
+			continue
+		}
+		if !isDir {
+			t.Errorf("resolveTarget(%q) should be a package directory", form)
+		}
+		if resolved, _ := filepath.EvalSymlinks(path); resolved != appDir {
+			t.Errorf("resolveTarget(%q) = %s, expected %s", form, path, appDir)
+		}
+	}
+}
+
+func TestResolveTargetPackagePatternErrors(t *testing.T) {
+	tempDir := writePatternModule(t)
+	toolDir := filepath.Join(tempDir, "cmd", "tool")
+	if err := os.MkdirAll(toolDir, 0o755); err != nil {
+		t.Fatalf("Failed to create %s: %v", toolDir, err)
+	}
+	if err := os.WriteFile(filepath.Join(toolDir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create main.go: %v", err)
+	}
+
+	// Two main packages: the error lists what matched
+	_, _, err := resolveTarget("./cmd/...", Options{})
+	if err == nil {
+		t.Fatal("Expected an error for a pattern matching two main packages")
+	}
+	for _, want := range []string{"example.com/m/cmd/app", "example.com/m/cmd/tool"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to list %s, got: %v", want, err)
+		}
+	}
+
+	if _, _, err := resolveTarget("./lib/...", Options{}); err == nil || !strings.Contains(err.Error(), "not a main package") {
+		t.Errorf("Expected a library package to be rejected, got: %v", err)
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "docs"), 0o755); err != nil {
+		t.Fatalf("Failed to create docs: %v", err)
+	}
+	if _, _, err := resolveTarget("./docs/...", Options{}); err == nil || !strings.Contains(err.Error(), "matched no packages") {
+		t.Errorf("Expected a pattern without packages to be rejected, got: %v", err)
+	}
+}
@@ -0,0 +1,3199 @@
+// Package peep instruments a Go program with profiling code and runs it. It
+// is the core of the peep command, which fills Options from its flags and
+// calls Run.
+package peep
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Metrics holds both CPU and memory usage
+type Metrics struct {
+	Alloc       uint64    `json:"alloc"`
+	TotalAlloc  uint64    `json:"totalAlloc"`
+	Sys         uint64    `json:"sys"`
+	NumGC       uint32    `json:"numGC"`
+	PauseTotal  uint64    `json:"pauseTotal"`
+	HeapInuse   uint64    `json:"heapInuse"`            // bytes in in-use heap spans; above Alloc by the fragmentation
+	HeapObjects uint64    `json:"heapObjects"`          // live heap objects
+	Mallocs     uint64    `json:"mallocs"`              // cumulative heap objects allocated
+	Frees       uint64    `json:"frees"`                // cumulative heap objects freed
+	CPUPercent  float64   `json:"cpuPercent"`           // total system CPU percent (0-100 * cores)
+	CPUPerCore  []float64 `json:"cpuPerCore,omitempty"` // per-core CPU percent (0-100 each), with -per-core
+	Goroutines  int       `json:"goroutines"`
+	Threads     int       `json:"threads"` // OS threads created, from the threadcreate profile
+	TimestampMS int64     `json:"timestampMs,omitempty"`
+	TimestampNS int64     `json:"timestampNs,omitempty"` // set instead of TimestampMS with -timestamp-ns
+	PeakAlloc   uint64    `json:"peakAlloc,omitempty"`   // final snapshot only, when baselining
+
+	GCCPUFraction float64 `json:"gcCpuFraction"` // share of the available CPU the GC used since the program started
+	LastPauseNs   uint64  `json:"lastPauseNs"`   // stop-the-world pause of the latest GC cycle, 0 before the first
+}
+
+// Options holds the settings that control how a target is instrumented and run
+type Options struct {
+	Target      string    // file or package directory being profiled, or - for a program read from Stdin
+	Stdin       io.Reader // source of a - target, os.Stdin if nil
+	Generate    bool      // run go generate in the package directory before instrumenting
+	Entry       string    // package file whose main is the entry point, when several files define one
+	CPUFile     string
+	MemFile     string
+	EnableCPU   bool
+	EnableMem   bool
+	EnableWeb   bool
+	Port        string // dashboard port; empty uses defaultDashboardPort, or a free port when that is taken
+	ProgramArgs []string
+	MarkRegex   *regexp.Regexp // stdout lines matching this are recorded as annotations
+	ListRegex   *regexp.Regexp // print the annotated source of matching functions after the run, if set
+
+	EnableMutex bool   // also write a mutex contention profile
+	MutexFile   string // where the mutex profile is written
+	MutexRate   int    // sample one in this many contention events
+
+	FailOnEmptyProfile bool   // fail the run if a written profile has no samples
+	InjectAtReturn     bool   // record a final metrics snapshot when main returns
+	FinalSnapshotFile  string // where the deferred end-of-run snapshot is written, if set
+	GCTrace            bool   // run the target with GODEBUG=gctrace=1 and parse its GC lines
+	NanoTimestamps     bool   // stamp metrics samples in nanoseconds instead of milliseconds
+	PTY                bool   // run the target attached to a pseudo-terminal (Unix only)
+	CPUContinuous      bool   // start CPU profiling in init and stop it once, on main return or SIGINT/SIGTERM
+	ShowEnv            bool   // include environment values in the dashboard's run info
+	Adaptive           bool   // vary the metrics sampling interval with how quickly Alloc changes
+	MetricsPriority    string // normal, or low for a collector that avoids perturbing the target
+	ArchiveMetricsFile string // where the final metrics frame is copied after the target exits, if set
+	PerCore            bool   // also report per-core CPU usage in the metrics stream
+	PostInitHeap       bool   // also write a heap profile at the start of main, after package initialization
+	PostInitHeapFile   string // where the heap profile taken at the start of main is written, set from MemFile
+	SilentTarget       bool   // discard the target's stdout
+	Toolchain          string // Go toolchain requested through GOTOOLCHAIN, if set
+	BuildParallelism   int    // number of build jobs the go command runs in parallel (-p), if positive
+	BuildTags          string // comma-separated build tags passed to the go command (-tags), if set
+	LDFlags            string // linker flags passed to the go command (-ldflags), if set
+	Race               bool   // build the target with the race detector (-race)
+	ResolveImports     bool   // let go build look up the modules of a file outside any module (-mod=mod)
+	HistorySize        int    // number of recent metrics samples kept for /history
+	LogsSize           int    // bytes of the target's recent output kept for /logs
+	HistoryFile        string // append every metrics sample to this JSONL file, if set
+	CSVFile            string // write every metrics sample to this CSV file when the run ends, if set
+	Summary            bool   // collect metrics, without the dashboard if need be, and print their peaks and averages after the run
+	MaxAlloc           uint64 // fail the run when a metrics sample reports Alloc above this many bytes, if set
+	ManifestFile       string // write the run info, timing and output files here after the run, if set
+	StaticDir          string // serve the dashboard page from this directory instead of the embedded one, if set
+	LivePprofAddr      string // the target serves net/http/pprof on this address while it runs, if set
+	NoStaleCheck       bool   // serve the last metrics sample however old it is
+	TopN               int    // print the top functions of each profile after the run, if positive (DefaultOptions prints 10)
+	ReportFormat       string // format of the reports, text or json
+	AllocSites         int    // print the top allocation sites of the heap profile after the run, if positive
+	TopLines           int    // with TopN, also print the hottest source lines of each top function, if positive
+	Trace              bool   // also write an execution trace
+	TraceFile          string // where the execution trace is written, with Trace or TraceRegionFunc
+	TraceRegionFunc    string // function wrapped in a trace task and region, with TraceFile
+	RecoverPanic       bool   // log a panic in main and write a last metrics sample before re-panicking
+	CPUHz              int    // CPU profiling rate in hertz, 0 keeps the runtime default of 100
+
+	Func      string // function to instrument instead of main, as Name or Type.Method
+	WarmCalls int    // start CPU profiling once Func has been called more than this many times
+
+	DaemonSocket     string // serve the dashboard on this socket for a peep daemon instead of Port
+	UseMetricsSocket bool   // send metrics over a Unix socket instead of the metrics file, where supported
+
+	MetricsSocket string // the collector sends samples to peep on this Unix socket instead of the metrics file, if set
+	MetricsFile   string // absolute path the collector writes samples to and the dashboard reads them from
+
+	AlertGoroutines int    // dashboard alert when goroutines exceed this, if set
+	AlertAlloc      uint64 // dashboard alert when Alloc exceeds this many bytes, if set
+	AlertSound      bool   // beep in the browser when an alert starts
+
+	MaxRuntime      time.Duration // how long the dashboard stays up after the target exits, 0 waits for Ctrl+C
+	MetricsDuration time.Duration // stop collecting metrics this long after main starts, if positive
+	Interval        time.Duration // how often metrics are sampled, 0 uses defaultMetricsInterval
+	Duration        time.Duration // interrupt the target this long after it starts, flushing the profiles, if positive
+	CPUDuration     time.Duration // stop CPU profiling this long after it starts, if positive
+	Warmup          time.Duration // start CPU profiling this long after main starts, if positive
+
+	GoroutineInterval time.Duration // write a goroutine profile this often while the program runs, if positive
+	GoroutinePrefix   string        // path prefix of the periodic goroutine profiles, <prefix>-<n>.prof
+	MemSnapshots      time.Duration // have the metrics collector write a heap profile this often, if positive
+	MemSnapshotPrefix string        // path prefix of the heap snapshots, <prefix>-<unix>.prof
+	MaxSnapshots      int           // number of newest heap snapshots kept, all of them if 0
+
+	BaselineFile      string  // compare the run's summary metrics against this baseline, if set
+	SaveBaselineFile  string  // write the run's summary metrics here as a new baseline, if set
+	BaselineThreshold float64 // largest allowed increase over the baseline, in percent
+
+	ProfileInTargetDir bool   // default profile outputs go next to the target instead of the working directory
+	Cgo                bool   // set CGO_ENABLED=1 for the go commands and the target
+	Example            string // profile this Example function of the package through go test instead of running main
+	Test               bool   // profile the package's tests through go test instead of running main
+	EmitPatchesDir     string // write the instrumentation as unified diffs here instead of running the target, if set
+	DryRun             bool   // print the instrumented file to stdout instead of running the target
+	BestOf             int    // run the target this many times and keep the profiles of one run, chosen by BestBy
+	BestBy             string // which BestOf run is kept, fastest or median
+	Verbose            bool   // also log temp files, go commands and added imports
+	Quiet              bool   // log only errors, no progress messages or warnings
+
+	MetricsChan chan<- Metrics // each new sample is sent here while the target runs, if set; Run closes it before returning
+
+	log *logger // prints peep's messages for the run, set by setup
+}
+
+// dashboardData holds the run state served by the dashboard besides the metrics file
+type dashboardData struct {
+	annotations *eventLog[Annotation]
+	gcEvents    *eventLog[GCEvent]
+	runInfo     *RunInfo
+	config      *DashboardConfig
+	history     *ringBuffer[json.RawMessage] // recent metrics samples, served at /history
+	historyOut  io.Writer                    // every sample is also appended here as JSONL, if set
+	samples     *eventLog[json.RawMessage]   // every sample, kept for -csv-out, -summary and -max-alloc when set
+	historyPoll time.Duration                // how often the metrics source is checked for new samples
+	logs        *outputBuffer                // the target's recent stdout and stderr, served at /logs
+	status      *runStatus                   // whether the target is running and how it exited, served at /status
+	feed        *sampleFeed                  // new samples are pushed to /metrics/stream clients through this
+	staticDir   string                       // the dashboard page is served from here instead of peep itself, if set
+	log         *logger                      // prints the server's messages
+
+	finalMetrics atomic.Pointer[[]byte] // set once the target exits when metrics are archived
+}
+
+// cancelWaitDelay is how long a cancelled target may take to exit before it is killed
+const cancelWaitDelay = 5 * time.Second
+
+// randomSuffix returns a short random hex string
+func randomSuffix() string {
+	var randBytes [4]byte
+	rand.Read(randBytes[:])
+	return hex.EncodeToString(randBytes[:])
+}
+
+// varSuffix draws the suffixes of generated variable names; tests replace it
+// to force collisions
+var varSuffix = randomSuffix
+
+// issuedVarSuffixes records every suffix generateUniqueVars has returned
+var (
+	issuedVarSuffixesMu sync.Mutex
+	issuedVarSuffixes   = map[string]bool{}
+)
+
+// generateUniqueVars creates unique variable names to avoid conflicts. No two
+// calls in a run return the same names: a suffix drawn before is drawn again.
+func generateUniqueVars() (string, string) {
+	issuedVarSuffixesMu.Lock()
+	defer issuedVarSuffixesMu.Unlock()
+
+	suffix := varSuffix()
+	for issuedVarSuffixes[suffix] {
+		suffix = varSuffix()
+	}
+	issuedVarSuffixes[suffix] = true
+	return "f_" + suffix, "err_" + suffix
+}
+
+// hasMainFunction checks if the AST contains a main function
+func hasMainFunction(node *ast.File) bool {
+	var found bool
+	ast.Inspect(node, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FuncDecl)
+		if ok && fn.Name.Name == "main" && fn.Recv == nil {
+			found = true
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// addImportIfMissing adds an import to the AST if it's not already present
+func addImportIfMissing(fset *token.FileSet, node *ast.File, pkg string) {
+	for _, imp := range node.Imports {
+		if imp.Path.Value == fmt.Sprintf("\"%s\"", pkg) {
+			return
+		}
+	}
+	astutil.AddImport(fset, node, pkg)
+}
+
+// createCPUProfilingStmts creates AST statements for CPU profiling setup. If
+// the profile file cannot be created, the program logs a warning and runs
+// without the CPU profile instead of exiting before main's body. A
+// positive warmup starts the profile that long after main starts instead,
+// from a goroutine, so main goes on meanwhile.
+func createCPUProfilingStmts(cpuFile, cpuFileVar, cpuErrVar string, warmup time.Duration) []ast.Stmt {
+	// pprof.StartCPUProfile(cpuFile)
+	var start ast.Stmt = &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("pprof"),
+				Sel: ast.NewIdent("StartCPUProfile"),
+			},
+			Args: []ast.Expr{ast.NewIdent(cpuFileVar)},
+		},
+	}
+	if warmup > 0 {
+		// go func() { time.Sleep(warmup); pprof.StartCPUProfile(cpuFile) }()
+		start = &ast.GoStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.FuncLit{
+					Type: &ast.FuncType{Params: &ast.FieldList{}},
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.ExprStmt{
+								X: &ast.CallExpr{
+									Fun: &ast.SelectorExpr{
+										X:   ast.NewIdent("time"),
+										Sel: ast.NewIdent("Sleep"),
+									},
+									Args: []ast.Expr{
+										&ast.CallExpr{
+											Fun: &ast.SelectorExpr{
+												X:   ast.NewIdent("time"),
+												Sel: ast.NewIdent("Duration"),
+											},
+											Args: []ast.Expr{
+												&ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(int64(warmup), 10)},
+											},
+										},
+									},
+								},
+							},
+							start,
+						},
+					},
+				},
+			},
+		}
+	}
+
+	return []ast.Stmt{
+		// cpuFile, cpuErr := os.Create("cpu.prof")
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{
+				ast.NewIdent(cpuFileVar),
+				ast.NewIdent(cpuErrVar),
+			},
+			Tok: token.DEFINE,
+			Rhs: profileOpenExprs(cpuFile),
+		},
+		// if cpuErr != nil { log.Printf(...) } else { pprof.StartCPUProfile(cpuFile) }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  ast.NewIdent(cpuErrVar),
+				Op: token.NEQ,
+				Y:  ast.NewIdent("nil"),
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{createProfileWarningStmt("CPU", cpuErrVar)},
+			},
+			Else: &ast.BlockStmt{List: []ast.Stmt{start}},
+		},
+		// defer pprof.StopCPUProfile(), which does nothing if profiling never started
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("pprof"),
+					Sel: ast.NewIdent("StopCPUProfile"),
+				},
+			},
+		},
+	}
+}
+
+// createProfileWarningStmt creates the warning the instrumented program logs
+// when it cannot create the file for a profile and runs without it:
+//
+//	log.Printf("[prof] Warning: running without the CPU profile: %v", err)
+func createProfileWarningStmt(profile, errVar string) ast.Stmt {
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("log"),
+				Sel: ast.NewIdent("Printf"),
+			},
+			Args: []ast.Expr{
+				&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("[prof] Warning: running without the " + profile + " profile: %v")},
+				ast.NewIdent(errVar),
+			},
+		},
+	}
+}
+
+// createCPURateStmt creates runtime.SetCPUProfileRate(hz). Called before
+// pprof.StartCPUProfile, the rate sticks, although the runtime then warns
+// that it cannot be set while profiling starts.
+func createCPURateStmt(hz int) ast.Stmt {
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("runtime"),
+				Sel: ast.NewIdent("SetCPUProfileRate"),
+			},
+			Args: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(hz)}},
+		},
+	}
+}
+
+// createCPUDurationStmt creates time.AfterFunc(d, stop), ending the CPU
+// profile d after it starts while the rest of the program keeps running.
+// The stop function must be safe to call again when main returns, as both
+// pprof.StopCPUProfile and the stop function of -cpu-continuous are.
+func createCPUDurationStmt(d time.Duration, stop ast.Expr) ast.Stmt {
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("time"),
+				Sel: ast.NewIdent("AfterFunc"),
+			},
+			Args: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("time"),
+						Sel: ast.NewIdent("Duration"),
+					},
+					Args: []ast.Expr{
+						&ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(int64(d), 10)},
+					},
+				},
+				stop,
+			},
+		},
+	}
+}
+
+// stopCPUProfileExpr refers to pprof.StopCPUProfile
+func stopCPUProfileExpr() ast.Expr {
+	return &ast.SelectorExpr{X: ast.NewIdent("pprof"), Sel: ast.NewIdent("StopCPUProfile")}
+}
+
+// createContinuousCPUDecls creates package-level declarations that start CPU
+// profiling in init, before main runs, and expose a stop function guarded by
+// sync.Once. The profile is flushed by whichever comes first: the stop function
+// (deferred in main) or SIGINT/SIGTERM, after which the signal is re-raised. If
+// the profile file cannot be created, init warns and the stop function does nothing.
+func createContinuousCPUDecls(cpuFile, stopVar string) []ast.Decl {
+	return []ast.Decl{
+		// var peepStopCPU func()
+		&ast.GenDecl{
+			Tok: token.VAR,
+			Specs: []ast.Spec{
+				&ast.ValueSpec{
+					Names: []*ast.Ident{ast.NewIdent(stopVar)},
+					Type:  &ast.FuncType{Params: &ast.FieldList{}},
+				},
+			},
+		},
+		// func init() { ... }
+		&ast.FuncDecl{
+			Name: ast.NewIdent("init"),
+			Type: &ast.FuncType{Params: &ast.FieldList{}},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					// f, err := os.Create("cpu.prof")
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("f"), ast.NewIdent("err")},
+						Tok: token.DEFINE,
+						Rhs: profileOpenExprs(cpuFile),
+					},
+					// if err != nil { log.Printf(...); peepStopCPU = func() {}; return }
+					&ast.IfStmt{
+						Cond: &ast.BinaryExpr{
+							X:  ast.NewIdent("err"),
+							Op: token.NEQ,
+							Y:  ast.NewIdent("nil"),
+						},
+						Body: &ast.BlockStmt{
+							List: []ast.Stmt{
+								createProfileWarningStmt("CPU", "err"),
+								// main defers the stop function, which must not be nil
+								&ast.AssignStmt{
+									Lhs: []ast.Expr{ast.NewIdent(stopVar)},
+									Tok: token.ASSIGN,
+									Rhs: []ast.Expr{&ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{}}},
+								},
+								&ast.ReturnStmt{},
+							},
+						},
+					},
+					// pprof.StartCPUProfile(f)
+					&ast.ExprStmt{
+						X: &ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent("pprof"),
+								Sel: ast.NewIdent("StartCPUProfile"),
+							},
+							Args: []ast.Expr{ast.NewIdent("f")},
+						},
+					},
+					// var once sync.Once
+					&ast.DeclStmt{
+						Decl: &ast.GenDecl{
+							Tok: token.VAR,
+							Specs: []ast.Spec{
+								&ast.ValueSpec{
+									Names: []*ast.Ident{ast.NewIdent("once")},
+									Type: &ast.SelectorExpr{
+										X:   ast.NewIdent("sync"),
+										Sel: ast.NewIdent("Once"),
+									},
+								},
+							},
+						},
+					},
+					// peepStopCPU = func() { once.Do(func() { pprof.StopCPUProfile(); f.Close() }) }
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent(stopVar)},
+						Tok: token.ASSIGN,
+						Rhs: []ast.Expr{
+							&ast.FuncLit{
+								Type: &ast.FuncType{},
+								Body: &ast.BlockStmt{
+									List: []ast.Stmt{
+										&ast.ExprStmt{
+											X: &ast.CallExpr{
+												Fun: &ast.SelectorExpr{
+													X:   ast.NewIdent("once"),
+													Sel: ast.NewIdent("Do"),
+												},
+												Args: []ast.Expr{
+													&ast.FuncLit{
+														Type: &ast.FuncType{},
+														Body: &ast.BlockStmt{
+															List: []ast.Stmt{
+																&ast.ExprStmt{
+																	X: &ast.CallExpr{
+																		Fun: &ast.SelectorExpr{
+																			X:   ast.NewIdent("pprof"),
+																			Sel: ast.NewIdent("StopCPUProfile"),
+																		},
+																	},
+																},
+																&ast.ExprStmt{
+																	X: &ast.CallExpr{
+																		Fun: &ast.SelectorExpr{
+																			X:   ast.NewIdent("f"),
+																			Sel: ast.NewIdent("Close"),
+																		},
+																	},
+																},
+															},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+					// go func() { ... }()
+					&ast.GoStmt{
+						Call: &ast.CallExpr{
+							Fun: &ast.FuncLit{
+								Type: &ast.FuncType{},
+								Body: &ast.BlockStmt{
+									List: []ast.Stmt{
+										// c := make(chan os.Signal, 1)
+										&ast.AssignStmt{
+											Lhs: []ast.Expr{ast.NewIdent("c")},
+											Tok: token.DEFINE,
+											Rhs: []ast.Expr{
+												&ast.CallExpr{
+													Fun: ast.NewIdent("make"),
+													Args: []ast.Expr{
+														&ast.ChanType{
+															Dir: ast.SEND | ast.RECV,
+															Value: &ast.SelectorExpr{
+																X:   ast.NewIdent("os"),
+																Sel: ast.NewIdent("Signal"),
+															},
+														},
+														&ast.BasicLit{Kind: token.INT, Value: "1"},
+													},
+												},
+											},
+										},
+										// signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+										&ast.ExprStmt{
+											X: &ast.CallExpr{
+												Fun: &ast.SelectorExpr{
+													X:   ast.NewIdent("signal"),
+													Sel: ast.NewIdent("Notify"),
+												},
+												Args: []ast.Expr{
+													ast.NewIdent("c"),
+													&ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("Interrupt")},
+													&ast.SelectorExpr{X: ast.NewIdent("syscall"), Sel: ast.NewIdent("SIGTERM")},
+												},
+											},
+										},
+										// sig := <-c
+										&ast.AssignStmt{
+											Lhs: []ast.Expr{ast.NewIdent("sig")},
+											Tok: token.DEFINE,
+											Rhs: []ast.Expr{
+												&ast.UnaryExpr{Op: token.ARROW, X: ast.NewIdent("c")},
+											},
+										},
+										// peepStopCPU()
+										&ast.ExprStmt{
+											X: &ast.CallExpr{Fun: ast.NewIdent(stopVar)},
+										},
+										// signal.Stop(c)
+										&ast.ExprStmt{
+											X: &ast.CallExpr{
+												Fun: &ast.SelectorExpr{
+													X:   ast.NewIdent("signal"),
+													Sel: ast.NewIdent("Stop"),
+												},
+												Args: []ast.Expr{ast.NewIdent("c")},
+											},
+										},
+										// Re-raise so the program's own handling (or the default exit) still happens
+										// if p, err := os.FindProcess(os.Getpid()); err != nil || p.Signal(sig) != nil { os.Exit(1) }
+										&ast.IfStmt{
+											Init: &ast.AssignStmt{
+												Lhs: []ast.Expr{ast.NewIdent("p"), ast.NewIdent("err")},
+												Tok: token.DEFINE,
+												Rhs: []ast.Expr{
+													&ast.CallExpr{
+														Fun: &ast.SelectorExpr{
+															X:   ast.NewIdent("os"),
+															Sel: ast.NewIdent("FindProcess"),
+														},
+														Args: []ast.Expr{
+															&ast.CallExpr{
+																Fun: &ast.SelectorExpr{
+																	X:   ast.NewIdent("os"),
+																	Sel: ast.NewIdent("Getpid"),
+																},
+															},
+														},
+													},
+												},
+											},
+											Cond: &ast.BinaryExpr{
+												X: &ast.BinaryExpr{
+													X:  ast.NewIdent("err"),
+													Op: token.NEQ,
+													Y:  ast.NewIdent("nil"),
+												},
+												Op: token.LOR,
+												Y: &ast.BinaryExpr{
+													X: &ast.CallExpr{
+														Fun: &ast.SelectorExpr{
+															X:   ast.NewIdent("p"),
+															Sel: ast.NewIdent("Signal"),
+														},
+														Args: []ast.Expr{ast.NewIdent("sig")},
+													},
+													Op: token.NEQ,
+													Y:  ast.NewIdent("nil"),
+												},
+											},
+											Body: &ast.BlockStmt{
+												List: []ast.Stmt{
+													&ast.ExprStmt{
+														X: &ast.CallExpr{
+															Fun: &ast.SelectorExpr{
+																X:   ast.NewIdent("os"),
+																Sel: ast.NewIdent("Exit"),
+															},
+															Args: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "1"}},
+														},
+													},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// postInitHeapPath derives the post-init heap profile path from the memory
+// profile path, e.g. mem.prof becomes mem_postinit.prof
+func postInitHeapPath(memFile string) string {
+	ext := filepath.Ext(memFile)
+	return strings.TrimSuffix(memFile, ext) + "_postinit" + ext
+}
+
+// createPostInitHeapStmts creates AST statements that write a heap profile
+// before any of main's own code runs, capturing what package initialization
+// allocated. It is skipped with a warning if the file cannot be created.
+func createPostInitHeapStmts(heapFile, heapFileVar, heapErrVar string) []ast.Stmt {
+	return []ast.Stmt{
+		// heapFile, heapErr := os.Create("mem_postinit.prof")
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{
+				ast.NewIdent(heapFileVar),
+				ast.NewIdent(heapErrVar),
+			},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("os"),
+						Sel: ast.NewIdent("Create"),
+					},
+					Args: []ast.Expr{
+						&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(heapFile)},
+					},
+				},
+			},
+		},
+		// if heapErr != nil { log.Printf(...) } else { runtime.GC(); pprof.WriteHeapProfile(heapFile); heapFile.Close() }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  ast.NewIdent(heapErrVar),
+				Op: token.NEQ,
+				Y:  ast.NewIdent("nil"),
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{createProfileWarningStmt("post-init heap", heapErrVar)},
+			},
+			Else: &ast.BlockStmt{
+				List: []ast.Stmt{
+					// runtime.GC() so the profile includes all allocations made so far
+					&ast.ExprStmt{
+						X: &ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent("runtime"),
+								Sel: ast.NewIdent("GC"),
+							},
+						},
+					},
+					// pprof.WriteHeapProfile(heapFile)
+					&ast.ExprStmt{
+						X: &ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent("pprof"),
+								Sel: ast.NewIdent("WriteHeapProfile"),
+							},
+							Args: []ast.Expr{ast.NewIdent(heapFileVar)},
+						},
+					},
+					// heapFile.Close()
+					&ast.ExprStmt{
+						X: &ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent(heapFileVar),
+								Sel: ast.NewIdent("Close"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createMemoryProfilingStmts creates AST statements for memory profiling setup.
+// Like the CPU profile, it is skipped with a warning if its file cannot be created.
+func createMemoryProfilingStmts(memFile, memFileVar, memErrVar string) []ast.Stmt {
+	return []ast.Stmt{
+		// memFile, memErr := os.Create("mem.prof")
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{
+				ast.NewIdent(memFileVar),
+				ast.NewIdent(memErrVar),
+			},
+			Tok: token.DEFINE,
+			Rhs: profileOpenExprs(memFile),
+		},
+		// if memErr != nil { log.Printf(...) }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  ast.NewIdent(memErrVar),
+				Op: token.NEQ,
+				Y:  ast.NewIdent("nil"),
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{createProfileWarningStmt("memory", memErrVar)},
+			},
+		},
+		// defer func() { if memErr == nil { pprof.WriteHeapProfile(memFile); memFile.Close() } }()
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.FuncLit{
+					Type: &ast.FuncType{},
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.IfStmt{
+								Cond: &ast.BinaryExpr{
+									X:  ast.NewIdent(memErrVar),
+									Op: token.EQL,
+									Y:  ast.NewIdent("nil"),
+								},
+								Body: &ast.BlockStmt{
+									List: []ast.Stmt{
+										&ast.ExprStmt{
+											X: &ast.CallExpr{
+												Fun: &ast.SelectorExpr{
+													X:   ast.NewIdent("pprof"),
+													Sel: ast.NewIdent("WriteHeapProfile"),
+												},
+												Args: []ast.Expr{ast.NewIdent(memFileVar)},
+											},
+										},
+										&ast.ExprStmt{
+											X: &ast.CallExpr{
+												Fun: &ast.SelectorExpr{
+													X:   ast.NewIdent(memFileVar),
+													Sel: ast.NewIdent("Close"),
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createMutexProfilingStmts creates AST statements that sample one in rate
+// mutex contention events and write the mutex profile when main returns
+func createMutexProfilingStmts(mutexFile, mutexFileVar, mutexErrVar string, rate int) []ast.Stmt {
+	return []ast.Stmt{
+		// runtime.SetMutexProfileFraction(rate)
+		&ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("runtime"),
+					Sel: ast.NewIdent("SetMutexProfileFraction"),
+				},
+				Args: []ast.Expr{
+					&ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(rate)},
+				},
+			},
+		},
+		// mutexFile, mutexErr := os.Create("mutex.prof")
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{
+				ast.NewIdent(mutexFileVar),
+				ast.NewIdent(mutexErrVar),
+			},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("os"),
+						Sel: ast.NewIdent("Create"),
+					},
+					Args: []ast.Expr{
+						&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(mutexFile)},
+					},
+				},
+			},
+		},
+		// if mutexErr != nil { log.Printf(...) }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  ast.NewIdent(mutexErrVar),
+				Op: token.NEQ,
+				Y:  ast.NewIdent("nil"),
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{createProfileWarningStmt("mutex", mutexErrVar)},
+			},
+		},
+		// defer func() { if mutexErr == nil { pprof.Lookup("mutex").WriteTo(mutexFile, 0); mutexFile.Close() } }()
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.FuncLit{
+					Type: &ast.FuncType{},
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.IfStmt{
+								Cond: &ast.BinaryExpr{
+									X:  ast.NewIdent(mutexErrVar),
+									Op: token.EQL,
+									Y:  ast.NewIdent("nil"),
+								},
+								Body: &ast.BlockStmt{
+									List: []ast.Stmt{
+										&ast.ExprStmt{
+											X: &ast.CallExpr{
+												Fun: &ast.SelectorExpr{
+													X: &ast.CallExpr{
+														Fun: &ast.SelectorExpr{
+															X:   ast.NewIdent("pprof"),
+															Sel: ast.NewIdent("Lookup"),
+														},
+														Args: []ast.Expr{
+															&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("mutex")},
+														},
+													},
+													Sel: ast.NewIdent("WriteTo"),
+												},
+												Args: []ast.Expr{
+													ast.NewIdent(mutexFileVar),
+													&ast.BasicLit{Kind: token.INT, Value: "0"},
+												},
+											},
+										},
+										&ast.ExprStmt{
+											X: &ast.CallExpr{
+												Fun: &ast.SelectorExpr{
+													X:   ast.NewIdent(mutexFileVar),
+													Sel: ast.NewIdent("Close"),
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createLastPauseExpr creates m.PauseNs[(m.NumGC+255)%256], the pause of the
+// latest GC cycle in the MemStats circular buffer. The modulo keeps the index
+// in range whatever NumGC is: before the first cycle it reads the unused slot
+// 255, which is still 0.
+func createLastPauseExpr() ast.Expr {
+	return &ast.IndexExpr{
+		X: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("PauseNs")},
+		Index: &ast.BinaryExpr{
+			X: &ast.ParenExpr{
+				X: &ast.BinaryExpr{
+					X:  &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("NumGC")},
+					Op: token.ADD,
+					Y:  &ast.BasicLit{Kind: token.INT, Value: "255"},
+				},
+			},
+			Op: token.REM,
+			Y:  &ast.BasicLit{Kind: token.INT, Value: "256"},
+		},
+	}
+}
+
+// createMetricsCollectionStmts creates AST statements for metrics collection
+func createMetricsCollectionStmts(opts Options) []ast.Stmt {
+	timestampKey, timestampFunc := metricsTimestamp(opts)
+	lowPriority := opts.MetricsPriority == metricsPriorityLow
+	socket := opts.MetricsSocket != ""
+	sample := createMetricsSampleStmts(timestampKey, timestampFunc, opts.PerCore, lowPriority, socket)
+	if opts.MetricsDuration > 0 {
+		sample = append([]ast.Stmt{createMetricsDeadlineCheckStmt()}, sample...)
+	}
+	if opts.MemSnapshots > 0 {
+		sample = append(sample, createMemSnapshotStmt(opts.MemSnapshotPrefix, opts.MemSnapshots, opts.MaxSnapshots))
+	}
+
+	loop := createTickerLoopStmts(sample, metricsInterval(opts))
+	if opts.Adaptive {
+		loop = createAdaptiveLoopStmts(sample, metricsInterval(opts))
+	} else if lowPriority {
+		loop = createLowPriorityLoopStmts(sample)
+	}
+	if opts.MetricsDuration > 0 {
+		loop = append([]ast.Stmt{createMetricsDeadlineStmt(opts.MetricsDuration)}, loop...)
+	}
+	if opts.MemSnapshots > 0 {
+		loop = append(createMemSnapshotDeclStmts(), loop...)
+	}
+
+	var stmts []ast.Stmt
+	if socket {
+		stmts = createMetricsDialStmts(opts.MetricsSocket)
+	} else {
+		// metricsFile := "/tmp/peep_metrics_<hex>.json"
+		stmts = append(stmts, &ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("metricsFile")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.BasicLit{
+					Kind:  token.STRING,
+					Value: strconv.Quote(opts.MetricsFile),
+				},
+			},
+		})
+	}
+
+	// When archiving, peep keeps the file past exit and removes it itself
+	if !socket && opts.ArchiveMetricsFile == "" {
+		// defer os.Remove(metricsFile)
+		stmts = append(stmts, &ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("os"),
+					Sel: ast.NewIdent("Remove"),
+				},
+				Args: []ast.Expr{ast.NewIdent("metricsFile")},
+			},
+		})
+	}
+
+	if lowPriority {
+		return append(stmts, createLabeledGoStmt(loop))
+	}
+
+	// go func() { ... }()
+	return append(stmts, &ast.GoStmt{
+		Call: &ast.CallExpr{
+			Fun: &ast.FuncLit{
+				Type: &ast.FuncType{},
+				Body: &ast.BlockStmt{
+					List: loop,
+				},
+			},
+		},
+	})
+}
+
+// createMetricsDeadlineStmt creates metricsDeadline := time.Now().Add(d), the
+// time after which the collector stops sampling
+func createMetricsDeadlineStmt(d time.Duration) ast.Stmt {
+	return &ast.AssignStmt{
+		Lhs: []ast.Expr{ast.NewIdent("metricsDeadline")},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{
+			&ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X: &ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   ast.NewIdent("time"),
+							Sel: ast.NewIdent("Now"),
+						},
+					},
+					Sel: ast.NewIdent("Add"),
+				},
+				Args: []ast.Expr{
+					&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   ast.NewIdent("time"),
+							Sel: ast.NewIdent("Duration"),
+						},
+						Args: []ast.Expr{
+							&ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(int64(d), 10)},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createMetricsDeadlineCheckStmt creates if time.Now().After(metricsDeadline) { return },
+// ending the collector goroutine and with it its ticker
+func createMetricsDeadlineCheckStmt() ast.Stmt {
+	return &ast.IfStmt{
+		Cond: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X: &ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("time"),
+						Sel: ast.NewIdent("Now"),
+					},
+				},
+				Sel: ast.NewIdent("After"),
+			},
+			Args: []ast.Expr{ast.NewIdent("metricsDeadline")},
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{&ast.ReturnStmt{}},
+		},
+	}
+}
+
+// defaultMetricsInterval is how often metrics are sampled without -interval
+const defaultMetricsInterval = 500 * time.Millisecond
+
+// metricsStaleAfter is the shortest age at which the dashboard treats a
+// sample as stale. Longer intervals get a window of metricsStaleIntervals of
+// them, so a slow collector is not mistaken for a stopped one.
+const (
+	metricsStaleAfter     = 2 * time.Second
+	metricsStaleIntervals = 4
+)
+
+// metricsInterval returns how often the collector samples metrics for opts
+func metricsInterval(opts Options) time.Duration {
+	if opts.Interval > 0 {
+		return opts.Interval
+	}
+	return defaultMetricsInterval
+}
+
+// createTickerLoopStmts creates a loop that takes a sample every interval
+func createTickerLoopStmts(sample []ast.Stmt, interval time.Duration) []ast.Stmt {
+	return []ast.Stmt{
+		// ticker := time.NewTicker(time.Duration(ns))
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("ticker")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("time"),
+						Sel: ast.NewIdent("NewTicker"),
+					},
+					Args: []ast.Expr{
+						&ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent("time"),
+								Sel: ast.NewIdent("Duration"),
+							},
+							Args: []ast.Expr{
+								&ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(int64(interval), 10)},
+							},
+						},
+					},
+				},
+			},
+		},
+		// defer ticker.Stop()
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("ticker"),
+					Sel: ast.NewIdent("Stop"),
+				},
+			},
+		},
+		// for range ticker.C { ... }
+		&ast.RangeStmt{
+			Key:   ast.NewIdent("_"),
+			Value: nil,
+			Tok:   token.ASSIGN,
+			X: &ast.SelectorExpr{
+				X:   ast.NewIdent("ticker"),
+				Sel: ast.NewIdent("C"),
+			},
+			Body: &ast.BlockStmt{
+				List: sample,
+			},
+		},
+	}
+}
+
+// Bounds for the adaptive sampling interval. The maximum stays below the
+// dashboard's 2 second staleness window so slow sampling never blanks it.
+const (
+	adaptiveMinIntervalMS = 100
+	adaptiveMaxIntervalMS = 1500
+)
+
+// millisecondsExpr creates the expression n * time.Millisecond
+func millisecondsExpr(n int) ast.Expr {
+	return &ast.BinaryExpr{
+		X:  &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(n)},
+		Op: token.MUL,
+		Y: &ast.SelectorExpr{
+			X:   ast.NewIdent("time"),
+			Sel: ast.NewIdent("Millisecond"),
+		},
+	}
+}
+
+// clampIntervalStmt creates: if interval <op> bound { interval = bound }
+func clampIntervalStmt(op token.Token, boundMS int) ast.Stmt {
+	return &ast.IfStmt{
+		Cond: &ast.BinaryExpr{
+			X:  ast.NewIdent("interval"),
+			Op: op,
+			Y:  millisecondsExpr(boundMS),
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("interval")},
+					Tok: token.ASSIGN,
+					Rhs: []ast.Expr{millisecondsExpr(boundMS)},
+				},
+			},
+		},
+	}
+}
+
+// createAdaptiveLoopStmts creates a loop that halves the sampling interval when
+// Alloc moved by more than 10% since the previous sample and doubles it otherwise,
+// keeping it between adaptiveMinIntervalMS and adaptiveMaxIntervalMS. The
+// interval starts at start, clamped to the same bounds.
+func createAdaptiveLoopStmts(sample []ast.Stmt, start time.Duration) []ast.Stmt {
+	startMS := min(max(int(start/time.Millisecond), adaptiveMinIntervalMS), adaptiveMaxIntervalMS)
+
+	mAlloc := func() ast.Expr {
+		return &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Alloc")}
+	}
+
+	body := []ast.Stmt{
+		// time.Sleep(interval)
+		&ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("time"),
+					Sel: ast.NewIdent("Sleep"),
+				},
+				Args: []ast.Expr{ast.NewIdent("interval")},
+			},
+		},
+	}
+	body = append(body, sample...)
+	body = append(body,
+		// delta := m.Alloc - lastAlloc
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("delta")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.BinaryExpr{X: mAlloc(), Op: token.SUB, Y: ast.NewIdent("lastAlloc")},
+			},
+		},
+		// if lastAlloc > m.Alloc { delta = lastAlloc - m.Alloc }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{X: ast.NewIdent("lastAlloc"), Op: token.GTR, Y: mAlloc()},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("delta")},
+						Tok: token.ASSIGN,
+						Rhs: []ast.Expr{
+							&ast.BinaryExpr{X: ast.NewIdent("lastAlloc"), Op: token.SUB, Y: mAlloc()},
+						},
+					},
+				},
+			},
+		},
+		// if delta*10 > lastAlloc { interval /= 2 } else { interval *= 2 }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X: &ast.BinaryExpr{
+					X:  ast.NewIdent("delta"),
+					Op: token.MUL,
+					Y:  &ast.BasicLit{Kind: token.INT, Value: "10"},
+				},
+				Op: token.GTR,
+				Y:  ast.NewIdent("lastAlloc"),
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("interval")},
+						Tok: token.QUO_ASSIGN,
+						Rhs: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "2"}},
+					},
+				},
+			},
+			Else: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("interval")},
+						Tok: token.MUL_ASSIGN,
+						Rhs: []ast.Expr{&ast.BasicLit{Kind: token.INT, Value: "2"}},
+					},
+				},
+			},
+		},
+		clampIntervalStmt(token.LSS, adaptiveMinIntervalMS),
+		clampIntervalStmt(token.GTR, adaptiveMaxIntervalMS),
+		// lastAlloc = m.Alloc
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("lastAlloc")},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{mAlloc()},
+		},
+	)
+
+	return []ast.Stmt{
+		// interval := startMS * time.Millisecond
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("interval")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{millisecondsExpr(startMS)},
+		},
+		// var lastAlloc uint64
+		&ast.DeclStmt{
+			Decl: &ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{
+					&ast.ValueSpec{
+						Names: []*ast.Ident{ast.NewIdent("lastAlloc")},
+						Type:  ast.NewIdent("uint64"),
+					},
+				},
+			},
+		},
+		// for { ... }
+		&ast.ForStmt{
+			Body: &ast.BlockStmt{List: body},
+		},
+	}
+}
+
+// metricsTempSuffix names the file a metrics sample is written to before it
+// replaces the metrics file
+const metricsTempSuffix = ".tmp"
+
+// createMetricsFileWriteStmts creates AST statements that replace the metrics
+// file with data. The sample is written next to it first and renamed over it,
+// which is atomic on the same filesystem, so peep never reads a truncated or
+// half-written sample:
+//
+//	os.WriteFile(metricsFile+".tmp", data, 0644)
+//	os.Rename(metricsFile+".tmp", metricsFile)
+func createMetricsFileWriteStmts() []ast.Stmt {
+	tempFile := func() ast.Expr {
+		return &ast.BinaryExpr{
+			X:  ast.NewIdent("metricsFile"),
+			Op: token.ADD,
+			Y:  &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(metricsTempSuffix)},
+		}
+	}
+	return []ast.Stmt{
+		&ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("os"),
+					Sel: ast.NewIdent("WriteFile"),
+				},
+				Args: []ast.Expr{
+					tempFile(),
+					ast.NewIdent("data"),
+					&ast.BasicLit{Kind: token.INT, Value: "0644"},
+				},
+			},
+		},
+		&ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("os"),
+					Sel: ast.NewIdent("Rename"),
+				},
+				Args: []ast.Expr{tempFile(), ast.NewIdent("metricsFile")},
+			},
+		},
+	}
+}
+
+// createMetricsSampleStmts creates AST statements that read one metrics sample
+// and write it to the metrics file, or to the metrics socket when socket is set
+func createMetricsSampleStmts(timestampKey, timestampFunc string, perCore, lowPriority, socket bool) []ast.Stmt {
+	coresVar := "_"
+	if perCore {
+		coresVar = "cpuCores"
+	}
+
+	stmts := []ast.Stmt{
+		// var m runtime.MemStats
+		&ast.DeclStmt{
+			Decl: &ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{
+					&ast.ValueSpec{
+						Names: []*ast.Ident{ast.NewIdent("m")},
+						Type: &ast.SelectorExpr{
+							X:   ast.NewIdent("runtime"),
+							Sel: ast.NewIdent("MemStats"),
+						},
+					},
+				},
+			},
+		},
+		createMemStatsReadStmt(lowPriority),
+		// cpuVal, cpuCores := peepCPUSample()
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("cpuVal"), ast.NewIdent(coresVar)},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{Fun: ast.NewIdent(cpuHelperFunc)},
+			},
+		},
+		// metrics := map[string]interface{}{ ... }
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("metrics")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CompositeLit{
+					Type: &ast.MapType{
+						Key: ast.NewIdent("string"),
+						Value: &ast.InterfaceType{
+							Methods: &ast.FieldList{},
+						},
+					},
+					Elts: []ast.Expr{
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"alloc"`},
+							Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Alloc")},
+						},
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"totalAlloc"`},
+							Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("TotalAlloc")},
+						},
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"sys"`},
+							Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Sys")},
+						},
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"numGC"`},
+							Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("NumGC")},
+						},
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"pauseTotal"`},
+							Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("PauseTotalNs")},
+						},
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"gcCpuFraction"`},
+							Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("GCCPUFraction")},
+						},
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"lastPauseNs"`},
+							Value: createLastPauseExpr(),
+						},
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"heapInuse"`},
+							Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("HeapInuse")},
+						},
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"heapObjects"`},
+							Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("HeapObjects")},
+						},
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"mallocs"`},
+							Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Mallocs")},
+						},
+						&ast.KeyValueExpr{
+							Key:   &ast.BasicLit{Kind: token.STRING, Value: `"frees"`},
+							Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Frees")},
+						},
+						&ast.KeyValueExpr{
+							Key: &ast.BasicLit{Kind: token.STRING, Value: `"cpuPercent"`},
+							Value: &ast.CallExpr{
+								Fun:  ast.NewIdent(cpuFieldHelperFunc),
+								Args: []ast.Expr{ast.NewIdent("cpuVal")},
+							},
+						},
+						&ast.KeyValueExpr{
+							Key: &ast.BasicLit{Kind: token.STRING, Value: `"goroutines"`},
+							Value: &ast.CallExpr{
+								Fun: &ast.SelectorExpr{
+									X:   ast.NewIdent("runtime"),
+									Sel: ast.NewIdent("NumGoroutine"),
+								},
+							},
+						},
+						// OS threads created by the runtime, which matters for
+						// programs that lock threads (CGo, OpenGL)
+						&ast.KeyValueExpr{
+							Key: &ast.BasicLit{Kind: token.STRING, Value: `"threads"`},
+							Value: &ast.CallExpr{
+								Fun: &ast.SelectorExpr{
+									X: &ast.CallExpr{
+										Fun: &ast.SelectorExpr{
+											X:   ast.NewIdent("pprof"),
+											Sel: ast.NewIdent("Lookup"),
+										},
+										Args: []ast.Expr{
+											&ast.BasicLit{Kind: token.STRING, Value: `"threadcreate"`},
+										},
+									},
+									Sel: ast.NewIdent("Count"),
+								},
+							},
+						},
+						&ast.KeyValueExpr{
+							Key: &ast.BasicLit{Kind: token.STRING, Value: timestampKey},
+							Value: &ast.CallExpr{
+								Fun: &ast.SelectorExpr{
+									X: &ast.CallExpr{
+										Fun: &ast.SelectorExpr{
+											X:   ast.NewIdent("time"),
+											Sel: ast.NewIdent("Now"),
+										},
+									},
+									Sel: ast.NewIdent(timestampFunc),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		// data, _ := json.Marshal(metrics)
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("data"), ast.NewIdent("_")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("json"),
+						Sel: ast.NewIdent("Marshal"),
+					},
+					Args: []ast.Expr{ast.NewIdent("metrics")},
+				},
+			},
+		},
+	}
+
+	if socket {
+		stmts = append(stmts, createMetricsSocketWriteStmt())
+	} else {
+		stmts = append(stmts, createMetricsFileWriteStmts()...)
+	}
+
+	if perCore {
+		// metrics["cpuPerCore"] = cpuCores, right after the metrics map is built
+		stmts = slices.Insert(stmts, 4, ast.Stmt(&ast.AssignStmt{
+			Lhs: []ast.Expr{
+				&ast.IndexExpr{
+					X:     ast.NewIdent("metrics"),
+					Index: &ast.BasicLit{Kind: token.STRING, Value: `"cpuPerCore"`},
+				},
+			},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{ast.NewIdent("cpuCores")},
+		}))
+	}
+	return stmts
+}
+
+// peakAllocVar derives the name of the peak Alloc tracker from the generated
+// CPU file variable
+func peakAllocVar(cpuFileVar string) string {
+	return "peak" + cpuFileVar
+}
+
+// collectsMetrics reports whether the target is instrumented with the metrics
+// collector, which feeds the dashboard, the end-of-run summary, -max-alloc
+// and MetricsChan
+func collectsMetrics(opts Options) bool {
+	return opts.EnableWeb || opts.Summary || opts.MaxAlloc > 0 || opts.MetricsChan != nil
+}
+
+// tracksPeakAlloc reports whether the final snapshot needs the peak Alloc,
+// which MemStats does not record and so has to be sampled during the run
+func tracksPeakAlloc(opts Options) bool {
+	return opts.BaselineFile != "" || opts.SaveBaselineFile != ""
+}
+
+// createPeakAllocStmts creates AST statements that sample Alloc every 100ms
+// and keep the highest value seen in peakVar
+func createPeakAllocStmts(peakVar string) []ast.Stmt {
+	return []ast.Stmt{
+		// var peakVar atomic.Uint64
+		&ast.DeclStmt{
+			Decl: &ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{
+					&ast.ValueSpec{
+						Names: []*ast.Ident{ast.NewIdent(peakVar)},
+						Type: &ast.SelectorExpr{
+							X:   ast.NewIdent("atomic"),
+							Sel: ast.NewIdent("Uint64"),
+						},
+					},
+				},
+			},
+		},
+		// go func() { for { ... } }()
+		&ast.GoStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.FuncLit{
+					Type: &ast.FuncType{},
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.ForStmt{
+								Body: &ast.BlockStmt{
+									List: []ast.Stmt{
+										// var m runtime.MemStats
+										&ast.DeclStmt{
+											Decl: &ast.GenDecl{
+												Tok: token.VAR,
+												Specs: []ast.Spec{
+													&ast.ValueSpec{
+														Names: []*ast.Ident{ast.NewIdent("m")},
+														Type: &ast.SelectorExpr{
+															X:   ast.NewIdent("runtime"),
+															Sel: ast.NewIdent("MemStats"),
+														},
+													},
+												},
+											},
+										},
+										// runtime.ReadMemStats(&m)
+										&ast.ExprStmt{
+											X: &ast.CallExpr{
+												Fun: &ast.SelectorExpr{
+													X:   ast.NewIdent("runtime"),
+													Sel: ast.NewIdent("ReadMemStats"),
+												},
+												Args: []ast.Expr{
+													&ast.UnaryExpr{
+														Op: token.AND,
+														X:  ast.NewIdent("m"),
+													},
+												},
+											},
+										},
+										// if m.Alloc > peakVar.Load() { peakVar.Store(m.Alloc) }
+										&ast.IfStmt{
+											Cond: &ast.BinaryExpr{
+												X:  &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Alloc")},
+												Op: token.GTR,
+												Y: &ast.CallExpr{
+													Fun: &ast.SelectorExpr{
+														X:   ast.NewIdent(peakVar),
+														Sel: ast.NewIdent("Load"),
+													},
+												},
+											},
+											Body: &ast.BlockStmt{
+												List: []ast.Stmt{
+													&ast.ExprStmt{
+														X: &ast.CallExpr{
+															Fun: &ast.SelectorExpr{
+																X:   ast.NewIdent(peakVar),
+																Sel: ast.NewIdent("Store"),
+															},
+															Args: []ast.Expr{
+																&ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Alloc")},
+															},
+														},
+													},
+												},
+											},
+										},
+										// time.Sleep(100 * time.Millisecond)
+										&ast.ExprStmt{
+											X: &ast.CallExpr{
+												Fun: &ast.SelectorExpr{
+													X:   ast.NewIdent("time"),
+													Sel: ast.NewIdent("Sleep"),
+												},
+												Args: []ast.Expr{millisecondsExpr(100)},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// peakAllocSnapshotStmts creates the final snapshot statements that combine the
+// sampled peak with the Alloc read at return into peakAlloc
+func peakAllocSnapshotStmts(peakVar string) []ast.Stmt {
+	return []ast.Stmt{
+		// peakAlloc := peakVar.Load()
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("peakAlloc")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent(peakVar),
+						Sel: ast.NewIdent("Load"),
+					},
+				},
+			},
+		},
+		// if m.Alloc > peakAlloc { peakAlloc = m.Alloc }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Alloc")},
+				Op: token.GTR,
+				Y:  ast.NewIdent("peakAlloc"),
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("peakAlloc")},
+						Tok: token.ASSIGN,
+						Rhs: []ast.Expr{&ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Alloc")}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createFinalSnapshotStmts creates AST statements that record end-of-run metrics.
+// When peakVar is set the snapshot also carries the peak Alloc it tracked.
+func createFinalSnapshotStmts(snapshotFile, peakVar string) []ast.Stmt {
+	fields := []ast.Expr{
+		&ast.KeyValueExpr{
+			Key:   &ast.BasicLit{Kind: token.STRING, Value: `"alloc"`},
+			Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Alloc")},
+		},
+		&ast.KeyValueExpr{
+			Key:   &ast.BasicLit{Kind: token.STRING, Value: `"totalAlloc"`},
+			Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("TotalAlloc")},
+		},
+		&ast.KeyValueExpr{
+			Key:   &ast.BasicLit{Kind: token.STRING, Value: `"sys"`},
+			Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("Sys")},
+		},
+		&ast.KeyValueExpr{
+			Key:   &ast.BasicLit{Kind: token.STRING, Value: `"numGC"`},
+			Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("NumGC")},
+		},
+		&ast.KeyValueExpr{
+			Key:   &ast.BasicLit{Kind: token.STRING, Value: `"pauseTotal"`},
+			Value: &ast.SelectorExpr{X: ast.NewIdent("m"), Sel: ast.NewIdent("PauseTotalNs")},
+		},
+		&ast.KeyValueExpr{
+			Key: &ast.BasicLit{Kind: token.STRING, Value: `"goroutines"`},
+			Value: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("runtime"),
+					Sel: ast.NewIdent("NumGoroutine"),
+				},
+			},
+		},
+		&ast.KeyValueExpr{
+			Key: &ast.BasicLit{Kind: token.STRING, Value: `"timestampMs"`},
+			Value: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X: &ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   ast.NewIdent("time"),
+							Sel: ast.NewIdent("Now"),
+						},
+					},
+					Sel: ast.NewIdent("UnixMilli"),
+				},
+			},
+		},
+	}
+
+	body := []ast.Stmt{
+		// var m runtime.MemStats
+		&ast.DeclStmt{
+			Decl: &ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{
+					&ast.ValueSpec{
+						Names: []*ast.Ident{ast.NewIdent("m")},
+						Type: &ast.SelectorExpr{
+							X:   ast.NewIdent("runtime"),
+							Sel: ast.NewIdent("MemStats"),
+						},
+					},
+				},
+			},
+		},
+		// runtime.ReadMemStats(&m)
+		&ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("runtime"),
+					Sel: ast.NewIdent("ReadMemStats"),
+				},
+				Args: []ast.Expr{
+					&ast.UnaryExpr{
+						Op: token.AND,
+						X:  ast.NewIdent("m"),
+					},
+				},
+			},
+		},
+	}
+
+	if peakVar != "" {
+		body = append(body, peakAllocSnapshotStmts(peakVar)...)
+		fields = append(fields, &ast.KeyValueExpr{
+			Key:   &ast.BasicLit{Kind: token.STRING, Value: `"peakAlloc"`},
+			Value: ast.NewIdent("peakAlloc"),
+		})
+	}
+
+	body = append(body,
+		// snapshot := map[string]interface{}{ ... }
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("snapshot")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CompositeLit{
+					Type: &ast.MapType{
+						Key: ast.NewIdent("string"),
+						Value: &ast.InterfaceType{
+							Methods: &ast.FieldList{},
+						},
+					},
+					Elts: fields,
+				},
+			},
+		},
+		// data, _ := json.Marshal(snapshot)
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("data"), ast.NewIdent("_")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("json"),
+						Sel: ast.NewIdent("Marshal"),
+					},
+					Args: []ast.Expr{ast.NewIdent("snapshot")},
+				},
+			},
+		},
+		// os.WriteFile("peep_final.json", data, 0644)
+		&ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("os"),
+					Sel: ast.NewIdent("WriteFile"),
+				},
+				Args: []ast.Expr{
+					&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(snapshotFile)},
+					ast.NewIdent("data"),
+					&ast.BasicLit{Kind: token.INT, Value: "0644"},
+				},
+			},
+		},
+	)
+
+	return []ast.Stmt{
+		// defer func() { ... }()
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.FuncLit{
+					Type: &ast.FuncType{},
+					Body: &ast.BlockStmt{List: body},
+				},
+			},
+		},
+	}
+}
+
+// continuousStopVar derives the name of the continuous CPU profile's stop
+// function from the generated CPU file variable
+func continuousStopVar(cpuFileVar string) string {
+	return "stop" + cpuFileVar
+}
+
+// sourcePosPrinter prints instrumented files like gofmt, with //line directives
+// so that compiler errors and profile locations refer to the original source
+var sourcePosPrinter = printer.Config{Mode: printer.UseSpaces | printer.TabIndent | printer.SourcePos, Tabwidth: 8}
+
+// writeInstrumented writes node to w as the go command builds it: printed
+// with //line directives, then gofmt'ed, as the printer misindents the lines
+// that follow a directive. gofmt leaves the directives in place, and a
+// malformed AST fails here rather than in the build.
+func writeInstrumented(w io.Writer, fset *token.FileSet, node *ast.File) error {
+	var buf bytes.Buffer
+	if err := sourcePosPrinter.Fprint(&buf, fset, node); err != nil {
+		return fmt.Errorf("failed to print instrumented code: %w", err)
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to format instrumented code: %w", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// instrumentMainFunction injects profiling code into the main function, or
+// into the function named by opts.Func
+func instrumentMainFunction(node *ast.File, cpuFileVar, cpuErrVar, memFileVar, memErrVar string, opts Options) {
+	name := "main"
+	if opts.Func != "" {
+		name = opts.Func
+	}
+	ast.Inspect(node, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FuncDecl)
+		if ok && fn.Body != nil && funcDeclName(fn) == name {
+			var stmts []ast.Stmt
+
+			if opts.PostInitHeapFile != "" {
+				// Taken first so that none of the injected or user code has run yet
+				heapFileVar, heapErrVar := generateUniqueVars()
+				stmts = append(stmts, createPostInitHeapStmts(opts.PostInitHeapFile, heapFileVar, heapErrVar)...)
+			}
+
+			if opts.LivePprofAddr != "" {
+				stmts = append(stmts, createLivePprofStmts(opts.LivePprofAddr)...)
+			}
+
+			if opts.GoroutineInterval > 0 {
+				stmts = append(stmts, createGoroutineTickerStmt(opts.GoroutinePrefix, opts.GoroutineInterval))
+			}
+
+			if opts.TraceFile != "" {
+				traceFileVar, traceErrVar := generateUniqueVars()
+				stmts = append(stmts, createTraceStartStmts(opts.TraceFile, traceFileVar, traceErrVar)...)
+			}
+
+			if opts.FinalSnapshotFile != "" {
+				var peakVar string
+				if tracksPeakAlloc(opts) {
+					peakVar = peakAllocVar(cpuFileVar)
+					stmts = append(stmts, createPeakAllocStmts(peakVar)...)
+				}
+				// Final snapshot is deferred first so it runs after the profiles are flushed
+				stmts = append(stmts, createFinalSnapshotStmts(opts.FinalSnapshotFile, peakVar)...)
+			}
+
+			if opts.EnableCPU && opts.CPUContinuous {
+				// CPU profiling was started in init, stop it when main returns
+				stmts = append(stmts, &ast.DeferStmt{
+					Call: &ast.CallExpr{Fun: ast.NewIdent(continuousStopVar(cpuFileVar))},
+				})
+			} else if opts.EnableCPU && opts.WarmCalls > 0 {
+				// CPU profiling starts on a later call, and stops when main returns
+				stmts = append(stmts, createWarmCallsCountStmt(newWarmCallsVars(cpuFileVar), opts.WarmCalls))
+			} else if opts.EnableCPU {
+				// CPU profiling setup
+				if opts.CPUHz > 0 {
+					stmts = append(stmts, createCPURateStmt(opts.CPUHz))
+				}
+				stmts = append(stmts, createCPUProfilingStmts(opts.CPUFile, cpuFileVar, cpuErrVar, opts.Warmup)...)
+				if opts.CPUDuration > 0 {
+					// The window starts with the profile, after any warmup
+					stmts = append(stmts, createCPUDurationStmt(opts.Warmup+opts.CPUDuration, stopCPUProfileExpr()))
+				}
+			}
+
+			if opts.EnableMem {
+				// Memory profiling setup
+				stmts = append(stmts, createMemoryProfilingStmts(opts.MemFile, memFileVar, memErrVar)...)
+			}
+
+			if opts.EnableMutex {
+				mutexFileVar, mutexErrVar := generateUniqueVars()
+				stmts = append(stmts, createMutexProfilingStmts(opts.MutexFile, mutexFileVar, mutexErrVar, opts.MutexRate)...)
+			}
+
+			if collectsMetrics(opts) {
+				// Metrics collection for dashboard, -summary and -max-alloc
+				stmts = append(stmts, createMetricsCollectionStmts(opts)...)
+			}
+
+			if opts.Func == "" && declaresFunc(node, exitFunc) {
+				// The rewritten os.Exit calls run the deferred flushes too
+				stmts = createExitFlushStmts(stmts)
+			}
+
+			if opts.Duration > 0 {
+				// Once peepFlush is set, so the signal handler can run it
+				stmts = append(stmts, createDurationStmt())
+			}
+
+			if opts.RecoverPanic {
+				// Deferred last so it runs before the flushes above
+				stmts = append(stmts, createRecoverPanicStmts(opts)...)
+			}
+
+			// Inject at beginning of main
+			fn.Body.List = append(stmts, fn.Body.List...)
+			return false
+		}
+		return true
+	})
+}
+
+// processGoFile instruments a Go file with profiling code
+func processGoFile(sourceFile string, opts Options) (*ast.File, *token.FileSet, error) {
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, sourceFile, nil, parser.ParseComments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", sourceFile, err)
+	}
+
+	imported := make(map[string]bool)
+	for _, imp := range node.Imports {
+		imported[imp.Path.Value] = true
+	}
+
+	if opts.Func != "" {
+		if !declaresFunc(node, opts.Func) {
+			return nil, nil, funcNotFoundError(opts.Func, sourceFile, methodsNamed(node, opts.Func))
+		}
+	} else if !hasMainFunction(node) {
+		return nil, nil, fmt.Errorf("no main function found in %s", sourceFile)
+	}
+
+	funcName := "main"
+	if opts.Func != "" {
+		funcName = opts.Func
+	}
+	if err := checkExistingProfiling(fset, node, funcName, opts); err != nil {
+		return nil, nil, err
+	}
+
+	// Before any code is injected, so that only the program's own calls are
+	// rewritten. -duration flushes through the same replacement when stopped.
+	if opts.Func == "" && (rewriteExitCalls(node) || opts.Duration > 0) {
+		addImportIfMissing(fset, node, "sync")
+		addImportIfMissing(fset, node, "fmt")
+		node.Decls = append(node.Decls, createExitDecls()...)
+	}
+
+	// Add required imports
+	addImportIfMissing(fset, node, "os")
+	addImportIfMissing(fset, node, "log")
+	addImportIfMissing(fset, node, "runtime/pprof")
+
+	if collectsMetrics(opts) {
+		addImportIfMissing(fset, node, "runtime")
+		addImportIfMissing(fset, node, "time")
+		addImportIfMissing(fset, node, "encoding/json")
+	}
+
+	if collectsMetrics(opts) && opts.MemSnapshots > 0 {
+		addImportIfMissing(fset, node, "strconv")
+	}
+
+	if opts.EnableWeb && opts.MetricsSocket != "" {
+		addImportIfMissing(fset, node, "net")
+	}
+
+	if opts.LivePprofAddr != "" {
+		addImportIfMissing(fset, node, "net/http")
+		addBlankImportIfMissing(fset, node, "net/http/pprof")
+	}
+
+	if opts.EnableWeb && opts.MetricsPriority == metricsPriorityLow {
+		addImportIfMissing(fset, node, "context")
+	}
+
+	if opts.PostInitHeapFile != "" {
+		addImportIfMissing(fset, node, "runtime")
+	}
+
+	if opts.Duration > 0 {
+		addImportIfMissing(fset, node, "os/signal")
+		addImportIfMissing(fset, node, "syscall")
+	}
+
+	if opts.FinalSnapshotFile != "" {
+		addImportIfMissing(fset, node, "runtime")
+		addImportIfMissing(fset, node, "time")
+		addImportIfMissing(fset, node, "encoding/json")
+		if tracksPeakAlloc(opts) {
+			addImportIfMissing(fset, node, "sync/atomic")
+		}
+	}
+
+	if opts.EnableCPU && opts.CPUHz > 0 {
+		addImportIfMissing(fset, node, "runtime")
+	}
+	if opts.EnableCPU && (opts.CPUDuration > 0 || opts.Warmup > 0) {
+		addImportIfMissing(fset, node, "time")
+	}
+	if opts.TraceFile != "" {
+		addImportIfMissing(fset, node, "runtime/trace")
+	}
+	if opts.EnableMutex {
+		addImportIfMissing(fset, node, "runtime")
+	}
+	if opts.GoroutineInterval > 0 {
+		addImportIfMissing(fset, node, "time")
+		addImportIfMissing(fset, node, "strconv")
+	}
+	if opts.TraceRegionFunc != "" {
+		// Before main is instrumented, so a region in main starts after the trace
+		if err := instrumentTraceRegion(node, opts.TraceRegionFunc); err != nil {
+			return nil, nil, err
+		}
+		addImportIfMissing(fset, node, "context")
+	}
+
+	// Generate unique variable names and instrument
+	cpuFileVar, cpuErrVar := generateUniqueVars()
+	memFileVar, memErrVar := generateUniqueVars()
+
+	if streamsToStdout(opts) {
+		node.Decls = append(node.Decls, createSilenceStdoutDecls()...)
+	}
+	if opts.EnableCPU && opts.CPUContinuous {
+		addImportIfMissing(fset, node, "os/signal")
+		addImportIfMissing(fset, node, "sync")
+		addImportIfMissing(fset, node, "syscall")
+		decls := createContinuousCPUDecls(opts.CPUFile, continuousStopVar(cpuFileVar))
+		if opts.CPUHz > 0 {
+			// The rate is set at the top of the injected init
+			init := decls[1].(*ast.FuncDecl)
+			init.Body.List = append([]ast.Stmt{createCPURateStmt(opts.CPUHz)}, init.Body.List...)
+		}
+		if opts.CPUDuration > 0 {
+			// Once the stop function is set, at the end of the injected init
+			init := decls[1].(*ast.FuncDecl)
+			init.Body.List = append(init.Body.List, createCPUDurationStmt(opts.CPUDuration, ast.NewIdent(continuousStopVar(cpuFileVar))))
+		}
+		node.Decls = append(node.Decls, decls...)
+	}
+	if opts.EnableCPU && opts.WarmCalls > 0 {
+		addImportIfMissing(fset, node, "sync")
+		addImportIfMissing(fset, node, "sync/atomic")
+		if err := instrumentWarmCalls(node, newWarmCallsVars(cpuFileVar), opts); err != nil {
+			return nil, nil, err
+		}
+	}
+	instrumentMainFunction(node, cpuFileVar, cpuErrVar, memFileVar, memErrVar, opts)
+
+	for _, imp := range node.Imports {
+		if !imported[imp.Path.Value] {
+			opts.log.logf(levelVerbose, "Added import %s for the injected code", imp.Path.Value)
+		}
+	}
+	return node, fset, nil
+}
+
+// sampleAge returns how old a metrics sample is, using whichever timestamp unit it carries
+func sampleAge(metrics map[string]any, now time.Time) (time.Duration, bool) {
+	if ts, ok := metrics["timestampNs"].(float64); ok {
+		return now.Sub(time.Unix(0, int64(ts))), true
+	}
+	if ts, ok := metrics["timestampMs"].(float64); ok {
+		return now.Sub(time.UnixMilli(int64(ts))), true
+	}
+	return 0, false
+}
+
+// withSampleAge adds the sample's age in milliseconds as ageMs, keeping the
+// other fields byte for byte so large timestamps don't lose precision
+func withSampleAge(metrics []byte, age time.Duration) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(metrics, &fields); err != nil {
+		return metrics
+	}
+	fields["ageMs"] = json.RawMessage(strconv.FormatInt(age.Milliseconds(), 10))
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return metrics
+	}
+	return out
+}
+
+// currentSample returns the latest sample the target reported to source, or
+// the archived final frame once the target has exited, and nil when there is
+// none. Samples older than staleAfter are dropped; with staleAfter 0 they are
+// still returned, with their age in ageMs.
+func currentSample(source metricsSource, staleAfter time.Duration, data *dashboardData) []byte {
+	if final := data.finalMetrics.Load(); final != nil {
+		return *final
+	}
+
+	// Read the latest metrics reported by the target process
+	metrics, err := source.Latest()
+	if err != nil {
+		return nil
+	}
+
+	// Parse the JSON to check timestamp
+	var sample map[string]any
+	if err := json.Unmarshal(metrics, &sample); err != nil {
+		return nil
+	}
+
+	age, ok := sampleAge(sample, time.Now())
+	if staleAfter == 0 {
+		if ok {
+			metrics = withSampleAge(metrics, age)
+		}
+		return metrics
+	}
+
+	// Check if data is stale
+	if ok && age > staleAfter {
+		return nil
+	}
+	return metrics
+}
+
+// metricsHandler serves the current sample as JSON, or empty metrics when
+// there is none or it is stale
+func metricsHandler(source metricsSource, staleAfter time.Duration, data *dashboardData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		metrics := currentSample(source, staleAfter, data)
+		if metrics == nil {
+			w.Write([]byte("{}"))
+			return
+		}
+		w.Write(metrics)
+	}
+}
+
+// archiveMetrics copies the target's final metrics frame to archivePath, keeps
+// serving it on the dashboard and closes source, removing the working metrics file
+func archiveMetrics(source metricsSource, archivePath string, data *dashboardData) error {
+	final, err := source.Latest()
+	if err != nil {
+		return fmt.Errorf("failed to read final metrics: %w", err)
+	}
+	data.finalMetrics.Store(&final)
+	source.Close()
+
+	if err := os.WriteFile(archivePath, final, 0o644); err != nil {
+		return fmt.Errorf("failed to archive metrics: %w", err)
+	}
+	return nil
+}
+
+// procStatPath is where the CPU helper reads system CPU usage from
+const procStatPath = "/proc/stat"
+
+// defaultDashboardPort is the dashboard's port when none is given
+const defaultDashboardPort = "6060"
+
+// listenDashboard listens for the dashboard on the given port, or on
+// defaultDashboardPort if it is empty. A taken default port is swapped for a
+// free one, so that several peep runs can show their dashboards side by side.
+func listenDashboard(port string) (net.Listener, error) {
+	if port != "" {
+		listener, err := net.Listen("tcp", ":"+port)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start dashboard server: %w", err)
+		}
+		return listener, nil
+	}
+	listener, err := net.Listen("tcp", ":"+defaultDashboardPort)
+	if err != nil {
+		listener, err = net.Listen("tcp", ":0")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start dashboard server: %w", err)
+	}
+	return listener, nil
+}
+
+// listenerPort returns the port a TCP listener was given
+func listenerPort(listener net.Listener) string {
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		return strconv.Itoa(addr.Port)
+	}
+	return ""
+}
+
+// startDashboardServer serves the live dashboard on listener, a TCP port or a
+// daemon's Unix socket, until ctx is done. It returns early with the error
+// that stopped the server, if any.
+func startDashboardServer(ctx context.Context, listener net.Listener, source metricsSource, staleAfter time.Duration, data *dashboardData) error {
+	// A mux of its own, so a second run in the same process can register its handlers
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler(source, staleAfter, data))
+	mux.HandleFunc("/metrics/stream", streamHandler(data.feed))
+	mux.HandleFunc("/metrics/prom", promHandler(source, staleAfter, data))
+
+	mux.HandleFunc("/annotations", eventsHandler(data.annotations))
+	mux.HandleFunc("/gc", eventsHandler(data.gcEvents))
+	mux.HandleFunc("/runinfo", runInfoHandler(data.runInfo))
+	mux.HandleFunc("/history", historyHandler(data.history))
+	mux.HandleFunc("/config", configHandler(data.config))
+	mux.HandleFunc("/logs", logsHandler(data.logs))
+	mux.HandleFunc("/status", statusHandler(data.status))
+
+	go recordHistory(ctx, source, data)
+
+	mux.Handle("/", staticHandler(data.staticDir))
+
+	// Requests end with ctx, so open /metrics/stream connections do not hold up the shutdown
+	server := &http.Server{Handler: mux, BaseContext: func(net.Listener) context.Context { return ctx }}
+
+	if f, err := os.Open(procStatPath); err != nil {
+		data.log.logf(levelNormal, "Warning: CPU usage is unavailable, as %s cannot be read; the dashboard shows no CPU series", procStatPath)
+	} else {
+		f.Close()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		data.log.logf(levelNormal, "Live dashboard server listening on %s", listener.Addr())
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		// Serve only returns before Shutdown when it failed
+		return fmt.Errorf("dashboard server error: %w", err)
+	case <-ctx.Done():
+	}
+	data.log.logf(levelNormal, "Shutting down dashboard server")
+	ctxShutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctxShutdown)
+	return nil
+}
+
+// writeAndExecute writes the instrumented AST to a temp file and executes it
+func writeAndExecute(ctx context.Context, node *ast.File, fset *token.FileSet, opts Options) error {
+	// Check for nil input
+	if node == nil {
+		return fmt.Errorf("cannot write nil AST")
+	}
+
+	// Write the modified file to a directory of its own, so concurrent runs
+	// do not overwrite each other's copy
+	tempDir, err := os.MkdirTemp("", "peep-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tempFile := filepath.Join(tempDir, "main_prof.go")
+	out, err := os.Create(tempFile)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer out.Close()
+
+	if err := writeInstrumented(out, fset, node); err != nil {
+		return fmt.Errorf("failed to write modified code: %w", err)
+	}
+	opts.log.logf(levelVerbose, "Instrumented copy written to %s", tempFile)
+
+	// Build the instrumented file and run the binary with program arguments
+	files := []string{tempFile}
+	if collectsMetrics(opts) {
+		helperFile, err := writeCPUHelper(tempDir)
+		if err != nil {
+			return err
+		}
+		opts.log.logf(levelVerbose, "Metrics helper written to %s", helperFile)
+		files = append(files, helperFile)
+	}
+	binary, err := buildTarget(ctx, opts, "", tempDir, files...)
+	if err != nil {
+		return err
+	}
+	cmd, stop := targetCommand(ctx, opts, binary, "")
+	defer stop()
+	return runInstrumented(ctx, cmd, opts, "program")
+}
+
+// targetCommand returns the command that runs the built target from dir with
+// the program arguments. With -duration its context ends once the duration has
+// elapsed, which interrupts the target so it flushes its profiles and exits.
+// stop releases the context.
+func targetCommand(ctx context.Context, opts Options, binary, dir string) (cmd *exec.Cmd, stop context.CancelFunc) {
+	targetCtx, stop := ctx, func() {}
+	if opts.Duration > 0 {
+		targetCtx, stop = context.WithTimeoutCause(ctx, opts.Duration, errDurationElapsed)
+	}
+	cmd = exec.CommandContext(targetCtx, binary, opts.ProgramArgs...)
+	cmd.Dir = dir
+	configureCancel(ctx, cmd, opts.PTY)
+	if opts.Duration > 0 {
+		configureInterrupt(targetCtx, cmd)
+	}
+	return cmd, stop
+}
+
+// goBuildFlags returns the flags that control how the go command builds the
+// target. -ldflags is one argument, so its quoting reaches the go command intact.
+func goBuildFlags(opts Options) []string {
+	var flags []string
+	if opts.BuildParallelism > 0 {
+		flags = append(flags, "-p", strconv.Itoa(opts.BuildParallelism))
+	}
+	if opts.BuildTags != "" {
+		flags = append(flags, "-tags", opts.BuildTags)
+	}
+	if opts.LDFlags != "" {
+		flags = append(flags, "-ldflags", opts.LDFlags)
+	}
+	if opts.Race {
+		flags = append(flags, "-race")
+	}
+	return flags
+}
+
+// goEnv returns the environment of the go commands peep runs for opts, and
+// of the target: peep's own, with CGO_ENABLED=1 for -cgo, so go list discovers
+// cgo files too, and GOTOOLCHAIN for -toolchain
+func goEnv(opts Options) []string {
+	env := os.Environ()
+	if opts.Cgo {
+		env = append(env, "CGO_ENABLED=1")
+	}
+	if opts.Toolchain != "" {
+		env = append(env, "GOTOOLCHAIN="+opts.Toolchain)
+	}
+	return env
+}
+
+// buildContext returns the context that matches package files for opts the
+// way the go command does, with its build tags and cgo setting
+func buildContext(opts Options) build.Context {
+	ctx := build.Default
+	if opts.BuildTags != "" {
+		ctx.BuildTags = strings.Split(opts.BuildTags, ",")
+	}
+	if opts.Cgo {
+		ctx.CgoEnabled = true
+	}
+	return ctx
+}
+
+// goListArgs returns the arguments of go list ahead of the package pattern,
+// with the build tags of opts so it sees the files the build does
+func goListArgs(opts Options) []string {
+	// -e reports a package whose files are all excluded (e.g. cgo files with
+	// cgo disabled) instead of failing
+	args := []string{"list", "-e", "-json"}
+	if opts.BuildTags != "" {
+		args = append(args, "-tags", opts.BuildTags)
+	}
+	return args
+}
+
+// goTargetFlags returns the flags passed to go build ahead of the files or
+// package of the target
+func goTargetFlags(opts Options) []string {
+	flags := goBuildFlags(opts)
+	if opts.ResolveImports {
+		// Outside a module this looks up the modules providing the imports
+		flags = append(flags, "-mod=mod")
+	}
+	return flags
+}
+
+// buildTarget builds the instrumented target from dir into a binary in
+// tempDir and returns its path. The binary is run directly rather than through
+// go run, which exits 1 whatever the program's exit code. A failed build is
+// returned with the go command's exit code.
+func buildTarget(ctx context.Context, opts Options, dir, tempDir string, args ...string) (string, error) {
+	binary := filepath.Join(tempDir, "prog")
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	buildArgs := append([]string{"build", "-o", binary}, goTargetFlags(opts)...)
+	cmd := exec.CommandContext(ctx, "go", append(buildArgs, args...)...)
+	cmd.Dir = dir
+	cmd.Env = goEnv(opts)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	configureCancel(ctx, cmd, false)
+
+	// Ctrl+C during the setup leaves nothing to build
+	if interrupted.Load() {
+		return "", fmt.Errorf("execution cancelled: %w", errInterrupted)
+	}
+	if dir != "" {
+		opts.log.logf(levelVerbose, "Building from %s: %s", dir, strings.Join(cmd.Args, " "))
+	} else {
+		opts.log.logf(levelVerbose, "Building: %s", strings.Join(cmd.Args, " "))
+	}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("execution cancelled: %w", ctx.Err())
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			err = &targetExitError{code: exitErr.ExitCode(), err: err}
+		}
+		return "", fmt.Errorf("build failed: %w", err)
+	}
+	return binary, nil
+}
+
+// runInstrumented starts the dashboard if requested, runs the instrumented
+// command and reports where the profiles were written. kind names the target
+// in progress messages ("program" or "package").
+func runInstrumented(ctx context.Context, cmd *exec.Cmd, opts Options, kind string) error {
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	cmd.Env = goEnv(opts)
+	if opts.GCTrace {
+		cmd.Env = append(cmd.Env, "GODEBUG="+gctraceGODEBUG())
+	}
+
+	if opts.GoroutineInterval > 0 {
+		if err := removeGoroutineProfiles(opts.log.writer(), opts.GoroutinePrefix); err != nil {
+			return err
+		}
+	}
+	if opts.MemSnapshots > 0 {
+		if err := removeMemSnapshots(opts.log.writer(), opts.MemSnapshotPrefix); err != nil {
+			return err
+		}
+	}
+
+	// A streamed profile shares the target's stdout, which the injected code
+	// silences itself; otherwise the output is simply dropped here
+	if opts.SilentTarget && !streamsToStdout(opts) {
+		cmd.Stdout = io.Discard
+	}
+
+	data := &dashboardData{
+		annotations: &eventLog[Annotation]{},
+		gcEvents:    &eventLog[GCEvent]{},
+		status:      &runStatus{},
+		history:     newRingBuffer[json.RawMessage](opts.HistorySize),
+		historyPoll: min(historyPollInterval, metricsInterval(opts)),
+		staticDir:   opts.StaticDir,
+		log:         opts.log,
+		feed:        &sampleFeed{},
+	}
+	if opts.CSVFile != "" || opts.Summary || opts.MaxAlloc > 0 {
+		data.samples = &eventLog[json.RawMessage]{}
+	}
+
+	// Scan the target's stdout for marker lines while still forwarding it
+	if opts.MarkRegex != nil {
+		marker := newMarkWriter(cmd.Stdout, opts.MarkRegex, data.annotations)
+		defer marker.Flush()
+		cmd.Stdout = marker
+	}
+
+	// Parse the runtime's GC trace from the target's stderr
+	if opts.GCTrace {
+		gcTrace := newGCTraceWriter(os.Stderr, data.gcEvents)
+		defer gcTrace.Flush()
+		cmd.Stderr = gcTrace
+	}
+
+	// The target writes its metrics to the file injected into it, or sends
+	// them over a socket with -metrics-socket
+	var source metricsSource = metricsFileSource{opts.MetricsFile}
+	var socketSource *metricsSocketSource
+	if opts.EnableWeb && opts.MetricsSocket != "" {
+		s, err := listenMetricsSocket(opts.MetricsSocket, opts.ArchiveMetricsFile != "")
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		socketSource, source = s, s
+	}
+
+	// Start live dashboard if requested (before running the program)
+	var dashboardCtx context.Context
+	var dashboardStop context.CancelFunc
+	dashboardErr := make(chan error, 1)
+	if opts.EnableWeb {
+		data.runInfo = newRunInfo(cmd, opts, opts.ShowEnv)
+		if opts.HistoryFile != "" {
+			f, err := os.Create(opts.HistoryFile)
+			if err != nil {
+				return fmt.Errorf("failed to create history file: %w", err)
+			}
+			defer f.Close()
+			data.historyOut = f
+		}
+		if opts.CSVFile != "" {
+			f, err := os.Create(opts.CSVFile)
+			if err != nil {
+				return fmt.Errorf("failed to create CSV file: %w", err)
+			}
+			defer func() {
+				samples := data.samples.List()
+				if err := writeMetricsCSV(f, samples); err != nil {
+					opts.log.logf(levelNormal, "Warning: %s: %v", opts.CSVFile, err)
+				} else {
+					opts.log.logf(levelNormal, "%d metrics samples written to %s", len(samples), opts.CSVFile)
+				}
+				f.Close()
+			}()
+		}
+		data.config = newDashboardConfig(opts)
+
+		// Keep the recent output for /logs, while still showing it in the
+		// terminal. A streamed profile owns stdout, so only stderr is kept then.
+		data.logs = newOutputBuffer(opts.LogsSize)
+		if !streamsToStdout(opts) {
+			cmd.Stdout = io.MultiWriter(cmd.Stdout, data.logs)
+		}
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, data.logs)
+
+		opts.log.logf(levelNormal, "Starting live dashboard server...")
+		dashboardCtx, dashboardStop = signal.NotifyContext(ctx, os.Interrupt)
+		defer dashboardStop()
+
+		// A collector stopped by -metrics-duration leaves its last sample frozen
+		var staleAfter time.Duration
+		if !opts.NoStaleCheck && opts.MetricsDuration == 0 {
+			staleAfter = max(metricsStaleAfter, metricsStaleIntervals*metricsInterval(opts))
+		}
+		var listener net.Listener
+		var err error
+		if opts.DaemonSocket != "" {
+			listener, err = net.Listen("unix", opts.DaemonSocket)
+		} else if listener, err = listenDashboard(opts.Port); err == nil {
+			opts.Port = listenerPort(listener)
+		}
+		if err != nil {
+			return err
+		}
+		go func() {
+			// A failed server ends the dashboard, and the run reports it at the end
+			dashboardErr <- startDashboardServer(dashboardCtx, listener, source, staleAfter, data)
+			dashboardStop()
+		}()
+
+		// Give the dashboard time to start
+		time.Sleep(1 * time.Second)
+		if opts.DaemonSocket != "" {
+			opts.log.logf(levelNormal, "Dashboard available on the peep daemon")
+		} else {
+			opts.log.logf(levelNormal, "Dashboard available at http://localhost:%s", opts.Port)
+		}
+	} else if collectsMetrics(opts) {
+		// Without the dashboard the samples are only recorded for -summary,
+		// -max-alloc and MetricsChan
+		collectCtx, stopCollect := context.WithCancel(ctx)
+		defer stopCollect()
+		go recordHistory(collectCtx, source, data)
+	}
+
+	if opts.Toolchain != "" {
+		opts.log.logf(levelNormal, "Using Go toolchain %s", goVersion(cmd.Env))
+	}
+
+	if opts.EnableCPU && opts.EnableMem {
+		opts.log.logf(levelNormal, "Running instrumented %s with CPU and memory profiling...", kind)
+	} else if opts.EnableMem {
+		opts.log.logf(levelNormal, "Running instrumented %s with memory profiling...", kind)
+	} else {
+		opts.log.logf(levelNormal, "Running instrumented %s with CPU profiling...", kind)
+	}
+	if opts.Duration > 0 {
+		opts.log.logf(levelNormal, "The %s will be stopped %s after it starts", kind, opts.Duration)
+	}
+	if cmd.Dir != "" {
+		opts.log.logf(levelVerbose, "Running from %s: %s", cmd.Dir, strings.Join(cmd.Args, " "))
+	} else {
+		opts.log.logf(levelVerbose, "Running: %s", strings.Join(cmd.Args, " "))
+	}
+
+	// Ctrl+C during the setup leaves nothing to run
+	if interrupted.Load() {
+		return fmt.Errorf("execution cancelled: %w", errInterrupted)
+	}
+
+	// Samples are only sent while the target runs
+	var stopSending func()
+	if opts.MetricsChan != nil {
+		stopSending = sendMetrics(ctx, source, opts.MetricsChan, data.historyPoll)
+		defer stopSending()
+	}
+
+	start := time.Now()
+	var err error
+	data.status.start()
+	if opts.PTY {
+		err = runInPTY(cmd, cmd.Stdout)
+	} else {
+		err = cmd.Run()
+	}
+	// The target answers the -duration deadline by flushing its profiles and
+	// exiting, which Wait reports as the deadline
+	if opts.Duration > 0 && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		opts.log.logf(levelNormal, "The %s was stopped after %s", kind, opts.Duration)
+		err = nil
+	}
+	exitCode := targetExitCode(err)
+	data.status.finish(exitCode)
+	if stopSending != nil {
+		stopSending()
+	}
+	if opts.ManifestFile != "" && ctx.Err() == nil {
+		if manifestErr := writeManifest(cmd, opts, start, err); manifestErr != nil {
+			opts.log.logf(levelNormal, "Warning: %v", manifestErr)
+		}
+	}
+	if socketSource != nil {
+		socketSource.drain(metricsSocketDrainTimeout)
+	}
+	if opts.EnableWeb && opts.ArchiveMetricsFile != "" {
+		if archiveErr := archiveMetrics(source, opts.ArchiveMetricsFile, data); archiveErr != nil {
+			opts.log.logf(levelNormal, "Warning: %v", archiveErr)
+		} else {
+			opts.log.logf(levelNormal, "Final metrics archived to %s", opts.ArchiveMetricsFile)
+		}
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("execution cancelled: %w", ctx.Err())
+	}
+	if err != nil {
+		if exitCode > 0 {
+			err = &targetExitError{code: exitCode, err: err}
+		}
+		return fmt.Errorf("execution failed: %w", err)
+	}
+
+	if opts.EnableCPU && opts.WarmCalls > 0 && opts.CPUFile != stdoutPath {
+		if _, err := os.Stat(opts.CPUFile); os.IsNotExist(err) {
+			return fmt.Errorf("-warm-calls: %s was not called more than %d times, no CPU profile was written", opts.Func, opts.WarmCalls)
+		}
+	}
+	if opts.EnableCPU && opts.Warmup > 0 && opts.CPUFile != stdoutPath {
+		if info, err := os.Stat(opts.CPUFile); err == nil && info.Size() == 0 {
+			return fmt.Errorf("-warmup: the %s exited within the %s warmup, the CPU profile is empty", kind, opts.Warmup)
+		}
+	}
+
+	if opts.PostInitHeapFile != "" {
+		opts.log.logf(levelNormal, "Post-init heap profile saved to %s", opts.PostInitHeapFile)
+	}
+	if opts.TraceFile != "" {
+		opts.log.logf(levelNormal, "Execution trace saved to %s, view it with: go tool trace %s", opts.TraceFile, opts.TraceFile)
+	}
+	if opts.EnableMutex {
+		opts.log.logf(levelNormal, "Mutex profile saved to %s", opts.MutexFile)
+	}
+	if opts.EnableCPU && opts.EnableMem {
+		opts.log.logf(levelNormal, "CPU profile saved to %s", profileDest(opts.CPUFile))
+		opts.log.logf(levelNormal, "Memory profile saved to %s", profileDest(opts.MemFile))
+	} else if opts.EnableMem {
+		opts.log.logf(levelNormal, "Memory profile saved to %s", profileDest(opts.MemFile))
+	} else {
+		opts.log.logf(levelNormal, "CPU profile saved to %s", profileDest(opts.CPUFile))
+	}
+
+	if opts.EnableCPU && opts.EnableWeb && opts.MetricsPriority == metricsPriorityLow && opts.CPUFile != stdoutPath {
+		if err := reportDroppedMetricsSamples(opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.EnableCPU && opts.CPUHz > 0 {
+		corrected, err := annotateCPURate(opts.CPUFile, opts.CPUHz)
+		if err != nil {
+			return err
+		}
+		if corrected {
+			opts.log.logf(levelNormal, "Corrected the CPU profile's sampling period to %s for %d Hz", time.Duration(cpuPeriod(opts.CPUHz)), opts.CPUHz)
+		}
+	}
+
+	if opts.FinalSnapshotFile != "" {
+		snapshot, err := readFinalSnapshot(opts.FinalSnapshotFile)
+		os.Remove(opts.FinalSnapshotFile)
+		if err != nil {
+			if tracksPeakAlloc(opts) {
+				return err
+			}
+			opts.log.logf(levelNormal, "Warning: %v", err)
+		} else {
+			printFinalSnapshot(opts.log, snapshot)
+			if err := checkBaseline(opts.log.writer(), snapshot, opts); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.FailOnEmptyProfile {
+		if err := checkProfilesNotEmpty(opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.TopN > 0 {
+		if err := reportTop(opts.log.writer(), os.Stdout, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.ListRegex != nil {
+		if err := reportListing(opts.log.writer(), opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.AllocSites > 0 {
+		if err := reportAllocSites(opts.log.writer(), opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.GoroutineInterval > 0 {
+		if err := reportGoroutineProfiles(opts.log.writer(), opts); err != nil {
+			return err
+		}
+	}
+	if opts.MemSnapshots > 0 {
+		if err := reportMemSnapshots(opts.log.writer(), opts); err != nil {
+			return err
+		}
+	}
+
+	daemon := opts.EnableWeb && opts.DaemonSocket != ""
+	if opts.EnableWeb && !daemon {
+		opts.log.logf(levelNormal, "Program completed. Dashboard still running at http://localhost:%s", opts.Port)
+	} else if opts.Summary {
+		opts.log.logf(levelNormal, "Program completed")
+	}
+	if opts.Summary {
+		printRunMetricsSummary(opts.log, kind, data.samples.List())
+	}
+
+	// The dashboard stays up over a failed budget, so the run can be inspected
+	var maxAllocErr error
+	if opts.MaxAlloc > 0 {
+		maxAllocErr = checkMaxAlloc(opts.log.writer(), data.samples.List(), opts.MaxAlloc)
+	}
+
+	if daemon {
+		// The daemon keeps serving the last responses it proxied, so stay up
+		// long enough for open dashboards to fetch the final state
+		time.Sleep(daemonLinger)
+		select {
+		case err := <-dashboardErr:
+			if err != nil {
+				return err
+			}
+		default:
+		}
+		return maxAllocErr
+	}
+
+	// Keep dashboard running after program completion if requested
+	if opts.EnableWeb {
+		if opts.MaxRuntime > 0 {
+			opts.log.logf(levelNormal, "Dashboard will stop in %s, press Ctrl+C to stop it sooner", opts.MaxRuntime)
+		} else {
+			opts.log.logf(levelNormal, "Press Ctrl+C to stop the dashboard server")
+		}
+		waitForDashboard(dashboardCtx, opts.MaxRuntime)
+		dashboardStop()
+		if err := <-dashboardErr; err != nil {
+			return err
+		}
+		opts.log.logf(levelNormal, "Dashboard server stopped")
+	}
+
+	return maxAllocErr
+}
+
+// waitForDashboard blocks until ctx is done or, when maxRuntime is positive,
+// until maxRuntime has elapsed
+func waitForDashboard(ctx context.Context, maxRuntime time.Duration) {
+	if maxRuntime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxRuntime)
+		defer cancel()
+	}
+	<-ctx.Done()
+}
+
+// resolveTarget normalizes the target argument to a clean absolute path and
+// reports whether it names a package directory rather than a single Go file.
+// A package pattern, or an import path that is not on disk, resolves to the
+// directory of the one main package go list finds for it.
+func resolveTarget(target string, opts Options) (string, bool, error) {
+	if isPackagePattern(target) {
+		dir, err := resolvePackagePattern(target, opts)
+		return dir, err == nil, err
+	}
+
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	stat, err := os.Stat(absTarget)
+	if os.IsNotExist(err) && filepath.Ext(target) != ".go" {
+		dir, listErr := resolvePackagePattern(target, opts)
+		if listErr != nil {
+			return "", false, fmt.Errorf("%s does not exist and is not a package go list knows: %w", target, listErr)
+		}
+		return dir, true, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to stat %s: %w", target, err)
+	}
+
+	if stat.IsDir() {
+		return absTarget, true, nil
+	}
+
+	if filepath.Ext(absTarget) != ".go" {
+		return "", false, fmt.Errorf("%s is neither a Go source file nor a package directory", target)
+	}
+	return absTarget, false, nil
+}
+
+// resolveProfilePath returns the absolute path a profile is written to. An
+// empty path is replaced by defaultName in defaultDir, or in the working
+// directory when defaultDir is empty. Profiles need absolute paths because the
+// target runs from a temp directory in package mode and may change directory
+// itself. "-" (stdout) is returned unchanged.
+func resolveProfilePath(path, defaultName, defaultDir string) (string, error) {
+	if path == stdoutPath {
+		return path, nil
+	}
+	if path == "" {
+		path = filepath.Join(defaultDir, defaultName)
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve profile path %s: %w", path, err)
+	}
+	return absPath, nil
+}
+
+// outputPath names a profile output file and the flag that set it
+type outputPath struct {
+	flag string
+	path string
+}
+
+// validateOutputPaths ensures the enabled profile outputs resolve to distinct
+// paths and that none of them is an existing directory
+func validateOutputPaths(outputs []outputPath) error {
+	seen := make(map[string]string)
+	for _, out := range outputs {
+		absPath, err := filepath.Abs(out.path)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s %s: %w", out.flag, out.path, err)
+		}
+
+		if stat, err := os.Stat(absPath); err == nil && stat.IsDir() {
+			return fmt.Errorf("%s %s is a directory, expected a file path", out.flag, out.path)
+		}
+
+		if other, ok := seen[absPath]; ok {
+			return fmt.Errorf("%s and %s both write to %s", other, out.flag, absPath)
+		}
+		seen[absPath] = out.flag
+	}
+	return nil
+}
+
+// PackageInfo holds information about a Go package
+type PackageInfo struct {
+	Name           string   `json:"Name"`
+	ImportPath     string   `json:"ImportPath"`
+	Dir            string   `json:"Dir"`
+	GoFiles        []string `json:"GoFiles"`
+	CgoFiles       []string `json:"CgoFiles"`
+	IgnoredGoFiles []string `json:"IgnoredGoFiles"`
+	Error          *struct {
+		Err string `json:"Err"`
+	} `json:"Error"`
+}
+
+// discoverPackage discovers package information using go list
+func discoverPackage(dir string, opts Options) (*PackageInfo, error) {
+	// Get absolute path
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	// Run go list from the package directory
+	cmd := exec.Command("go", append(goListArgs(opts), ".")...)
+	cmd.Dir = absDir
+	cmd.Env = goEnv(opts)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("go list failed: %s\nHint: run from module root or specify a correct path", string(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("failed to run go list: %w", err)
+	}
+
+	pkgs, err := decodePackages(bytes.NewReader(output))
+	if err != nil {
+		return nil, err
+	}
+	pkgInfo, err := selectMainPackage(pkgs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCgo(cgoRequiredFiles(pkgInfo, opts), opts); err != nil {
+		return nil, err
+	}
+
+	if pkgInfo.Error != nil {
+		return nil, fmt.Errorf("go list failed: %s\nHint: run from module root or specify a correct path", pkgInfo.Error.Err)
+	}
+
+	if pkgInfo.Name != "main" {
+		return nil, fmt.Errorf("directory is not a main package (found package %s)", pkgInfo.Name)
+	}
+
+	return pkgInfo, nil
+}
+
+// decodePackages reads the stream of JSON objects go list -json prints, one
+// per package
+func decodePackages(r io.Reader) ([]PackageInfo, error) {
+	var pkgs []PackageInfo
+	dec := json.NewDecoder(r)
+	for {
+		var pkg PackageInfo
+		if err := dec.Decode(&pkg); err == io.EOF {
+			return pkgs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse go list output: %w", err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+}
+
+// selectMainPackage picks the package to run from go list's output: the only
+// package, or the only main package among several
+func selectMainPackage(pkgs []PackageInfo) (*PackageInfo, error) {
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("go list reported no packages")
+	}
+	if len(pkgs) == 1 {
+		return &pkgs[0], nil
+	}
+
+	var mains []*PackageInfo
+	var listed []string
+	for i := range pkgs {
+		if pkgs[i].Name == "main" {
+			mains = append(mains, &pkgs[i])
+		}
+		listed = append(listed, fmt.Sprintf("%s (package %s)", pkgs[i].ImportPath, pkgs[i].Name))
+	}
+	if len(mains) == 1 {
+		return mains[0], nil
+	}
+	return nil, fmt.Errorf("go list reported %d packages with %d main packages, expected exactly one main package:\n  %s", len(pkgs), len(mains), strings.Join(listed, "\n  "))
+}
+
+// findMainFile finds the file containing the main function, or the one named
+// by entry. Of several files defining main, those the build constraints of
+// buildCtx exclude are dropped; when several remain and no entry is given,
+// prompt picks one, and without a prompt it is an error.
+func findMainFile(buildCtx build.Context, files []string, entry string, prompt entryPrompt) (string, error) {
+	var mainFiles []string
+
+	for _, file := range files {
+		fset := token.NewFileSet()
+		node, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+		if err != nil {
+			continue // Skip files that can't be parsed
+		}
+
+		if hasMainFunction(node) {
+			mainFiles = append(mainFiles, file)
+		}
+	}
+
+	if entry != "" {
+		return matchEntry(buildCtx, files, mainFiles, entry)
+	}
+
+	if len(mainFiles) == 0 {
+		return "", fmt.Errorf("no func main() found in any of the package files")
+	}
+
+	// Files for another platform or build tag are never built together, so
+	// only the ones go build would pick right now are candidates
+	if buildable := buildableFiles(buildCtx, mainFiles); len(mainFiles) > 1 && len(buildable) > 0 {
+		mainFiles = buildable
+	}
+
+	if len(mainFiles) > 1 {
+		if prompt != nil {
+			return prompt(mainFiles)
+		}
+		return "", fmt.Errorf("multiple files define func main(): %v, select one with -entry", mainFiles)
+	}
+
+	return mainFiles[0], nil
+}
+
+// readFinalSnapshot loads the end-of-run snapshot written by the instrumented program
+func readFinalSnapshot(path string) (*Metrics, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read final snapshot: %w", err)
+	}
+
+	var m Metrics
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse final snapshot: %w", err)
+	}
+	return &m, nil
+}
+
+// printFinalSnapshot prints the end-of-run snapshot
+func printFinalSnapshot(log *logger, m *Metrics) {
+	log.logf(levelNormal, "Final snapshot:")
+	log.logf(levelNormal, "  Alloc:       %.2f MiB", float64(m.Alloc)/1024/1024)
+	if m.PeakAlloc > 0 {
+		log.logf(levelNormal, "  PeakAlloc:   %.2f MiB", float64(m.PeakAlloc)/1024/1024)
+	}
+	log.logf(levelNormal, "  TotalAlloc:  %.2f MiB", float64(m.TotalAlloc)/1024/1024)
+	log.logf(levelNormal, "  Sys:         %.2f MiB", float64(m.Sys)/1024/1024)
+	log.logf(levelNormal, "  NumGC:       %d", m.NumGC)
+	log.logf(levelNormal, "  PauseTotal:  %s", time.Duration(m.PauseTotal))
+	log.logf(levelNormal, "  Goroutines:  %d", m.Goroutines)
+}
+
+// runGenerate runs go generate in the package directory
+func runGenerate(dir string, opts Options) error {
+	cmd := exec.Command("go", "generate")
+	cmd.Dir = dir
+	cmd.Env = goEnv(opts)
+	cmd.Stdout = opts.log.writer()
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go generate failed: %w", err)
+	}
+	return nil
+}
+
+// resolvePackage discovers the main package in dir and returns its main file
+// along with all package files. With opts.Generate, go generate runs first
+// so that generated files are part of the discovered file set.
+func resolvePackage(dir string, opts Options) (string, []string, error) {
+	if opts.Generate {
+		opts.log.logf(levelNormal, "Running go generate...")
+		if err := runGenerate(dir, opts); err != nil {
+			return "", nil, err
+		}
+	}
+
+	pkgInfo, err := discoverPackage(dir, opts)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Build absolute paths for all package files
+	var allFiles []string
+	for _, file := range pkgInfo.GoFiles {
+		allFiles = append(allFiles, filepath.Join(pkgInfo.Dir, file))
+	}
+	for _, file := range pkgInfo.CgoFiles {
+		allFiles = append(allFiles, filepath.Join(pkgInfo.Dir, file))
+	}
+
+	// Find the main file
+	mainFile, err := findMainFile(buildContext(opts), allFiles, opts.Entry, terminalEntryPrompt(opts.log.writer()))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return mainFile, allFiles, nil
+}
+
+// checkReservedNames returns an error if a package file uses one of the
+// names peep adds to the package itself, which the overlay would shadow
+func checkReservedNames(files, reserved []string) error {
+	for _, file := range files {
+		for _, name := range reserved {
+			if filepath.Base(file) == name {
+				return fmt.Errorf("package file %s uses the name %s, which peep needs for its own file", file, name)
+			}
+		}
+	}
+	return nil
+}
+
+// writeOverlay writes a go build -overlay file to dir that replaces each
+// key of replace with the file it maps to. A key that does not exist on
+// disk adds the file to its directory.
+func writeOverlay(dir string, replace map[string]string) (string, error) {
+	data, err := json.Marshal(struct{ Replace map[string]string }{replace})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode overlay: %w", err)
+	}
+	path := filepath.Join(dir, "overlay.json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write overlay: %w", err)
+	}
+	return path, nil
+}
+
+// writeAndExecutePackage runs the package from its own directory with the
+// instrumented file swapped in through a go build -overlay, so the sibling
+// and internal packages of its module resolve as in a normal build
+func writeAndExecutePackage(ctx context.Context, node *ast.File, fset *token.FileSet, instrumentedFile string, allPkgFiles []string, opts Options) error {
+	var reserved []string
+	if collectsMetrics(opts) {
+		reserved = append(reserved, cpuHelperFile)
+	}
+	if err := checkReservedNames(allPkgFiles, reserved); err != nil {
+		return err
+	}
+
+	original, err := filepath.Abs(instrumentedFile)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", instrumentedFile, err)
+	}
+	pkgDir := filepath.Dir(original)
+
+	// Create temp directory for the overlay files
+	tempDir, err := os.MkdirTemp("", "peep-pkg-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Write the instrumented main file
+	tempMainFile := filepath.Join(tempDir, filepath.Base(original))
+
+	out, err := os.Create(tempMainFile)
+	if err != nil {
+		return fmt.Errorf("failed to create temp main file: %w", err)
+	}
+	defer out.Close()
+
+	if err := writeInstrumented(out, fset, node); err != nil {
+		return fmt.Errorf("failed to write instrumented main file: %w", err)
+	}
+	opts.log.logf(levelVerbose, "Instrumented copy of %s written to %s", original, tempMainFile)
+
+	replace := map[string]string{original: tempMainFile}
+	if collectsMetrics(opts) {
+		helper, err := writeCPUHelper(tempDir)
+		if err != nil {
+			return err
+		}
+		replace[filepath.Join(pkgDir, cpuHelperFile)] = helper
+	}
+	overlay, err := writeOverlay(tempDir, replace)
+	if err != nil {
+		return err
+	}
+	opts.log.logf(levelVerbose, "Build overlay written to %s", overlay)
+
+	// Build the package and run the binary with program arguments, from the
+	// package directory inside its module
+	binary, err := buildTarget(ctx, opts, pkgDir, tempDir, "-overlay", overlay, ".")
+	if err != nil {
+		return err
+	}
+	cmd, stop := targetCommand(ctx, opts, binary, pkgDir)
+	defer stop()
+	return runInstrumented(ctx, cmd, opts, "package")
+}
@@ -0,0 +1,2921 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHasMainFunction(t *testing.T) {
+	// Test with main function
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("Hello, World!")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, testFile, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Failed to parse test file: %v", err)
+	}
+
+	if !hasMainFunction(node) {
+		t.Error("Expected to find main function")
+	}
+}
+
+func TestHasMainFunctionWithoutMain(t *testing.T) {
+	// Test without main function
+	content := `package main
+
+import "fmt"
+
+func helper() {
+	fmt.Println("Helper function")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, testFile, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Failed to parse test file: %v", err)
+	}
+
+	if hasMainFunction(node) {
+		t.Error("Expected not to find main function")
+	}
+}
+
+func TestAddImportIfMissing(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	println("test")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, testFile, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Failed to parse test file: %v", err)
+	}
+
+	// Test adding a new import
+	addImportIfMissing(fset, node, "os")
+
+	// Verify the import was added
+	found := false
+	for _, imp := range node.Imports {
+		if imp.Path.Value == `"os"` {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Error("Expected to find os import after adding")
+	}
+
+	// Test that existing import is not duplicated
+	originalLen := len(node.Imports)
+	addImportIfMissing(fset, node, "fmt") // fmt already exists
+	if len(node.Imports) != originalLen {
+		t.Error("Expected no change when adding existing import")
+	}
+}
+
+func TestGenerateUniqueVars(t *testing.T) {
+	// Test that we can generate unique variable names
+	seen := make(map[string]bool)
+
+	for range 100 {
+		fileVar, errVar := generateUniqueVars()
+
+		if seen[fileVar] {
+			t.Errorf("Generated duplicate file variable: %s", fileVar)
+		}
+		if seen[errVar] {
+			t.Errorf("Generated duplicate error variable: %s", errVar)
+		}
+
+		// Verify expected format
+		if !strings.HasPrefix(fileVar, "f_") {
+			t.Errorf("File variable should start with 'f_', got: %s", fileVar)
+		}
+		if !strings.HasPrefix(errVar, "err_") {
+			t.Errorf("Error variable should start with 'err_', got: %s", errVar)
+		}
+
+		seen[fileVar] = true
+		seen[errVar] = true
+	}
+}
+
+func TestWriteAndExecute(t *testing.T) {
+	// Create a simple Go program that we can instrument and execute
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("test output")
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Process the file to get instrumented AST
+	cpuProfileFile := filepath.Join(tempDir, "test_cpu.prof")
+	memProfileFile := filepath.Join(tempDir, "test_mem.prof")
+	node, fset, err := processGoFile(testFile, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true})
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// Test writeAndExecute without web UI
+	err = writeAndExecute(context.Background(), node, fset, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true})
+	if err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	// Wait a moment for file to be written
+	time.Sleep(200 * time.Millisecond)
+
+	// The profile file should be created in the working directory of the executed program
+	// which is the same as our test directory
+	if _, err := os.Stat(cpuProfileFile); os.IsNotExist(err) {
+		// Check if it was created in current directory instead
+		currentDirProfile := "test_cpu.prof"
+		if _, err := os.Stat(currentDirProfile); os.IsNotExist(err) {
+			t.Error("Expected CPU profile file to be created")
+		} else {
+			os.Remove(currentDirProfile) // cleanup
+		}
+	} else {
+		os.Remove(cpuProfileFile) // cleanup
+	}
+}
+
+func TestWriteAndExecuteInvalidCode(t *testing.T) {
+	// Create invalid Go code to test error handling
+	content := `package main
+
+func main() {
+	invalid syntax here
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "invalid.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// This should fail during parsing
+	_, _, err = processGoFile(testFile, Options{CPUFile: "test_cpu.prof", MemFile: "test_mem.prof", EnableCPU: true})
+	if err == nil {
+		t.Error("Expected error when processing invalid Go code")
+	}
+}
+
+func TestProcessGoFile(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("Hello, World!")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Test processing a valid Go file
+	node, fset, err := processGoFile(testFile, Options{CPUFile: "test_cpu.prof", MemFile: "test_mem.prof", EnableCPU: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if node == nil || fset == nil {
+		t.Error("Expected non-nil node and fset")
+	}
+
+	// Verify required imports were added
+	requiredImports := []string{"os", "log", "runtime/pprof"}
+	for _, required := range requiredImports {
+		found := false
+		for _, imp := range node.Imports {
+			if imp.Path.Value == `"`+required+`"` {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected to find import: %s", required)
+		}
+	}
+}
+
+func TestProcessGoFileWithoutMain(t *testing.T) {
+	content := `package main
+
+func helper() {
+	println("helper")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Test processing file without main function should error
+	_, _, err = processGoFile(testFile, Options{CPUFile: "test_cpu.prof", MemFile: "test_mem.prof", EnableCPU: true})
+	if err == nil {
+		t.Error("Expected error for file without main function")
+	}
+}
+
+func TestMemoryProfilingOnly(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("test output")
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Process the file with memory profiling only
+	memProfileFile := filepath.Join(tempDir, "test_mem.prof")
+	node, fset, err := processGoFile(testFile, Options{MemFile: memProfileFile, EnableMem: true})
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// Test writeAndExecute with memory profiling only
+	err = writeAndExecute(context.Background(), node, fset, Options{MemFile: memProfileFile, EnableMem: true})
+	if err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	// Wait a moment for file to be written
+	time.Sleep(200 * time.Millisecond)
+
+	// Check that memory profile file was created
+	if _, err := os.Stat(memProfileFile); os.IsNotExist(err) {
+		// Check if it was created in current directory instead
+		currentDirProfile := "test_mem.prof"
+		if _, err := os.Stat(currentDirProfile); os.IsNotExist(err) {
+			t.Error("Expected memory profile file to be created")
+		} else {
+			os.Remove(currentDirProfile) // cleanup
+		}
+	} else {
+		os.Remove(memProfileFile) // cleanup
+	}
+}
+
+func TestBothCPUAndMemoryProfiling(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("test output")
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Process the file with both CPU and memory profiling
+	cpuProfileFile := filepath.Join(tempDir, "test_cpu.prof")
+	memProfileFile := filepath.Join(tempDir, "test_mem.prof")
+	node, fset, err := processGoFile(testFile, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true, EnableMem: true})
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// Test writeAndExecute with both profiling types
+	err = writeAndExecute(context.Background(), node, fset, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true, EnableMem: true})
+	if err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	// Wait a moment for files to be written
+	time.Sleep(200 * time.Millisecond)
+
+	// Check that both profile files were created
+	cpuExists := false
+	memExists := false
+
+	if _, err := os.Stat(cpuProfileFile); err == nil {
+		cpuExists = true
+		os.Remove(cpuProfileFile) // cleanup
+	} else if _, err := os.Stat("test_cpu.prof"); err == nil {
+		cpuExists = true
+		os.Remove("test_cpu.prof") // cleanup
+	}
+
+	if _, err := os.Stat(memProfileFile); err == nil {
+		memExists = true
+		os.Remove(memProfileFile) // cleanup
+	} else if _, err := os.Stat("test_mem.prof"); err == nil {
+		memExists = true
+		os.Remove("test_mem.prof") // cleanup
+	}
+
+	if !cpuExists {
+		t.Error("Expected CPU profile file to be created")
+	}
+	if !memExists {
+		t.Error("Expected memory profile file to be created")
+	}
+}
+
+func TestCreateCPUProfilingStmts(t *testing.T) {
+	// Test CPU profiling statements creation
+	cpuFile := "test_cpu.prof"
+	cpuFileVar, cpuErrVar := generateUniqueVars()
+
+	stmts := createCPUProfilingStmts(cpuFile, cpuFileVar, cpuErrVar, 0)
+
+	if len(stmts) != 3 {
+		t.Fatalf("Expected 3 statements, got %d", len(stmts))
+	}
+
+	// First should be assignment
+	if _, ok := stmts[0].(*ast.AssignStmt); !ok {
+		t.Error("First statement should be assignment")
+	}
+
+	// Second should warn on error and otherwise start the profile
+	ifStmt, ok := stmts[1].(*ast.IfStmt)
+	if !ok {
+		t.Fatal("Second statement should be if statement")
+	}
+	assertWarnsInsteadOfFatal(t, ifStmt, "CPU")
+	if els, ok := ifStmt.Else.(*ast.BlockStmt); !ok || len(els.List) != 1 {
+		t.Error("Expected the else branch to start the profile")
+	}
+
+	// Third should be defer statement
+	if _, ok := stmts[2].(*ast.DeferStmt); !ok {
+		t.Error("Third statement should be defer statement")
+	}
+}
+
+func TestUncreatableProfileStillRunsProgram(t *testing.T) {
+	tempDir := t.TempDir()
+	marker := filepath.Join(tempDir, "ran")
+	content := fmt.Sprintf(`package main
+
+import "os"
+
+func main() {
+	os.WriteFile(%q, []byte("ok"), 0o644)
+}
+`, marker)
+	testFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	missing := filepath.Join(tempDir, "missing")
+	opts := Options{
+		CPUFile:   filepath.Join(missing, "cpu.prof"),
+		MemFile:   filepath.Join(tempDir, "mem.prof"),
+		EnableCPU: true,
+		EnableMem: true,
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("Expected the program to run without its CPU profile, got: %v", err)
+	}
+
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("Expected the program's own code to run: %v", err)
+	}
+	if info, err := os.Stat(opts.MemFile); err != nil || info.Size() == 0 {
+		t.Errorf("Expected the memory profile to be written anyway, got %v", err)
+	}
+}
+
+func TestUncreatableMutexAndHeapProfilesStillRunProgram(t *testing.T) {
+	tempDir := t.TempDir()
+	marker := filepath.Join(tempDir, "ran")
+	content := fmt.Sprintf(`package main
+
+import "os"
+
+func main() {
+	os.WriteFile(%q, []byte("ok"), 0o644)
+}
+`, marker)
+	testFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	missing := filepath.Join(tempDir, "missing")
+	opts := Options{
+		CPUFile:          filepath.Join(missing, "cpu.prof"),
+		MemFile:          filepath.Join(tempDir, "mem.prof"),
+		MutexFile:        filepath.Join(missing, "mutex.prof"),
+		PostInitHeapFile: filepath.Join(missing, "mem_postinit.prof"),
+		TraceFile:        filepath.Join(missing, "trace.out"),
+		EnableCPU:        true,
+		EnableMem:        true,
+		EnableMutex:      true,
+		MutexRate:        1,
+		CPUContinuous:    true,
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := writeInstrumented(&buf, fset, node); err != nil {
+		t.Fatalf("Failed to print the instrumented file: %v", err)
+	}
+	if strings.Contains(buf.String(), "log.Fatal(") {
+		t.Errorf("Expected no profile setup to exit the program, got:\n%s", buf.String())
+	}
+
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("Expected the program to run without its mutex, post-init heap, trace and CPU profiles, got: %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("Expected the program's own code to run: %v", err)
+	}
+	if info, err := os.Stat(opts.MemFile); err != nil || info.Size() == 0 {
+		t.Errorf("Expected the memory profile to be written anyway, got %v", err)
+	}
+}
+
+// assertWarnsInsteadOfFatal checks that the error branch of a profile's
+// setup logs a warning and leaves the program running
+func assertWarnsInsteadOfFatal(t *testing.T, ifStmt *ast.IfStmt, profile string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), ifStmt.Body); err != nil {
+		t.Fatalf("Failed to print the error branch: %v", err)
+	}
+	body := buf.String()
+	if strings.Contains(body, "log.Fatal") {
+		t.Errorf("Expected the error branch not to exit, got:\n%s", body)
+	}
+	if !strings.Contains(body, "log.Printf") || !strings.Contains(body, "Warning: running without the "+profile+" profile") {
+		t.Errorf("Expected the error branch to log a warning, got:\n%s", body)
+	}
+}
+
+func TestWriteInstrumentedIsGofmtCanonical(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	content := `package main
+
+import "fmt"
+
+func sum(n int) int {
+	s := 0
+	for i := 0; i < n; i++ { s += i }
+	return s
+}
+
+func main() {
+	var broken int = "not an int"
+	fmt.Println(sum(10), broken)
+}
+`
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{CPUFile: "cpu.prof", MemFile: "mem.prof", EnableCPU: true, EnableMem: true}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := writeInstrumented(&buf, fset, node); err != nil {
+		t.Fatalf("writeInstrumented failed: %v", err)
+	}
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to gofmt the written file: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), formatted) {
+		t.Errorf("Expected the written file to be gofmt'ed, got:\n%s", buf.String())
+	}
+
+	// The //line directives survive formatting, so errors still refer to the original lines
+	written := filepath.Join(tempDir, "build", "main.go")
+	if err := os.MkdirAll(filepath.Dir(written), 0o755); err != nil {
+		t.Fatalf("Failed to create build directory: %v", err)
+	}
+	if err := os.WriteFile(written, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write instrumented file: %v", err)
+	}
+	output, err := exec.Command("go", "vet", written).CombinedOutput()
+	if err == nil || !strings.Contains(string(output), "main.go:12:") {
+		t.Errorf("Expected the type error reported at main.go:12 of the original, got %v:\n%s", err, output)
+	}
+}
+
+func TestCreateMutexProfilingStmts(t *testing.T) {
+	mutexFileVar, mutexErrVar := generateUniqueVars()
+
+	stmts := createMutexProfilingStmts("test_mutex.prof", mutexFileVar, mutexErrVar, 5)
+
+	if len(stmts) != 4 {
+		t.Fatalf("Expected 4 statements, got %d", len(stmts))
+	}
+
+	// First should set the profile fraction
+	if _, ok := stmts[0].(*ast.ExprStmt); !ok {
+		t.Error("First statement should be expression statement")
+	}
+
+	// Second should be assignment
+	if _, ok := stmts[1].(*ast.AssignStmt); !ok {
+		t.Error("Second statement should be assignment")
+	}
+
+	// Third should warn if the file cannot be created
+	if ifStmt, ok := stmts[2].(*ast.IfStmt); !ok {
+		t.Error("Third statement should be if statement")
+	} else {
+		assertWarnsInsteadOfFatal(t, ifStmt, "mutex")
+	}
+
+	// Fourth should be defer statement
+	if _, ok := stmts[3].(*ast.DeferStmt); !ok {
+		t.Error("Fourth statement should be defer statement")
+	}
+
+	var buf bytes.Buffer
+	printer.Fprint(&buf, token.NewFileSet(), stmts[0])
+	if buf.String() != "runtime.SetMutexProfileFraction(5)" {
+		t.Errorf("Expected the mutex rate to be set, got %s", buf.String())
+	}
+}
+
+func TestMutexProfileRecordsContention(t *testing.T) {
+	content := `package main
+
+import (
+	"sync"
+	"time"
+)
+
+func main() {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mu.Lock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{EnableMutex: true, MutexFile: filepath.Join(tempDir, "mutex.prof"), MutexRate: 1}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	p, err := loadProfile(opts.MutexFile)
+	if err != nil {
+		t.Fatalf("Failed to load mutex profile: %v", err)
+	}
+	if isEmptyProfile(p) {
+		t.Error("Expected the mutex profile to record the contention")
+	}
+}
+
+func TestCreateMemoryProfilingStmts(t *testing.T) {
+	// Test memory profiling statements creation
+	memFile := "test_mem.prof"
+	memFileVar, memErrVar := generateUniqueVars()
+
+	stmts := createMemoryProfilingStmts(memFile, memFileVar, memErrVar)
+
+	if len(stmts) != 3 {
+		t.Fatalf("Expected 3 statements, got %d", len(stmts))
+	}
+
+	// First should be assignment
+	if _, ok := stmts[0].(*ast.AssignStmt); !ok {
+		t.Error("First statement should be assignment")
+	}
+
+	// Second should warn on error
+	ifStmt, ok := stmts[1].(*ast.IfStmt)
+	if !ok {
+		t.Fatal("Second statement should be if statement")
+	}
+	assertWarnsInsteadOfFatal(t, ifStmt, "memory")
+
+	// Third should be defer statement
+	if _, ok := stmts[2].(*ast.DeferStmt); !ok {
+		t.Error("Third statement should be defer statement")
+	}
+}
+
+func TestCreateMetricsCollectionStmts(t *testing.T) {
+	// Test metrics collection statements creation
+	stmts := createMetricsCollectionStmts(Options{})
+
+	if len(stmts) != 3 {
+		t.Errorf("Expected 3 statements, got %d", len(stmts))
+	}
+
+	// Verify the statements are of expected types
+	// First should be assignment
+	if _, ok := stmts[0].(*ast.AssignStmt); !ok {
+		t.Error("First statement should be assignment")
+	}
+
+	// Second should be defer statement
+	if _, ok := stmts[1].(*ast.DeferStmt); !ok {
+		t.Error("Second statement should be defer statement")
+	}
+
+	// Third should be go statement
+	if _, ok := stmts[2].(*ast.GoStmt); !ok {
+		t.Error("Third statement should be go statement")
+	}
+
+	// The sample reports the live goroutine count and the MemStats fields it
+	// reads, with the latest pause indexed so that it wraps around the buffer
+	keys := metricsKeys(stmts)
+	for key, want := range map[string]string{
+		"goroutines":    "runtime.NumGoroutine()",
+		"heapInuse":     "m.HeapInuse",
+		"heapObjects":   "m.HeapObjects",
+		"mallocs":       "m.Mallocs",
+		"frees":         "m.Frees",
+		"gcCpuFraction": "m.GCCPUFraction",
+		"lastPauseNs":   "m.PauseNs[(m.NumGC+255)%256]",
+	} {
+		value, ok := keys[key]
+		if !ok {
+			t.Errorf("Expected the sample to have a %s key", key)
+			continue
+		}
+		var buf bytes.Buffer
+		printer.Fprint(&buf, token.NewFileSet(), value)
+		if buf.String() != want {
+			t.Errorf("Expected %s to be %s, got %s", key, want, buf.String())
+		}
+	}
+}
+
+func TestInstrumentMainFunction(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("Hello, World!")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, testFile, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Failed to parse test file: %v", err)
+	}
+
+	// Count statements before instrumentation
+	var mainFunc *ast.FuncDecl
+	ast.Inspect(node, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FuncDecl)
+		if ok && fn.Name.Name == "main" && fn.Recv == nil {
+			mainFunc = fn
+			return false
+		}
+		return true
+	})
+
+	originalStmtCount := len(mainFunc.Body.List)
+
+	// Test instrumentation with CPU profiling only
+	cpuFileVar, cpuErrVar := generateUniqueVars()
+	memFileVar, memErrVar := generateUniqueVars()
+	instrumentMainFunction(node, cpuFileVar, cpuErrVar, memFileVar, memErrVar, Options{CPUFile: "cpu.prof", MemFile: "mem.prof", EnableCPU: true})
+
+	// Verify statements were added
+	ast.Inspect(node, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FuncDecl)
+		if ok && fn.Name.Name == "main" && fn.Recv == nil {
+			newStmtCount := len(fn.Body.List)
+			if newStmtCount <= originalStmtCount {
+				t.Errorf("Expected more statements after instrumentation, got %d (was %d)", newStmtCount, originalStmtCount)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+func TestInstrumentMainFunctionWithAllProfiling(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("Hello, World!")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, testFile, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Failed to parse test file: %v", err)
+	}
+
+	// Count statements before instrumentation
+	var mainFunc *ast.FuncDecl
+	ast.Inspect(node, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FuncDecl)
+		if ok && fn.Name.Name == "main" && fn.Recv == nil {
+			mainFunc = fn
+			return false
+		}
+		return true
+	})
+
+	originalStmtCount := len(mainFunc.Body.List)
+
+	// Test instrumentation with all profiling enabled
+	cpuFileVar, cpuErrVar := generateUniqueVars()
+	memFileVar, memErrVar := generateUniqueVars()
+	instrumentMainFunction(node, cpuFileVar, cpuErrVar, memFileVar, memErrVar, Options{CPUFile: "cpu.prof", MemFile: "mem.prof", EnableCPU: true, EnableMem: true, EnableWeb: true})
+
+	// Verify statements were added
+	ast.Inspect(node, func(n ast.Node) bool {
+		fn, ok := n.(*ast.FuncDecl)
+		if ok && fn.Name.Name == "main" && fn.Recv == nil {
+			newStmtCount := len(fn.Body.List)
+			if newStmtCount <= originalStmtCount {
+				t.Errorf("Expected more statements after instrumentation, got %d (was %d)", newStmtCount, originalStmtCount)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+func TestHasMainFunctionWithMethodReceiver(t *testing.T) {
+	// Test that methods with "main" name are not considered main functions
+	content := `package main
+
+import "fmt"
+
+type MyStruct struct{}
+
+func (m MyStruct) main() {
+	fmt.Println("This is a method, not a main function")
+}
+
+func main() {
+	fmt.Println("This is the real main function")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, testFile, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Failed to parse test file: %v", err)
+	}
+
+	if !hasMainFunction(node) {
+		t.Error("Expected to find main function even with method named main")
+	}
+}
+
+func TestProcessGoFileWithWebEnabled(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("Hello, World!")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Test processing with web UI enabled
+	node, fset, err := processGoFile(testFile, Options{CPUFile: "test_cpu.prof", MemFile: "test_mem.prof", EnableCPU: true, EnableWeb: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if node == nil || fset == nil {
+		t.Error("Expected non-nil node and fset")
+	}
+
+	// Verify web-related imports were added
+	webImports := []string{"runtime", "time", "encoding/json"}
+	for _, required := range webImports {
+		found := false
+		for _, imp := range node.Imports {
+			if imp.Path.Value == `"`+required+`"` {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected to find web import: %s", required)
+		}
+	}
+}
+
+func TestWriteAndExecuteWithWebUI(t *testing.T) {
+	// This test verifies that web UI processing works without actually starting the server
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("test output")
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Process the file without web UI to avoid dependency issues
+	cpuProfileFile := filepath.Join(tempDir, "test_cpu.prof")
+	memProfileFile := filepath.Join(tempDir, "test_mem.prof")
+	node, fset, err := processGoFile(testFile, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true})
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// Test writeAndExecute without web UI to avoid server startup
+	err = writeAndExecute(context.Background(), node, fset, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true})
+	if err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	// Wait a moment for file to be written
+	time.Sleep(200 * time.Millisecond)
+
+	// Check that CPU profile file was created
+	if _, err := os.Stat(cpuProfileFile); os.IsNotExist(err) {
+		// Check if it was created in current directory instead
+		currentDirProfile := "test_cpu.prof"
+		if _, err := os.Stat(currentDirProfile); os.IsNotExist(err) {
+			t.Error("Expected CPU profile file to be created")
+		} else {
+			os.Remove(currentDirProfile) // cleanup
+		}
+	} else {
+		os.Remove(cpuProfileFile) // cleanup
+	}
+}
+
+func TestProcessGoFileNonexistentFile(t *testing.T) {
+	// Test processing a file that doesn't exist
+	_, _, err := processGoFile("nonexistent.go", Options{CPUFile: "cpu.prof", MemFile: "mem.prof", EnableCPU: true})
+	if err == nil {
+		t.Error("Expected error when processing nonexistent file")
+	}
+}
+
+func TestAddImportIfMissingWithEmptyImports(t *testing.T) {
+	content := `package main
+
+func main() {
+	println("test")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, testFile, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Failed to parse test file: %v", err)
+	}
+
+	// Test adding import to file with no existing imports
+	originalLen := len(node.Imports)
+	addImportIfMissing(fset, node, "os")
+
+	if len(node.Imports) != originalLen+1 {
+		t.Errorf("Expected import count to increase by 1, got %d (was %d)", len(node.Imports), originalLen)
+	}
+}
+
+func TestGenerateUniqueVarsUniqueness(t *testing.T) {
+	// A source that keeps repeating a few suffixes, so collisions are certain
+	suffixes := []string{"aa", "aa", "bb", "aa", "bb", "cc"}
+	next := 0
+	varSuffix = func() string {
+		s := suffixes[next%len(suffixes)] + strconv.Itoa(next/len(suffixes))
+		next++
+		return s
+	}
+	t.Cleanup(func() { varSuffix = randomSuffix })
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		fileVar, errVar := generateUniqueVars()
+
+		if seen[fileVar] {
+			t.Errorf("Generated duplicate file variable at iteration %d: %s", i, fileVar)
+		}
+		if seen[errVar] {
+			t.Errorf("Generated duplicate error variable at iteration %d: %s", i, errVar)
+		}
+
+		seen[fileVar] = true
+		seen[errVar] = true
+	}
+}
+
+func TestWriteAndExecuteWithInvalidAST(t *testing.T) {
+	// Test writeAndExecute with a nil AST
+	err := writeAndExecute(context.Background(), nil, token.NewFileSet(), Options{CPUFile: "cpu.prof", MemFile: "mem.prof", EnableCPU: true})
+	if err == nil {
+		t.Error("Expected error when writing nil AST")
+	}
+}
+
+func TestProcessGoFileWithMethodNamedMain(t *testing.T) {
+	// Test processing a file that has a method named "main" but no main function
+	content := `package main
+
+import "fmt"
+
+type MyStruct struct{}
+
+func (m MyStruct) main() {
+	fmt.Println("This is a method named main")
+}
+
+func helper() {
+	fmt.Println("Helper function")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// This should fail because there's no main function (only a method named main)
+	_, _, err = processGoFile(testFile, Options{CPUFile: "test_cpu.prof", MemFile: "test_mem.prof", EnableCPU: true})
+	if err == nil {
+		t.Error("Expected error for file with method named main but no main function")
+	}
+}
+
+func TestInstrumentMainFunctionNoMain(t *testing.T) {
+	// Test instrumentation on a file without main function
+	content := `package main
+
+import "fmt"
+
+func helper() {
+	fmt.Println("Helper function")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, testFile, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Failed to parse test file: %v", err)
+	}
+
+	// This should not panic and should not modify anything
+	cpuFileVar, cpuErrVar := generateUniqueVars()
+	memFileVar, memErrVar := generateUniqueVars()
+	instrumentMainFunction(node, cpuFileVar, cpuErrVar, memFileVar, memErrVar, Options{CPUFile: "cpu.prof", MemFile: "mem.prof", EnableCPU: true, EnableMem: true, EnableWeb: true})
+
+	// Verify no main function was found
+	if hasMainFunction(node) {
+		t.Error("Expected no main function to be found")
+	}
+}
+
+func TestProcessGoFileWithAllProfilingModes(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("Hello, World!")
+}
+`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Test processing with all profiling modes enabled
+	node, fset, err := processGoFile(testFile, Options{CPUFile: "test_cpu.prof", MemFile: "test_mem.prof", EnableCPU: true, EnableMem: true, EnableWeb: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if node == nil || fset == nil {
+		t.Error("Expected non-nil node and fset")
+	}
+
+	// Verify all required imports were added
+	allImports := []string{
+		"os", "log", "runtime/pprof", // Basic profiling
+		"runtime", "time", "encoding/json", // Web UI
+	}
+
+	for _, required := range allImports {
+		found := false
+		for _, imp := range node.Imports {
+			if imp.Path.Value == `"`+required+`"` {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected to find import: %s", required)
+		}
+	}
+}
+
+func TestWriteAndExecuteWithProgramArguments(t *testing.T) {
+	// Create a simple Go program that prints its arguments
+	content := `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Printf("Program arguments: %v\n", os.Args)
+	
+	// Print each argument on a separate line
+	for i, arg := range os.Args {
+		fmt.Printf("Arg %d: %s\n", i, arg)
+	}
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Process the file to get instrumented AST
+	cpuProfileFile := filepath.Join(tempDir, "test_cpu.prof")
+	memProfileFile := filepath.Join(tempDir, "test_mem.prof")
+	node, fset, err := processGoFile(testFile, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true})
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// Test writeAndExecute with program arguments
+	programArgs := []string{"-arg1", "value1", "-arg2", "value2", "--flag", "test"}
+	err = writeAndExecute(context.Background(), node, fset, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true, ProgramArgs: programArgs})
+	if err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	// Wait a moment for file to be written
+	time.Sleep(200 * time.Millisecond)
+
+	// The profile file should be created in the working directory of the executed program
+	// which is the same as our test directory
+	if _, err := os.Stat(cpuProfileFile); os.IsNotExist(err) {
+		// Check if it was created in current directory instead
+		currentDirProfile := "test_cpu.prof"
+		if _, err := os.Stat(currentDirProfile); os.IsNotExist(err) {
+			t.Error("Expected CPU profile file to be created")
+		} else {
+			os.Remove(currentDirProfile) // cleanup
+		}
+	} else {
+		os.Remove(cpuProfileFile) // cleanup
+	}
+}
+
+func TestWriteAndExecuteWithEmptyProgramArguments(t *testing.T) {
+	// Test that writeAndExecute works correctly with empty program arguments
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("test output")
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Process the file to get instrumented AST
+	cpuProfileFile := filepath.Join(tempDir, "test_cpu.prof")
+	memProfileFile := filepath.Join(tempDir, "test_mem.prof")
+	node, fset, err := processGoFile(testFile, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true})
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// Test writeAndExecute with empty program arguments
+	err = writeAndExecute(context.Background(), node, fset, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true})
+	if err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	// Wait a moment for file to be written
+	time.Sleep(200 * time.Millisecond)
+
+	// Check that CPU profile file was created
+	if _, err := os.Stat(cpuProfileFile); os.IsNotExist(err) {
+		// Check if it was created in current directory instead
+		currentDirProfile := "test_cpu.prof"
+		if _, err := os.Stat(currentDirProfile); os.IsNotExist(err) {
+			t.Error("Expected CPU profile file to be created")
+		} else {
+			os.Remove(currentDirProfile) // cleanup
+		}
+	} else {
+		os.Remove(cpuProfileFile) // cleanup
+	}
+}
+
+func TestWriteAndExecutePackageWithProgramArguments(t *testing.T) {
+	// Create a test package with multiple files
+	tempDir := t.TempDir()
+
+	// Create go.mod
+	goModContent := `module testpackage
+
+go 1.21
+`
+	err := os.WriteFile(filepath.Join(tempDir, "go.mod"), []byte(goModContent), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create go.mod: %v", err)
+	}
+
+	// Create main.go
+	mainContent := `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Printf("Package arguments: %v\n", os.Args)
+	
+	// Print each argument on a separate line
+	for i, arg := range os.Args {
+		fmt.Printf("Package Arg %d: %s\n", i, arg)
+	}
+	
+	// Use helper function
+	helper := NewHelper()
+	helper.DoSomething()
+}`
+
+	err = os.WriteFile(filepath.Join(tempDir, "main.go"), []byte(mainContent), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create main.go: %v", err)
+	}
+
+	// Create helper.go
+	helperContent := `package main
+
+import "fmt"
+
+type Helper struct{}
+
+func NewHelper() *Helper {
+	return &Helper{}
+}
+
+func (h *Helper) DoSomething() {
+	fmt.Println("Helper is doing something...")
+}`
+
+	err = os.WriteFile(filepath.Join(tempDir, "helper.go"), []byte(helperContent), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create helper.go: %v", err)
+	}
+
+	// Discover the package
+	pkgInfo, err := discoverPackage(tempDir, Options{})
+	if err != nil {
+		t.Fatalf("Failed to discover package: %v", err)
+	}
+
+	// Build absolute paths for all package files
+	var allFiles []string
+	for _, file := range pkgInfo.GoFiles {
+		allFiles = append(allFiles, filepath.Join(pkgInfo.Dir, file))
+	}
+
+	// Find the main file
+	mainFile, err := findMainFile(build.Default, allFiles, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to find main file: %v", err)
+	}
+
+	// Process the main file
+	cpuProfileFile := filepath.Join(tempDir, "test_cpu.prof")
+	memProfileFile := filepath.Join(tempDir, "test_mem.prof")
+	node, fset, err := processGoFile(mainFile, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true})
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// Test writeAndExecutePackage with program arguments
+	programArgs := []string{"-package-arg1", "value1", "-package-arg2", "value2", "--package-flag", "test"}
+	err = writeAndExecutePackage(context.Background(), node, fset, mainFile, allFiles, Options{CPUFile: cpuProfileFile, MemFile: memProfileFile, EnableCPU: true, ProgramArgs: programArgs})
+	if err != nil {
+		t.Fatalf("writeAndExecutePackage failed: %v", err)
+	}
+
+	// Wait a moment for file to be written
+	time.Sleep(200 * time.Millisecond)
+
+	// Check that CPU profile file was created
+	if _, err := os.Stat(cpuProfileFile); os.IsNotExist(err) {
+		// Check if it was created in current directory instead
+		currentDirProfile := "test_cpu.prof"
+		if _, err := os.Stat(currentDirProfile); os.IsNotExist(err) {
+			t.Error("Expected CPU profile file to be created")
+		} else {
+			os.Remove(currentDirProfile) // cleanup
+		}
+	} else {
+		os.Remove(cpuProfileFile) // cleanup
+	}
+}
+
+func TestResolvePackageWithGenerate(t *testing.T) {
+	// The package only gains its main function once go generate has run
+	tempDir := t.TempDir()
+
+	files := map[string]string{
+		"go.mod": `module genpackage
+
+go 1.21
+`,
+		"doc.go": `package main
+
+//go:generate go run gen.go
+`,
+		"gen.go": `//go:build ignore
+
+package main
+
+import "os"
+
+func main() {
+	src := "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"generated main\")\n}\n"
+	if err := os.WriteFile("main_gen.go", []byte(src), 0o644); err != nil {
+		panic(err)
+	}
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	// Without generation there is no main function to instrument
+	if _, _, err := resolvePackage(tempDir, Options{}); err == nil {
+		t.Fatal("Expected error before generation")
+	}
+
+	mainFile, allFiles, err := resolvePackage(tempDir, Options{Generate: true})
+	if err != nil {
+		t.Fatalf("Failed to resolve package: %v", err)
+	}
+
+	if filepath.Base(mainFile) != "main_gen.go" {
+		t.Errorf("Expected generated main_gen.go to be the main file, got %s", mainFile)
+	}
+	if len(allFiles) != 2 {
+		t.Errorf("Expected doc.go and main_gen.go in the file set, got %v", allFiles)
+	}
+}
+
+func TestCreateFinalSnapshotStmts(t *testing.T) {
+	stmts := createFinalSnapshotStmts("final.json", "")
+
+	if len(stmts) != 1 {
+		t.Fatalf("Expected 1 statement, got %d", len(stmts))
+	}
+
+	// The snapshot must be deferred so it runs when main returns
+	if _, ok := stmts[0].(*ast.DeferStmt); !ok {
+		t.Error("Statement should be defer statement")
+	}
+}
+
+func TestFinalSnapshotWrittenAtReturn(t *testing.T) {
+	// A short program finishes before any periodic sample could be taken
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("short run")
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	snapshotFile := filepath.Join(tempDir, "final.json")
+	node, fset, err := processGoFile(testFile, Options{CPUFile: filepath.Join(tempDir, "cpu.prof"), EnableCPU: true, FinalSnapshotFile: snapshotFile})
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// Run the instrumented source directly so the snapshot file is kept for inspection
+	instrumented := filepath.Join(tempDir, "instrumented.go")
+	out, err := os.Create(instrumented)
+	if err != nil {
+		t.Fatalf("Failed to create instrumented file: %v", err)
+	}
+	if err := printer.Fprint(out, fset, node); err != nil {
+		t.Fatalf("Failed to write instrumented file: %v", err)
+	}
+	out.Close()
+
+	if output, err := exec.Command("go", "run", instrumented).CombinedOutput(); err != nil {
+		t.Fatalf("Instrumented program failed: %v\n%s", err, output)
+	}
+
+	m, err := readFinalSnapshot(snapshotFile)
+	if err != nil {
+		t.Fatalf("Failed to read final snapshot: %v", err)
+	}
+	if m.Goroutines < 1 {
+		t.Errorf("Expected at least one goroutine in final snapshot, got %d", m.Goroutines)
+	}
+	if m.Sys == 0 || m.TimestampMS == 0 {
+		t.Errorf("Expected populated final snapshot, got %+v", m)
+	}
+}
+
+func TestFinalSnapshotTracksPeakAlloc(t *testing.T) {
+	// The allocation is released before main returns, so only the sampler sees it
+	content := `package main
+
+import (
+	"runtime"
+	"time"
+)
+
+var sink []byte
+
+func main() {
+	sink = make([]byte, 64<<20)
+	time.Sleep(300 * time.Millisecond)
+	sink = nil
+	runtime.GC()
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	snapshotFile := filepath.Join(tempDir, "final.json")
+	opts := Options{
+		CPUFile:           filepath.Join(tempDir, "cpu.prof"),
+		EnableCPU:         true,
+		FinalSnapshotFile: snapshotFile,
+		SaveBaselineFile:  filepath.Join(tempDir, "baseline.json"),
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	instrumented := filepath.Join(tempDir, "instrumented.go")
+	out, err := os.Create(instrumented)
+	if err != nil {
+		t.Fatalf("Failed to create instrumented file: %v", err)
+	}
+	if err := printer.Fprint(out, fset, node); err != nil {
+		t.Fatalf("Failed to write instrumented file: %v", err)
+	}
+	out.Close()
+
+	if output, err := exec.Command("go", "run", instrumented).CombinedOutput(); err != nil {
+		t.Fatalf("Instrumented program failed: %v\n%s", err, output)
+	}
+
+	m, err := readFinalSnapshot(snapshotFile)
+	if err != nil {
+		t.Fatalf("Failed to read final snapshot: %v", err)
+	}
+	if m.PeakAlloc < 64<<20 {
+		t.Errorf("Expected peak alloc of at least 64 MiB, got %d", m.PeakAlloc)
+	}
+	if m.Alloc >= m.PeakAlloc {
+		t.Errorf("Expected final alloc %d below peak %d", m.Alloc, m.PeakAlloc)
+	}
+}
+
+func TestResolveTargetPathForms(t *testing.T) {
+	tempDir := t.TempDir()
+	appDir := filepath.Join(tempDir, "cmd", "app")
+	if err := os.MkdirAll(appDir, 0o755); err != nil {
+		t.Fatalf("Failed to create package directory: %v", err)
+	}
+	mainFile := filepath.Join(appDir, "main.go")
+	if err := os.WriteFile(mainFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create main.go: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	// All spellings of the package directory resolve to the same absolute path
+	for _, form := range []string{"cmd/app", "cmd/app/", "./cmd/app", "./cmd/app/", "cmd/../cmd/app"} {
+		path, isDir, err := resolveTarget(form, Options{})
+		if err != nil {
+			t.Errorf("resolveTarget(%q) failed: %v", form, err)
+			continue
+		}
+		if !isDir {
+			t.Errorf("resolveTarget(%q) should be a package directory", form)
+		}
+		if path != appDir {
+			t.Errorf("resolveTarget(%q) = %s, expected %s", form, path, appDir)
+		}
+	}
+
+	// All spellings of the file resolve to the same absolute file
+	for _, form := range []string{"cmd/app/main.go", "./cmd/app/main.go", "cmd/app/./main.go"} {
+		path, isDir, err := resolveTarget(form, Options{})
+		if err != nil {
+			t.Errorf("resolveTarget(%q) failed: %v", form, err)
+			continue
+		}
+		if isDir {
+			t.Errorf("resolveTarget(%q) should be a single file", form)
+		}
+		if path != mainFile {
+			t.Errorf("resolveTarget(%q) = %s, expected %s", form, path, mainFile)
+		}
+	}
+}
+
+func TestResolveTargetRejectsInvalid(t *testing.T) {
+	tempDir := t.TempDir()
+	notGo := filepath.Join(tempDir, "README.md")
+	if err := os.WriteFile(notGo, []byte("readme"), 0o644); err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	if _, _, err := resolveTarget(notGo, Options{}); err == nil {
+		t.Error("Expected error for a non-Go file")
+	}
+	if _, _, err := resolveTarget(filepath.Join(tempDir, "missing"), Options{}); err == nil {
+		t.Error("Expected error for a missing path")
+	}
+}
+
+// metricsKeys collects the string keys and values of map literals in the injected statements
+func metricsKeys(stmts []ast.Stmt) map[string]ast.Expr {
+	keys := make(map[string]ast.Expr)
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			kv, ok := n.(*ast.KeyValueExpr)
+			if !ok {
+				return true
+			}
+			if lit, ok := kv.Key.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				keys[strings.Trim(lit.Value, `"`)] = kv.Value
+			}
+			return true
+		})
+	}
+	return keys
+}
+
+// timestampFunc returns the time method called for a sample timestamp, e.g. UnixMilli
+func timestampFunc(t *testing.T, value ast.Expr) string {
+	t.Helper()
+	call, ok := value.(*ast.CallExpr)
+	if !ok {
+		t.Fatalf("Expected timestamp to be a call, got %T", value)
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		t.Fatalf("Expected timestamp to be a method call, got %T", call.Fun)
+	}
+	return sel.Sel.Name
+}
+
+func TestCreateMetricsCollectionStmtsTimestampPrecision(t *testing.T) {
+	// Milliseconds remain the default
+	keys := metricsKeys(createMetricsCollectionStmts(Options{}))
+	value, ok := keys["timestampMs"]
+	if !ok {
+		t.Fatal("Expected timestampMs key by default")
+	}
+	if got := timestampFunc(t, value); got != "UnixMilli" {
+		t.Errorf("Expected UnixMilli timestamp, got %s", got)
+	}
+	if _, ok := keys["timestampNs"]; ok {
+		t.Error("Expected no timestampNs key by default")
+	}
+
+	keys = metricsKeys(createMetricsCollectionStmts(Options{NanoTimestamps: true}))
+	value, ok = keys["timestampNs"]
+	if !ok {
+		t.Fatal("Expected timestampNs key with nanosecond timestamps")
+	}
+	if got := timestampFunc(t, value); got != "UnixNano" {
+		t.Errorf("Expected UnixNano timestamp, got %s", got)
+	}
+	if _, ok := keys["timestampMs"]; ok {
+		t.Error("Expected no timestampMs key with nanosecond timestamps")
+	}
+}
+
+func TestSampleAge(t *testing.T) {
+	now := time.Now()
+
+	msSample := map[string]any{"timestampMs": float64(now.Add(-3 * time.Second).UnixMilli())}
+	age, ok := sampleAge(msSample, now)
+	if !ok || age < 2*time.Second || age > 4*time.Second {
+		t.Errorf("Expected millisecond sample to be ~3s old, got %v (ok=%v)", age, ok)
+	}
+
+	nsSample := map[string]any{"timestampNs": float64(now.Add(-500 * time.Millisecond).UnixNano())}
+	age, ok = sampleAge(nsSample, now)
+	if !ok || age < 400*time.Millisecond || age > 600*time.Millisecond {
+		t.Errorf("Expected nanosecond sample to be ~500ms old, got %v (ok=%v)", age, ok)
+	}
+
+	if _, ok := sampleAge(map[string]any{}, now); ok {
+		t.Error("Expected no age for a sample without a timestamp")
+	}
+}
+
+func TestCreateContinuousCPUDecls(t *testing.T) {
+	decls := createContinuousCPUDecls("cpu.prof", "stopCPU")
+
+	if len(decls) != 2 {
+		t.Fatalf("Expected 2 declarations, got %d", len(decls))
+	}
+
+	// First should declare the package-level stop function
+	gen, ok := decls[0].(*ast.GenDecl)
+	if !ok || gen.Tok != token.VAR {
+		t.Error("First declaration should be a var declaration")
+	}
+
+	// Second should be an init function that starts profiling
+	fn, ok := decls[1].(*ast.FuncDecl)
+	if !ok || fn.Name.Name != "init" || fn.Recv != nil {
+		t.Fatal("Second declaration should be func init()")
+	}
+	started := false
+	ast.Inspect(fn, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && sel.Sel.Name == "StartCPUProfile" {
+			started = true
+		}
+		return true
+	})
+	if !started {
+		t.Error("Expected init to start CPU profiling")
+	}
+}
+
+func TestContinuousCPUProfileFlushedOnSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires SIGINT delivery")
+	}
+
+	// A supervisor-style main that restarts its work forever
+	content := `package main
+
+func work() int {
+	sum := 0
+	for i := 0; i < 1000000; i++ {
+		sum += i % 7
+	}
+	return sum
+}
+
+func main() {
+	for {
+		work()
+	}
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	cpuProfileFile := filepath.Join(tempDir, "cpu.prof")
+	node, fset, err := processGoFile(testFile, Options{CPUFile: cpuProfileFile, EnableCPU: true, CPUContinuous: true})
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	instrumented := filepath.Join(tempDir, "instrumented.go")
+	out, err := os.Create(instrumented)
+	if err != nil {
+		t.Fatalf("Failed to create instrumented file: %v", err)
+	}
+	if err := printer.Fprint(out, fset, node); err != nil {
+		t.Fatalf("Failed to write instrumented file: %v", err)
+	}
+	out.Close()
+
+	// Build a binary so the signal reaches the target rather than go run
+	binary := filepath.Join(tempDir, "target")
+	if output, err := exec.Command("go", "build", "-o", binary, instrumented).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to build instrumented program: %v\n%s", err, output)
+	}
+
+	cmd := exec.Command(binary)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start target: %v", err)
+	}
+	time.Sleep(1 * time.Second)
+	cmd.Process.Signal(os.Interrupt)
+
+	// The re-raised signal should still terminate the program
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("Expected the target to exit after SIGINT")
+	}
+
+	p, err := loadProfile(cpuProfileFile)
+	if err != nil {
+		t.Fatalf("Expected a readable CPU profile: %v", err)
+	}
+	if isEmptyProfile(p) {
+		t.Error("Expected CPU profile flushed on signal to contain samples")
+	}
+}
+
+func TestValidateOutputPaths(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Chdir(tempDir)
+
+	// Distinct files are accepted
+	err := validateOutputPaths([]outputPath{{"-cpu-out", "cpu.prof"}, {"-mem-out", "mem.prof"}})
+	if err != nil {
+		t.Errorf("Expected distinct paths to be valid, got: %v", err)
+	}
+
+	// The same file spelled differently still collides
+	err = validateOutputPaths([]outputPath{{"-cpu-out", "out.prof"}, {"-mem-out", filepath.Join(tempDir, "sub", "..", "out.prof")}})
+	if err == nil {
+		t.Error("Expected error when CPU and memory profiles share a path")
+	} else if !strings.Contains(err.Error(), "-cpu-out") || !strings.Contains(err.Error(), "-mem-out") {
+		t.Errorf("Expected error to name both flags, got: %v", err)
+	}
+
+	// A directory is not a valid profile path
+	if err := os.Mkdir(filepath.Join(tempDir, "profiles"), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	err = validateOutputPaths([]outputPath{{"-cpu-out", "profiles"}})
+	if err == nil {
+		t.Error("Expected error when a profile path is a directory")
+	}
+}
+
+func TestCreateMetricsCollectionStmtsAdaptive(t *testing.T) {
+	stmts := createMetricsCollectionStmts(Options{Adaptive: true})
+	if len(stmts) != 3 {
+		t.Fatalf("Expected 3 statements, got %d", len(stmts))
+	}
+
+	goStmt, ok := stmts[2].(*ast.GoStmt)
+	if !ok {
+		t.Fatalf("Expected go statement, got %T", stmts[2])
+	}
+	loop := goStmt.Call.Fun.(*ast.FuncLit).Body.List
+	if len(loop) != 3 {
+		t.Fatalf("Expected interval, lastAlloc and loop statements, got %d", len(loop))
+	}
+
+	forStmt, ok := loop[2].(*ast.ForStmt)
+	if !ok {
+		t.Fatalf("Expected for loop, got %T", loop[2])
+	}
+	sleep, ok := forStmt.Body.List[0].(*ast.ExprStmt)
+	if !ok {
+		t.Fatalf("Expected loop to start with time.Sleep, got %T", forStmt.Body.List[0])
+	}
+	if sel := sleep.X.(*ast.CallExpr).Fun.(*ast.SelectorExpr); sel.Sel.Name != "Sleep" {
+		t.Errorf("Expected time.Sleep, got %s", sel.Sel.Name)
+	}
+
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), goStmt); err != nil {
+		t.Fatalf("Failed to print adaptive loop: %v", err)
+	}
+	src := buf.String()
+	if strings.Contains(src, "NewTicker") {
+		t.Error("Expected adaptive loop not to use a fixed ticker")
+	}
+	for _, bound := range []string{"100 * time.Millisecond", "1500 * time.Millisecond"} {
+		if !strings.Contains(src, bound) {
+			t.Errorf("Expected adaptive loop to clamp at %s", bound)
+		}
+	}
+}
+
+func TestCreateMetricsCollectionStmtsInterval(t *testing.T) {
+	for _, tc := range []struct {
+		interval time.Duration
+		want     string
+	}{
+		{0, "time.NewTicker(time.Duration(500000000))"},
+		{100 * time.Millisecond, "time.NewTicker(time.Duration(100000000))"},
+	} {
+		var buf bytes.Buffer
+		for _, stmt := range createMetricsCollectionStmts(Options{Interval: tc.interval}) {
+			if err := printer.Fprint(&buf, token.NewFileSet(), stmt); err != nil {
+				t.Fatalf("Failed to print collector: %v", err)
+			}
+		}
+		if !strings.Contains(buf.String(), tc.want) {
+			t.Errorf("Expected %s for interval %s, got:\n%s", tc.want, tc.interval, buf.String())
+		}
+	}
+}
+
+func TestCreateMetricsCollectionStmtsAdaptiveInterval(t *testing.T) {
+	for _, tc := range []struct {
+		interval time.Duration
+		want     string
+	}{
+		{0, "interval := 500 * time.Millisecond"},
+		{200 * time.Millisecond, "interval := 200 * time.Millisecond"},
+		{10 * time.Millisecond, "interval := 100 * time.Millisecond"},
+		{5 * time.Second, "interval := 1500 * time.Millisecond"},
+	} {
+		var buf bytes.Buffer
+		for _, stmt := range createMetricsCollectionStmts(Options{Adaptive: true, Interval: tc.interval}) {
+			if err := printer.Fprint(&buf, token.NewFileSet(), stmt); err != nil {
+				t.Fatalf("Failed to print collector: %v", err)
+			}
+		}
+		if !strings.Contains(buf.String(), tc.want) {
+			t.Errorf("Expected %s for interval %s, got:\n%s", tc.want, tc.interval, buf.String())
+		}
+	}
+}
+
+func TestCreateMetricsCollectionStmtsDuration(t *testing.T) {
+	if len(createMetricsCollectionStmts(Options{MetricsDuration: time.Minute})) != 3 {
+		t.Fatal("Expected -metrics-duration to keep the collector's statements")
+	}
+
+	for _, opts := range []Options{
+		{MetricsDuration: time.Minute},
+		{MetricsDuration: time.Minute, Adaptive: true},
+		{MetricsDuration: time.Minute, MetricsPriority: metricsPriorityLow},
+	} {
+		stmts := createMetricsCollectionStmts(opts)
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, token.NewFileSet(), stmts[len(stmts)-1]); err != nil {
+			t.Fatalf("Failed to print collector: %v", err)
+		}
+		src := buf.String()
+		if !strings.Contains(src, "metricsDeadline := time.Now().Add(time.Duration(60000000000))") {
+			t.Errorf("Expected the collector to compute its deadline, got:\n%s", src)
+		}
+		check := strings.Index(src, "if time.Now().After(metricsDeadline) {")
+		if check < 0 || check > strings.Index(src, "var m runtime.MemStats") {
+			t.Errorf("Expected the loop to stop after the deadline, got:\n%s", src)
+		}
+	}
+
+	buildWebInstrumented(t, Options{MetricsDuration: time.Minute, RecoverPanic: true})
+}
+
+func TestCreateMetricsCollectionStmtsThreads(t *testing.T) {
+	keys := metricsKeys(createMetricsCollectionStmts(Options{}))
+
+	if _, ok := keys["goroutines"]; !ok {
+		t.Error("Expected goroutines in the metrics stream")
+	}
+
+	value, ok := keys["threads"]
+	if !ok {
+		t.Fatal("Expected threads in the metrics stream")
+	}
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), value); err != nil {
+		t.Fatalf("Failed to print threads expression: %v", err)
+	}
+	if got := buf.String(); got != `pprof.Lookup("threadcreate").Count()` {
+		t.Errorf("Expected thread count from the threadcreate profile, got %s", got)
+	}
+}
+
+func TestWaitForDashboardMaxRuntime(t *testing.T) {
+	start := time.Now()
+	waitForDashboard(context.Background(), 100*time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected to wait about 100ms, waited %v", elapsed)
+	}
+
+	// Ctrl+C still stops the dashboard before the limit
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	waitForDashboard(ctx, time.Hour)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected cancellation to end the wait immediately, waited %v", elapsed)
+	}
+}
+
+func TestSingleFileRunsSideBySide(t *testing.T) {
+	tempDir := t.TempDir()
+	// The runs share the temp directory, as two peep processes would
+	t.Setenv("TMPDIR", t.TempDir())
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	markers := make([]string, 2)
+	for i := range 2 {
+		markers[i] = filepath.Join(tempDir, fmt.Sprintf("ran-%d", i))
+		content := fmt.Sprintf(`package main
+
+import (
+	"os"
+	"time"
+)
+
+func main() {
+	time.Sleep(200 * time.Millisecond)
+	os.WriteFile(%q, []byte("ok"), 0o644)
+}
+`, markers[i])
+		testFile := filepath.Join(tempDir, fmt.Sprintf("main%d.go", i))
+		if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+		opts := Options{CPUFile: filepath.Join(tempDir, fmt.Sprintf("cpu%d.prof", i)), EnableCPU: true}
+		node, fset, err := processGoFile(testFile, opts)
+		if err != nil {
+			t.Fatalf("Failed to process Go file: %v", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = writeAndExecute(context.Background(), node, fset, opts)
+		}()
+	}
+	wg.Wait()
+
+	for i := range 2 {
+		if errs[i] != nil {
+			t.Errorf("Run %d failed: %v", i, errs[i])
+		}
+		if _, err := os.Stat(markers[i]); err != nil {
+			t.Errorf("Expected run %d to run its own program: %v", i, err)
+		}
+	}
+}
+
+func TestDashboardServersRunSideBySide(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	// Without a port, the second server moves off a default port the first took
+	ports := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		listener, err := listenDashboard("")
+		if err != nil {
+			t.Fatalf("listenDashboard failed: %v", err)
+		}
+		port := listenerPort(listener)
+		if ports[port] {
+			t.Fatalf("Expected each dashboard on a port of its own, %s was reused", port)
+		}
+		ports[port] = true
+
+		data := &dashboardData{
+			annotations: &eventLog[Annotation]{},
+			gcEvents:    &eventLog[GCEvent]{},
+			status:      &runStatus{},
+			history:     newRingBuffer[json.RawMessage](0),
+			historyPoll: historyPollInterval,
+		}
+		data.status.finish(i)
+		source := metricsFileSource{filepath.Join(t.TempDir(), "peep_metrics.json")}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each server registers its handlers on a mux of its own
+			startDashboardServer(ctx, listener, source, 0, data)
+		}()
+
+		var status RunStatus
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			resp, err := http.Get("http://localhost:" + port + "/status")
+			if err == nil {
+				json.NewDecoder(resp.Body).Decode(&status)
+				resp.Body.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Dashboard %d did not answer: %v", i, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if !status.Completed || status.ExitCode != i {
+			t.Errorf("Expected dashboard %d to serve its own status, got %+v", i, status)
+		}
+	}
+}
+
+func TestListenDashboardTakenPort(t *testing.T) {
+	taken, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer taken.Close()
+
+	// A port asked for explicitly is not swapped for another
+	if listener, err := listenDashboard(listenerPort(taken)); err == nil {
+		listener.Close()
+		t.Error("Expected an error for a port already in use")
+	}
+}
+
+func TestDashboardServerReturnsServeError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	// A closed listener makes Serve fail at once
+	listener.Close()
+
+	data := &dashboardData{
+		annotations: &eventLog[Annotation]{},
+		gcEvents:    &eventLog[GCEvent]{},
+		status:      &runStatus{},
+		history:     newRingBuffer[json.RawMessage](0),
+		historyPoll: historyPollInterval,
+	}
+	source := metricsFileSource{filepath.Join(t.TempDir(), "peep_metrics.json")}
+	done := make(chan error, 1)
+	go func() {
+		done <- startDashboardServer(context.Background(), listener, source, 0, data)
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected the serve error to be returned")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("startDashboardServer kept running after Serve failed")
+	}
+}
+
+func TestPostInitHeapPath(t *testing.T) {
+	cases := map[string]string{
+		"mem.prof":                   "mem_postinit.prof",
+		"out/heap.pb.gz":             "out/heap.pb_postinit.gz",
+		"profiles/mem":               "profiles/mem_postinit",
+		filepath.Join("a", "b.prof"): filepath.Join("a", "b_postinit.prof"),
+	}
+	for memFile, want := range cases {
+		if got := postInitHeapPath(memFile); got != want {
+			t.Errorf("postInitHeapPath(%q) = %q, want %q", memFile, got, want)
+		}
+	}
+}
+
+func TestPostInitHeapProfileCapturesInit(t *testing.T) {
+	// The package-level table is allocated during initialization, before main
+	content := `package main
+
+var table = make([]byte, 4<<20)
+
+func main() {
+	_ = table
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	memFile := filepath.Join(tempDir, "mem.prof")
+	opts := Options{MemFile: memFile, EnableMem: true, PostInitHeapFile: postInitHeapPath(memFile)}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	p, err := loadProfile(opts.PostInitHeapFile)
+	if err != nil {
+		t.Fatalf("Failed to load post-init heap profile: %v", err)
+	}
+	if isEmptyProfile(p) {
+		t.Error("Expected post-init heap profile to include initialization allocations")
+	}
+}
+
+func TestCreateMetricsCollectionStmtsPerCore(t *testing.T) {
+	if _, ok := metricsKeys(createMetricsCollectionStmts(Options{}))["cpuPerCore"]; ok {
+		t.Error("Expected no per-core usage by default")
+	}
+
+	var buf bytes.Buffer
+	for _, stmt := range createMetricsCollectionStmts(Options{PerCore: true}) {
+		if err := printer.Fprint(&buf, token.NewFileSet(), stmt); err != nil {
+			t.Fatalf("Failed to print metrics collection: %v", err)
+		}
+	}
+	src := buf.String()
+	if !strings.Contains(src, "cpuVal, cpuCores := peepCPUSample()") {
+		t.Errorf("Expected per-core usage to be read, got:\n%s", src)
+	}
+	if !strings.Contains(src, `"cpuPercent": peepCPUField(cpuVal)`) {
+		t.Errorf("Expected the aggregate usage to go through peepCPUField, got:\n%s", src)
+	}
+	if !strings.Contains(src, `metrics["cpuPerCore"] = cpuCores`) {
+		t.Errorf("Expected per-core usage in the metrics, got:\n%s", src)
+	}
+	if i, j := strings.Index(src, `metrics["cpuPerCore"]`), strings.Index(src, "json.Marshal(metrics)"); i > j {
+		t.Error("Expected per-core usage to be set before the metrics are marshalled")
+	}
+}
+
+func TestCreateMetricsCollectionStmtsArchive(t *testing.T) {
+	stmts := createMetricsCollectionStmts(Options{ArchiveMetricsFile: "archive.json"})
+	if len(stmts) != 2 {
+		t.Fatalf("Expected 2 statements, got %d", len(stmts))
+	}
+	for _, stmt := range stmts {
+		if _, ok := stmt.(*ast.DeferStmt); ok {
+			t.Error("Expected the metrics file to be kept for archiving")
+		}
+	}
+}
+
+func TestArchiveMetricsServesFinalFrame(t *testing.T) {
+	tempDir := t.TempDir()
+	metricsPath := filepath.Join(tempDir, "peep_metrics.json")
+	archivePath := filepath.Join(tempDir, "archive.json")
+
+	// Older than the staleness window, as the last frame is after the target exits
+	frame := fmt.Sprintf(`{"alloc":42,"timestampMs":%d}`, time.Now().Add(-time.Minute).UnixMilli())
+	if err := os.WriteFile(metricsPath, []byte(frame), 0o644); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+
+	data := &dashboardData{}
+	handler := metricsHandler(metricsFileSource{metricsPath}, metricsStaleAfter, data)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Body.String() != "{}" {
+		t.Errorf("Expected stale live metrics to be hidden, got %s", rec.Body.String())
+	}
+
+	if err := archiveMetrics(metricsFileSource{metricsPath}, archivePath, data); err != nil {
+		t.Fatalf("archiveMetrics failed: %v", err)
+	}
+
+	archived, err := os.ReadFile(archivePath)
+	if err != nil || string(archived) != frame {
+		t.Errorf("Expected archive to hold the final frame, got %q (%v)", archived, err)
+	}
+	if _, err := os.Stat(metricsPath); !os.IsNotExist(err) {
+		t.Error("Expected working metrics file to be removed")
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Body.String() != frame {
+		t.Errorf("Expected dashboard to serve the final frame, got %s", rec.Body.String())
+	}
+}
+
+func TestMetricsHandlerWithoutStaleCheck(t *testing.T) {
+	metricsPath := filepath.Join(t.TempDir(), "peep_metrics.json")
+
+	// Nanosecond timestamps exceed float64 precision and must be kept exactly
+	ts := time.Now().Add(-time.Minute).UnixNano()
+	frame := fmt.Sprintf(`{"alloc":42,"timestampNs":%d}`, ts)
+	if err := os.WriteFile(metricsPath, []byte(frame), 0o644); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	metricsHandler(metricsFileSource{metricsPath}, 0, &dashboardData{})(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	var got map[string]json.Number
+	dec := json.NewDecoder(rec.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("Expected the stale sample to be served: %v", err)
+	}
+	if got["timestampNs"].String() != strconv.FormatInt(ts, 10) {
+		t.Errorf("Expected timestamp %d to be kept, got %s", ts, got["timestampNs"])
+	}
+	age, err := got["ageMs"].Int64()
+	if err != nil || age < time.Minute.Milliseconds() {
+		t.Errorf("Expected ageMs of at least a minute, got %s", got["ageMs"])
+	}
+}
+
+func TestResolveProfilePath(t *testing.T) {
+	workDir := t.TempDir()
+	t.Chdir(workDir)
+	targetDir := filepath.Join(workDir, "app")
+
+	tests := []struct {
+		path, defaultDir, expected string
+	}{
+		{"", "", filepath.Join(workDir, "cpu.prof")},
+		{"", targetDir, filepath.Join(targetDir, "cpu.prof")},
+		{"out/my.prof", targetDir, filepath.Join(workDir, "out", "my.prof")}, // explicit paths stay relative to the working directory
+		{"/abs/my.prof", "", "/abs/my.prof"},
+		{"-", targetDir, "-"},
+	}
+	for _, tt := range tests {
+		got, err := resolveProfilePath(tt.path, "cpu.prof", tt.defaultDir)
+		if err != nil {
+			t.Fatalf("resolveProfilePath(%q) failed: %v", tt.path, err)
+		}
+		if got != tt.expected {
+			t.Errorf("resolveProfilePath(%q, %q) = %s, want %s", tt.path, tt.defaultDir, got, tt.expected)
+		}
+	}
+}
+
+func TestPackageProfilesLandInInvocationDir(t *testing.T) {
+	// Package mode runs from the package directory, so a relative -cpu-out
+	// must already be absolute when it is embedded
+	pkgDir := t.TempDir()
+	mainFile := filepath.Join(pkgDir, "main.go")
+	content := "package main\n\nfunc main() {\n\tdata := make([][]byte, 0)\n\tfor i := 0; i < 100; i++ {\n\t\tdata = append(data, make([]byte, 1024))\n\t}\n\t_ = data\n}\n"
+	if err := os.WriteFile(mainFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create main file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, "go.mod"), []byte("module example\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create go.mod: %v", err)
+	}
+	workDir := t.TempDir()
+	t.Chdir(workDir)
+
+	cpuFile, err := resolveProfilePath("cpu.prof", "cpu.prof", "")
+	if err != nil {
+		t.Fatalf("Failed to resolve CPU profile path: %v", err)
+	}
+	memFile, err := resolveProfilePath("out.mem.prof", "mem.prof", "")
+	if err != nil {
+		t.Fatalf("Failed to resolve memory profile path: %v", err)
+	}
+
+	opts := Options{CPUFile: cpuFile, MemFile: memFile, EnableCPU: true, EnableMem: true}
+	node, fset, err := processGoFile(mainFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecutePackage(context.Background(), node, fset, mainFile, []string{mainFile}, opts); err != nil {
+		t.Fatalf("writeAndExecutePackage failed: %v", err)
+	}
+
+	for _, name := range []string{"cpu.prof", "out.mem.prof"} {
+		if info, err := os.Stat(filepath.Join(workDir, name)); err != nil || info.Size() == 0 {
+			t.Errorf("Expected %s in the directory peep was invoked from, got %v", name, err)
+		}
+	}
+}
+
+func TestProfilesSurviveTargetChdir(t *testing.T) {
+	// The profile files are opened when main starts, so a relative path only
+	// goes astray when the target changes directory before that, in init
+	content := `package main
+
+import "os"
+
+func init() {
+	if err := os.Chdir(os.Args[1]); err != nil {
+		panic(err)
+	}
+}
+
+func main() {
+	data := make([][]byte, 0)
+	for i := 0; i < 100; i++ {
+		data = append(data, make([]byte, 1024))
+	}
+	_ = data
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	workDir := filepath.Join(tempDir, "work")
+	elsewhere := filepath.Join(tempDir, "elsewhere")
+	for _, dir := range []string{workDir, elsewhere} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	t.Chdir(workDir)
+
+	// Resolved the way main resolves the default outputs
+	cpuFile, err := resolveProfilePath("", "cpu.prof", "")
+	if err != nil {
+		t.Fatalf("Failed to resolve CPU profile path: %v", err)
+	}
+	memFile, err := resolveProfilePath("", "mem.prof", "")
+	if err != nil {
+		t.Fatalf("Failed to resolve memory profile path: %v", err)
+	}
+
+	opts := Options{
+		CPUFile:     cpuFile,
+		MemFile:     memFile,
+		EnableCPU:   true,
+		EnableMem:   true,
+		ProgramArgs: []string{elsewhere},
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	for _, name := range []string{"cpu.prof", "mem.prof"} {
+		if _, err := os.Stat(filepath.Join(workDir, name)); err != nil {
+			t.Errorf("Expected %s at its absolute path in the working directory: %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(elsewhere, name)); err == nil {
+			t.Errorf("Expected %s not to follow the target's chdir", name)
+		}
+	}
+}
+
+func TestCheckReservedNames(t *testing.T) {
+	if err := checkReservedNames([]string{"/pkg/main.go", "/pkg/util.go"}, []string{cpuHelperFile}); err != nil {
+		t.Errorf("Expected other names to pass, got %v", err)
+	}
+
+	err := checkReservedNames([]string{"/pkg/main.go", "/pkg/" + cpuHelperFile}, []string{cpuHelperFile})
+	if err == nil || !strings.Contains(err.Error(), cpuHelperFile) {
+		t.Errorf("Expected a clash with the CPU helper to be reported, got %v", err)
+	}
+}
+
+func TestWriteAndExecutePackageRejectsReservedNames(t *testing.T) {
+	runDir := t.TempDir()
+	t.Setenv("TMPDIR", runDir)
+
+	files := []string{"/pkg/main.go", "/pkg/" + cpuHelperFile}
+	err := writeAndExecutePackage(context.Background(), nil, nil, files[0], files, Options{EnableCPU: true, EnableWeb: true})
+	if err == nil || !strings.Contains(err.Error(), "peep needs for its own file") {
+		t.Fatalf("Expected the CPU helper name to be rejected, got %v", err)
+	}
+	if entries, _ := os.ReadDir(runDir); len(entries) != 0 {
+		t.Error("Expected no temp directory to be created")
+	}
+}
+
+func TestWriteAndExecutePackageImportsSiblingPackages(t *testing.T) {
+	// main lives below the module root and imports an internal package of the
+	// module, which only resolves when building in place
+	moduleDir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"internal/greet/greet.go": `package greet
+
+func Hello() string { return "hello from a sibling" }
+`,
+		"cmd/app/main.go": `package main
+
+import (
+	"fmt"
+	"os"
+
+	"example.com/app/internal/greet"
+)
+
+func main() {
+	if err := os.WriteFile(os.Args[1], []byte(greet.Hello()), 0o644); err != nil {
+		panic(err)
+	}
+	fmt.Println(greet.Hello())
+}
+`,
+	}
+	for name, content := range files {
+		path := filepath.Join(moduleDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	mainFile, allFiles, err := resolvePackage(filepath.Join(moduleDir, "cmd", "app"), Options{})
+	if err != nil {
+		t.Fatalf("resolvePackage failed: %v", err)
+	}
+	marker := filepath.Join(t.TempDir(), "out.txt")
+	opts := Options{CPUFile: filepath.Join(t.TempDir(), "cpu.prof"), EnableCPU: true, ProgramArgs: []string{marker}}
+	node, fset, err := processGoFile(mainFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecutePackage(context.Background(), node, fset, mainFile, allFiles, opts); err != nil {
+		t.Fatalf("writeAndExecutePackage failed: %v", err)
+	}
+
+	if data, err := os.ReadFile(marker); err != nil || string(data) != "hello from a sibling" {
+		t.Errorf("Expected the program to call the sibling package, got %q (%v)", data, err)
+	}
+	if info, err := os.Stat(opts.CPUFile); err != nil || info.Size() == 0 {
+		t.Errorf("Expected a CPU profile, got %v", err)
+	}
+	// The original source is left as it was
+	if src, _ := os.ReadFile(mainFile); strings.Contains(string(src), "pprof") {
+		t.Error("Expected the original main file not to be rewritten")
+	}
+}
+
+func TestCPUHzSetsRateBeforeProfiling(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, continuous := range []bool{false, true} {
+		opts := Options{CPUFile: "cpu.prof", EnableCPU: true, CPUHz: 500, CPUContinuous: continuous}
+		node, fset, err := processGoFile(testFile, opts)
+		if err != nil {
+			t.Fatalf("Failed to process Go file: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, node); err != nil {
+			t.Fatalf("Failed to print instrumented file: %v", err)
+		}
+		src := buf.String()
+
+		rate := strings.Index(src, "runtime.SetCPUProfileRate(500)")
+		start := strings.Index(src, "pprof.StartCPUProfile(")
+		if rate < 0 || start < 0 || rate > start {
+			t.Errorf("continuous=%v: expected the rate to be set before profiling starts:\n%s", continuous, src)
+		}
+	}
+}
+
+func TestCPUDurationStopsAfterProfilingStarts(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc work() {}\n\nfunc main() { work() }\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for _, opts := range []Options{
+		{CPUFile: "cpu.prof", EnableCPU: true, CPUDuration: 2 * time.Second},
+		{CPUFile: "cpu.prof", EnableCPU: true, CPUDuration: 2 * time.Second, CPUContinuous: true},
+		{CPUFile: "cpu.prof", EnableCPU: true, CPUDuration: 2 * time.Second, Func: "work", WarmCalls: 1},
+	} {
+		node, fset, err := processGoFile(testFile, opts)
+		if err != nil {
+			t.Fatalf("Failed to process Go file: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, node); err != nil {
+			t.Fatalf("Failed to print instrumented file: %v", err)
+		}
+		src := buf.String()
+
+		start := strings.Index(src, "pprof.StartCPUProfile(")
+		timer := strings.Index(src, "time.AfterFunc(time.Duration(2000000000), ")
+		if start < 0 || timer < start {
+			t.Errorf("continuous=%v warm=%d: expected the timer to start with the profile:\n%s", opts.CPUContinuous, opts.WarmCalls, src)
+		}
+	}
+}
+
+func TestWarmupDelaysCPUProfile(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{CPUFile: "cpu.prof", EnableCPU: true, Warmup: 3 * time.Second}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	// The profile starts from a goroutine once the warmup has passed
+	var start *ast.GoStmt
+	ast.Inspect(node, func(n ast.Node) bool {
+		if g, ok := n.(*ast.GoStmt); ok {
+			var buf bytes.Buffer
+			printer.Fprint(&buf, fset, g)
+			if strings.Contains(buf.String(), "pprof.StartCPUProfile(") {
+				start = g
+			}
+		}
+		return true
+	})
+	if start == nil {
+		t.Fatal("Expected CPU profiling to start from a goroutine")
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, start)
+	sleep := strings.Index(buf.String(), "time.Sleep(time.Duration(3000000000))")
+	if sleep < 0 || sleep > strings.Index(buf.String(), "pprof.StartCPUProfile(") {
+		t.Errorf("Expected the goroutine to sleep for the warmup before starting the profile:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	printer.Fprint(&buf, fset, node)
+	if !strings.Contains(buf.String(), `"time"`) {
+		t.Errorf("Expected time to be imported:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "defer pprof.StopCPUProfile()") {
+		t.Errorf("Expected the profile to stop when main returns:\n%s", buf.String())
+	}
+}
+
+func TestWarmupLeavesOutStartOfRun(t *testing.T) {
+	content := `package main
+
+import "time"
+
+func main() {
+	deadline := time.Now().Add(1200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+	}
+}`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{CPUFile: filepath.Join(tempDir, "cpu.prof"), EnableCPU: true, Warmup: 700 * time.Millisecond}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+	cpu, err := loadProfile(opts.CPUFile)
+	if err != nil {
+		t.Fatalf("Failed to load CPU profile: %v", err)
+	}
+	if d := time.Duration(cpu.DurationNanos); d <= 0 || d > time.Second {
+		t.Errorf("Expected the CPU profile to cover about 500ms of the 1.2s run, got %v", d)
+	}
+
+	// A program done within the warmup leaves the profile empty
+	opts.Warmup = time.Minute
+	node, fset, err = processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	err = writeAndExecute(context.Background(), node, fset, opts)
+	if err == nil || !strings.Contains(err.Error(), "-warmup") {
+		t.Errorf("Expected an error for the empty CPU profile, got %v", err)
+	}
+}
+
+func TestCPUDurationKeepsMemoryProfileOfWholeRun(t *testing.T) {
+	content := `package main
+
+import "time"
+
+var sink [][]byte
+
+func main() {
+	deadline := time.Now().Add(1500 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		sink = append(sink, make([]byte, 1024))
+		if len(sink) > 1000 {
+			sink = sink[:0]
+		}
+	}
+	late := make([]byte, 8<<20)
+	sink = append(sink, late)
+}`
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{
+		CPUFile:     filepath.Join(tempDir, "cpu.prof"),
+		MemFile:     filepath.Join(tempDir, "mem.prof"),
+		EnableCPU:   true,
+		EnableMem:   true,
+		CPUDuration: 300 * time.Millisecond,
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	cpu, err := loadProfile(opts.CPUFile)
+	if err != nil {
+		t.Fatalf("Failed to load CPU profile: %v", err)
+	}
+	if d := time.Duration(cpu.DurationNanos); d <= 0 || d > time.Second {
+		t.Errorf("Expected the CPU profile to cover about 300ms of the 1.5s run, got %v", d)
+	}
+
+	// Written when main returns, so it sees the allocation after the CPU window
+	mem, err := loadProfile(opts.MemFile)
+	if err != nil {
+		t.Fatalf("Failed to load memory profile: %v", err)
+	}
+	var late bool
+	for _, s := range mem.Sample {
+		if len(s.NumLabel["bytes"]) > 0 && s.NumLabel["bytes"][0] >= 8<<20 {
+			late = true
+		}
+	}
+	if !late {
+		t.Error("Expected the memory profile to include the allocation made after CPU profiling stopped")
+	}
+}
+
+func TestDecodePackagesSelectsMainPackage(t *testing.T) {
+	// go list -json prints one object per package, as for ./...
+	output := `{
+	"ImportPath": "example.com/app/internal/util",
+	"Name": "util"
+}
+{
+	"ImportPath": "example.com/app/cmd/app",
+	"Name": "main",
+	"GoFiles": ["main.go"]
+}
+`
+	pkgs, err := decodePackages(strings.NewReader(output))
+	if err != nil {
+		t.Fatalf("decodePackages failed: %v", err)
+	}
+	if len(pkgs) != 2 {
+		t.Fatalf("Expected 2 packages, got %d", len(pkgs))
+	}
+
+	pkg, err := selectMainPackage(pkgs)
+	if err != nil {
+		t.Fatalf("selectMainPackage failed: %v", err)
+	}
+	if pkg.ImportPath != "example.com/app/cmd/app" || len(pkg.GoFiles) != 1 {
+		t.Errorf("Expected the main package, got %+v", pkg)
+	}
+
+	// Several main packages are ambiguous, and are listed in the error
+	pkgs = append(pkgs, PackageInfo{ImportPath: "example.com/app/cmd/tool", Name: "main"})
+	_, err = selectMainPackage(pkgs)
+	if err == nil || !strings.Contains(err.Error(), "example.com/app/cmd/app (package main)") || !strings.Contains(err.Error(), "example.com/app/cmd/tool (package main)") {
+		t.Errorf("Expected an error listing the main packages, got %v", err)
+	}
+
+	if _, err := selectMainPackage(nil); err == nil {
+		t.Error("Expected an error when go list reports no packages")
+	}
+	if _, err := decodePackages(strings.NewReader(`{"Name": "main"} {`)); err == nil {
+		t.Error("Expected an error for truncated go list output")
+	}
+}
+
+func TestGoTargetFlagsBuildParallelism(t *testing.T) {
+	if flags := goTargetFlags(Options{}); len(flags) != 0 {
+		t.Errorf("Expected no flags by default, got %v", flags)
+	}
+
+	flags := goTargetFlags(Options{BuildParallelism: 2, GCTrace: true})
+	if len(flags) < 2 || flags[0] != "-p" || flags[1] != "2" {
+		t.Errorf("Expected -p 2 ahead of the other flags, got %v", flags)
+	}
+}
+
+func TestGoTargetFlagsTagsAndLDFlags(t *testing.T) {
+	ldflags := `-X 'main.version=1.2.3 beta' -s`
+	flags := goTargetFlags(Options{BuildTags: "integration,debug", LDFlags: ldflags})
+	want := []string{"-tags", "integration,debug", "-ldflags", ldflags}
+	if !slices.Equal(flags, want) {
+		t.Errorf("Expected %q, got %q", want, flags)
+	}
+}
+
+func TestGoTargetFlagsRace(t *testing.T) {
+	if slices.Contains(goTargetFlags(Options{}), "-race") {
+		t.Error("Expected no -race without Race")
+	}
+	opts := Options{Race: true, CPUFile: "cpu.prof", EnableCPU: true}
+	if !slices.Contains(goTargetFlags(opts), "-race") {
+		t.Errorf("Expected -race in the build flags, got %v", goTargetFlags(opts))
+	}
+	args, err := goTestArgs("pkg.test", opts)
+	if err != nil {
+		t.Fatalf("goTestArgs failed: %v", err)
+	}
+	if !slices.Contains(args, "-race") {
+		t.Errorf("Expected -race in the go test arguments, got %v", args)
+	}
+}
+
+func TestBuildTagsSelectFiles(t *testing.T) {
+	pkgDir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/tagged\n\ngo 1.21\n",
+		"main.go": `package main
+
+import "os"
+
+var version = "dev"
+
+func main() {
+	if err := os.WriteFile(os.Args[1], []byte(mode()+" "+version), 0o644); err != nil {
+		panic(err)
+	}
+}
+`,
+		"mode_integration.go": "//go:build integration\n\npackage main\n\nfunc mode() string { return \"integration\" }\n",
+		"mode_default.go":     "//go:build !integration\n\npackage main\n\nfunc mode() string { return \"default\" }\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(pkgDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	mainFile, allFiles, err := resolvePackage(pkgDir, Options{})
+	if err != nil {
+		t.Fatalf("resolvePackage failed: %v", err)
+	}
+	tests := []struct {
+		tags, want string
+	}{
+		{"", "default 1.2.3 beta"},
+		{"integration", "integration 1.2.3 beta"},
+	}
+	for _, tt := range tests {
+		marker := filepath.Join(t.TempDir(), "out.txt")
+		opts := Options{
+			CPUFile:     filepath.Join(t.TempDir(), "cpu.prof"),
+			EnableCPU:   true,
+			BuildTags:   tt.tags,
+			LDFlags:     "-X 'main.version=1.2.3 beta'",
+			ProgramArgs: []string{marker},
+		}
+		node, fset, err := processGoFile(mainFile, opts)
+		if err != nil {
+			t.Fatalf("Failed to process Go file: %v", err)
+		}
+		if err := writeAndExecutePackage(context.Background(), node, fset, mainFile, allFiles, opts); err != nil {
+			t.Fatalf("writeAndExecutePackage with tags %q failed: %v", tt.tags, err)
+		}
+		if data, err := os.ReadFile(marker); err != nil || string(data) != tt.want {
+			t.Errorf("Expected %q with tags %q, got %q (%v)", tt.want, tt.tags, data, err)
+		}
+	}
+}
+
+func TestBuildTagsPickMainFile(t *testing.T) {
+	pkgDir := t.TempDir()
+	files := map[string]string{
+		"go.mod":    "module example.com/entries\n\ngo 1.21\n",
+		"main_a.go": "//go:build a\n\npackage main\n\nfunc main() {}\n",
+		"main_b.go": "//go:build !a\n\npackage main\n\nfunc main() {}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(pkgDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	for tags, want := range map[string]string{"": "main_b.go", "a": "main_a.go"} {
+		mainFile, _, err := resolvePackage(pkgDir, Options{BuildTags: tags})
+		if err != nil {
+			t.Fatalf("resolvePackage with tags %q failed: %v", tags, err)
+		}
+		if filepath.Base(mainFile) != want {
+			t.Errorf("Expected %s with tags %q, got %s", want, tags, mainFile)
+		}
+	}
+	// The tags only apply to the run that asked for them
+	if len(build.Default.BuildTags) != 0 {
+		t.Errorf("Expected the default build context to be left alone, got tags %v", build.Default.BuildTags)
+	}
+}
+
+func TestGoEnv(t *testing.T) {
+	t.Setenv("CGO_ENABLED", "0")
+	env := goEnv(Options{Cgo: true, Toolchain: "go1.22.0"})
+	// Later entries win, so these override peep's own environment
+	if !slices.Contains(env, "CGO_ENABLED=1") || !slices.Contains(env, "GOTOOLCHAIN=go1.22.0") {
+		t.Errorf("Expected -cgo and -toolchain in the go commands' environment, got %v", env)
+	}
+	if os.Getenv("CGO_ENABLED") != "0" || os.Getenv("GOTOOLCHAIN") == "go1.22.0" {
+		t.Error("Expected peep's own environment to be left alone")
+	}
+	if !cgoEnabled(Options{Cgo: true}) || cgoEnabled(Options{}) {
+		t.Error("Expected go env to see CGO_ENABLED=1 only with -cgo")
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !windows
+
+package peep
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// configureCancel makes cancelling ctx stop the whole instrumented program,
+// or the go command and the compiler it started. The command runs in its own
+// process group and the group gets SIGTERM. A separate group no
+// longer receives Ctrl+C from the terminal, so contexts that can never be
+// cancelled keep the default setup.
+func configureCancel(ctx context.Context, cmd *exec.Cmd, usePTY bool) {
+	if ctx.Done() == nil {
+		return
+	}
+	if !usePTY {
+		// pty.Start already makes the target a session, and so process group, leader
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	cmd.WaitDelay = cancelWaitDelay
+}
+
+// configureInterrupt makes the end of the -duration deadline send SIGINT to
+// the target, whose injected handler flushes the profiles and exits. The
+// signal goes to the target's process group when it has one of its own, and to
+// the target alone otherwise; a kill follows after cancelWaitDelay. Cancelling
+// ctx for any other reason stops the target as before.
+func configureInterrupt(ctx context.Context, cmd *exec.Cmd) {
+	cancel := cmd.Cancel
+	cmd.Cancel = func() error {
+		if context.Cause(ctx) != errDurationElapsed {
+			return cancel()
+		}
+		pid := cmd.Process.Pid
+		if attr := cmd.SysProcAttr; attr != nil && (attr.Setpgid || attr.Setsid) {
+			pid = -pid
+		}
+		return syscall.Kill(pid, syscall.SIGINT)
+	}
+	cmd.WaitDelay = cancelWaitDelay
+}
+
+// metricsSocketSupported reports whether -metrics-socket can be used
+const metricsSocketSupported = true
@@ -0,0 +1,192 @@
+//go:build !windows
+
+package peep
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWriteAndExecuteCancelStopsTarget(t *testing.T) {
+	// The target records its pid so the test can check it was stopped
+	tempDir := t.TempDir()
+	pidFile := filepath.Join(tempDir, "pid")
+	content := `package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+func main() {
+	os.WriteFile(` + strconv.Quote(pidFile) + `, []byte(strconv.Itoa(os.Getpid())), 0o644)
+	time.Sleep(time.Minute)
+}`
+
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// Keep the run's temp directory apart from other tests'
+	runDir := t.TempDir()
+	t.Setenv("TMPDIR", runDir)
+
+	opts := Options{CPUFile: filepath.Join(tempDir, "cpu.prof"), EnableCPU: true}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// Cancel once the target is running
+		for {
+			if _, err := os.Stat(pidFile); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	err = writeAndExecute(ctx, node, fset, opts)
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("Expected cancellation error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("Expected cancellation to stop the run promptly, took %v", elapsed)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("Failed to read target pid: %v", err)
+	}
+	pid, _ := strconv.Atoi(string(data))
+	// The orphaned target may linger briefly before it is reaped
+	deadline := time.Now().Add(5 * time.Second)
+	for syscall.Kill(pid, 0) == nil {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatal("Expected the target process to be stopped")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if dirs, _ := filepath.Glob(filepath.Join(runDir, "peep-*")); len(dirs) > 0 {
+		t.Errorf("Expected the run's temp directory to be removed after cancellation, found %v", dirs)
+	}
+}
+
+func TestInterruptBeforeRunCleansUp(t *testing.T) {
+	CatchInterrupts()
+	t.Cleanup(func() { interrupted.Store(false) })
+
+	// Ctrl+C is caught instead of killing the test binary
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatalf("Failed to send SIGINT: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !interrupted.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the interrupt to be recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pkgDir := t.TempDir()
+	mainFile := filepath.Join(pkgDir, "main.go")
+	if err := os.WriteFile(mainFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create main file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, "go.mod"), []byte("module example\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create go.mod: %v", err)
+	}
+
+	// Keep the peep-pkg-* directory out of the shared temp directory
+	runDir := t.TempDir()
+	t.Setenv("TMPDIR", runDir)
+
+	opts := Options{CPUFile: filepath.Join(pkgDir, "cpu.prof"), EnableCPU: true}
+	node, fset, err := processGoFile(mainFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	err = writeAndExecutePackage(context.Background(), node, fset, mainFile, []string{mainFile}, opts)
+	if !errors.Is(err, errInterrupted) {
+		t.Fatalf("Expected the run not to start after Ctrl+C, got %v", err)
+	}
+	entries, _ := os.ReadDir(runDir)
+	if len(entries) != 0 {
+		t.Errorf("Expected the package temp directory to be removed, found %d entries", len(entries))
+	}
+	if _, err := os.Stat(opts.CPUFile); !os.IsNotExist(err) {
+		t.Error("Expected the target not to have run")
+	}
+}
+
+func TestPackageRunIssuesNoModCommands(t *testing.T) {
+	// A go wrapper on PATH logs every command peep runs
+	realGo, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found on PATH")
+	}
+	binDir := t.TempDir()
+	logFile := filepath.Join(binDir, "commands.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + strconv.Quote(logFile) + "\nexec " + strconv.Quote(realGo) + " \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(binDir, "go"), []byte(script), 0o755); err != nil {
+		t.Fatalf("Failed to write go wrapper: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	pkgDir := t.TempDir()
+	goMod := "module example\n\ngo 1.21\n"
+	if err := os.WriteFile(filepath.Join(pkgDir, "go.mod"), []byte(goMod), 0o644); err != nil {
+		t.Fatalf("Failed to create go.mod: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create main file: %v", err)
+	}
+
+	mainFile, allFiles, err := resolvePackage(pkgDir, Options{})
+	if err != nil {
+		t.Fatalf("resolvePackage failed: %v", err)
+	}
+	opts := Options{CPUFile: filepath.Join(pkgDir, "cpu.prof"), EnableCPU: true}
+	node, fset, err := processGoFile(mainFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecutePackage(context.Background(), node, fset, mainFile, allFiles, opts); err != nil {
+		t.Fatalf("writeAndExecutePackage failed: %v", err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Expected the go wrapper to be used: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if sub, _, _ := strings.Cut(line, " "); sub != "list" && sub != "build" {
+			t.Errorf("Expected only go list and go build, got go %s", line)
+		}
+	}
+	// Nothing may rewrite the module files, as go mod tidy would
+	if got, _ := os.ReadFile(filepath.Join(pkgDir, "go.mod")); string(got) != goMod {
+		t.Errorf("Expected go.mod to be left alone, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(pkgDir, "go.sum")); !os.IsNotExist(err) {
+		t.Error("Expected no go.sum to be written")
+	}
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package peep
+
+import (
+	"context"
+	"os/exec"
+)
+
+// configureCancel bounds how long a cancelled run may take to exit. Only the
+// command itself is killed on cancellation; Windows has no process groups to signal.
+func configureCancel(ctx context.Context, cmd *exec.Cmd, usePTY bool) {
+	if ctx.Done() == nil {
+		return
+	}
+	cmd.WaitDelay = cancelWaitDelay
+}
+
+// configureInterrupt bounds how long the target may take to exit once the
+// -duration deadline has passed. Windows cannot send it SIGINT, so it is
+// killed without flushing its profiles.
+func configureInterrupt(ctx context.Context, cmd *exec.Cmd) {
+	cmd.WaitDelay = cancelWaitDelay
+}
+
+// metricsSocketSupported reports whether -metrics-socket can be used; Windows
+// falls back to the metrics file
+const metricsSocketSupported = false
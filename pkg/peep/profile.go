@@ -0,0 +1,118 @@
+package peep
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/google/pprof/profile"
+)
+
+// loadProfile parses a pprof profile from disk
+func loadProfile(path string) (*profile.Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open profile %s: %w", path, err)
+	}
+	defer f.Close()
+
+	p, err := profile.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profile %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// isEmptyProfile reports whether a profile has no samples with a non-zero value
+func isEmptyProfile(p *profile.Profile) bool {
+	for _, s := range p.Sample {
+		for _, v := range s.Value {
+			if v != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkProfilesNotEmpty returns an error if any enabled CPU or memory profile has no samples
+func checkProfilesNotEmpty(opts Options) error {
+	var paths []string
+	if opts.EnableCPU {
+		paths = append(paths, opts.CPUFile)
+	}
+	if opts.EnableMem {
+		paths = append(paths, opts.MemFile)
+	}
+
+	for _, path := range paths {
+		p, err := loadProfile(path)
+		if err != nil {
+			return err
+		}
+		if isEmptyProfile(p) {
+			return fmt.Errorf("profile %s contains no samples (was the workload too short?)", path)
+		}
+	}
+	return nil
+}
+
+// cpuPeriod returns the sampling period in nanoseconds for a CPU profile rate in hertz
+func cpuPeriod(hz int) int64 {
+	return 1e9 / int64(hz)
+}
+
+// annotateCPURate checks that a CPU profile captured at hz hertz declares the
+// matching cpu/nanoseconds sampling period and records the rate in its
+// comments, so tools don't misread sample counts taken at a custom rate. A
+// wrong period is corrected, along with the cpu values derived from it.
+// It reports whether the period had to be corrected.
+func annotateCPURate(path string, hz int) (bool, error) {
+	p, err := loadProfile(path)
+	if err != nil {
+		return false, err
+	}
+
+	period := cpuPeriod(hz)
+	corrected := false
+	if p.PeriodType == nil || p.PeriodType.Type != "cpu" || p.PeriodType.Unit != "nanoseconds" || p.Period != period {
+		corrected = true
+		p.PeriodType = &profile.ValueType{Type: "cpu", Unit: "nanoseconds"}
+		p.Period = period
+
+		// cpu/nanoseconds values are the sample count times the period
+		countIdx, cpuIdx := -1, -1
+		for i, st := range p.SampleType {
+			switch {
+			case st.Type == "samples" && st.Unit == "count":
+				countIdx = i
+			case st.Type == "cpu" && st.Unit == "nanoseconds":
+				cpuIdx = i
+			}
+		}
+		if countIdx >= 0 && cpuIdx >= 0 {
+			for _, s := range p.Sample {
+				s.Value[cpuIdx] = s.Value[countIdx] * period
+			}
+		}
+	}
+
+	p.Comments = append(p.Comments, fmt.Sprintf("peep: CPU profile rate %d Hz", hz))
+
+	if err := writeProfile(path, p); err != nil {
+		return false, err
+	}
+	return corrected, nil
+}
+
+// writeProfile replaces the profile at path with p
+func writeProfile(path string, p *profile.Profile) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite profile %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := p.Write(f); err != nil {
+		return fmt.Errorf("failed to rewrite profile %s: %w", path, err)
+	}
+	return nil
+}
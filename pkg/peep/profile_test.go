@@ -0,0 +1,129 @@
+package peep
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// writeTestProfile writes a CPU-style profile with one sample per value
+func writeTestProfile(t *testing.T, path string, values ...int64) {
+	t.Helper()
+
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     10000000,
+	}
+	for _, v := range values {
+		p.Sample = append(p.Sample, &profile.Sample{Value: []int64{v}})
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create profile: %v", err)
+	}
+	defer f.Close()
+
+	if err := p.Write(f); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+}
+
+func TestIsEmptyProfile(t *testing.T) {
+	tempDir := t.TempDir()
+
+	emptyFile := filepath.Join(tempDir, "empty.prof")
+	writeTestProfile(t, emptyFile)
+	zeroFile := filepath.Join(tempDir, "zero.prof")
+	writeTestProfile(t, zeroFile, 0, 0)
+	fullFile := filepath.Join(tempDir, "full.prof")
+	writeTestProfile(t, fullFile, 3, 1)
+
+	tests := map[string]bool{
+		emptyFile: true,
+		zeroFile:  true,
+		fullFile:  false,
+	}
+	for path, expected := range tests {
+		p, err := loadProfile(path)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", path, err)
+		}
+		if got := isEmptyProfile(p); got != expected {
+			t.Errorf("isEmptyProfile(%s) = %v, expected %v", filepath.Base(path), got, expected)
+		}
+	}
+}
+
+func TestCheckProfilesNotEmpty(t *testing.T) {
+	tempDir := t.TempDir()
+
+	cpuFile := filepath.Join(tempDir, "cpu.prof")
+	writeTestProfile(t, cpuFile, 5)
+	memFile := filepath.Join(tempDir, "mem.prof")
+	writeTestProfile(t, memFile)
+
+	// Only the CPU profile is enabled, so the empty memory profile is ignored
+	if err := checkProfilesNotEmpty(Options{CPUFile: cpuFile, MemFile: memFile, EnableCPU: true}); err != nil {
+		t.Errorf("Expected no error for non-empty CPU profile, got: %v", err)
+	}
+
+	if err := checkProfilesNotEmpty(Options{CPUFile: cpuFile, MemFile: memFile, EnableCPU: true, EnableMem: true}); err == nil {
+		t.Error("Expected error for empty memory profile")
+	}
+
+	if err := checkProfilesNotEmpty(Options{CPUFile: filepath.Join(tempDir, "missing.prof"), EnableCPU: true}); err == nil {
+		t.Error("Expected error for missing profile")
+	}
+}
+
+func TestAnnotateCPURate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu.prof")
+
+	// Declares the default 100 Hz period although captured at 1000 Hz
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     10000000,
+		Sample:     []*profile.Sample{{Value: []int64{3, 30000000}}},
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create profile: %v", err)
+	}
+	if err := p.Write(f); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	f.Close()
+
+	corrected, err := annotateCPURate(path, 1000)
+	if err != nil {
+		t.Fatalf("annotateCPURate failed: %v", err)
+	}
+	if !corrected {
+		t.Error("Expected the mismatched period to be corrected")
+	}
+
+	got, err := loadProfile(path)
+	if err != nil {
+		t.Fatalf("Failed to load annotated profile: %v", err)
+	}
+	if got.Period != 1000000 {
+		t.Errorf("Expected a 1ms period, got %d", got.Period)
+	}
+	if got.Sample[0].Value[1] != 3000000 {
+		t.Errorf("Expected cpu time to be recomputed from the count, got %d", got.Sample[0].Value[1])
+	}
+	if len(got.Comments) != 1 || got.Comments[0] != "peep: CPU profile rate 1000 Hz" {
+		t.Errorf("Expected the rate to be recorded in the comments, got %v", got.Comments)
+	}
+
+	// A matching period is left alone
+	corrected, err = annotateCPURate(path, 1000)
+	if err != nil || corrected {
+		t.Errorf("Expected a matching period not to be corrected, got %v (%v)", corrected, err)
+	}
+}
@@ -0,0 +1,82 @@
+package peep
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// promContentType is the Prometheus text exposition format
+const promContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// promMetric describes how a Metrics field is exported to Prometheus
+type promMetric struct {
+	name     string
+	kind     string // gauge or counter
+	help     string
+	value    func(m Metrics) float64
+	optional bool // left out while the value is 0, for fields only some samples carry
+}
+
+// promMetrics lists the exported metrics in the order they are written.
+// Durations are converted to seconds, Prometheus's base unit.
+var promMetrics = []promMetric{
+	{name: "peep_alloc_bytes", kind: "gauge", help: "Bytes of allocated heap objects.", value: func(m Metrics) float64 { return float64(m.Alloc) }},
+	{name: "peep_total_alloc_bytes", kind: "counter", help: "Cumulative bytes allocated for heap objects.", value: func(m Metrics) float64 { return float64(m.TotalAlloc) }},
+	{name: "peep_sys_bytes", kind: "gauge", help: "Bytes of memory obtained from the OS.", value: func(m Metrics) float64 { return float64(m.Sys) }},
+	{name: "peep_heap_inuse_bytes", kind: "gauge", help: "Bytes in in-use heap spans.", value: func(m Metrics) float64 { return float64(m.HeapInuse) }},
+	{name: "peep_heap_objects", kind: "gauge", help: "Number of live heap objects.", value: func(m Metrics) float64 { return float64(m.HeapObjects) }},
+	{name: "peep_mallocs", kind: "counter", help: "Cumulative count of heap objects allocated.", value: func(m Metrics) float64 { return float64(m.Mallocs) }},
+	{name: "peep_frees", kind: "counter", help: "Cumulative count of heap objects freed.", value: func(m Metrics) float64 { return float64(m.Frees) }},
+	{name: "peep_num_gc", kind: "counter", help: "Number of completed GC cycles.", value: func(m Metrics) float64 { return float64(m.NumGC) }},
+	{name: "peep_gc_pause_total_seconds", kind: "counter", help: "Cumulative stop-the-world GC pause time.", value: func(m Metrics) float64 { return time.Duration(m.PauseTotal).Seconds() }},
+	{name: "peep_gc_last_pause_seconds", kind: "gauge", help: "Stop-the-world pause of the latest GC cycle.", value: func(m Metrics) float64 { return time.Duration(m.LastPauseNs).Seconds() }},
+	{name: "peep_gc_cpu_fraction", kind: "gauge", help: "Share of the available CPU the GC used since the program started.", value: func(m Metrics) float64 { return m.GCCPUFraction }},
+	{name: "peep_cpu_percent", kind: "gauge", help: "Total system CPU usage, 0-100 per core.", value: func(m Metrics) float64 { return m.CPUPercent }},
+	{name: "peep_goroutines", kind: "gauge", help: "Number of goroutines.", value: func(m Metrics) float64 { return float64(m.Goroutines) }},
+	{name: "peep_threads", kind: "counter", help: "OS threads created, from the threadcreate profile.", value: func(m Metrics) float64 { return float64(m.Threads) }},
+	{name: "peep_peak_alloc_bytes", kind: "gauge", help: "Peak bytes of allocated heap objects, in the final snapshot when baselining.", value: func(m Metrics) float64 { return float64(m.PeakAlloc) }, optional: true},
+}
+
+// formatPromValue renders v the way the text format expects
+func formatPromValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// writePromMetrics writes m in the Prometheus text exposition format
+func writePromMetrics(w io.Writer, m Metrics) {
+	for _, pm := range promMetrics {
+		v := pm.value(m)
+		if pm.optional && v == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", pm.name, pm.help, pm.name, pm.kind, pm.name, formatPromValue(v))
+	}
+	if len(m.CPUPerCore) > 0 {
+		fmt.Fprintf(w, "# HELP peep_cpu_core_percent CPU usage of each core, 0-100.\n# TYPE peep_cpu_core_percent gauge\n")
+		for i, v := range m.CPUPerCore {
+			fmt.Fprintf(w, "peep_cpu_core_percent{core=\"%d\"} %s\n", i, formatPromValue(v))
+		}
+	}
+}
+
+// promHandler serves the current sample in the Prometheus text exposition
+// format, with an empty body when there is none or it is stale
+func promHandler(source metricsSource, staleAfter time.Duration, data *dashboardData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", promContentType)
+
+		sample := currentSample(source, staleAfter, data)
+		if sample == nil {
+			return
+		}
+		var m Metrics
+		if err := json.Unmarshal(sample, &m); err != nil {
+			return
+		}
+		writePromMetrics(w, m)
+	}
+}
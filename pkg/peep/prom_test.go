@@ -0,0 +1,88 @@
+package peep
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPromHandler(t *testing.T) {
+	metricsPath := filepath.Join(t.TempDir(), "peep_metrics.json")
+	sample := `{"alloc":1048576,"totalAlloc":4194304,"sys":8388608,"numGC":3,"pauseTotal":1500000,` +
+		`"heapInuse":2097152,"heapObjects":512,"mallocs":900,"frees":388,"cpuPercent":12.5,"cpuPerCore":[20,5],` +
+		`"goroutines":4,"threads":6,"gcCpuFraction":0.0025,"lastPauseNs":250000,"timestampMs":%d}`
+	if err := os.WriteFile(metricsPath, []byte(fmt.Sprintf(sample, time.Now().UnixMilli())), 0o644); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	handler := promHandler(metricsFileSource{metricsPath}, metricsStaleAfter, &dashboardData{})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/metrics/prom", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != promContentType {
+		t.Errorf("Expected content type %q, got %q", promContentType, ct)
+	}
+	want := `# HELP peep_alloc_bytes Bytes of allocated heap objects.
+# TYPE peep_alloc_bytes gauge
+peep_alloc_bytes 1048576
+# HELP peep_total_alloc_bytes Cumulative bytes allocated for heap objects.
+# TYPE peep_total_alloc_bytes counter
+peep_total_alloc_bytes 4194304
+# HELP peep_sys_bytes Bytes of memory obtained from the OS.
+# TYPE peep_sys_bytes gauge
+peep_sys_bytes 8388608
+# HELP peep_heap_inuse_bytes Bytes in in-use heap spans.
+# TYPE peep_heap_inuse_bytes gauge
+peep_heap_inuse_bytes 2097152
+# HELP peep_heap_objects Number of live heap objects.
+# TYPE peep_heap_objects gauge
+peep_heap_objects 512
+# HELP peep_mallocs Cumulative count of heap objects allocated.
+# TYPE peep_mallocs counter
+peep_mallocs 900
+# HELP peep_frees Cumulative count of heap objects freed.
+# TYPE peep_frees counter
+peep_frees 388
+# HELP peep_num_gc Number of completed GC cycles.
+# TYPE peep_num_gc counter
+peep_num_gc 3
+# HELP peep_gc_pause_total_seconds Cumulative stop-the-world GC pause time.
+# TYPE peep_gc_pause_total_seconds counter
+peep_gc_pause_total_seconds 0.0015
+# HELP peep_gc_last_pause_seconds Stop-the-world pause of the latest GC cycle.
+# TYPE peep_gc_last_pause_seconds gauge
+peep_gc_last_pause_seconds 0.00025
+# HELP peep_gc_cpu_fraction Share of the available CPU the GC used since the program started.
+# TYPE peep_gc_cpu_fraction gauge
+peep_gc_cpu_fraction 0.0025
+# HELP peep_cpu_percent Total system CPU usage, 0-100 per core.
+# TYPE peep_cpu_percent gauge
+peep_cpu_percent 12.5
+# HELP peep_goroutines Number of goroutines.
+# TYPE peep_goroutines gauge
+peep_goroutines 4
+# HELP peep_threads OS threads created, from the threadcreate profile.
+# TYPE peep_threads counter
+peep_threads 6
+# HELP peep_cpu_core_percent CPU usage of each core, 0-100.
+# TYPE peep_cpu_core_percent gauge
+peep_cpu_core_percent{core="0"} 20
+peep_cpu_core_percent{core="1"} 5
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+
+	// A stale sample is left out, as /metrics blanks it
+	stale := fmt.Sprintf(`{"alloc":42,"timestampMs":%d}`, time.Now().Add(-time.Minute).UnixMilli())
+	if err := os.WriteFile(metricsPath, []byte(stale), 0o644); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("GET", "/metrics/prom", nil))
+	if rec.Body.Len() != 0 {
+		t.Errorf("Expected an empty body for a stale sample, got:\n%s", rec.Body.String())
+	}
+}
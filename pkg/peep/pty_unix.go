@@ -0,0 +1,57 @@
+//go:build !windows
+
+package peep
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/creack/pty"
+	"golang.org/x/term"
+)
+
+// runInPTY runs cmd attached to a pseudo-terminal, relaying peep's stdin to it
+// and copying everything the target writes to its terminal into out
+func runInPTY(cmd *exec.Cmd, out io.Writer) error {
+	// pty.Start only attaches the terminal to unset streams
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = nil, nil, nil
+
+	ptmx, err := pty.Start(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to start pty: %w", err)
+	}
+	defer ptmx.Close()
+
+	// Keep the pty size in sync with peep's terminal
+	resize := make(chan os.Signal, 1)
+	signal.Notify(resize, syscall.SIGWINCH)
+	defer func() {
+		signal.Stop(resize)
+		close(resize)
+	}()
+	go func() {
+		for range resize {
+			pty.InheritSize(os.Stdin, ptmx)
+		}
+	}()
+	resize <- syscall.SIGWINCH
+
+	// Put peep's terminal in raw mode so keystrokes reach the target unmodified
+	stdinFd := int(os.Stdin.Fd())
+	if term.IsTerminal(stdinFd) {
+		if state, err := term.MakeRaw(stdinFd); err == nil {
+			defer term.Restore(stdinFd, state)
+		}
+	}
+
+	go io.Copy(ptmx, os.Stdin)
+
+	// Reading the master fails once the target closes its terminal
+	io.Copy(out, ptmx)
+
+	return cmd.Wait()
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+
+package peep
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunInPTYProvidesTerminal(t *testing.T) {
+	// The target reports whether its stdout is a terminal rather than a pipe
+	content := `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	info, _ := os.Stdout.Stat()
+	fmt.Printf("tty=%v\n", info.Mode()&os.ModeCharDevice != 0)
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+
+	err := os.WriteFile(testFile, []byte(content), 0o644)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := runInPTY(exec.Command("go", "run", testFile), &out); err != nil {
+		t.Fatalf("runInPTY failed: %v\n%s", err, out.String())
+	}
+
+	if !strings.Contains(out.String(), "tty=true") {
+		t.Errorf("Expected the target to see a terminal, got: %q", out.String())
+	}
+}
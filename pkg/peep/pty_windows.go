@@ -0,0 +1,14 @@
+//go:build windows
+
+package peep
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+// runInPTY is not supported on Windows
+func runInPTY(cmd *exec.Cmd, out io.Writer) error {
+	return fmt.Errorf("-pty is only supported on Unix systems")
+}
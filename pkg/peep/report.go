@@ -0,0 +1,211 @@
+package peep
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/google/pprof/profile"
+)
+
+// Report output formats accepted by -format
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// defaultTopN is the number of top functions printed after a run unless
+// -top=false turns the report off
+const defaultTopN = 10
+
+// ParseTopCount parses the value of -top: a number of functions, or a boolean
+// that turns the report on with the default count or off
+func ParseTopCount(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, nil
+	}
+	on, err := strconv.ParseBool(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid count %q, expected a number or false", s)
+	}
+	if !on {
+		return 0, nil
+	}
+	return defaultTopN, nil
+}
+
+// TopEntry is one function in a top report
+type TopEntry struct {
+	Function    string  `json:"function"`
+	Flat        int64   `json:"flat"`
+	FlatPercent float64 `json:"flatPercent"`
+	Cum         int64   `json:"cum"`
+	CumPercent  float64 `json:"cumPercent"`
+
+	Lines []LineEntry `json:"lines,omitempty"` // hottest source lines, with -top-lines
+}
+
+// TopReport lists the functions with the highest flat value in a profile,
+// shared by the text and JSON renderers
+type TopReport struct {
+	Profile    string     `json:"profile"`
+	SampleType string     `json:"sampleType"`
+	Unit       string     `json:"unit"`
+	Total      int64      `json:"total"`
+	Entries    []TopEntry `json:"entries"`
+}
+
+// sampleIndex returns the sample value pprof reports by default: the
+// profile's default sample type, otherwise the last one
+func sampleIndex(p *profile.Profile) int {
+	for i, st := range p.SampleType {
+		if st.Type == p.DefaultSampleType {
+			return i
+		}
+	}
+	return len(p.SampleType) - 1
+}
+
+// newTopReport builds a report of the n functions with the highest flat value.
+// A function's flat value counts samples where it is the leaf, its cum value
+// samples where it appears anywhere in the stack.
+func newTopReport(path string, p *profile.Profile, n int) TopReport {
+	report := TopReport{Profile: path}
+	if len(p.SampleType) == 0 {
+		return report
+	}
+	idx := sampleIndex(p)
+	report.SampleType = p.SampleType[idx].Type
+	report.Unit = p.SampleType[idx].Unit
+
+	flat := make(map[string]int64)
+	cum := make(map[string]int64)
+	for _, s := range p.Sample {
+		v := s.Value[idx]
+		report.Total += v
+
+		seen := make(map[string]bool)
+		for i, loc := range s.Location {
+			for j, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				name := line.Function.Name
+				// The first line of the first location is the innermost frame
+				if i == 0 && j == 0 {
+					flat[name] += v
+				}
+				if !seen[name] {
+					seen[name] = true
+					cum[name] += v
+				}
+			}
+		}
+	}
+
+	for name, c := range cum {
+		report.Entries = append(report.Entries, TopEntry{Function: name, Flat: flat[name], Cum: c})
+	}
+	sort.Slice(report.Entries, func(i, j int) bool {
+		a, b := report.Entries[i], report.Entries[j]
+		if a.Flat != b.Flat {
+			return a.Flat > b.Flat
+		}
+		if a.Cum != b.Cum {
+			return a.Cum > b.Cum
+		}
+		return a.Function < b.Function
+	})
+	if len(report.Entries) > n {
+		report.Entries = report.Entries[:n]
+	}
+
+	for i := range report.Entries {
+		report.Entries[i].FlatPercent = percentOf(report.Entries[i].Flat, report.Total)
+		report.Entries[i].CumPercent = percentOf(report.Entries[i].Cum, report.Total)
+	}
+	return report
+}
+
+// percentOf returns v as a percentage of total, or 0 for an empty profile
+func percentOf(v, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(v) / float64(total) * 100
+}
+
+// writeTopText renders the reports like pprof's top listing
+func writeTopText(w io.Writer, reports []TopReport) {
+	for _, r := range reports {
+		fmt.Fprintf(w, "[prof] Top functions in %s (%s, total %d %s):\n", r.Profile, r.SampleType, r.Total, r.Unit)
+		fmt.Fprintf(w, "[prof] %12s %7s %12s %7s  %s\n", "flat", "flat%", "cum", "cum%", "function")
+		for _, e := range r.Entries {
+			fmt.Fprintf(w, "[prof] %12d %6.2f%% %12d %6.2f%%  %s\n", e.Flat, e.FlatPercent, e.Cum, e.CumPercent, e.Function)
+			for _, l := range e.Lines {
+				fmt.Fprintf(w, "[prof] %12d %6.2f%% %12d %6.2f%%    %s:%d\n", l.Flat, l.FlatPercent, l.Cum, l.CumPercent, l.File, l.Line)
+			}
+		}
+	}
+}
+
+// writeTopJSON renders the reports as a JSON array
+func writeTopJSON(w io.Writer, reports []TopReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(reports); err != nil {
+		return fmt.Errorf("failed to encode top report: %w", err)
+	}
+	return nil
+}
+
+// reportedProfiles lists the enabled profiles that were written to a file
+func reportedProfiles(opts Options) []string {
+	var paths []string
+	if opts.EnableCPU && opts.CPUFile != stdoutPath {
+		paths = append(paths, opts.CPUFile)
+	}
+	if opts.EnableMem && opts.MemFile != stdoutPath {
+		paths = append(paths, opts.MemFile)
+	}
+	if opts.EnableMutex {
+		paths = append(paths, opts.MutexFile)
+	}
+	return paths
+}
+
+// summarizeProfile parses the profile at path and reports its n functions
+// with the highest flat value, returning the profile for further listings
+func summarizeProfile(path string, n int) (TopReport, *profile.Profile, error) {
+	p, err := loadProfile(path)
+	if err != nil {
+		return TopReport{}, nil, err
+	}
+	return newTopReport(path, p, n), p, nil
+}
+
+// reportTop prints the top opts.TopN functions of each profile written to a
+// file: as text to textOut, or as JSON to jsonOut. A profile that is missing
+// or cannot be parsed is skipped with a warning, as the run itself succeeded.
+func reportTop(textOut, jsonOut io.Writer, opts Options) error {
+	var reports []TopReport
+	for _, path := range reportedProfiles(opts) {
+		report, p, err := summarizeProfile(path, opts.TopN)
+		if err != nil {
+			opts.log.logf(levelNormal, "Warning: no top functions for %s: %v", path, err)
+			continue
+		}
+		if opts.TopLines > 0 {
+			addTopLines(&report, p, opts.TopLines)
+		}
+		reports = append(reports, report)
+	}
+
+	if opts.ReportFormat == formatJSON {
+		return writeTopJSON(jsonOut, reports)
+	}
+	writeTopText(textOut, reports)
+	return nil
+}
@@ -0,0 +1,197 @@
+package peep
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// newStackProfile builds a profile of samples with the given stacks, leaf first
+func newStackProfile(samples map[string]int64) *profile.Profile {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+	}
+	funcs := make(map[string]*profile.Function)
+	for stack, v := range samples {
+		s := &profile.Sample{Value: []int64{v / 10, v}}
+		for _, name := range strings.Split(stack, ";") {
+			fn, ok := funcs[name]
+			if !ok {
+				fn = &profile.Function{ID: uint64(len(funcs) + 1), Name: name}
+				funcs[name] = fn
+				p.Function = append(p.Function, fn)
+			}
+			loc := &profile.Location{ID: uint64(len(p.Location) + 1), Line: []profile.Line{{Function: fn}}}
+			p.Location = append(p.Location, loc)
+			s.Location = append(s.Location, loc)
+		}
+		p.Sample = append(p.Sample, s)
+	}
+	return p
+}
+
+func TestNewTopReport(t *testing.T) {
+	p := newStackProfile(map[string]int64{
+		"main.hash;main.work;main.main": 600,
+		"main.work;main.main":           300,
+		"runtime.mallocgc;main.main":    100,
+	})
+
+	report := newTopReport("cpu.prof", p, 2)
+
+	// The last sample type is reported, as pprof does by default
+	if report.SampleType != "cpu" || report.Unit != "nanoseconds" || report.Total != 1000 {
+		t.Errorf("Unexpected report header: %+v", report)
+	}
+	if len(report.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %+v", len(report.Entries), report.Entries)
+	}
+
+	expected := []TopEntry{
+		{Function: "main.hash", Flat: 600, FlatPercent: 60, Cum: 600, CumPercent: 60},
+		{Function: "main.work", Flat: 300, FlatPercent: 30, Cum: 900, CumPercent: 90},
+	}
+	for i, e := range expected {
+		if !reflect.DeepEqual(report.Entries[i], e) {
+			t.Errorf("Entry %d: expected %+v, got %+v", i, e, report.Entries[i])
+		}
+	}
+}
+
+func TestSummarizeProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu.prof")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create profile: %v", err)
+	}
+	p := newStackProfile(map[string]int64{
+		"main.parse;main.main":          200,
+		"main.hash;main.work;main.main": 500,
+		"runtime.mallocgc;main.main":    300,
+	})
+	if err := p.Write(f); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	f.Close()
+
+	report, _, err := summarizeProfile(path, 3)
+	if err != nil {
+		t.Fatalf("summarizeProfile failed: %v", err)
+	}
+	var got []string
+	for _, e := range report.Entries {
+		got = append(got, e.Function)
+	}
+	expected := []string{"main.hash", "runtime.mallocgc", "main.parse"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected functions by flat value %v, got %v", expected, got)
+	}
+
+	if _, _, err := summarizeProfile(filepath.Join(t.TempDir(), "missing.prof"), 3); err == nil {
+		t.Error("Expected a missing profile to fail")
+	}
+}
+
+func TestParseTopCount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"5", 5},
+		{"0", 0},
+		{"1", 1},
+		{"false", 0},
+		{"true", defaultTopN},
+	}
+	for _, tt := range tests {
+		got, err := ParseTopCount(tt.in)
+		if err != nil {
+			t.Errorf("ParseTopCount(%q) failed: %v", tt.in, err)
+		} else if got != tt.want {
+			t.Errorf("ParseTopCount(%q) = %d, expected %d", tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := ParseTopCount("ten"); err == nil {
+		t.Error("Expected ParseTopCount(\"ten\") to fail")
+	}
+}
+
+func TestNewTopReportCountsRecursionOnce(t *testing.T) {
+	p := newStackProfile(map[string]int64{"main.fib;main.fib;main.fib;main.main": 50})
+
+	report := newTopReport("cpu.prof", p, 10)
+	for _, e := range report.Entries {
+		if e.Function == "main.fib" && e.Cum != 50 {
+			t.Errorf("Expected recursive frames to count once towards cum, got %d", e.Cum)
+		}
+	}
+}
+
+func TestReportTopFormats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cpu.prof")
+	writeTestProfile(t, path, 3, 4)
+	opts := Options{EnableCPU: true, CPUFile: path, TopN: 5}
+
+	var text, jsonOut bytes.Buffer
+	if err := reportTop(&text, &jsonOut, opts); err != nil {
+		t.Fatalf("reportTop failed: %v", err)
+	}
+	if jsonOut.Len() != 0 || !strings.Contains(text.String(), "total 7 count") {
+		t.Errorf("Expected a text report, got text %q and JSON %q", text.String(), jsonOut.String())
+	}
+
+	text.Reset()
+	opts.ReportFormat = formatJSON
+	if err := reportTop(&text, &jsonOut, opts); err != nil {
+		t.Fatalf("reportTop failed: %v", err)
+	}
+	var reports []TopReport
+	if err := json.Unmarshal(jsonOut.Bytes(), &reports); err != nil {
+		t.Fatalf("Expected a JSON report, got %q: %v", jsonOut.String(), err)
+	}
+	if text.Len() != 0 || len(reports) != 1 || reports[0].Profile != path || reports[0].Total != 7 {
+		t.Errorf("Unexpected JSON report: %+v", reports)
+	}
+}
+
+func TestReportTopSkipsUnreadableProfiles(t *testing.T) {
+	dir := t.TempDir()
+	cpuPath := filepath.Join(dir, "cpu.prof")
+	writeTestProfile(t, cpuPath, 3, 4)
+	memPath := filepath.Join(dir, "mem.prof")
+	if err := os.WriteFile(memPath, []byte("not a profile"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	opts := Options{
+		EnableCPU: true, CPUFile: cpuPath,
+		EnableMem: true, MemFile: memPath,
+		EnableMutex: true, MutexFile: filepath.Join(dir, "missing.prof"),
+		TopN: 5,
+	}
+
+	var warnings bytes.Buffer
+	opts.log = newLogger(&warnings, levelNormal)
+
+	var text, jsonOut bytes.Buffer
+	if err := reportTop(&text, &jsonOut, opts); err != nil {
+		t.Fatalf("Expected unreadable profiles not to fail the report: %v", err)
+	}
+	if !strings.Contains(text.String(), "Top functions in "+cpuPath) || strings.Contains(text.String(), memPath) {
+		t.Errorf("Expected a report of the CPU profile only, got:\n%s", text.String())
+	}
+	for _, path := range []string{memPath, opts.MutexFile} {
+		if !strings.Contains(warnings.String(), "no top functions for "+path) {
+			t.Errorf("Expected a warning about %s, got:\n%s", path, warnings.String())
+		}
+	}
+}
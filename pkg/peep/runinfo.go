@@ -0,0 +1,189 @@
+package peep
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// redactedValue replaces environment values in the run info unless they are requested
+const redactedValue = "<redacted>"
+
+// RunInfo describes what the dashboard is showing so a shared dashboard is reproducible
+type RunInfo struct {
+	Target    string            `json:"target"`
+	Command   []string          `json:"command"`
+	Modes     []string          `json:"modes"`
+	GoVersion string            `json:"goVersion"`
+	Toolchain string            `json:"toolchain,omitempty"` // requested with -toolchain
+	Env       map[string]string `json:"env"`
+}
+
+// enabledModes lists the profiling and run modes turned on in opts
+func enabledModes(opts Options) []string {
+	var modes []string
+	if opts.EnableCPU {
+		modes = append(modes, "cpu")
+	}
+	if opts.CPUContinuous {
+		modes = append(modes, "cpu-continuous")
+	}
+	if opts.EnableMem {
+		modes = append(modes, "mem")
+	}
+	if opts.EnableMutex {
+		modes = append(modes, "mutex")
+	}
+	if opts.EnableWeb {
+		modes = append(modes, "dash")
+	}
+	if opts.Summary {
+		modes = append(modes, "summary")
+	}
+	if opts.MaxAlloc > 0 {
+		modes = append(modes, "max-alloc")
+	}
+	if opts.ArchiveMetricsFile != "" {
+		modes = append(modes, "archive-metrics")
+	}
+	if opts.PerCore {
+		modes = append(modes, "per-core")
+	}
+	if opts.Adaptive {
+		modes = append(modes, "adaptive")
+	}
+	if opts.GCTrace {
+		modes = append(modes, "gctrace")
+	}
+	if opts.PTY {
+		modes = append(modes, "pty")
+	}
+	if opts.Race {
+		modes = append(modes, "race")
+	}
+	if opts.TraceFile != "" {
+		modes = append(modes, "trace")
+	}
+	if opts.RecoverPanic {
+		modes = append(modes, "recover-panic")
+	}
+	if opts.FinalSnapshotFile != "" {
+		modes = append(modes, "inject-at-return")
+	}
+	if opts.PostInitHeapFile != "" {
+		modes = append(modes, "post-init-heap")
+	}
+	if opts.BaselineFile != "" {
+		modes = append(modes, "metrics-baseline")
+	}
+	if opts.SaveBaselineFile != "" {
+		modes = append(modes, "save-baseline")
+	}
+	if opts.GoroutineInterval > 0 {
+		modes = append(modes, "goroutine-interval")
+	}
+	if opts.MemSnapshots > 0 {
+		modes = append(modes, "mem-snapshots")
+	}
+	if opts.MarkRegex != nil {
+		modes = append(modes, "mark-regex")
+	}
+	return modes
+}
+
+// goVersion reports the version of the go command used to run the target,
+// resolved under env so GOTOOLCHAIN switching is taken into account. A nil env
+// inherits peep's environment.
+func goVersion(env []string) string {
+	cmd := exec.Command("go", "env", "GOVERSION")
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newRunInfo describes the command about to run. Environment values are
+// redacted unless showEnv is set, since they commonly hold secrets.
+func newRunInfo(cmd *exec.Cmd, opts Options, showEnv bool) *RunInfo {
+	env := make(map[string]string)
+	for _, kv := range cmd.Env {
+		key, value, _ := strings.Cut(kv, "=")
+		if !showEnv {
+			value = redactedValue
+		}
+		env[key] = value
+	}
+
+	return &RunInfo{
+		Target:    opts.Target,
+		Command:   cmd.Args,
+		Modes:     enabledModes(opts),
+		GoVersion: goVersion(cmd.Env),
+		Toolchain: opts.Toolchain,
+		Env:       env,
+	}
+}
+
+// runInfoHandler serves the run info as JSON
+func runInfoHandler(info *RunInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
+
+// Manifest records a finished run for peep report: its run info, when it
+// started, how long it took and the files it wrote
+type Manifest struct {
+	RunInfo
+	Started    time.Time `json:"started"`
+	DurationMs int64     `json:"durationMs"`
+	Files      []string  `json:"files"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// manifestFiles lists the profiles, trace, metrics history and CSV the run wrote
+func manifestFiles(opts Options) []string {
+	files := reportedProfiles(opts)
+	if opts.PostInitHeapFile != "" {
+		files = append(files, opts.PostInitHeapFile)
+	}
+	if opts.TraceFile != "" {
+		files = append(files, opts.TraceFile)
+	}
+	if opts.HistoryFile != "" {
+		files = append(files, opts.HistoryFile)
+	}
+	if opts.CSVFile != "" {
+		files = append(files, opts.CSVFile)
+	}
+	return files
+}
+
+// writeManifest writes the manifest of a run that started at start and ended
+// with runErr to opts.ManifestFile
+func writeManifest(cmd *exec.Cmd, opts Options, start time.Time, runErr error) error {
+	m := Manifest{
+		RunInfo:    *newRunInfo(cmd, opts, opts.ShowEnv),
+		Started:    start,
+		DurationMs: time.Since(start).Milliseconds(),
+		Files:      manifestFiles(opts),
+	}
+	if runErr != nil {
+		m.Error = runErr.Error()
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(opts.ManifestFile, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
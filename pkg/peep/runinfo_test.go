@@ -0,0 +1,55 @@
+package peep
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+func TestNewRunInfoRedactsEnv(t *testing.T) {
+	cmd := exec.Command("go", "run", "main_prof.go", "-v")
+	cmd.Env = []string{"API_TOKEN=secret", "HOME=/home/user"}
+	opts := Options{Target: "/src/app/main.go", EnableCPU: true, EnableWeb: true}
+
+	info := newRunInfo(cmd, opts, false)
+
+	if info.Target != opts.Target {
+		t.Errorf("Expected target %s, got %s", opts.Target, info.Target)
+	}
+	if !slices.Equal(info.Command, []string{"go", "run", "main_prof.go", "-v"}) {
+		t.Errorf("Unexpected command: %v", info.Command)
+	}
+	if !slices.Equal(info.Modes, []string{"cpu", "dash"}) {
+		t.Errorf("Expected cpu and dash modes, got %v", info.Modes)
+	}
+	if info.GoVersion == "" {
+		t.Error("Expected a Go version")
+	}
+
+	// Variable names are kept but values are hidden by default
+	if value, ok := info.Env["API_TOKEN"]; !ok || value != redactedValue {
+		t.Errorf("Expected API_TOKEN to be redacted, got %q", value)
+	}
+
+	shown := newRunInfo(cmd, opts, true)
+	if shown.Env["API_TOKEN"] != "secret" {
+		t.Errorf("Expected env value when requested, got %q", shown.Env["API_TOKEN"])
+	}
+}
+
+func TestRunInfoHandler(t *testing.T) {
+	info := &RunInfo{Target: "main.go", Command: []string{"go", "run", "main.go"}, GoVersion: "go1.24.6"}
+
+	rec := httptest.NewRecorder()
+	runInfoHandler(info)(rec, httptest.NewRequest("GET", "/runinfo", nil))
+
+	var got RunInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode run info: %v", err)
+	}
+	if got.Target != "main.go" || got.GoVersion != "go1.24.6" {
+		t.Errorf("Unexpected run info: %+v", got)
+	}
+}
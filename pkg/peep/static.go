@@ -0,0 +1,25 @@
+package peep
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// staticFiles holds the dashboard page, so it is served wherever peep is run from
+//
+//go:embed static
+var staticFiles embed.FS
+
+// staticHandler serves the dashboard page from dir, or from the copy embedded
+// in peep if dir is empty
+func staticHandler(dir string) http.Handler {
+	if dir != "" {
+		return http.FileServer(http.Dir(dir))
+	}
+	files, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(err) // the embedded directory always exists
+	}
+	return http.FileServer(http.FS(files))
+}
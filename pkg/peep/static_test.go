@@ -0,0 +1,37 @@
+package peep
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStaticHandlerServesEmbeddedDashboard(t *testing.T) {
+	// Away from the source tree, where ./static does not exist
+	t.Chdir(t.TempDir())
+
+	rec := httptest.NewRecorder()
+	staticHandler("").ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `<canvas id="chart"`) {
+		t.Errorf("Expected the embedded dashboard page, got:\n%s", rec.Body.String())
+	}
+}
+
+func TestStaticHandlerServesOverrideDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<p>custom</p>"), 0o644); err != nil {
+		t.Fatalf("Failed to write page: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	staticHandler(dir).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "<p>custom</p>" {
+		t.Errorf("Expected the page from -static-dir, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
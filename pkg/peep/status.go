@@ -0,0 +1,80 @@
+package peep
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sync"
+)
+
+// RunStatus is the state of the target served at /status
+type RunStatus struct {
+	Running   bool `json:"running"`
+	Completed bool `json:"completed"`
+	ExitCode  int  `json:"exitCode"` // once completed: -1 if the target was killed or did not start
+}
+
+// runStatus tracks the target's RunStatus as it starts and exits
+type runStatus struct {
+	mu     sync.Mutex
+	status RunStatus
+}
+
+// start records that the target is running
+func (s *runStatus) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = RunStatus{Running: true}
+}
+
+// finish records that the target exited with exitCode
+func (s *runStatus) finish(exitCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = RunStatus{Completed: true, ExitCode: exitCode}
+}
+
+// Status returns the current state of the target
+func (s *runStatus) Status() RunStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// statusHandler serves whether the target is running and how it exited
+func statusHandler(s *runStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Status())
+	}
+}
+
+// targetExitCode returns the exit code of the target from the error it
+// exited with, and -1 if it did not exit normally
+func targetExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return -1
+	}
+	return exitErr.ExitCode()
+}
+
+// targetExitError reports a target that exited with a non-zero code, which
+// peep exits with too
+type targetExitError struct {
+	code int
+	err  error // the error the target or its build exited with
+}
+
+func (e *targetExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e *targetExitError) Unwrap() error {
+	return e.err
+}
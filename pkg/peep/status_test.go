@@ -0,0 +1,75 @@
+package peep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestTargetExitCode(t *testing.T) {
+	if code := targetExitCode(nil); code != 0 {
+		t.Errorf("Expected 0 for a successful run, got %d", code)
+	}
+	if code := targetExitCode(errors.New("failed to start")); code != -1 {
+		t.Errorf("Expected -1 when the target did not run, got %d", code)
+	}
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	content := "package main\n\nimport \"os\"\n\nfunc main() { os.Exit(3) }\n"
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// The built binary exits with the program's own code, unlike go run
+	binary, err := buildTarget(context.Background(), Options{}, "", tempDir, testFile)
+	if err != nil {
+		t.Fatalf("buildTarget failed: %v", err)
+	}
+	err = exec.Command(binary).Run()
+	if code := targetExitCode(err); code != 3 {
+		t.Errorf("Expected the program's exit code 3, got %d (%v)", code, err)
+	}
+}
+
+func TestBuildTargetFailure(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "main.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() { undefined() }\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	// A failed build exits with the go command's code
+	_, err := buildTarget(context.Background(), Options{}, "", tempDir, testFile)
+	var exitErr *targetExitError
+	if !errors.As(err, &exitErr) || exitErr.code != 1 {
+		t.Errorf("Expected the go command's exit code 1, got %v", err)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	status := &runStatus{}
+	get := func() RunStatus {
+		rec := httptest.NewRecorder()
+		statusHandler(status)(rec, httptest.NewRequest("GET", "/status", nil))
+		var got RunStatus
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("Failed to decode /status: %v", err)
+		}
+		return got
+	}
+
+	status.start()
+	if got := get(); !got.Running || got.Completed {
+		t.Errorf("Expected a running target, got %+v", got)
+	}
+	status.finish(3)
+	if got := get(); got.Running || !got.Completed || got.ExitCode != 3 {
+		t.Errorf("Expected a completed target with exit code 3, got %+v", got)
+	}
+}
@@ -0,0 +1,35 @@
+package peep
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// stdinTarget is the target argument that reads the program from stdin
+const stdinTarget = "-"
+
+// writeStdinTarget saves the program read from r as main.go in a new temp
+// directory, so it runs through the normal single-file flow, and returns
+// its path. The caller removes the directory.
+func writeStdinTarget(r io.Reader) (string, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the program from stdin: %w", err)
+	}
+	if len(src) == 0 {
+		return "", fmt.Errorf("no program on stdin, pipe one in: cat main.go | peep -")
+	}
+
+	dir, err := os.MkdirTemp("", "peep-stdin-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	path := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(path, src, 0o644); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to write the program read from stdin: %w", err)
+	}
+	return path, nil
+}
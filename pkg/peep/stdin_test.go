@@ -0,0 +1,92 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"go/printer"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStdinTargetRuns(t *testing.T) {
+	tempDir := t.TempDir()
+	marker := filepath.Join(tempDir, "ran")
+	src := `package main
+
+import "os"
+
+func main() {
+	os.WriteFile(` + "`" + marker + "`" + `, nil, 0o644)
+}
+`
+	path, err := writeStdinTarget(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("writeStdinTarget failed: %v", err)
+	}
+	defer os.RemoveAll(filepath.Dir(path))
+
+	opts := Options{Target: path, CPUFile: filepath.Join(tempDir, "cpu.prof"), EnableCPU: true}
+	node, fset, err := Instrument(opts)
+	if err != nil {
+		t.Fatalf("Instrument failed: %v", err)
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, node)
+	if !strings.Contains(buf.String(), "pprof.StartCPUProfile(") {
+		t.Errorf("Expected the program read from stdin to be instrumented:\n%s", buf.String())
+	}
+
+	if _, err := Run(context.Background(), opts); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("Expected the program to run: %v", err)
+	}
+	if info, err := os.Stat(opts.CPUFile); err != nil || info.Size() == 0 {
+		t.Errorf("Expected a non-empty CPU profile: %v", err)
+	}
+}
+
+func TestStdinTargetWithoutMain(t *testing.T) {
+	path, err := writeStdinTarget(strings.NewReader("package main\n"))
+	if err != nil {
+		t.Fatalf("writeStdinTarget failed: %v", err)
+	}
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if _, _, err := Instrument(Options{Target: path, EnableCPU: true}); err == nil || !strings.Contains(err.Error(), "no main function") {
+		t.Errorf("Expected the missing main to be reported as for any file, got %v", err)
+	}
+
+	if _, err := writeStdinTarget(strings.NewReader("")); err == nil {
+		t.Error("Expected an error for empty stdin")
+	}
+}
+
+func TestStdinTargetRemovedOnFailure(t *testing.T) {
+	// The stdin program is saved under TMPDIR, which only this test uses
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	for _, tc := range []struct {
+		name string
+		src  string
+		code int
+	}{
+		{"no main", "package main\n", -1},
+		{"program fails", "package main\n\nimport \"os\"\n\nfunc main() { os.Exit(3) }\n", 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := Options{Target: stdinTarget, Stdin: strings.NewReader(tc.src), EnableCPU: true, CPUFile: filepath.Join(tempDir, "cpu.prof")}
+			result, err := Run(context.Background(), opts)
+			if err == nil || result.ExitCode != tc.code {
+				t.Errorf("Expected the run to fail with exit code %d, got %d (%v)", tc.code, result.ExitCode, err)
+			}
+			if left, _ := filepath.Glob(filepath.Join(tempDir, "peep-stdin-*")); len(left) > 0 {
+				t.Errorf("Expected the stdin program to be removed, found %v", left)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package peep
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// stdoutPath as a profile output streams the profile to stdout
+const stdoutPath = "-"
+
+// Names of the injected declarations used when a profile streams to stdout
+const (
+	stdoutVar         = "peepStdout"
+	silenceStdoutFunc = "peepSilenceStdout"
+)
+
+// streamsToStdout reports whether an enabled profile is written to stdout
+func streamsToStdout(opts Options) bool {
+	return (opts.EnableCPU && opts.CPUFile == stdoutPath) || (opts.EnableMem && opts.MemFile == stdoutPath)
+}
+
+// profileDest describes where a profile output goes in progress messages
+func profileDest(path string) string {
+	if path == stdoutPath {
+		return "stdout"
+	}
+	return path
+}
+
+// profileOpenExprs returns the right-hand side of the injected
+// `f, err := ...` that opens a profile output: os.Create for a file, or the
+// target's original stdout for "-"
+func profileOpenExprs(path string) []ast.Expr {
+	if path == stdoutPath {
+		// peepStdout, error(nil)
+		return []ast.Expr{
+			ast.NewIdent(stdoutVar),
+			&ast.CallExpr{Fun: ast.NewIdent("error"), Args: []ast.Expr{ast.NewIdent("nil")}},
+		}
+	}
+	return []ast.Expr{
+		&ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("os"),
+				Sel: ast.NewIdent("Create"),
+			},
+			Args: []ast.Expr{
+				&ast.BasicLit{
+					Kind:  token.STRING,
+					Value: strconv.Quote(path),
+				},
+			},
+		},
+	}
+}
+
+// createSilenceStdoutDecls creates package-level declarations that keep the
+// target's original stdout for the profile stream and point os.Stdout at the
+// null device, so the program's own output cannot corrupt the profile. Being a
+// variable initializer, this runs before any init function.
+func createSilenceStdoutDecls() []ast.Decl {
+	return []ast.Decl{
+		// var peepStdout = peepSilenceStdout()
+		&ast.GenDecl{
+			Tok: token.VAR,
+			Specs: []ast.Spec{
+				&ast.ValueSpec{
+					Names:  []*ast.Ident{ast.NewIdent(stdoutVar)},
+					Values: []ast.Expr{&ast.CallExpr{Fun: ast.NewIdent(silenceStdoutFunc)}},
+				},
+			},
+		},
+		// func peepSilenceStdout() *os.File { ... }
+		&ast.FuncDecl{
+			Name: ast.NewIdent(silenceStdoutFunc),
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{},
+				Results: &ast.FieldList{
+					List: []*ast.Field{{
+						Type: &ast.StarExpr{X: &ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("File")}},
+					}},
+				},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					// stdout := os.Stdout
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("stdout")},
+						Tok: token.DEFINE,
+						Rhs: []ast.Expr{&ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("Stdout")}},
+					},
+					// if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil { os.Stdout = devNull }
+					&ast.IfStmt{
+						Init: &ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("devNull"), ast.NewIdent("err")},
+							Tok: token.DEFINE,
+							Rhs: []ast.Expr{
+								&ast.CallExpr{
+									Fun: &ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("OpenFile")},
+									Args: []ast.Expr{
+										&ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("DevNull")},
+										&ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("O_WRONLY")},
+										&ast.BasicLit{Kind: token.INT, Value: "0"},
+									},
+								},
+							},
+						},
+						Cond: &ast.BinaryExpr{
+							X:  ast.NewIdent("err"),
+							Op: token.EQL,
+							Y:  ast.NewIdent("nil"),
+						},
+						Body: &ast.BlockStmt{
+							List: []ast.Stmt{
+								&ast.AssignStmt{
+									Lhs: []ast.Expr{&ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("Stdout")}},
+									Tok: token.ASSIGN,
+									Rhs: []ast.Expr{ast.NewIdent("devNull")},
+								},
+							},
+						},
+					},
+					// return stdout
+					&ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("stdout")}},
+				},
+			},
+		},
+	}
+}
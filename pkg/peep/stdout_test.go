@@ -0,0 +1,90 @@
+package peep
+
+import (
+	"bytes"
+	"go/printer"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestStreamsToStdout(t *testing.T) {
+	tests := []struct {
+		opts     Options
+		expected bool
+	}{
+		{Options{EnableCPU: true, CPUFile: "cpu.prof", EnableMem: true, MemFile: "mem.prof"}, false},
+		{Options{EnableCPU: true, CPUFile: "-"}, true},
+		{Options{EnableMem: true, MemFile: "-"}, true},
+		{Options{EnableCPU: true, CPUFile: "cpu.prof", MemFile: "-"}, false}, // memory profiling disabled
+	}
+	for _, tt := range tests {
+		if got := streamsToStdout(tt.opts); got != tt.expected {
+			t.Errorf("streamsToStdout(%+v) = %v, want %v", tt.opts, got, tt.expected)
+		}
+	}
+}
+
+func TestMemProfileStreamsToStdout(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+var data [][]byte
+
+func init() {
+	fmt.Println("init output")
+}
+
+func main() {
+	for i := 0; i < 1000; i++ {
+		data = append(data, make([]byte, 1024))
+	}
+	fmt.Println("program output")
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{EnableMem: true, MemFile: stdoutPath, SilentTarget: true}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+
+	instrumented := filepath.Join(tempDir, "main_prof.go")
+	out, err := os.Create(instrumented)
+	if err != nil {
+		t.Fatalf("Failed to create instrumented file: %v", err)
+	}
+	if err := printer.Fprint(out, fset, node); err != nil {
+		t.Fatalf("Failed to write instrumented file: %v", err)
+	}
+	out.Close()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("go", "run", instrumented)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("go run failed: %v\n%s", err, stderr.String())
+	}
+
+	// The program's own output, including from init, must not reach the stream
+	if bytes.Contains(stdout.Bytes(), []byte("output")) {
+		t.Fatalf("Expected the target's output to be silenced, got %q", stdout.String())
+	}
+	p, err := profile.Parse(&stdout)
+	if err != nil {
+		t.Fatalf("Expected stdout to be a valid profile: %v", err)
+	}
+	if len(p.SampleType) == 0 {
+		t.Error("Expected the streamed profile to have sample types")
+	}
+}
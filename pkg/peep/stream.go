@@ -0,0 +1,83 @@
+package peep
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// sampleFeedBuffer is how many samples a slow /metrics/stream client may fall
+// behind before newer samples are dropped for it
+const sampleFeedBuffer = 16
+
+// sampleFeed passes each new metrics sample to the connected /metrics/stream
+// clients
+type sampleFeed struct {
+	mu      sync.Mutex
+	clients map[chan json.RawMessage]struct{}
+}
+
+// Subscribe registers a client, returning the channel its samples arrive on
+// and a function that unregisters it again
+func (f *sampleFeed) Subscribe() (<-chan json.RawMessage, func()) {
+	ch := make(chan json.RawMessage, sampleFeedBuffer)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.clients == nil {
+		f.clients = make(map[chan json.RawMessage]struct{})
+	}
+	f.clients[ch] = struct{}{}
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.clients, ch)
+	}
+}
+
+// Publish sends sample to every client without waiting for any of them
+func (f *sampleFeed) Publish(sample json.RawMessage) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.clients {
+		select {
+		case ch <- sample:
+		default:
+		}
+	}
+}
+
+// streamHandler pushes each new sample from feed to the client as a
+// Server-Sent Event until the client disconnects
+func streamHandler(feed *sampleFeed) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		samples, unsubscribe := feed.Subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case sample := <-samples:
+				// An event's data ends at a newline, so send the sample on one line
+				var line bytes.Buffer
+				if json.Compact(&line, sample) != nil {
+					continue
+				}
+				fmt.Fprintf(w, "data: %s\n\n", line.Bytes())
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package peep
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsStreamPushesNewSamples(t *testing.T) {
+	metricsPath := filepath.Join(t.TempDir(), "peep_metrics.json")
+	feed := &sampleFeed{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go recordHistory(ctx, metricsFileSource{metricsPath}, &dashboardData{history: newRingBuffer[json.RawMessage](0), feed: feed, historyPoll: 5 * time.Millisecond})
+
+	server := httptest.NewServer(streamHandler(feed))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+
+	// Written after connecting, multi-line as the frame must still be one line
+	if err := os.WriteFile(metricsPath, []byte("{\n  \"alloc\": 42\n}"), 0o644); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+
+	frames := make(chan string, 1)
+	go func() {
+		reader := bufio.NewReader(resp.Body)
+		var frame strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\n" {
+				frames <- frame.String()
+				return
+			}
+			frame.WriteString(line)
+		}
+	}()
+	select {
+	case frame := <-frames:
+		if frame != "data: {\"alloc\":42}\n" {
+			t.Errorf("Expected the sample as one data line, got %q", frame)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a frame")
+	}
+}
+
+func TestMetricsStreamUnsubscribesOnDisconnect(t *testing.T) {
+	feed := &sampleFeed{}
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/metrics/stream", nil).WithContext(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		streamHandler(feed).ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	for {
+		feed.mu.Lock()
+		n := len(feed.clients)
+		feed.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the handler to return when the client disconnects")
+	}
+	if len(feed.clients) != 0 {
+		t.Errorf("Expected the client to be unsubscribed, got %d clients", len(feed.clients))
+	}
+}
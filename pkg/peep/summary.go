@@ -0,0 +1,267 @@
+package peep
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MetricsSummary condenses a metrics history into its peaks and averages
+type MetricsSummary struct {
+	Source         string  `json:"source"`
+	Samples        int     `json:"samples"`
+	DurationMs     float64 `json:"durationMs"`
+	PeakCPUPercent float64 `json:"peakCpuPercent"`
+	AvgCPUPercent  float64 `json:"avgCpuPercent"`
+	PeakAlloc      uint64  `json:"peakAlloc"`
+	AvgAlloc       float64 `json:"avgAlloc"`
+	PeakSys        uint64  `json:"peakSys"`
+	PeakGoroutines int     `json:"peakGoroutines"`
+	AvgGoroutines  float64 `json:"avgGoroutines"`
+	PeakThreads    int     `json:"peakThreads"`
+	NumGC          uint32  `json:"numGC"`        // GC cycles between the first and last sample
+	GCPauseTotal   uint64  `json:"gcPauseTotal"` // pause time of those cycles, in nanoseconds
+}
+
+// RunSummary is what peep report found in a run directory
+type RunSummary struct {
+	Dir      string           `json:"dir"`
+	Manifest *Manifest        `json:"manifest,omitempty"`
+	Metrics  []MetricsSummary `json:"metrics,omitempty"`
+	Profiles []TopReport      `json:"profiles"`
+}
+
+// newMetricsSummary summarizes the samples of a metrics history
+func newMetricsSummary(source string, samples []json.RawMessage) (MetricsSummary, error) {
+	summary := MetricsSummary{Source: source, Samples: len(samples)}
+	var first, last Metrics
+	var cpu, alloc, goroutines float64
+	for i, raw := range samples {
+		var m Metrics
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return summary, fmt.Errorf("failed to parse sample %d of %s: %w", i+1, source, err)
+		}
+		if i == 0 {
+			first = m
+		}
+		last = m
+
+		cpu += m.CPUPercent
+		alloc += float64(m.Alloc)
+		goroutines += float64(m.Goroutines)
+		summary.PeakCPUPercent = max(summary.PeakCPUPercent, m.CPUPercent)
+		summary.PeakAlloc = max(summary.PeakAlloc, m.Alloc)
+		summary.PeakSys = max(summary.PeakSys, m.Sys)
+		summary.PeakGoroutines = max(summary.PeakGoroutines, m.Goroutines)
+		summary.PeakThreads = max(summary.PeakThreads, m.Threads)
+	}
+	if len(samples) == 0 {
+		return summary, nil
+	}
+
+	n := float64(len(samples))
+	summary.AvgCPUPercent = cpu / n
+	summary.AvgAlloc = alloc / n
+	summary.AvgGoroutines = goroutines / n
+	summary.DurationMs = sampleTimeMs(last) - sampleTimeMs(first)
+	summary.NumGC = last.NumGC - first.NumGC
+	summary.GCPauseTotal = last.PauseTotal - first.PauseTotal
+	return summary, nil
+}
+
+// sampleTimeMs returns a sample's timestamp in milliseconds, whichever unit it carries
+func sampleTimeMs(m Metrics) float64 {
+	if m.TimestampNS > 0 {
+		return float64(m.TimestampNS) / 1e6
+	}
+	return float64(m.TimestampMS)
+}
+
+// readManifest reads a manifest written by -manifest. A JSON file without a
+// command is not a manifest, and reported as nil.
+func readManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var m Manifest
+	if json.Unmarshal(data, &m) != nil || len(m.Command) == 0 {
+		return nil, nil
+	}
+	return &m, nil
+}
+
+// isProfileFile reports whether name looks like a pprof profile
+func isProfileFile(name string) bool {
+	return strings.HasSuffix(name, ".prof") || strings.HasSuffix(name, ".pprof") || strings.HasSuffix(name, ".pb.gz")
+}
+
+// summarizeRunDir collects the manifest, metrics histories and profiles in
+// dir, along with the files the manifest lists elsewhere, into a summary with
+// the top n functions of each profile. Skipped files are logged to log.
+func summarizeRunDir(log *logger, dir string, n int) (*RunSummary, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run directory: %w", err)
+	}
+
+	summary := &RunSummary{Dir: dir}
+	var profiles, histories []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		switch {
+		case isProfileFile(entry.Name()):
+			profiles = append(profiles, path)
+		case strings.HasSuffix(entry.Name(), ".jsonl"):
+			histories = append(histories, path)
+		case strings.HasSuffix(entry.Name(), ".json") && summary.Manifest == nil:
+			if summary.Manifest, err = readManifest(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Outputs the manifest lists outside dir, such as profiles in the working directory
+	if summary.Manifest != nil {
+		for _, file := range summary.Manifest.Files {
+			if _, err := os.Stat(file); err != nil || filepath.Dir(file) == filepath.Clean(dir) {
+				continue
+			}
+			if isProfileFile(file) {
+				profiles = append(profiles, file)
+			} else if strings.HasSuffix(file, ".jsonl") {
+				histories = append(histories, file)
+			}
+		}
+	}
+	sort.Strings(profiles)
+	sort.Strings(histories)
+
+	for _, path := range histories {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open history: %w", err)
+		}
+		samples, err := readHistory(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		m, err := newMetricsSummary(path, samples)
+		if err != nil {
+			return nil, err
+		}
+		summary.Metrics = append(summary.Metrics, m)
+	}
+
+	for _, path := range profiles {
+		p, err := loadProfile(path)
+		if err != nil {
+			// Files like the execution trace share the extension
+			log.logf(levelNormal, "Skipping %s: %v", path, err)
+			continue
+		}
+		summary.Profiles = append(summary.Profiles, newTopReport(path, p, n))
+	}
+	return summary, nil
+}
+
+// writeSummaryText renders a run summary for reading in a terminal
+func writeSummaryText(w io.Writer, s *RunSummary) {
+	fmt.Fprintf(w, "[prof] Report of %s\n", s.Dir)
+	if m := s.Manifest; m != nil {
+		fmt.Fprintf(w, "[prof] Run of %s started %s and took %s, including the build\n", m.Target, m.Started.Format("2006-01-02 15:04:05"), time.Duration(m.DurationMs)*time.Millisecond)
+		fmt.Fprintf(w, "[prof]   Command:     %s\n", strings.Join(m.Command, " "))
+		fmt.Fprintf(w, "[prof]   Modes:       %s\n", strings.Join(m.Modes, ", "))
+		fmt.Fprintf(w, "[prof]   Go:          %s\n", m.GoVersion)
+		if m.Error != "" {
+			fmt.Fprintf(w, "[prof]   Error:       %s\n", m.Error)
+		}
+	}
+	for _, m := range s.Metrics {
+		writeMetricsSummaryText(w, m)
+	}
+	if len(s.Profiles) == 0 {
+		fmt.Fprintln(w, "[prof] No profiles found")
+	}
+	writeTopText(w, s.Profiles)
+}
+
+// writeMetricsSummaryText renders the peaks and averages of a metrics
+// summary, as peep report and -summary print them
+func writeMetricsSummaryText(w io.Writer, m MetricsSummary) {
+	if m.Samples == 0 {
+		fmt.Fprintf(w, "[prof] Metrics from %s: no samples\n", m.Source)
+		return
+	}
+	fmt.Fprintf(w, "[prof] Metrics from %s (%d samples over %s):\n", m.Source, m.Samples, time.Duration(m.DurationMs*float64(time.Millisecond)).Round(time.Millisecond))
+	fmt.Fprintf(w, "[prof]   CPU:         peak %.1f%%, average %.1f%%\n", m.PeakCPUPercent, m.AvgCPUPercent)
+	fmt.Fprintf(w, "[prof]   Alloc:       peak %.2f MiB, average %.2f MiB\n", float64(m.PeakAlloc)/1024/1024, m.AvgAlloc/1024/1024)
+	fmt.Fprintf(w, "[prof]   Sys:         peak %.2f MiB\n", float64(m.PeakSys)/1024/1024)
+	fmt.Fprintf(w, "[prof]   Goroutines:  peak %d, average %.1f\n", m.PeakGoroutines, m.AvgGoroutines)
+	fmt.Fprintf(w, "[prof]   OS threads:  peak %d\n", m.PeakThreads)
+	fmt.Fprintf(w, "[prof]   GC:          %d cycles, %s total pause\n", m.NumGC, time.Duration(m.GCPauseTotal))
+}
+
+// printRunMetricsSummary prints the summary of the samples collected during
+// the run to log's writer, for -summary. A sample that cannot be parsed only
+// costs a warning.
+func printRunMetricsSummary(log *logger, kind string, samples []json.RawMessage) {
+	m, err := newMetricsSummary("the "+kind, samples)
+	if err != nil {
+		log.logf(levelNormal, "Warning: %v", err)
+		return
+	}
+	writeMetricsSummaryText(log.writer(), m)
+}
+
+// RunReport implements peep report
+func RunReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	topN := fs.Int("top", 10, "Number of top functions to list for each profile")
+	format := fs.String("format", formatText, "Output format, text or json")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: peep report [-top n] [-format text|json] <dir>")
+	}
+	if *topN < 1 {
+		return fmt.Errorf("-top must be at least 1")
+	}
+	if *format != formatText && *format != formatJSON {
+		return fmt.Errorf("invalid -format %q, expected text or json", *format)
+	}
+
+	log := newLogger(os.Stdout, levelNormal)
+	if *format == formatJSON {
+		// Keep stdout for the JSON report, as -format json does
+		log = newLogger(os.Stderr, levelNormal)
+	}
+	summary, err := summarizeRunDir(log, fs.Arg(0), *topN)
+	if err != nil {
+		return err
+	}
+	if summary.Manifest == nil && len(summary.Metrics) == 0 && len(summary.Profiles) == 0 {
+		return fmt.Errorf("no manifest, metrics history or profiles found in %s", fs.Arg(0))
+	}
+
+	if *format == formatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(summary); err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		return nil
+	}
+	writeSummaryText(os.Stdout, summary)
+	return nil
+}
@@ -0,0 +1,121 @@
+package peep
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewMetricsSummary(t *testing.T) {
+	samples := []json.RawMessage{
+		json.RawMessage(`{"timestampMs":1000,"cpuPercent":20,"alloc":100,"sys":500,"goroutines":2,"threads":4,"numGC":3,"pauseTotal":1000}`),
+		json.RawMessage(`{"timestampMs":1500,"cpuPercent":80,"alloc":300,"sys":700,"goroutines":6,"threads":5,"numGC":5,"pauseTotal":4000}`),
+		json.RawMessage(`{"timestampMs":3000,"cpuPercent":50,"alloc":200,"sys":600,"goroutines":4,"threads":5,"numGC":8,"pauseTotal":6000}`),
+	}
+	got, err := newMetricsSummary("h.jsonl", samples)
+	if err != nil {
+		t.Fatalf("newMetricsSummary failed: %v", err)
+	}
+	want := MetricsSummary{
+		Source: "h.jsonl", Samples: 3, DurationMs: 2000,
+		PeakCPUPercent: 80, AvgCPUPercent: 50,
+		PeakAlloc: 300, AvgAlloc: 200, PeakSys: 700,
+		PeakGoroutines: 6, AvgGoroutines: 4, PeakThreads: 5,
+		NumGC: 5, GCPauseTotal: 5000,
+	}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestPrintRunMetricsSummary(t *testing.T) {
+	samples := []json.RawMessage{
+		json.RawMessage(`{"timestampMs":1000,"cpuPercent":10,"alloc":1048576,"sys":4194304,"numGC":1,"pauseTotal":1000000}`),
+		json.RawMessage(`{"timestampMs":2000,"cpuPercent":90,"alloc":3145728,"sys":8388608,"numGC":4,"pauseTotal":3000000}`),
+		json.RawMessage(`{"timestampMs":3500,"cpuPercent":50,"alloc":2097152,"sys":6291456,"numGC":7,"pauseTotal":4500000}`),
+	}
+	var out bytes.Buffer
+	printRunMetricsSummary(newLogger(&out, levelNormal), "program", samples)
+
+	for _, want := range []string{
+		"Metrics from the program (3 samples over 2.5s):",
+		"CPU:         peak 90.0%, average 50.0%",
+		"Alloc:       peak 3.00 MiB, average 2.00 MiB",
+		"Sys:         peak 8.00 MiB",
+		"GC:          6 cycles, 3.5ms total pause",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected %q in summary, got:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	printRunMetricsSummary(newLogger(&out, levelNormal), "package", nil)
+	if !strings.Contains(out.String(), "Metrics from the package: no samples") {
+		t.Errorf("Expected no samples to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestSummarizeRunDir(t *testing.T) {
+	dir := t.TempDir()
+
+	// A manifest as written by -manifest, listing a profile outside dir
+	elsewhere := filepath.Join(t.TempDir(), "mem.prof")
+	if err := writeProfile(elsewhere, newLineProfile("main.go", map[string]int64{"main.alloc:3;main.main:9": 10})); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	cmd := exec.Command("go", "run", "main_prof.go")
+	opts := Options{Target: "main.go", EnableCPU: true, EnableMem: true, CPUFile: filepath.Join(dir, "cpu.prof"), MemFile: elsewhere, ManifestFile: filepath.Join(dir, "manifest.json")}
+	if err := writeManifest(cmd, opts, time.Now().Add(-time.Second), errors.New("exit status 2")); err != nil {
+		t.Fatalf("writeManifest failed: %v", err)
+	}
+
+	if err := writeProfile(opts.CPUFile, newLineProfile("main.go", map[string]int64{"main.work:6;main.main:12": 70, "main.main:12": 30})); err != nil {
+		t.Fatalf("Failed to write profile: %v", err)
+	}
+	history := "{\"cpuPercent\":10,\"alloc\":1048576}\n{\"cpuPercent\":30,\"alloc\":3145728}\n"
+	if err := os.WriteFile(filepath.Join(dir, "h.jsonl"), []byte(history), 0o644); err != nil {
+		t.Fatalf("Failed to write history: %v", err)
+	}
+	// Not a profile despite the name, skipped rather than failing the report
+	if err := os.WriteFile(filepath.Join(dir, "notes.prof"), []byte("not a profile"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	summary, err := summarizeRunDir(newLogger(io.Discard, levelNormal), dir, 1)
+	if err != nil {
+		t.Fatalf("summarizeRunDir failed: %v", err)
+	}
+	if m := summary.Manifest; m == nil || m.Target != "main.go" || m.Error != "exit status 2" || m.DurationMs < 1000 {
+		t.Errorf("Expected the manifest of the failed run, got %+v", m)
+	}
+	if len(summary.Metrics) != 1 || summary.Metrics[0].PeakAlloc != 3145728 || summary.Metrics[0].AvgCPUPercent != 20 {
+		t.Errorf("Expected the history to be summarized, got %+v", summary.Metrics)
+	}
+	if len(summary.Profiles) != 2 {
+		t.Fatalf("Expected the CPU profile and the manifest's memory profile, got %+v", summary.Profiles)
+	}
+	if top := summary.Profiles[0]; top.Profile != opts.CPUFile || len(top.Entries) != 1 || top.Entries[0].Function != "main.work" {
+		t.Errorf("Expected main.work to top the CPU profile, got %+v", top)
+	}
+
+	var buf bytes.Buffer
+	writeSummaryText(&buf, summary)
+	for _, want := range []string{
+		"[prof] Run of main.go started",
+		"[prof]   Error:       exit status 2",
+		"[prof]   Alloc:       peak 3.00 MiB, average 2.00 MiB",
+		"[prof] Top functions in " + elsewhere,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected the report to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
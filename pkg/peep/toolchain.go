@@ -0,0 +1,17 @@
+package peep
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// toolchainPattern matches Go toolchain names such as go1.22.0, go1.21rc2 or go1.20
+var toolchainPattern = regexp.MustCompile(`^go1\.\d+(\.\d+)?((rc|beta)\d+)?$`)
+
+// validateToolchain checks that name is a concrete toolchain version usable as GOTOOLCHAIN
+func validateToolchain(name string) error {
+	if !toolchainPattern.MatchString(name) {
+		return fmt.Errorf("invalid -toolchain %q, expected a Go version like go1.22.0", name)
+	}
+	return nil
+}
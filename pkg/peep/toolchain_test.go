@@ -0,0 +1,35 @@
+package peep
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestValidateToolchain(t *testing.T) {
+	for _, name := range []string{"go1.22.0", "go1.21rc2", "go1.20", "go1.23beta1"} {
+		if err := validateToolchain(name); err != nil {
+			t.Errorf("Expected %s to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "1.22.0", "go1.22.0+auto", "local", "go2.0", "go1.22.x"} {
+		if err := validateToolchain(name); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestGoVersionUsesCommandEnv(t *testing.T) {
+	current := goVersion(nil)
+	if !strings.HasPrefix(current, "go") {
+		t.Fatalf("Expected a Go version, got %q", current)
+	}
+
+	// GOTOOLCHAIN=local pins the installed toolchain, which must be reported
+	// the same as the inherited environment
+	cmd := exec.Command("go", "run", "main.go")
+	cmd.Env = append(cmd.Environ(), "GOTOOLCHAIN=local")
+	if got := goVersion(cmd.Env); got != current {
+		t.Errorf("Expected %s under GOTOOLCHAIN=local, got %s", current, got)
+	}
+}
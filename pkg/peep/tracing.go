@@ -0,0 +1,194 @@
+package peep
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// ParseTraceRegion parses a -trace-region value of the form func=Name, where
+// Name is a function or a Type.Method
+func ParseTraceRegion(value string) (string, error) {
+	name, ok := strings.CutPrefix(value, "func=")
+	if !ok || name == "" {
+		return "", fmt.Errorf("invalid -trace-region %q, expected func=Name", value)
+	}
+	return name, nil
+}
+
+// funcDeclName returns the name of fn as accepted by -trace-region: Name for
+// functions, Type.Method for methods
+func funcDeclName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	recv := fn.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	// Strip type parameters of generic receivers
+	switch t := recv.(type) {
+	case *ast.IndexExpr:
+		recv = t.X
+	case *ast.IndexListExpr:
+		recv = t.X
+	}
+	if ident, ok := recv.(*ast.Ident); ok {
+		return ident.Name + "." + fn.Name.Name
+	}
+	return fn.Name.Name
+}
+
+// createTraceStartStmts creates AST statements that write an execution trace
+// to traceFile until main returns, or warn and skip it if the file cannot be created
+func createTraceStartStmts(traceFile, traceFileVar, traceErrVar string) []ast.Stmt {
+	return []ast.Stmt{
+		// traceFile, traceErr := os.Create("trace.out")
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{
+				ast.NewIdent(traceFileVar),
+				ast.NewIdent(traceErrVar),
+			},
+			Tok: token.DEFINE,
+			Rhs: profileOpenExprs(traceFile),
+		},
+		// if traceErr != nil { log.Printf(...) }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  ast.NewIdent(traceErrVar),
+				Op: token.NEQ,
+				Y:  ast.NewIdent("nil"),
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{createProfileWarningStmt("execution trace", traceErrVar)},
+			},
+		},
+		// defer traceFile.Close(), deferred first so it runs after trace.Stop
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent(traceFileVar),
+					Sel: ast.NewIdent("Close"),
+				},
+			},
+		},
+		// if traceErr == nil { if traceErr = trace.Start(traceFile); traceErr != nil { log.Printf(...) } }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  ast.NewIdent(traceErrVar),
+				Op: token.EQL,
+				Y:  ast.NewIdent("nil"),
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.IfStmt{
+						Init: &ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent(traceErrVar)},
+							Tok: token.ASSIGN,
+							Rhs: []ast.Expr{
+								&ast.CallExpr{
+									Fun: &ast.SelectorExpr{
+										X:   ast.NewIdent("trace"),
+										Sel: ast.NewIdent("Start"),
+									},
+									Args: []ast.Expr{ast.NewIdent(traceFileVar)},
+								},
+							},
+						},
+						Cond: &ast.BinaryExpr{
+							X:  ast.NewIdent(traceErrVar),
+							Op: token.NEQ,
+							Y:  ast.NewIdent("nil"),
+						},
+						Body: &ast.BlockStmt{
+							List: []ast.Stmt{createProfileWarningStmt("execution trace", traceErrVar)},
+						},
+					},
+				},
+			},
+		},
+		// defer trace.Stop(), which does nothing if the trace never started
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("trace"),
+					Sel: ast.NewIdent("Stop"),
+				},
+			},
+		},
+	}
+}
+
+// createTraceRegionStmts creates AST statements that run the rest of a
+// function body in its own trace task and region, both named name
+func createTraceRegionStmts(name, ctxVar, taskVar string) []ast.Stmt {
+	nameLit := &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(name)}
+	return []ast.Stmt{
+		// ctx, task := trace.NewTask(context.Background(), "Name")
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{
+				ast.NewIdent(ctxVar),
+				ast.NewIdent(taskVar),
+			},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("trace"),
+						Sel: ast.NewIdent("NewTask"),
+					},
+					Args: []ast.Expr{
+						&ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent("context"),
+								Sel: ast.NewIdent("Background"),
+							},
+						},
+						nameLit,
+					},
+				},
+			},
+		},
+		// defer task.End()
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent(taskVar),
+					Sel: ast.NewIdent("End"),
+				},
+			},
+		},
+		// defer trace.StartRegion(ctx, "Name").End()
+		&ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X: &ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   ast.NewIdent("trace"),
+							Sel: ast.NewIdent("StartRegion"),
+						},
+						Args: []ast.Expr{ast.NewIdent(ctxVar), nameLit},
+					},
+					Sel: ast.NewIdent("End"),
+				},
+			},
+		},
+	}
+}
+
+// instrumentTraceRegion wraps the body of the function called name in a trace
+// task and region. The function must be declared in the instrumented file.
+func instrumentTraceRegion(node *ast.File, name string) error {
+	for _, decl := range node.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil || funcDeclName(fn) != name {
+			continue
+		}
+		ctxVar, taskVar := generateUniqueVars()
+		fn.Body.List = append(createTraceRegionStmts(name, ctxVar, taskVar), fn.Body.List...)
+		return nil
+	}
+	return fmt.Errorf("-trace-region: function %s not found in the instrumented file declaring main or -func", name)
+}
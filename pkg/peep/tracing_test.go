@@ -0,0 +1,157 @@
+package peep
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTraceRegion(t *testing.T) {
+	name, err := ParseTraceRegion("func=Server.Handle")
+	if err != nil || name != "Server.Handle" {
+		t.Errorf("Expected Server.Handle, got %q (%v)", name, err)
+	}
+	for _, value := range []string{"", "Handle", "func=", "fn=Handle"} {
+		if _, err := ParseTraceRegion(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestFuncDeclName(t *testing.T) {
+	src := `package main
+
+type Server struct{}
+type List[T any] struct{}
+
+func handle()                 {}
+func (s *Server) Handle()     {}
+func (s Server) Close()       {}
+func (l *List[T]) Push(v T)   {}
+`
+	node, err := parser.ParseFile(token.NewFileSet(), "main.go", src, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	var names []string
+	for _, decl := range node.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			names = append(names, funcDeclName(fn))
+		}
+	}
+	expected := []string{"handle", "Server.Handle", "Server.Close", "List.Push"}
+	for i, name := range expected {
+		if i >= len(names) || names[i] != name {
+			t.Fatalf("Expected %v, got %v", expected, names)
+		}
+	}
+}
+
+func TestTraceRegionMissingFunction(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{TraceFile: filepath.Join(tempDir, "trace.out"), TraceRegionFunc: "work"}
+	if _, _, err := processGoFile(testFile, opts); err == nil {
+		t.Error("Expected an error for a function that does not exist")
+	}
+}
+
+func TestTraceRegionWritesTrace(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func work(n int) int {
+	total := 0
+	for i := 0; i < n; i++ {
+		total += i
+	}
+	return total
+}
+
+func main() {
+	fmt.Println(work(1000))
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	traceFile := filepath.Join(tempDir, "trace.out")
+	opts := Options{
+		CPUFile:         filepath.Join(tempDir, "cpu.prof"),
+		EnableCPU:       true,
+		TraceFile:       traceFile,
+		TraceRegionFunc: "work",
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	events, err := exec.Command("go", "tool", "trace", "-d=parsed", traceFile).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to parse the execution trace: %v\n%s", err, events)
+	}
+	for _, event := range []string{"TaskBegin", "RegionBegin", "RegionEnd", "TaskEnd"} {
+		if !bytes.Contains(events, []byte(event)) || !bytes.Contains(events, []byte(`Type="work"`)) {
+			t.Errorf("Expected a %s event for work in the trace", event)
+		}
+	}
+}
+
+func TestTraceComposesWithProfiles(t *testing.T) {
+	content := `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("traced")
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{
+		CPUFile:   filepath.Join(tempDir, "cpu.prof"),
+		MemFile:   filepath.Join(tempDir, "mem.prof"),
+		EnableCPU: true,
+		EnableMem: true,
+		TraceFile: filepath.Join(tempDir, "trace.out"),
+	}
+	node, fset, err := processGoFile(testFile, opts)
+	if err != nil {
+		t.Fatalf("Failed to process Go file: %v", err)
+	}
+	if err := writeAndExecute(context.Background(), node, fset, opts); err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+
+	if out, err := exec.Command("go", "tool", "trace", "-d=parsed", opts.TraceFile).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to parse the execution trace: %v\n%s", err, out)
+	}
+	for _, path := range []string{opts.CPUFile, opts.MemFile} {
+		if _, err := loadProfile(path); err != nil {
+			t.Errorf("Expected %s next to the trace: %v", path, err)
+		}
+	}
+}
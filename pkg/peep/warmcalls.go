@@ -0,0 +1,247 @@
+package peep
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// warmCallsVars names the package-level declarations injected for -warm-calls
+type warmCallsVars struct {
+	calls, once, file, start, stop string
+}
+
+// newWarmCallsVars derives the -warm-calls names from the CPU file variable,
+// like continuousStopVar, so the function and main agree on them
+func newWarmCallsVars(cpuFileVar string) warmCallsVars {
+	return warmCallsVars{
+		calls: "calls" + cpuFileVar,
+		once:  "once" + cpuFileVar,
+		file:  "file" + cpuFileVar,
+		start: "start" + cpuFileVar,
+		stop:  "stop" + cpuFileVar,
+	}
+}
+
+// logFatalStmt creates if err != nil { log.Fatal(err) }
+func logFatalStmt(errVar string) *ast.IfStmt {
+	return &ast.IfStmt{
+		Cond: &ast.BinaryExpr{
+			X:  ast.NewIdent(errVar),
+			Op: token.NEQ,
+			Y:  ast.NewIdent("nil"),
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ExprStmt{
+					X: &ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   ast.NewIdent("log"),
+							Sel: ast.NewIdent("Fatal"),
+						},
+						Args: []ast.Expr{ast.NewIdent(errVar)},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createWarmCallsDecls creates package-level declarations that start CPU
+// profiling from a sync.Once-guarded start function, called by the -func
+// function once it has been called more than warmCalls times, and a stop
+// function deferred in main
+func createWarmCallsDecls(v warmCallsVars, opts Options) []ast.Decl {
+	varDecl := func(name string, typ ast.Expr) ast.Decl {
+		return &ast.GenDecl{
+			Tok:   token.VAR,
+			Specs: []ast.Spec{&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(name)}, Type: typ}},
+		}
+	}
+
+	var startStmts []ast.Stmt
+	if opts.CPUHz > 0 {
+		startStmts = append(startStmts, createCPURateStmt(opts.CPUHz))
+	}
+	startStmts = append(startStmts,
+		// f, err := os.Create("cpu.prof")
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("f"), ast.NewIdent("err")},
+			Tok: token.DEFINE,
+			Rhs: profileOpenExprs(opts.CPUFile),
+		},
+		// if err != nil { log.Printf(...); return }
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{X: ast.NewIdent("err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{createProfileWarningStmt("CPU", "err"), &ast.ReturnStmt{}},
+			},
+		},
+		// peepWarmFile = f
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent(v.file)},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{ast.NewIdent("f")},
+		},
+	)
+
+	// if err := pprof.StartCPUProfile(f); err != nil { log.Fatal(err) }
+	startErr := logFatalStmt("err")
+	startErr.Init = &ast.AssignStmt{
+		Lhs: []ast.Expr{ast.NewIdent("err")},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{
+			&ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("pprof"),
+					Sel: ast.NewIdent("StartCPUProfile"),
+				},
+				Args: []ast.Expr{ast.NewIdent("f")},
+			},
+		},
+	}
+	startStmts = append(startStmts, startErr)
+	if opts.CPUDuration > 0 {
+		// The window starts with the profile, once the function is warm. The
+		// deferred stop then finds the profile stopped and only closes the file.
+		startStmts = append(startStmts, createCPUDurationStmt(opts.CPUDuration, stopCPUProfileExpr()))
+	}
+
+	return []ast.Decl{
+		// var peepWarmCalls int64
+		varDecl(v.calls, ast.NewIdent("int64")),
+		// var peepWarmOnce sync.Once
+		varDecl(v.once, &ast.SelectorExpr{X: ast.NewIdent("sync"), Sel: ast.NewIdent("Once")}),
+		// var peepWarmFile *os.File
+		varDecl(v.file, &ast.StarExpr{X: &ast.SelectorExpr{X: ast.NewIdent("os"), Sel: ast.NewIdent("File")}}),
+		// func peepWarmStart() { ... }
+		&ast.FuncDecl{
+			Name: ast.NewIdent(v.start),
+			Type: &ast.FuncType{Params: &ast.FieldList{}},
+			Body: &ast.BlockStmt{List: startStmts},
+		},
+		// func peepWarmStop() { ... }
+		&ast.FuncDecl{
+			Name: ast.NewIdent(v.stop),
+			Type: &ast.FuncType{Params: &ast.FieldList{}},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					// Waits for a start in progress and keeps later calls from starting
+					// peepWarmOnce.Do(func() {})
+					&ast.ExprStmt{
+						X: &ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent(v.once),
+								Sel: ast.NewIdent("Do"),
+							},
+							Args: []ast.Expr{&ast.FuncLit{Type: &ast.FuncType{}, Body: &ast.BlockStmt{}}},
+						},
+					},
+					// if peepWarmFile == nil { log.Printf(...); return }
+					&ast.IfStmt{
+						Cond: &ast.BinaryExpr{
+							X:  ast.NewIdent(v.file),
+							Op: token.EQL,
+							Y:  ast.NewIdent("nil"),
+						},
+						Body: &ast.BlockStmt{
+							List: []ast.Stmt{
+								&ast.ExprStmt{
+									X: &ast.CallExpr{
+										Fun: &ast.SelectorExpr{
+											X:   ast.NewIdent("log"),
+											Sel: ast.NewIdent("Printf"),
+										},
+										Args: []ast.Expr{
+											&ast.BasicLit{
+												Kind:  token.STRING,
+												Value: strconv.Quote(fmt.Sprintf("[prof] %s was called %%d times, CPU profiling starts after %d calls, no CPU profile written", opts.Func, opts.WarmCalls)),
+											},
+											&ast.CallExpr{
+												Fun: &ast.SelectorExpr{
+													X:   ast.NewIdent("atomic"),
+													Sel: ast.NewIdent("LoadInt64"),
+												},
+												Args: []ast.Expr{&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(v.calls)}},
+											},
+										},
+									},
+								},
+								&ast.ReturnStmt{},
+							},
+						},
+					},
+					// pprof.StopCPUProfile()
+					&ast.ExprStmt{
+						X: &ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent("pprof"),
+								Sel: ast.NewIdent("StopCPUProfile"),
+							},
+						},
+					},
+					// peepWarmFile.Close()
+					&ast.ExprStmt{
+						X: &ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent(v.file),
+								Sel: ast.NewIdent("Close"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// createWarmCallsCountStmt creates the statement counting calls to the -func
+// function: if atomic.AddInt64(&peepWarmCalls, 1) > N { peepWarmOnce.Do(peepWarmStart) }
+func createWarmCallsCountStmt(v warmCallsVars, warmCalls int) ast.Stmt {
+	return &ast.IfStmt{
+		Cond: &ast.BinaryExpr{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("atomic"),
+					Sel: ast.NewIdent("AddInt64"),
+				},
+				Args: []ast.Expr{
+					&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent(v.calls)},
+					&ast.BasicLit{Kind: token.INT, Value: "1"},
+				},
+			},
+			Op: token.GTR,
+			Y:  &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(warmCalls)},
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ExprStmt{
+					X: &ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   ast.NewIdent(v.once),
+							Sel: ast.NewIdent("Do"),
+						},
+						Args: []ast.Expr{ast.NewIdent(v.start)},
+					},
+				},
+			},
+		},
+	}
+}
+
+// instrumentWarmCalls adds the -warm-calls declarations to node and defers
+// the stop function in main, which must be declared in the same file
+func instrumentWarmCalls(node *ast.File, v warmCallsVars, opts Options) error {
+	for _, decl := range node.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil || fn.Recv != nil || fn.Name.Name != "main" {
+			continue
+		}
+		stop := &ast.DeferStmt{Call: &ast.CallExpr{Fun: ast.NewIdent(v.stop)}}
+		fn.Body.List = append([]ast.Stmt{stop}, fn.Body.List...)
+		node.Decls = append(node.Decls, createWarmCallsDecls(v, opts)...)
+		return nil
+	}
+	return fmt.Errorf("-warm-calls: %s must be declared in the file containing main, which stops the CPU profile", opts.Func)
+}
@@ -0,0 +1,109 @@
+package peep
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWarmCallsProfilesOnlyWarmCalls(t *testing.T) {
+	// The first calls take a cold path, later ones the warm path
+	content := `package main
+
+import "time"
+
+var calls int
+
+//go:noinline
+func spin(d time.Duration) int {
+	n := 0
+	for start := time.Now(); time.Since(start) < d; {
+		n++
+	}
+	return n
+}
+
+//go:noinline
+func coldPath() int { return spin(150 * time.Millisecond) }
+
+//go:noinline
+func warmPath() int { return spin(150 * time.Millisecond) }
+
+func handle() int {
+	calls++
+	if calls <= 3 {
+		return coldPath()
+	}
+	return warmPath()
+}
+
+func main() {
+	for i := 0; i < 6; i++ {
+		handle()
+	}
+}`
+
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test.go")
+	if err := os.WriteFile(testFile, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	run := func(warmCalls int) (string, error) {
+		cpuFile := filepath.Join(t.TempDir(), "cpu.prof")
+		opts := Options{CPUFile: cpuFile, EnableCPU: true, Func: "handle", WarmCalls: warmCalls}
+		node, fset, err := processGoFile(testFile, opts)
+		if err != nil {
+			t.Fatalf("Failed to process Go file: %v", err)
+		}
+		err = writeAndExecute(context.Background(), node, fset, opts)
+		return cpuFile, err
+	}
+
+	cpuFile, err := run(3)
+	if err != nil {
+		t.Fatalf("writeAndExecute failed: %v", err)
+	}
+	p, err := loadProfile(cpuFile)
+	if err != nil {
+		t.Fatalf("Expected a CPU profile once handle got warm: %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, s := range p.Sample {
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				seen[line.Function.Name] = true
+			}
+		}
+	}
+	if !seen["main.warmPath"] {
+		t.Errorf("Expected samples in main.warmPath, got functions %v", seen)
+	}
+	if seen["main.coldPath"] {
+		t.Errorf("Expected no samples from the cold calls, got functions %v", seen)
+	}
+
+	// Never getting warm writes no profile, which is reported
+	cpuFile, err = run(10)
+	if err == nil || !strings.Contains(err.Error(), "no CPU profile was written") {
+		t.Errorf("Expected an error when handle never got warm, got %v", err)
+	}
+	if _, err := os.Stat(cpuFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no CPU profile when handle never got warm, got %v", err)
+	}
+}
+
+func TestWarmCallsRequiresMainInSameFile(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "serve.go")
+	if err := os.WriteFile(testFile, []byte("package main\n\nfunc handle() {}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	opts := Options{CPUFile: "cpu.prof", EnableCPU: true, Func: "handle", WarmCalls: 2}
+	if _, _, err := processGoFile(testFile, opts); err == nil {
+		t.Error("Expected an error when main is declared in another file")
+	}
+}